@@ -0,0 +1,34 @@
+package lightpatch
+
+import "encoding/binary"
+
+// WithBlockAlign causes MakePatch to place the data of every insert at a multiple
+// of size bytes from the start of the patch, and to pad the patch to a multiple of
+// size bytes, using padding records. This lets a patch be written directly to
+// storage with a fixed block size, such as flash sectors, with inserts that don't
+// straddle blocks needlessly. Alignment is of the binary patch, so it has no
+// purpose with WithArmor or WithFEC.
+func WithBlockAlign(size int) Option {
+	return func(c *config) {
+		c.blockSize = size
+	}
+}
+
+// padding returns the padding record that moves offset to a multiple of size, or
+// nil if offset is aligned.
+func padding(offset, size int) []byte {
+	gap := (size - offset%size) % size
+	if gap == 0 {
+		return nil
+	}
+
+	// A padding record is at least 2 bytes, and the length of the varint can make
+	// some gaps impossible to fill exactly, in which case the next block is used.
+	for ; ; gap += size {
+		for n := gap - 2; n >= 0 && n >= gap-1-binary.MaxVarintLen64; n-- {
+			if 1+uvarintLen(uint64(n))+n == gap {
+				return append(appendUvarint([]byte{OpPadding}, uint64(n)), make([]byte, n)...)
+			}
+		}
+	}
+}
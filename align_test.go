@@ -0,0 +1,64 @@
+package lightpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockAlign(t *testing.T) {
+	a := []byte(strings.Repeat("The quick brown fox jumped over the lazy dog. ", 20))
+	b := []byte(strings.Repeat("The quick brown cat jumped over the dog! ", 20))
+
+	for _, size := range []int{2, 16, 130, 512} {
+		for _, opts := range [][]Option{
+			nil,
+			{WithInsertChecksums()},
+			{WithChecksum(ChecksumBLAKE3), WithAnnotation([]byte("aligned"))},
+		} {
+			var patch bytes.Buffer
+			err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, append(opts, WithBlockAlign(size))...)
+			assert.NoError(t, err)
+			assert.Zero(t, patch.Len()%size)
+
+			var after bytes.Buffer
+			assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+			assert.Equal(t, b, after.Bytes())
+			assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+			var text, bin bytes.Buffer
+			assert.NoError(t, ToText(bytes.NewReader(patch.Bytes()), &text))
+			assert.Contains(t, text.String(), "\nZ ")
+			assert.NoError(t, ToBinary(&text, &bin))
+			assert.Equal(t, patch.Bytes(), bin.Bytes())
+
+			// Every insert starts on a block boundary.
+			ops, _, err := readPatchOps(bytes.NewReader(patch.Bytes()))
+			assert.NoError(t, err)
+			inserts := 0
+			for _, op := range ops {
+				if op.op == OpInsert {
+					inserts++
+					assert.Zero(t, bytes.Index(patch.Bytes(), op.data)%size)
+				}
+			}
+			assert.NotZero(t, inserts)
+		}
+	}
+}
+
+func TestPadding(t *testing.T) {
+	for size := 2; size < 300; size++ {
+		for offset := 0; offset < 2*size; offset++ {
+			rec := padding(offset, size)
+			assert.Zero(t, (offset+len(rec))%size)
+			if rec != nil {
+				n, l := binary.Uvarint(rec[1:])
+				assert.Equal(t, len(rec), 1+l+int(n))
+			}
+		}
+	}
+}
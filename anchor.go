@@ -0,0 +1,80 @@
+package lightpatch
+
+import (
+	"sort"
+	"time"
+)
+
+// anchor is a run of n identical bytes found at offset a in before and offset b in after.
+type anchor struct {
+	a, b, n int
+}
+
+// diffAnchors builds a diff from anchors found by one of the fast matchers. Since a patch
+// can only move forward through before, the longest chain of anchors that is increasing
+// in both inputs is kept, and only the gaps between them are handed to the byte-level differ.
+// anchors must be sorted by b and not overlap in after.
+func diffAnchors(text1, text2 []byte, anchors []anchor, deadline time.Time) []diff {
+	var diffs []diff
+	var a, b int
+
+	for _, an := range monotoneChain(anchors) {
+		// Trim any overlap with the previous anchor.
+		if d := a - an.a; d > 0 {
+			an.a += d
+			an.b += d
+			an.n -= d
+		}
+		if d := b - an.b; d > 0 {
+			an.a += d
+			an.b += d
+			an.n -= d
+		}
+		if an.n <= 0 {
+			continue
+		}
+
+		diffs = append(diffs, diffMainBytes(text1[a:an.a], text2[b:an.b], deadline)...)
+		diffs = append(diffs, diff{OpCopy, text1[an.a : an.a+an.n]})
+		a = an.a + an.n
+		b = an.b + an.n
+	}
+	diffs = append(diffs, diffMainBytes(text1[a:], text2[b:], deadline)...)
+
+	return diffCleanupMerge(diffs)
+}
+
+// monotoneChain returns the longest subsequence of anchors whose before offsets are
+// strictly increasing, using patience sorting.
+func monotoneChain(anchors []anchor) []anchor {
+	if len(anchors) == 0 {
+		return nil
+	}
+
+	// tails[k] is the index of the smallest-offset anchor ending a chain of length k+1.
+	var tails []int
+	prev := make([]int, len(anchors))
+
+	for i, an := range anchors {
+		k := sort.Search(len(tails), func(k int) bool {
+			return anchors[tails[k]].a >= an.a
+		})
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	chain := make([]anchor, len(tails))
+	for i, k := tails[len(tails)-1], len(tails)-1; k >= 0; i, k = prev[i], k-1 {
+		chain[k] = anchors[i]
+	}
+
+	return chain
+}
@@ -0,0 +1,122 @@
+package lightpatch
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonotoneChain(t *testing.T) {
+	anchors := []anchor{
+		{a: 10, b: 0, n: 5},
+		{a: 50, b: 5, n: 5},
+		{a: 20, b: 10, n: 5},
+		{a: 30, b: 15, n: 5},
+	}
+	assert.Equal(t, []anchor{anchors[0], anchors[2], anchors[3]}, monotoneChain(anchors))
+	assert.Nil(t, monotoneChain(nil))
+}
+
+// scatteredEdits returns a copy of before with small changes, insertions and
+// deletions spread throughout.
+func scatteredEdits(rng *rand.Rand, before []byte) []byte {
+	var after []byte
+	for i := 0; i < len(before); i += 1000 {
+		end := i + 1000
+		if end > len(before) {
+			end = len(before)
+		}
+		chunk := before[i:end]
+		switch rng.Intn(3) {
+		case 0:
+			after = append(after, chunk[:500]...)
+			after = append(after, 0xff, 0xfe)
+			after = append(after, chunk[502:]...)
+		case 1:
+			after = append(after, chunk[100:]...)
+		default:
+			after = append(after, chunk...)
+			after = append(after, []byte("inserted")...)
+		}
+	}
+	return after
+}
+
+func TestAnchoredAlgorithms(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	before := make([]byte, 1<<16)
+	rng.Read(before)
+	after := scatteredEdits(rng, before)
+
+	for _, alg := range []Algorithm{AlgorithmSuffixArray, AlgorithmRollingHash} {
+		var patch bytes.Buffer
+		err := MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, WithAlgorithm(alg))
+		assert.NoError(t, err)
+		assert.True(t, patch.Len() < len(after)/20, "algorithm %d: patch too large: %d", alg, patch.Len())
+
+		var out bytes.Buffer
+		err = ApplyPatch(bytes.NewReader(before), &patch, &out)
+		assert.NoError(t, err)
+		assert.Equal(t, after, out.Bytes())
+
+		for _, tc := range [][2]string{
+			{"", ""},
+			{"", "abc"},
+			{"abc", ""},
+			{"The quick brown fox jumped over the lazy dog.", "The quick brown cat jumped over the dog!"},
+		} {
+			patch.Reset()
+			err := MakePatch(bytes.NewReader([]byte(tc[0])), bytes.NewReader([]byte(tc[1])), &patch, WithAlgorithm(alg))
+			assert.NoError(t, err)
+
+			out.Reset()
+			err = ApplyPatch(bytes.NewReader([]byte(tc[0])), &patch, &out)
+			assert.NoError(t, err)
+			assert.Equal(t, tc[1], out.String())
+		}
+	}
+}
+
+func TestDiffHashed(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+
+	// A large shared middle, with differences at both ends that defeat the
+	// common prefix and suffix shortcuts.
+	middle := make([]byte, 2<<20)
+	rng.Read(middle)
+	text1 := append(append([]byte("head1"), middle...), "tail1"...)
+	text2 := append(append([]byte("head2"), scatteredEdits(rng, middle)...), "tail2"...)
+
+	deadline := time.Now().Add(time.Second)
+	diffs := diffHashed(text1, text2, deadline)
+	assert.NotNil(t, diffs)
+	assert.False(t, time.Now().After(deadline))
+
+	var rebuilt1, rebuilt2 []byte
+	var edited int
+	for _, d := range diffs {
+		if d.Type != OpInsert {
+			rebuilt1 = append(rebuilt1, d.Text...)
+		}
+		if d.Type != OpDelete {
+			rebuilt2 = append(rebuilt2, d.Text...)
+		}
+		if d.Type != OpCopy {
+			edited += len(d.Text)
+		}
+	}
+	assert.Equal(t, text1, rebuilt1)
+	assert.Equal(t, text2, rebuilt2)
+	assert.True(t, edited < len(text2)/10, "edited %d bytes", edited)
+
+	// Unrelated inputs, small inputs and unlimited time are left to bisect.
+	unrelated := make([]byte, len(text2))
+	rng.Read(unrelated)
+	assert.Nil(t, diffHashed(text1, unrelated, deadline))
+	assert.Nil(t, diffHashed(text1[:1000], text2[:1000], deadline))
+	assert.Nil(t, diffHashed(text1, text2, time.Time{}))
+}
@@ -0,0 +1,85 @@
+package lightpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// ApplyPatchAt is like ApplyPatch, but reads before at the offsets of its copied
+// parts with ReadAt, rather than reading all of it in order, so the parts a patch
+// deletes or replaces are skipped without being read. It suits large files and block
+// devices, of which a patch copies only some. A patch made WithBeforeChecksum still
+// has all of before read once to check its CRC, though not held in memory.
+func ApplyPatchAt(before io.ReaderAt, patch []byte, after io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	raw := &countingReader{r: bytes.NewReader(patch)}
+	r, err := openSignedPatch(raw, cfg.publicKey)
+	if err != nil {
+		return err
+	}
+	return applyPatch(&readerAtSource{r: before}, raw, r, after, cfg)
+}
+
+// readerAtSource is a beforeSource read with ReadAt, which skips bytes by moving its
+// offset.
+type readerAtSource struct {
+	r   io.ReaderAt
+	off int64
+}
+
+func (s *readerAtSource) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := s.r.ReadAt(p, s.off)
+	s.off += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Discard reads only the last of the n bytes, to check that they are there.
+func (s *readerAtSource) Discard(n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	var b [1]byte
+	if m, err := s.r.ReadAt(b[:], s.off+int64(n)-1); m == 0 {
+		return 0, err
+	}
+	s.off += int64(n)
+	return n, nil
+}
+
+func (s *readerAtSource) read() int64 {
+	return s.off
+}
+
+func (s *readerAtSource) more() (bool, error) {
+	var b [1]byte
+	if m, err := s.r.ReadAt(b[:], s.off); m == 1 {
+		return true, nil
+	} else if err != io.EOF {
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *readerAtSource) check(patch io.Reader) error {
+	crc := make([]byte, 4)
+	if _, err := io.ReadFull(patch, crc); err != nil {
+		return err
+	}
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, io.NewSectionReader(s.r, 0, math.MaxInt64)); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(crc) != h.Sum32() {
+		return ErrBeforeMismatch
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingReaderAt counts the bytes read through it.
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestApplyPatchAt(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	before := make([]byte, 1<<20)
+	rng.Read(before)
+
+	// Most of before is deleted.
+	var after []byte
+	after = append(after, before[:1000]...)
+	after = append(after, "inserted"...)
+	after = append(after, before[len(before)-1000:]...)
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, WithAlgorithm(AlgorithmRollingHash)))
+
+	ra := &countingReaderAt{r: bytes.NewReader(before)}
+	var out bytes.Buffer
+	assert.NoError(t, ApplyPatchAt(ra, patch.Bytes(), &out))
+	assert.Equal(t, after, out.Bytes())
+	assert.True(t, ra.n < 10000, "read %d bytes of before", ra.n)
+
+	err := ApplyPatchAt(bytes.NewReader(before[:len(before)-10]), patch.Bytes(), new(bytes.Buffer))
+	var de *DecodeError
+	assert.True(t, errors.As(err, &de), err)
+	assert.Equal(t, ErrSourceTooShort, de.Err)
+	assert.Equal(t, OpCopy, de.Op)
+	assert.True(t, de.Source >= int64(len(before)-1010), "copy from %d", de.Source)
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, WithBeforeChecksum(), WithArmor(ArmorBase64)))
+	out.Reset()
+	assert.NoError(t, ApplyPatchAt(bytes.NewReader(before), patch.Bytes(), &out))
+	assert.Equal(t, after, out.Bytes())
+
+	err = ApplyPatchAt(bytes.NewReader(after), patch.Bytes(), new(bytes.Buffer))
+	assert.Equal(t, ErrBeforeMismatch, err)
+}
@@ -0,0 +1,190 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/ascii85"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// Armor selects a text encoding for the patch written by MakePatch, so that it can be
+// pasted into JSON, YAML, email and the like. Armored patches are enclosed in
+// PGP-style begin and end lines, and are decoded automatically when read.
+type Armor int
+
+const (
+	// ArmorNone writes the binary patch as is. It is the default.
+	ArmorNone Armor = iota
+
+	// ArmorBase64 encodes the patch in base64.
+	ArmorBase64
+
+	// ArmorASCII85 encodes the patch in Ascii85, which is about 7% smaller than
+	// base64 but uses characters, such as quotes and backslashes, that need
+	// escaping in many formats.
+	ArmorASCII85
+)
+
+const armorLineLen = 64
+
+var armorLabels = map[Armor]string{
+	ArmorBase64:  "LIGHTPATCH PATCH",
+	ArmorASCII85: "LIGHTPATCH PATCH ASCII85",
+}
+
+var ErrArmor = errors.New("invalid armor")
+
+// WithArmor selects the text encoding of the patch written by MakePatch.
+func WithArmor(a Armor) Option {
+	return func(c *config) {
+		c.armor = a
+	}
+}
+
+func beginLine(label string) string { return "-----BEGIN " + label + "-----" }
+func endLine(label string) string   { return "-----END " + label + "-----" }
+
+// armorWriter encodes a patch, wrapping it in begin and end lines. Close must be
+// called to flush the encoding and write the end line.
+type armorWriter struct {
+	w     io.Writer
+	label string
+	enc   io.WriteCloser
+}
+
+func newArmorWriter(w io.Writer, a Armor) (*armorWriter, error) {
+	label := armorLabels[a]
+	if _, err := io.WriteString(w, beginLine(label)+"\n"); err != nil {
+		return nil, err
+	}
+
+	lw := &lineWriter{w: w}
+	aw := &armorWriter{w: w, label: label}
+	if a == ArmorASCII85 {
+		aw.enc = ascii85.NewEncoder(lw)
+	} else {
+		aw.enc = base64.NewEncoder(base64.StdEncoding, lw)
+	}
+	return aw, nil
+}
+
+func (a *armorWriter) Write(p []byte) (int, error) {
+	return a.enc.Write(p)
+}
+
+func (a *armorWriter) Close() error {
+	if err := a.enc.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(a.w, "\n"+endLine(a.label)+"\n")
+	return err
+}
+
+// lineWriter breaks its output into lines of armorLineLen bytes.
+type lineWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if l.col == armorLineLen {
+			if _, err := l.w.Write([]byte{'\n'}); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+
+		n := armorLineLen - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		n, err := l.w.Write(p[:n])
+		written += n
+		l.col += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// dearmor returns a reader of the binary patch in r, decoding it if it is armored.
+// Leading whitespace before the begin line is ignored.
+func dearmor(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	for {
+		c, err := br.Peek(1)
+		if err != nil || !isSpace(c[0]) {
+			break
+		}
+		br.Discard(1)
+	}
+	if c, err := br.Peek(1); err != nil || c[0] != '-' {
+		return br, nil
+	}
+
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = string(bytes.TrimSpace([]byte(line)))
+
+	for a, label := range armorLabels {
+		if line == beginLine(label) {
+			body := &armorBody{r: br, end: endLine(label)}
+			if a == ArmorASCII85 {
+				return ascii85.NewDecoder(body), nil
+			}
+			return base64.NewDecoder(base64.StdEncoding, body), nil
+		}
+	}
+
+	return nil, ErrArmor
+}
+
+// armorBody reads the encoded lines of an armored patch, with surrounding whitespace
+// removed, up to the end line.
+type armorBody struct {
+	r    *bufio.Reader
+	end  string
+	line []byte
+	done bool
+}
+
+func (a *armorBody) Read(p []byte) (int, error) {
+	for len(a.line) == 0 {
+		if a.done {
+			return 0, io.EOF
+		}
+
+		line, err := a.r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+		} else if err != nil {
+			return 0, err
+		}
+
+		a.line = bytes.TrimSpace(line)
+		if string(a.line) == a.end {
+			a.line, a.done = nil, true
+		} else if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+
+	n := copy(p, a.line)
+	a.line = a.line[n:]
+	return n, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
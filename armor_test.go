@@ -0,0 +1,51 @@
+package lightpatch
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArmor(t *testing.T) {
+	a := []byte(strings.Repeat("The quick brown fox jumped over the lazy dog. ", 10))
+	b := []byte(strings.Repeat("The quick brown cat jumped over the dog! ", 10))
+
+	for _, armor := range []Armor{ArmorBase64, ArmorASCII85} {
+		var patchr bytes.Buffer
+		var stats Stats
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithArmor(armor), WithStats(&stats))
+		assert.NoError(t, err)
+		patch := patchr.String()
+		assert.Equal(t, len(patch), stats.PatchSize)
+
+		lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+		assert.Equal(t, beginLine(armorLabels[armor]), lines[0])
+		assert.Equal(t, endLine(armorLabels[armor]), lines[len(lines)-1])
+		for _, line := range lines {
+			assert.True(t, len(line) <= armorLineLen, line)
+		}
+
+		var c bytes.Buffer
+		err = ApplyPatch(bytes.NewReader(a), strings.NewReader(patch), &c)
+		assert.NoError(t, err)
+		assert.Equal(t, b, c.Bytes())
+
+		// Indented, with CRLF line endings and trailing text, as when pasted
+		indented := "\n  " + strings.ReplaceAll(patch, "\n", "\r\n  ") + "-- \r\nsignature\n"
+		c.Reset()
+		err = ApplyPatch(bytes.NewReader(a), strings.NewReader(indented), &c)
+		assert.NoError(t, err)
+		assert.Equal(t, b, c.Bytes())
+
+		// Missing end line
+		truncated := strings.TrimSuffix(patch, lines[len(lines)-1]+"\n")
+		err = ApplyPatch(bytes.NewReader(a), strings.NewReader(truncated), new(bytes.Buffer))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	}
+
+	err := ApplyPatch(bytes.NewReader(a), strings.NewReader("-----BEGIN SOMETHING ELSE-----\n"), new(bytes.Buffer))
+	assert.Equal(t, ErrArmor, err)
+}
@@ -0,0 +1,68 @@
+package lightpatch
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// PatchJob is a before/after pair to be processed by MakePatchBatch.
+type PatchJob struct {
+	Before  io.Reader
+	After   io.Reader
+	Patch   io.Writer
+	Options []Option
+}
+
+// PatchResult is the outcome of a PatchJob.
+type PatchResult struct {
+	Stats Stats
+	Err   error
+}
+
+// MakePatchBatch runs MakePatch for each job using up to parallelism goroutines, or
+// GOMAXPROCS if parallelism is less than 1. Results are returned in the same order as
+// jobs, with any failure recorded in the job's result rather than stopping the batch.
+// Jobs are made with MakePatchContext, so they stop once ctx is done, and jobs that
+// have not started by then fail with ctx.Err().
+func MakePatchBatch(ctx context.Context, jobs []PatchJob, parallelism int) []PatchResult {
+	if parallelism < 1 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+
+	results := make([]PatchResult, len(jobs))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				job := jobs[i]
+				res := &results[i]
+				opts := append(job.Options[:len(job.Options):len(job.Options)], WithStats(&res.Stats))
+				res.Err = MakePatchContext(ctx, job.Before, job.After, job.Patch, opts...)
+			}
+		}()
+	}
+
+	i := 0
+dispatch:
+	for ; i < len(jobs) && ctx.Err() == nil; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	for ; i < len(jobs); i++ {
+		results[i].Err = ctx.Err()
+	}
+
+	return results
+}
@@ -0,0 +1,61 @@
+package lightpatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestMakePatchBatch(t *testing.T) {
+	before := []byte("The quick brown fox jumped over the lazy dog.")
+
+	var jobs []PatchJob
+	var patches []*bytes.Buffer
+	var afters [][]byte
+	for i := 0; i < 20; i++ {
+		after := []byte(fmt.Sprintf("The quick brown fox number %d jumped over the dog.", i))
+		patch := new(bytes.Buffer)
+		jobs = append(jobs, PatchJob{
+			Before: bytes.NewReader(before),
+			After:  bytes.NewReader(after),
+			Patch:  patch,
+		})
+		patches = append(patches, patch)
+		afters = append(afters, after)
+	}
+	jobs[7].After = failingReader{}
+
+	results := MakePatchBatch(context.Background(), jobs, 4)
+	assert.Len(t, results, len(jobs))
+
+	for i, res := range results {
+		if i == 7 {
+			assert.EqualError(t, res.Err, "read failed")
+			continue
+		}
+		assert.NoError(t, res.Err)
+		assert.Equal(t, patches[i].Len(), res.Stats.PatchSize)
+
+		var out bytes.Buffer
+		assert.NoError(t, ApplyPatch(bytes.NewReader(before), patches[i], &out))
+		assert.Equal(t, afters[i], out.Bytes())
+	}
+
+	// Nothing runs once the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = MakePatchBatch(ctx, jobs[:3], 0)
+	for _, res := range results {
+		assert.Equal(t, context.Canceled, res.Err)
+	}
+}
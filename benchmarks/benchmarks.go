@@ -0,0 +1,202 @@
+// Package benchmarks measures patch size and speed for lightpatch and other
+// approaches over a corpus of before/after file pairs.
+package benchmarks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kalafut/lightpatch"
+)
+
+// Pair is a before/after input pair.
+type Pair struct {
+	Name   string
+	Before []byte
+	After  []byte
+}
+
+// LoadCorpus reads all pairs from dir. A pair is two files named <name>_in and
+// <name>_out, the same layout used by the repository's testdata directory.
+// Files without a partner are ignored.
+func LoadCorpus(dir string) ([]Pair, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*_in"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	var pairs []Pair
+	for _, in := range matches {
+		out := strings.TrimSuffix(in, "_in") + "_out"
+		after, err := ioutil.ReadFile(out)
+		if err != nil {
+			continue
+		}
+		before, err := ioutil.ReadFile(in)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, Pair{
+			Name:   strings.TrimSuffix(filepath.Base(in), "_in"),
+			Before: before,
+			After:  after,
+		})
+	}
+
+	return pairs, nil
+}
+
+// LoadPair reads a single pair from two files.
+func LoadPair(beforeFile, afterFile string) (Pair, error) {
+	before, err := ioutil.ReadFile(beforeFile)
+	if err != nil {
+		return Pair{}, err
+	}
+	after, err := ioutil.ReadFile(afterFile)
+	if err != nil {
+		return Pair{}, err
+	}
+
+	return Pair{
+		Name:   filepath.Base(beforeFile) + "->" + filepath.Base(afterFile),
+		Before: before,
+		After:  after,
+	}, nil
+}
+
+// Approach is a way of producing and applying patches.
+type Approach struct {
+	Name  string
+	Make  func(before, after []byte) ([]byte, error)
+	Apply func(before, patch []byte) ([]byte, error)
+}
+
+// Lightpatch uses MakePatch and ApplyPatch with their default settings.
+var Lightpatch = Approach{
+	Name: "lightpatch",
+	Make: func(before, after []byte) ([]byte, error) {
+		var patch bytes.Buffer
+		err := lightpatch.MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch)
+		return patch.Bytes(), err
+	},
+	Apply: func(before, patch []byte) ([]byte, error) {
+		var out bytes.Buffer
+		err := lightpatch.ApplyPatch(bytes.NewReader(before), bytes.NewReader(patch), &out)
+		return out.Bytes(), err
+	},
+}
+
+// Naive transfers the after file in its entirety. It is the baseline any
+// diffing approach should beat.
+var Naive = Approach{
+	Name: "naive",
+	Make: func(before, after []byte) ([]byte, error) {
+		return append([]byte(nil), after...), nil
+	},
+	Apply: func(before, patch []byte) ([]byte, error) {
+		return append([]byte(nil), patch...), nil
+	},
+}
+
+// Result is the measurement of one approach on one pair.
+type Result struct {
+	Pair      string
+	Approach  string
+	AfterSize int
+	PatchSize int
+	MakeTime  time.Duration
+	ApplyTime time.Duration
+}
+
+// Ratio is the patch size relative to the after size.
+func (r Result) Ratio() float64 {
+	if r.AfterSize == 0 {
+		return 0
+	}
+	return float64(r.PatchSize) / float64(r.AfterSize)
+}
+
+// Harness runs a set of approaches over pairs.
+type Harness struct {
+	// Approaches to measure. If empty, Lightpatch and Naive are used.
+	Approaches []Approach
+
+	// Runs is the number of times each measurement is repeated. The fastest
+	// time is reported. Values less than 1 are treated as 1.
+	Runs int
+}
+
+// Run measures every approach on every pair. Each generated patch is applied
+// and checked against the after input; a mismatch is reported as an error.
+func (h Harness) Run(pairs []Pair) ([]Result, error) {
+	approaches := h.Approaches
+	if len(approaches) == 0 {
+		approaches = []Approach{Lightpatch, Naive}
+	}
+	runs := h.Runs
+	if runs < 1 {
+		runs = 1
+	}
+
+	var results []Result
+	for _, p := range pairs {
+		for _, a := range approaches {
+			res := Result{
+				Pair:      p.Name,
+				Approach:  a.Name,
+				AfterSize: len(p.After),
+			}
+
+			for i := 0; i < runs; i++ {
+				start := time.Now()
+				patch, err := a.Make(p.Before, p.After)
+				makeTime := time.Since(start)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: make: %w", p.Name, a.Name, err)
+				}
+
+				start = time.Now()
+				out, err := a.Apply(p.Before, patch)
+				applyTime := time.Since(start)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: apply: %w", p.Name, a.Name, err)
+				}
+				if !bytes.Equal(out, p.After) {
+					return nil, fmt.Errorf("%s: %s: output does not match after", p.Name, a.Name)
+				}
+
+				res.PatchSize = len(patch)
+				if i == 0 || makeTime < res.MakeTime {
+					res.MakeTime = makeTime
+				}
+				if i == 0 || applyTime < res.ApplyTime {
+					res.ApplyTime = applyTime
+				}
+			}
+
+			results = append(results, res)
+		}
+	}
+
+	return results, nil
+}
+
+// WriteTable writes results as an aligned text table.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "pair\tapproach\tafter\tpatch\tratio\tmake\tapply\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.3f\t%s\t%s\t\n",
+			r.Pair, r.Approach, r.AfterSize, r.PatchSize, r.Ratio(),
+			r.MakeTime.Round(time.Microsecond), r.ApplyTime.Round(time.Microsecond))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,50 @@
+package benchmarks
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHarness(t *testing.T) {
+	pairs, err := LoadCorpus("../testdata")
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 3)
+	assert.Equal(t, "angular", pairs[0].Name)
+
+	results, err := Harness{}.Run(pairs)
+	assert.NoError(t, err)
+	assert.Len(t, results, 6)
+
+	for i := 0; i < len(results); i += 2 {
+		lp, naive := results[i], results[i+1]
+		assert.Equal(t, "lightpatch", lp.Approach)
+		assert.Equal(t, "naive", naive.Approach)
+		assert.Equal(t, naive.AfterSize, naive.PatchSize)
+		assert.True(t, lp.PatchSize < naive.PatchSize)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteTable(&buf, results))
+	assert.Contains(t, buf.String(), "angular")
+}
+
+func TestHarnessVerifiesOutput(t *testing.T) {
+	broken := Approach{
+		Name:  "broken",
+		Make:  Naive.Make,
+		Apply: func(before, patch []byte) ([]byte, error) { return before, nil },
+	}
+	_, err := Harness{Approaches: []Approach{broken}}.Run([]Pair{{"p", []byte("a"), []byte("b")}})
+	assert.EqualError(t, err, "p: broken: output does not match after")
+
+	failing := Approach{
+		Name:  "failing",
+		Make:  func(before, after []byte) ([]byte, error) { return nil, errors.New("boom") },
+		Apply: Naive.Apply,
+	}
+	_, err = Harness{Approaches: []Approach{failing}}.Run([]Pair{{"p", []byte("a"), []byte("b")}})
+	assert.EqualError(t, err, "p: failing: make: boom")
+}
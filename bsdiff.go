@@ -0,0 +1,171 @@
+package lightpatch
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const bsdiffMagic = "BSDIFF40"
+
+var errBsdiff = errors.New("invalid bsdiff patch")
+
+// ToBsdiff converts patch to a patch in the BSDIFF40 format of bsdiff 4, which turns
+// before into the same output, so that pipelines built on bspatch can apply it. Each
+// copy of the patch becomes a run of the diff block with no differences, each insert a
+// run of the extra block, and each delete a seek in before.
+func ToBsdiff(before, patch []byte, w io.Writer) error {
+	edits, after, err := decodeEdits(before, patch)
+	if err != nil {
+		return err
+	}
+
+	// The control block is a list of triples: the length of a run of the diff block,
+	// which adds bytes of before to bytes of the diff block, the length of a run of
+	// the extra block, and how far to then seek in before.
+	var ctrl, diffs, extra []byte
+	var x, y, z int64
+	flush := func() {
+		if x != 0 || y != 0 || z != 0 {
+			ctrl = append(append(append(ctrl, bsdiffInt(x)...), bsdiffInt(y)...), bsdiffInt(z)...)
+		}
+		x, y, z = 0, 0, 0
+	}
+	for _, e := range edits {
+		switch e.Op {
+		case OpCopy:
+			if y != 0 || z != 0 {
+				flush()
+			}
+			x += int64(len(e.Text))
+			diffs = append(diffs, make([]byte, len(e.Text))...)
+		case OpInsert:
+			if z != 0 {
+				flush()
+			}
+			y += int64(len(e.Text))
+			extra = append(extra, e.Text...)
+		case OpDelete:
+			z += int64(len(e.Text))
+		}
+	}
+	flush()
+
+	ctrlBz, diffBz, extraBz := bzip2Compress(ctrl), bzip2Compress(diffs), bzip2Compress(extra)
+
+	header := append([]byte(bsdiffMagic), bsdiffInt(int64(len(ctrlBz)))...)
+	header = append(header, bsdiffInt(int64(len(diffBz)))...)
+	header = append(header, bsdiffInt(int64(len(after)))...)
+	for _, p := range [][]byte{header, ctrlBz, diffBz, extraBz} {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FromBsdiff converts a patch in the BSDIFF40 format of bsdiff 4 into a patch that
+// turns before into the same output, written to patch with the options given. The
+// bsdiff patch is applied as bspatch does, and the output diffed against before.
+func FromBsdiff(before []byte, bsdiff io.Reader, patch io.Writer, opts ...Option) error {
+	after, err := bspatch(before, bsdiff)
+	if err != nil {
+		return err
+	}
+
+	return MakePatch(bytes.NewReader(before), bytes.NewReader(after), patch, opts...)
+}
+
+// ApplyBsdiff applies a bsdiff 4 patch to before, writing the output to after. The
+// patch is converted with FromBsdiff and applied with ApplyPatch, so that the output
+// is checked against a CRC, which bsdiff patches lack.
+func ApplyBsdiff(before, bsdiff io.Reader, after io.Writer) error {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return err
+	}
+
+	var patch bytes.Buffer
+	if err := FromBsdiff(beforeBytes, bsdiff, &patch); err != nil {
+		return err
+	}
+
+	return ApplyPatch(bytes.NewReader(beforeBytes), &patch, after)
+}
+
+// bspatch applies a bsdiff 4 patch to before and returns the output.
+func bspatch(before []byte, r io.Reader) ([]byte, error) {
+	patch, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) < 32 || string(patch[:8]) != bsdiffMagic {
+		return nil, fmt.Errorf("%w: bad header", errBsdiff)
+	}
+	ctrlLen, diffLen, size := bsdiffReadInt(patch[8:]), bsdiffReadInt(patch[16:]), bsdiffReadInt(patch[24:])
+	if ctrlLen < 0 || diffLen < 0 || size < 0 || ctrlLen > int64(len(patch)-32) || diffLen > int64(len(patch)-32)-ctrlLen {
+		return nil, fmt.Errorf("%w: bad header", errBsdiff)
+	}
+	body := patch[32:]
+	ctrl := bzip2.NewReader(bytes.NewReader(body[:ctrlLen]))
+	diffs := bzip2.NewReader(bytes.NewReader(body[ctrlLen : ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(body[ctrlLen+diffLen:]))
+
+	// The output is grown as the blocks are read rather than allocated from the
+	// header, whose size may be damaged.
+	var after bytes.Buffer
+	var old int64 // Offset in before
+	triple := make([]byte, 24)
+	for int64(after.Len()) < size {
+		if _, err := io.ReadFull(ctrl, triple); err != nil {
+			return nil, fmt.Errorf("%w: control block: %v", errBsdiff, err)
+		}
+		x, y, z := bsdiffReadInt(triple), bsdiffReadInt(triple[8:]), bsdiffReadInt(triple[16:])
+		if x < 0 || y < 0 || x > size-int64(after.Len()) || y > size-int64(after.Len())-x {
+			return nil, fmt.Errorf("%w: run out of range", errBsdiff)
+		}
+
+		start := after.Len()
+		if _, err := io.CopyN(&after, diffs, x); err != nil {
+			return nil, fmt.Errorf("%w: diff block: %v", errBsdiff, err)
+		}
+		out := after.Bytes()[start:]
+		for i := range out {
+			if o := old + int64(i); o >= 0 && o < int64(len(before)) {
+				out[i] += before[o]
+			}
+		}
+		if _, err := io.CopyN(&after, extra, y); err != nil {
+			return nil, fmt.Errorf("%w: extra block: %v", errBsdiff, err)
+		}
+		old += x + z
+	}
+
+	return after.Bytes(), nil
+}
+
+// bsdiffInt encodes x as bsdiff does, in 8 bytes of sign and magnitude, least
+// significant first.
+func bsdiffInt(x int64) []byte {
+	b := make([]byte, 8)
+	if x < 0 {
+		binary.LittleEndian.PutUint64(b, uint64(-x)|1<<63)
+	} else {
+		binary.LittleEndian.PutUint64(b, uint64(x))
+	}
+	return b
+}
+
+// bsdiffReadInt decodes an integer encoded by bsdiffInt.
+func bsdiffReadInt(b []byte) int64 {
+	u := binary.LittleEndian.Uint64(b)
+	x := int64(u &^ (1 << 63))
+	if u&(1<<63) != 0 {
+		x = -x
+	}
+	return x
+}
@@ -0,0 +1,48 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBsdiff(t *testing.T) {
+	// simple.bsdiff was written with bzip2 itself, and seeks back in before.
+	before, _ := ioutil.ReadFile("testdata/simple_in")
+	want, _ := ioutil.ReadFile("testdata/simple_out")
+	golden, _ := ioutil.ReadFile("testdata/simple.bsdiff")
+	var after bytes.Buffer
+	assert.NoError(t, ApplyBsdiff(bytes.NewReader(before), bytes.NewReader(golden), &after))
+	assert.Equal(t, want, after.Bytes())
+
+	for _, name := range []string{"simple", "unicode", "angular"} {
+		a, _ := ioutil.ReadFile("testdata/" + name + "_in")
+		b, _ := ioutil.ReadFile("testdata/" + name + "_out")
+
+		var patch, bsdiff bytes.Buffer
+		assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+		assert.NoError(t, ToBsdiff(a, patch.Bytes(), &bsdiff))
+		out, err := bspatch(a, bytes.NewReader(bsdiff.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, b, out, name)
+	}
+
+	for _, x := range []int64{0, 1, -1, 1 << 40, -(1 << 40)} {
+		assert.Equal(t, x, bsdiffReadInt(bsdiffInt(x)))
+	}
+	assert.Equal(t, []byte{5, 0, 0, 0, 0, 0, 0, 0x80}, bsdiffInt(-5))
+
+	for _, bad := range [][]byte{
+		nil,
+		[]byte("BSDIFF39" + string(golden[8:])),
+		golden[:40],
+		append(append([]byte{}, golden[:24]...), append(bsdiffInt(1<<40), golden[32:]...)...),
+		append(append([]byte{}, golden[:8]...), append(bsdiffInt(1<<40), golden[16:]...)...),
+	} {
+		_, err := bspatch(before, bytes.NewReader(bad))
+		assert.True(t, errors.Is(err, errBsdiff), err)
+	}
+}
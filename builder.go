@@ -0,0 +1,69 @@
+package lightpatch
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrBuilderRange is returned by PatchBuilder when a copy or delete runs past the end
+// of before.
+var ErrBuilderRange = errors.New("edit runs past the end of before")
+
+// A PatchBuilder builds a patch from edits that are already known, such as those made
+// in an editor, without diffing. The edits are applied to before in order, and the
+// output checksum is computed as they are added, so the patch is as valid as one
+// made by MakePatch.
+type PatchBuilder struct {
+	before []byte
+	offset int // Offset of the next edit in before
+	diffs  []diff
+	after  []byte
+	cfg    config
+	start  time.Time
+}
+
+// NewPatchBuilder returns a PatchBuilder of a patch that applies to before, which is
+// written with the options given.
+func NewPatchBuilder(before []byte, opts ...Option) *PatchBuilder {
+	return &PatchBuilder{before: before, cfg: newConfig(opts), start: time.Now()}
+}
+
+// Copy copies the next n bytes of before to the output.
+func (b *PatchBuilder) Copy(n int) error {
+	if n < 0 || n > len(b.before)-b.offset {
+		return ErrBuilderRange
+	}
+	text := b.before[b.offset : b.offset+n]
+	b.diffs = append(b.diffs, diff{OpCopy, text})
+	b.after = append(b.after, text...)
+	b.offset += n
+	return nil
+}
+
+// Insert inserts data into the output.
+func (b *PatchBuilder) Insert(data []byte) {
+	text := append([]byte(nil), data...)
+	b.diffs = append(b.diffs, diff{OpInsert, text})
+	b.after = append(b.after, text...)
+}
+
+// Delete skips the next n bytes of before.
+func (b *PatchBuilder) Delete(n int) error {
+	if n < 0 || n > len(b.before)-b.offset {
+		return ErrBuilderRange
+	}
+	b.diffs = append(b.diffs, diff{OpDelete, b.before[b.offset : b.offset+n]})
+	b.offset += n
+	return nil
+}
+
+// Finish copies the rest of before to the output and writes the patch to patch. As
+// with MakePatch, adjacent edits of the same kind are merged, and a patch that would
+// be larger than one inserting the whole output is written as that instead.
+func (b *PatchBuilder) Finish(patch io.Writer) error {
+	if err := b.Copy(len(b.before) - b.offset); err != nil {
+		return err
+	}
+	return writePatch(patch, b.before, b.after, diffCleanupMerge(b.diffs), b.cfg, b.start, 0)
+}
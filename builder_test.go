@@ -0,0 +1,49 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchBuilder(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+
+	for _, opts := range [][]Option{nil, {WithChecksum(ChecksumBLAKE3), WithReplace()}} {
+		b := NewPatchBuilder(a, opts...)
+		assert.NoError(t, b.Copy(16))
+		assert.NoError(t, b.Delete(3))
+		b.Insert([]byte("ca"))
+		b.Insert([]byte("t"))
+		assert.NoError(t, b.Copy(5))
+		assert.NoError(t, b.Delete(2))
+		b.Insert([]byte("s"))
+		assert.Equal(t, ErrBuilderRange, b.Copy(len(a)))
+		assert.Equal(t, ErrBuilderRange, b.Delete(-1))
+
+		var patch, after bytes.Buffer
+		assert.NoError(t, b.Finish(&patch))
+		assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+		assert.Equal(t, "The quick brown cat jumps over the lazy dog.", after.String())
+
+		// Adjacent inserts are merged, and the rest of before is copied.
+		edits, err := Edits(a, patch.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, []Edit{
+			{OpCopy, []byte("The quick brown ")},
+			{OpDelete, []byte("fox")},
+			{OpInsert, []byte("cat")},
+			{OpCopy, []byte(" jump")},
+			{OpDelete, []byte("ed")},
+			{OpInsert, []byte("s")},
+			{OpCopy, []byte(" over the lazy dog.")},
+		}, edits)
+	}
+
+	// An empty builder copies before.
+	var patch, after bytes.Buffer
+	assert.NoError(t, NewPatchBuilder(a).Finish(&patch))
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, a, after.Bytes())
+}
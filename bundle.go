@@ -0,0 +1,159 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrUnverified is returned by ApplyBundle for a bundle that doesn't record the
+// checksum of an entry it replaces.
+var ErrUnverified = errors.New("bundle has no checksum of an entry")
+
+// ApplyBundle applies a bundle, a tree patch made by MakeTreePatch, to the directory
+// tree rootDir, as ApplyTreePatch does, but without holding the files in memory or
+// leaving a half-patched tree behind:
+//
+//   - The checksum of every entry the bundle replaces is checked, returning
+//     ErrBeforeMismatch if one has changed since the bundle was made.
+//   - Every patch is applied to a staging directory inside rootDir.
+//   - The changes are then committed by renaming entries into place. If one fails,
+//     those already made are rolled back.
+//
+// Renames are atomic only within a filesystem, so rootDir should not contain mount
+// points.
+func ApplyBundle(rootDir string, bundle io.Reader, opts ...Option) error {
+	changes, err := ReadTreePatch(bundle)
+	if err != nil {
+		return err
+	}
+
+	if err := checkTree(rootDir, changes, true); err != nil {
+		return err
+	}
+
+	staging, err := ioutil.TempDir(rootDir, ".lightpatch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	for i, c := range changes {
+		if c.Op == TreeDeleted || c.Op == TreeDir {
+			continue
+		}
+		if err := stageChange(rootDir, filepath.Join(staging, strconv.Itoa(i)), c, opts); err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+	}
+
+	return commitBundle(rootDir, staging, changes)
+}
+
+// stageChange writes the file or symlink resulting from the change c to name.
+func stageChange(rootDir, name string, c TreeChange, opts []Option) error {
+	switch c.Op {
+	case TreeAdded:
+		return ioutil.WriteFile(name, c.Data, 0666)
+	case TreeSymlink:
+		return os.Symlink(string(c.Data), name)
+	}
+
+	before, err := os.Open(filepath.Join(rootDir, filepath.FromSlash(c.Path)))
+	if err != nil {
+		return err
+	}
+	defer before.Close()
+	info, err := before.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The staged file replaces before, so it keeps the permissions of before, as
+	// ApplyTreePatch does by rewriting it in place.
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		out.Close()
+		return err
+	}
+	if err := ApplyPatch(before, bytes.NewReader(c.Data), out, opts...); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// commitBundle moves the entries staged for changes into rootDir, first moving the
+// entries they replace into staging, and creates the directories they add. If a step
+// fails, the steps already made are undone in reverse.
+func commitBundle(rootDir, staging string, changes []TreeChange) (err error) {
+	var undo []func()
+	defer func() {
+		if err != nil {
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+		}
+	}()
+
+	// Move the old entries aside first, in case a directory is replaced by a file.
+	for i, c := range changes {
+		if !replaces(c) {
+			continue
+		}
+		target := filepath.Join(rootDir, filepath.FromSlash(c.Path))
+		backup := filepath.Join(staging, strconv.Itoa(i)+".orig")
+		if err := os.Rename(target, backup); err != nil {
+			return err
+		}
+		undo = append(undo, func() {
+			os.MkdirAll(filepath.Dir(target), 0777)
+			os.Rename(backup, target)
+		})
+		removeEmptyDirs(rootDir, c.Path)
+	}
+
+	for i, c := range changes {
+		if c.Op == TreeDeleted {
+			continue
+		}
+		p := c.Path
+		target := filepath.Join(rootDir, filepath.FromSlash(p))
+		if c.Op == TreeDir {
+			if _, err := os.Lstat(target); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(target, 0777); err != nil {
+				removeEmptyDirs(rootDir, p)
+				return err
+			}
+			undo = append(undo, func() {
+				os.Remove(target)
+				removeEmptyDirs(rootDir, p)
+			})
+			continue
+		}
+
+		staged := filepath.Join(staging, strconv.Itoa(i))
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		if err := os.Rename(staged, target); err != nil {
+			removeEmptyDirs(rootDir, p)
+			return err
+		}
+		undo = append(undo, func() {
+			os.Rename(target, staged)
+			removeEmptyDirs(rootDir, p)
+		})
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBundle(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "lightpatch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	before := map[string]string{
+		"fox.txt":       "The quick brown fox jumped over the lazy dog.",
+		"old/gone.txt":  "deleted",
+		"becomes-a-dir": "a file, for now",
+	}
+	after := map[string]string{
+		"fox.txt":            "The quick brown cat jumped over the dog!",
+		"becomes-a-dir/file": "a file in a directory",
+		"z/new.txt":          "added",
+	}
+	a, b := filepath.Join(tmp, "a"), filepath.Join(tmp, "b")
+	writeTree(t, a, before)
+	writeTree(t, b, after)
+
+	var bundle bytes.Buffer
+	assert.NoError(t, MakeTreePatch(a, b, &bundle))
+
+	dir := filepath.Join(tmp, "dir")
+	writeTree(t, dir, before)
+	assert.NoError(t, ApplyBundle(dir, bytes.NewReader(bundle.Bytes())))
+	assert.Equal(t, after, readTree(t, dir))
+
+	// Only the tree is left, without the staging directory.
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	// A changed file keeps its permissions.
+	dir = filepath.Join(tmp, "mode")
+	writeTree(t, dir, before)
+	fox := filepath.Join(dir, "fox.txt")
+	assert.NoError(t, os.Chmod(fox, 0755))
+	assert.NoError(t, ApplyBundle(dir, bytes.NewReader(bundle.Bytes())))
+	info, err := os.Stat(fox)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.Equal(t, after["fox.txt"], readTree(t, dir)["fox.txt"])
+
+	// A changed file is found before anything is written.
+	dir = filepath.Join(tmp, "changed")
+	writeTree(t, dir, before)
+	writeTree(t, dir, map[string]string{"old/gone.txt": "edited since"})
+	err = ApplyBundle(dir, bytes.NewReader(bundle.Bytes()))
+	assert.True(t, errors.Is(err, ErrBeforeMismatch), err)
+	assert.Equal(t, "The quick brown fox jumped over the lazy dog.", readTree(t, dir)["fox.txt"])
+
+	// A change that fails when committed rolls back the others: here z is a file, so
+	// z/new.txt can't be created after the rest of the tree has been changed.
+	dir = filepath.Join(tmp, "blocked")
+	writeTree(t, dir, before)
+	writeTree(t, dir, map[string]string{"z": "in the way"})
+	assert.Error(t, ApplyBundle(dir, bytes.NewReader(bundle.Bytes())))
+	want := map[string]string{"z": "in the way"}
+	for p, content := range before {
+		want[p] = content
+	}
+	assert.Equal(t, want, readTree(t, dir))
+	entries, err = ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	// Bundles must record the checksums of the files they change.
+	var unverified bytes.Buffer
+	bw := bufio.NewWriter(&unverified)
+	bw.WriteString(treeMagic)
+	writeTreeRecord(bw, TreeDeleted, "fox.txt", nil)
+	assert.NoError(t, bw.Flush())
+	err = ApplyBundle(filepath.Join(tmp, "a"), &unverified)
+	assert.True(t, errors.Is(err, ErrUnverified), err)
+	assert.Equal(t, before, readTree(t, a))
+}
@@ -0,0 +1,337 @@
+package lightpatch
+
+import (
+	"bytes"
+	"sort"
+)
+
+// The standard library only reads bzip2, so bsdiff patches are compressed with this
+// minimal encoder. It uses a single Huffman table per block, which costs a little
+// size against bzip2 itself, but the output is read by any bzip2 decoder.
+
+const (
+	bzBlockMax   = 900000 - 19 // Data per block after the initial run-length coding
+	bzMaxCodeLen = 17
+	bzGroupSize  = 50
+)
+
+// bzCRCTable is the table of the CRC-32 used by bzip2, which is the CRC-32 of
+// crc32.IEEE computed most significant bit first.
+var bzCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// bzBitWriter writes bits most significant first.
+type bzBitWriter struct {
+	buf  bytes.Buffer
+	bits uint64
+	n    uint
+}
+
+func (w *bzBitWriter) write(n uint, v uint64) {
+	w.bits = w.bits<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.n -= 8
+		w.buf.WriteByte(byte(w.bits >> w.n))
+	}
+}
+
+// flush pads the last byte with zeros.
+func (w *bzBitWriter) flush() {
+	if w.n > 0 {
+		w.write(8-w.n, 0)
+	}
+}
+
+// bzip2Compress returns data compressed as a bzip2 stream.
+func bzip2Compress(data []byte) []byte {
+	var w bzBitWriter
+	w.buf.WriteString("BZh9")
+
+	var combined uint32
+	block := make([]byte, 0, bzBlockMax)
+	crc := ^uint32(0)
+	for i := 0; i < len(data); {
+		// Runs of 4 to 255 bytes are written as 4 bytes and the number of the rest.
+		c := data[i]
+		run := 1
+		for i+run < len(data) && data[i+run] == c && run < 255 {
+			run++
+		}
+		size := run
+		if run >= 4 {
+			size = 5
+		}
+		if len(block)+size > bzBlockMax {
+			combined = combined<<1 | combined>>31 ^ ^crc
+			bzWriteBlock(&w, block, ^crc)
+			block, crc = block[:0], ^uint32(0)
+		}
+
+		for j := 0; j < run; j++ {
+			crc = crc<<8 ^ bzCRCTable[byte(crc>>24)^c]
+		}
+		if run >= 4 {
+			block = append(block, c, c, c, c, byte(run-4))
+		} else {
+			block = append(block, data[i:i+run]...)
+		}
+		i += run
+	}
+	if len(block) > 0 {
+		combined = combined<<1 | combined>>31 ^ ^crc
+		bzWriteBlock(&w, block, ^crc)
+	}
+
+	w.write(24, 0x177245)
+	w.write(24, 0x385090)
+	w.write(32, uint64(combined))
+	w.flush()
+	return w.buf.Bytes()
+}
+
+// bzWriteBlock writes a block of run-length coded data, whose original bytes have the
+// given CRC.
+func bzWriteBlock(w *bzBitWriter, block []byte, crc uint32) {
+	// The Burrows-Wheeler transform is the last byte of each rotation of the block,
+	// in sorted order.
+	n := len(block)
+	bwt := make([]byte, n)
+	origPtr := 0
+	for i, p := range sortRotations(block) {
+		if p == 0 {
+			origPtr = i
+		}
+		bwt[i] = block[(p+n-1)%n]
+	}
+
+	var inUse [256]bool
+	for _, c := range block {
+		inUse[c] = true
+	}
+	var mtf []byte
+	for c := range inUse {
+		if inUse[c] {
+			mtf = append(mtf, byte(c))
+		}
+	}
+
+	// Move-to-front code the transform, writing runs of zeros in bijective base 2
+	// with the symbols RUNA and RUNB. Other values v are written as v+1.
+	const runA, runB = 0, 1
+	eob := uint16(len(mtf) + 1)
+	var syms []uint16
+	zeros := 0
+	flushZeros := func() {
+		for zeros > 0 {
+			zeros--
+			if zeros&1 == 0 {
+				syms = append(syms, runA)
+			} else {
+				syms = append(syms, runB)
+			}
+			zeros >>= 1
+		}
+	}
+	for _, c := range bwt {
+		j := bytes.IndexByte(mtf, c)
+		copy(mtf[1:j+1], mtf[:j])
+		mtf[0] = c
+		if j == 0 {
+			zeros++
+			continue
+		}
+		flushZeros()
+		syms = append(syms, uint16(j+1))
+	}
+	flushZeros()
+	syms = append(syms, eob)
+
+	freq := make([]int, eob+1)
+	for _, s := range syms {
+		freq[s]++
+	}
+	lengths := bzCodeLengths(freq)
+	codes := bzCodes(lengths)
+
+	w.write(24, 0x314159)
+	w.write(24, 0x265359)
+	w.write(32, uint64(crc))
+	w.write(1, 0) // Not randomized
+	w.write(24, uint64(origPtr))
+
+	var used uint64
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				used |= 1 << uint(15-i)
+				break
+			}
+		}
+	}
+	w.write(16, used)
+	for i := 0; i < 16; i++ {
+		if used&(1<<uint(15-i)) == 0 {
+			continue
+		}
+		var bits uint64
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				bits |= 1 << uint(15-j)
+			}
+		}
+		w.write(16, bits)
+	}
+
+	// At least two tables are required, so the table is written twice and every
+	// group of symbols selects the first, whose move-to-front code is a single 0.
+	selectors := (len(syms) + bzGroupSize - 1) / bzGroupSize
+	w.write(3, 2)
+	w.write(15, uint64(selectors))
+	for i := 0; i < selectors; i++ {
+		w.write(1, 0)
+	}
+	for t := 0; t < 2; t++ {
+		cur := lengths[0]
+		w.write(5, uint64(cur))
+		for _, l := range lengths {
+			for ; cur < l; cur++ {
+				w.write(2, 2)
+			}
+			for ; cur > l; cur-- {
+				w.write(2, 3)
+			}
+			w.write(1, 0)
+		}
+	}
+
+	for _, s := range syms {
+		w.write(uint(lengths[s]), uint64(codes[s]))
+	}
+}
+
+// sortRotations returns the offsets of the rotations of text in sorted order, by
+// prefix doubling like suffixArray. Equal rotations are in any order.
+func sortRotations(text []byte) []int {
+	n := len(text)
+	sa := make([]int, n)
+	rank := make([]int, n)
+	tmp := make([]int, n)
+	for i := range sa {
+		sa[i] = i
+		rank[i] = int(text[i])
+	}
+	count := make([]int, max(256, n)+1)
+
+	for k := 1; k < n; k <<= 1 {
+		second := func(i int) int {
+			return rank[(i+k)%n]
+		}
+		countingSort(sa, tmp, count, second)
+		countingSort(tmp, sa, count, func(i int) int { return rank[i] })
+
+		tmp[sa[0]] = 0
+		for i := 1; i < n; i++ {
+			tmp[sa[i]] = tmp[sa[i-1]]
+			if rank[sa[i]] != rank[sa[i-1]] || second(sa[i]) != second(sa[i-1]) {
+				tmp[sa[i]]++
+			}
+		}
+		rank, tmp = tmp, rank
+
+		if rank[sa[n-1]] == n-1 {
+			break
+		}
+	}
+	return sa
+}
+
+// bzCodeLengths returns the lengths of a Huffman code for freq of at most
+// bzMaxCodeLen bits. Every symbol has a code, as bzip2 requires.
+func bzCodeLengths(freq []int) []uint8 {
+	weights := make([]int, len(freq))
+	for i, f := range freq {
+		weights[i] = max(f, 1)
+	}
+
+	for {
+		lengths := huffmanLengths(weights)
+		longest := uint8(0)
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= bzMaxCodeLen {
+			return lengths
+		}
+
+		// Flatten the distribution and try again, as bzip2 does.
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for weights, which has
+// at least two symbols.
+func huffmanLengths(weights []int) []uint8 {
+	type node struct {
+		weight int
+		parent int
+	}
+	nodes := make([]node, len(weights), 2*len(weights))
+	active := make([]int, len(weights))
+	for i, w := range weights {
+		nodes[i] = node{w, -1}
+		active[i] = i
+	}
+
+	for len(active) > 1 {
+		sort.Slice(active, func(i, j int) bool {
+			return nodes[active[i]].weight < nodes[active[j]].weight
+		})
+		a, b := active[0], active[1]
+		nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+		active = append(active[2:], len(nodes)-1)
+	}
+
+	lengths := make([]uint8, len(weights))
+	for i := range lengths {
+		for p := nodes[i].parent; p >= 0; p = nodes[p].parent {
+			lengths[i]++
+		}
+	}
+	return lengths
+}
+
+// bzCodes returns the canonical codes of the given lengths: shorter codes come first,
+// and codes of the same length are in the order of their symbols.
+func bzCodes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	var code uint32
+	for l := uint8(1); l <= bzMaxCodeLen; l++ {
+		for s, sl := range lengths {
+			if sl == l {
+				codes[s] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
@@ -0,0 +1,35 @@
+package lightpatch
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBzip2Compress(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 1000000)
+	r.Read(random)
+	angular, _ := ioutil.ReadFile("testdata/angular_in")
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("banana bandana"),
+		bytes.Repeat([]byte("x"), 1000),
+		bytes.Repeat([]byte("ab"), 1000),
+		random[:1000],
+		angular,
+		// Spans two blocks.
+		random,
+	} {
+		out, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(bzip2Compress(data))))
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), len(out))
+		assert.True(t, bytes.Equal(data, out))
+	}
+}
@@ -0,0 +1,106 @@
+package lightpatch
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// The chunk sizes of content-defined chunking. Chunks average about cdcAvgSize
+// bytes, and are never smaller than cdcMinSize or larger than cdcMaxSize, except for
+// the last chunk of a text.
+const (
+	cdcMinSize = 2 << 10
+	cdcAvgSize = 8 << 10
+	cdcMaxSize = 64 << 10
+
+	// The masks of FastCDC's normalized chunking for 8 KiB chunks, with more bits set
+	// before the average size is reached and fewer after, so chunk sizes cluster around
+	// it. The bits are spread out to make the most of the gear hash.
+	cdcMaskS = 0x0003590703530000 // 15 bits
+	cdcMaskL = 0x0000d90003530000 // 11 bits
+)
+
+// cdcGear is the table of random values of the gear hash.
+var cdcGear = func() (gear [256]uint64) {
+	// splitmix64, so the table, and hence the boundaries, never change.
+	var x uint64
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		gear[i] = z ^ z>>31
+	}
+	return gear
+}()
+
+// diffCDC splits both texts into chunks at boundaries chosen by their content with
+// FastCDC, anchors the diff on the chunks of after found in before, and diffs only the
+// gaps between them. Since a boundary depends only on the bytes just before it, an edit
+// moves the boundaries of the chunks around it alone, so the chunks of the rest of the
+// texts still match, however far the edit shifts them. It suits inputs of many
+// gigabytes that are mostly unchanged.
+func diffCDC(text1, text2 []byte, timeout time.Duration) []diff {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	return diffAnchors(text1, text2, cdcMatches(text1, text2), deadline)
+}
+
+// cdcMatches returns the chunks of text2 that are also chunks of text1, as anchors.
+func cdcMatches(text1, text2 []byte) []anchor {
+	chunks := map[uint64]int{}
+	for a := 0; a < len(text1); {
+		n := cdcCut(text1[a:])
+		h := xxh3.Hash(text1[a : a+n])
+		if _, dup := chunks[h]; !dup {
+			chunks[h] = a
+		}
+		a += n
+	}
+
+	var anchors []anchor
+	for b := 0; b < len(text2); {
+		n := cdcCut(text2[b:])
+		if a, ok := chunks[xxh3.Hash(text2[b:b+n])]; ok && bytes.Equal(text1[a:a+n], text2[b:b+n]) {
+			anchors = append(anchors, anchor{a: a, b: b, n: n})
+		}
+		b += n
+	}
+	return anchors
+}
+
+// cdcCut returns the length of the first chunk of data, with FastCDC.
+func cdcCut(data []byte) int {
+	n := len(data)
+	if n <= cdcMinSize {
+		return n
+	}
+	if n > cdcMaxSize {
+		n = cdcMaxSize
+	}
+	normal := cdcAvgSize
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := cdcMinSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&cdcMaskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&cdcMaskL == 0 {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package lightpatch
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCDCChunks(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 1<<20)
+	rng.Read(data)
+
+	chunks := func(data []byte) map[string]bool {
+		set := map[string]bool{}
+		for len(data) > 0 {
+			n := cdcCut(data)
+			assert.True(t, n <= cdcMaxSize)
+			if n < len(data) {
+				assert.True(t, n >= cdcMinSize)
+			}
+			set[string(data[:n])] = true
+			data = data[n:]
+		}
+		return set
+	}
+
+	a := chunks(data)
+	assert.InDelta(t, len(data)/cdcAvgSize, len(a), float64(len(data)/cdcAvgSize)/2)
+
+	// An insert near the start shifts all that follows, but only disturbs the chunks
+	// around it.
+	b := chunks(append([]byte("inserted"), data...))
+	var shared int
+	for c := range b {
+		if a[c] {
+			shared++
+		}
+	}
+	assert.True(t, shared >= len(a)-2, "%d of %d chunks shared", shared, len(a))
+}
+
+func TestAlgorithmCDC(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	before := make([]byte, 4<<20)
+	rng.Read(before)
+
+	// A few edits far apart, each shifting the rest of the input.
+	var after []byte
+	after = append(after, before[:1<<20]...)
+	after = append(after, "inserted"...)
+	after = append(after, before[1<<20:2<<20]...)
+	after = append(after, before[2<<20+100:3<<20]...)
+	after = append(after, 'x')
+	after = append(after, before[3<<20+1:]...)
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, WithAlgorithm(AlgorithmCDC)))
+	assert.True(t, patch.Len() < 200, "patch too large: %d", patch.Len())
+
+	var out bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(before), &patch, &out))
+	assert.Equal(t, after, out.Bytes())
+
+	for _, tc := range [][2]string{
+		{"", ""},
+		{"", "abc"},
+		{"abc", ""},
+		{"The quick brown fox jumped over the lazy dog.", "The quick brown cat jumped over the dog!"},
+	} {
+		patch.Reset()
+		assert.NoError(t, MakePatch(bytes.NewReader([]byte(tc[0])), bytes.NewReader([]byte(tc[1])), &patch, WithAlgorithm(AlgorithmCDC)))
+
+		out.Reset()
+		assert.NoError(t, ApplyPatch(bytes.NewReader([]byte(tc[0])), &patch, &out))
+		assert.Equal(t, tc[1], out.String())
+	}
+	assert.Equal(t, "cdc", AlgorithmCDC.String())
+}
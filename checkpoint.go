@@ -0,0 +1,102 @@
+package lightpatch
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/zeebo/xxh3"
+	"lukechampine.com/blake3"
+)
+
+// ErrCheckpoint is returned by ApplyPatch when the output so far doesn't have the
+// checksum of a checkpoint.
+var ErrCheckpoint = errors.New("checkpoint mismatch")
+
+// errBadCheckpoint is returned for a checkpoint whose size isn't that of the hash.
+var errBadCheckpoint = fmt.Errorf("%w: bad checkpoint record", ErrMalformed)
+
+// WithCheckpoints causes MakePatch to write a checkpoint every n bytes of output, so
+// that ApplyPatch detects a wrong before or a damaged patch after at most n bytes of
+// wrong output, rather than at the end. Copies and inserts are split at the
+// checkpoints.
+func WithCheckpoints(n int) Option {
+	return func(c *config) {
+		c.checkpoints = n
+	}
+}
+
+// checkpointer tracks the output of the records written to a patch, and writes the
+// checkpoints. A nil checkpointer writes none.
+type checkpointer struct {
+	every int64
+	next  int64 // Output at which the next checkpoint is due
+	out   int64 // Output so far
+	h     hash.Hash
+}
+
+// newCheckpointer returns the checkpointer of cfg, or nil if it has no checkpoints.
+func newCheckpointer(cfg config) *checkpointer {
+	if cfg.checkpoints <= 0 {
+		return nil
+	}
+
+	var h hash.Hash
+	switch cfg.checksum {
+	case ChecksumBLAKE3:
+		h = blake3.New(blake3Size, nil)
+	case ChecksumXXH3:
+		h = xxh3.New()
+	default:
+		h = crc32.NewIEEE()
+	}
+	return &checkpointer{every: int64(cfg.checkpoints), next: int64(cfg.checkpoints), h: h}
+}
+
+// split cuts the copies and inserts of diffs that would take the output past a
+// checkpoint, so that one ends at each checkpoint.
+func (c *checkpointer) split(diffs []diff) []diff {
+	if c == nil {
+		return diffs
+	}
+
+	var out []diff
+	pos, next := c.out, c.next
+	for _, d := range diffs {
+		if d.Type == OpDelete {
+			out = append(out, d)
+			continue
+		}
+		for pos+int64(len(d.Text)) > next {
+			// A diff starting at a checkpoint follows the one it was written after.
+			if k := next - pos; k > 0 {
+				out = append(out, diff{d.Type, d.Text[:k]})
+				d.Text = d.Text[k:]
+			}
+			pos, next = next, next+c.every
+		}
+		pos += int64(len(d.Text))
+		out = append(out, d)
+	}
+	return out
+}
+
+// output adds data to the output, and writes a checkpoint to patch if one is due.
+func (c *checkpointer) output(patch io.Writer, data []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	c.h.Write(data)
+	c.out += int64(len(data))
+	if c.out < c.next {
+		return nil
+	}
+	c.next = c.out - c.out%c.every + c.every
+
+	sum := c.h.Sum(nil)
+	_, err := patch.Write(append([]byte{OpCheckpoint, byte(len(sum))}, sum...))
+	return err
+}
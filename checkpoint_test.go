@@ -0,0 +1,55 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoints(t *testing.T) {
+	a := strings.Repeat("The quick brown fox jumped over the lazy dog. ", 20)
+	b := strings.Replace(a, "fox", "cat", 1) + "The end."
+
+	for _, c := range []Checksum{ChecksumCRC32, ChecksumBLAKE3, ChecksumXXH3} {
+		var patch bytes.Buffer
+		assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch, WithChecksum(c), WithCheckpoints(100)))
+		assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+		var after bytes.Buffer
+		assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+		assert.Equal(t, b, after.String())
+
+		// A checkpoint follows every 100 bytes of output.
+		var text bytes.Buffer
+		assert.NoError(t, ToText(bytes.NewReader(patch.Bytes()), &text))
+		assert.Equal(t, len(b)/100, strings.Count(text.String(), "\nP "), c)
+
+		var bin bytes.Buffer
+		assert.NoError(t, ToBinary(&text, &bin))
+		assert.Equal(t, patch.Bytes(), bin.Bytes())
+
+		// The wrong before fails at the first checkpoint after the difference.
+		wrong := a[:300] + "x" + a[301:]
+		after.Reset()
+		err := ApplyPatch(strings.NewReader(wrong), bytes.NewReader(patch.Bytes()), &after)
+		assert.Equal(t, ErrCheckpoint, err)
+		assert.Equal(t, 400, after.Len())
+
+		edits, err := Edits([]byte(a), patch.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, []Edit{{OpCopy, []byte(a[:16])}, {OpDelete, []byte("fox")}, {OpInsert, []byte("cat")}}, edits[:3])
+	}
+
+	// The stream writer checkpoints across windows.
+	var patch, after bytes.Buffer
+	assert.NoError(t, MakePatchStream(strings.NewReader(a), strings.NewReader(b), &patch, WithCheckpoints(64)))
+	assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.String())
+	assert.Equal(t, len(b)/64, bytes.Count(patch.Bytes(), []byte{OpCheckpoint, 4}))
+
+	bad := append([]byte{OpCheckpoint, 1, 0}, patch.Bytes()...)
+	err := ApplyPatch(strings.NewReader(a), bytes.NewReader(bad), new(bytes.Buffer))
+	assert.Equal(t, &DecodeError{Op: OpCheckpoint, Err: errBadCheckpoint}, err)
+}
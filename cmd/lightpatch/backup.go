@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/kalafut/lightpatch/store"
+	"github.com/kalafut/lightpatch/store/kv"
+)
+
+// timeLayouts are the accepted forms of restore --at, in local time unless a zone is given.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// openRepo opens the backup repository in dir, and returns the key of file in it,
+// which is its absolute path.
+func openRepo(dir, file string) (*store.Chain, string, error) {
+	backend, err := kv.NewDir(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return nil, "", err
+	}
+	return store.NewChain(backend), filepath.ToSlash(key), nil
+}
+
+// backup records the content of file as its next version in the repository at dir.
+func backup(file, dir string) (version, size int, err error) {
+	c, key, err := openRepo(dir, file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	version, err = c.Put(key, content)
+	return version, len(content), err
+}
+
+// restore writes the latest version of file backed up at or before at to w, or the
+// latest version if at is empty.
+func restore(file, dir, at string, w io.Writer) (version, size int, err error) {
+	c, key, err := openRepo(dir, file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var content []byte
+	if at == "" {
+		content, version, err = c.Get(key)
+		if err == store.ErrNotFound {
+			return 0, 0, fmt.Errorf("no backups of %s in %s", file, dir)
+		}
+	} else {
+		var t time.Time
+		if t, err = parseTime(at); err != nil {
+			return 0, 0, err
+		}
+		content, version, err = c.AtTime(key, t)
+		if err == store.ErrNotFound {
+			return 0, 0, fmt.Errorf("no backups of %s in %s at or before %s", file, dir, t.Format(time.RFC3339))
+		}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	_, err = w.Write(content)
+	return version, len(content), err
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			// A bare date means the end of that day.
+			if layout == "2006-01-02" {
+				t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, expected RFC 3339 or YYYY-MM-DD [HH:MM[:SS]]", s)
+}
@@ -29,4 +29,37 @@ if ! ($CMD apply "$TMPDIR/random_in" "$TMPDIR/random.patch" | cmp -s "$TMPDIR/ra
   echo Failed random test; exit 1
 fi
 
+# Test journal replay
+$CMD make $TD/simple_in $TD/simple_out > "$TMPDIR/1.patch"
+$CMD make $TD/simple_out $TD/unicode_out > "$TMPDIR/2.patch"
+$CMD journal "$TMPDIR/1.patch" "$TMPDIR/2.patch" > "$TMPDIR/test.lpj"
+if ! ($CMD replay $TD/simple_in < "$TMPDIR/test.lpj" | cmp -s $TD/unicode_out); then
+  echo Failed replay test; exit 1
+fi
+
+# Test backup and restore
+rm -rf "$TMPDIR/repo"
+cp $TD/simple_in "$TMPDIR/backup"
+$CMD backup "$TMPDIR/backup" --repo "$TMPDIR/repo"
+cp $TD/simple_out "$TMPDIR/backup"
+$CMD backup "$TMPDIR/backup" --repo "$TMPDIR/repo"
+if ! ($CMD restore "$TMPDIR/backup" --repo "$TMPDIR/repo" | cmp -s $TD/simple_out); then
+  echo Failed restore test; exit 1
+fi
+if $CMD restore "$TMPDIR/backup" --repo "$TMPDIR/repo" --at 2000-01-01 2> /dev/null; then
+  echo Failed restore --at test; exit 1
+fi
+
+# Test unified diff against patch(1)
+$CMD diff $TD/unicode_in $TD/unicode_out > "$TMPDIR/test.diff"
+if ! (patch -s -o - $TD/unicode_in < "$TMPDIR/test.diff" | cmp -s $TD/unicode_out); then
+  echo Failed diff test; exit 1
+fi
+if ! ($CMD apply --unified $TD/unicode_in "$TMPDIR/test.diff" | cmp -s $TD/unicode_out); then
+  echo Failed apply --unified test; exit 1
+fi
+if $CMD apply --unified --max-output 10 $TD/unicode_in "$TMPDIR/test.diff" > /dev/null 2>&1; then
+  echo Failed apply --unified --max-output test; exit 1
+fi
+
 echo All test completed successfully
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kong"
+)
+
+// writeCompletions emits a completion script for shell, generated from the kong
+// model so that new commands and flags are picked up automatically.
+func writeCompletions(w io.Writer, app *kong.Application, shell string) error {
+	switch shell {
+	case "bash":
+		return writeBash(w, app)
+	case "zsh":
+		return writeZsh(w, app)
+	case "fish":
+		return writeFish(w, app)
+	}
+	return fmt.Errorf("unsupported shell: %s", shell)
+}
+
+func writeBash(w io.Writer, app *kong.Application) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "_%s() {\n", app.Name)
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n",
+		strings.Join(append(commandNames(app.Node), flagNames(app.Node)...), " "))
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range commands(app.Node) {
+		fmt.Fprintf(&b, "    %s)\n", cmd.Name)
+		b.WriteString("        case \"$cur\" in\n")
+		fmt.Fprintf(&b, "        -*) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n",
+			strings.Join(append(flagNames(cmd), flagNames(app.Node)...), " "))
+		if enum := positionalEnum(cmd); enum != "" {
+			fmt.Fprintf(&b, "        *) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", enum)
+		} else {
+			b.WriteString("        *) COMPREPLY=($(compgen -f -- \"$cur\")) ;;\n")
+		}
+		b.WriteString("        esac\n")
+		b.WriteString("        ;;\n")
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o filenames -F _%s %s\n", app.Name, app.Name)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeZsh(w io.Writer, app *kong.Application) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "#compdef %s\n\n", app.Name)
+	fmt.Fprintf(&b, "_%s() {\n", app.Name)
+	b.WriteString("    local -a commands\n")
+	b.WriteString("    commands=(\n")
+	for _, cmd := range commands(app.Node) {
+		fmt.Fprintf(&b, "        %s\n", zshQuote(cmd.Name+":"+cmd.Help))
+	}
+	b.WriteString("    )\n\n")
+	b.WriteString("    if (( CURRENT == 2 )); then\n")
+	b.WriteString("        _describe 'command' commands\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+	b.WriteString("    case \"$words[2]\" in\n")
+	for _, cmd := range commands(app.Node) {
+		fmt.Fprintf(&b, "    %s)\n", cmd.Name)
+		b.WriteString("        _arguments -s \\\n")
+		for _, f := range append(visibleFlags(cmd), visibleFlags(app.Node)...) {
+			fmt.Fprintf(&b, "            %s \\\n", zshQuote("--"+f.Name+"["+f.Help+"]"))
+		}
+		for _, p := range cmd.Positional {
+			action := "_files"
+			if p.Enum != "" {
+				action = "(" + strings.Replace(p.Enum, ",", " ", -1) + ")"
+			}
+			fmt.Fprintf(&b, "            %s \\\n", zshQuote(":"+p.Name+":"+action))
+		}
+		b.WriteString("        ;;\n")
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "_%s \"$@\"\n", app.Name)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFish(w io.Writer, app *kong.Application) error {
+	var b strings.Builder
+
+	names := strings.Join(commandNames(app.Node), " ")
+
+	for _, cmd := range commands(app.Node) {
+		fmt.Fprintf(&b, "complete -c %s -f -n 'not __fish_seen_subcommand_from %s' -a %s -d %s\n",
+			app.Name, names, cmd.Name, fishQuote(cmd.Help))
+	}
+	for _, f := range visibleFlags(app.Node) {
+		fmt.Fprintf(&b, "complete -c %s%s -d %s\n", app.Name, fishFlag(f), fishQuote(f.Help))
+	}
+	for _, cmd := range commands(app.Node) {
+		cond := fmt.Sprintf("-n '__fish_seen_subcommand_from %s'", cmd.Name)
+		for _, f := range visibleFlags(cmd) {
+			fmt.Fprintf(&b, "complete -c %s %s%s -d %s\n", app.Name, cond, fishFlag(f), fishQuote(f.Help))
+		}
+		if enum := positionalEnum(cmd); enum != "" {
+			fmt.Fprintf(&b, "complete -c %s %s -f -a %s\n", app.Name, cond, fishQuote(enum))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// commands returns the visible subcommands of node, sorted by name.
+func commands(node *kong.Node) []*kong.Node {
+	var out []*kong.Node
+	for _, child := range node.Children {
+		if child.Type == kong.CommandNode && !child.Hidden {
+			out = append(out, child)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func commandNames(node *kong.Node) []string {
+	var out []string
+	for _, cmd := range commands(node) {
+		out = append(out, cmd.Name)
+	}
+	return out
+}
+
+func visibleFlags(node *kong.Node) []*kong.Flag {
+	var out []*kong.Flag
+	for _, f := range node.Flags {
+		if !f.Hidden {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func flagNames(node *kong.Node) []string {
+	var out []string
+	for _, f := range visibleFlags(node) {
+		out = append(out, "--"+f.Name)
+		if f.Short != 0 {
+			out = append(out, "-"+string(f.Short))
+		}
+	}
+	return out
+}
+
+// positionalEnum returns the space-separated enum values of a command's first
+// positional argument, or "" if it is not an enum (e.g. a file name).
+func positionalEnum(cmd *kong.Node) string {
+	if len(cmd.Positional) == 0 || cmd.Positional[0].Enum == "" {
+		return ""
+	}
+	return strings.Replace(cmd.Positional[0].Enum, ",", " ", -1)
+}
+
+func fishFlag(f *kong.Flag) string {
+	s := " -l " + f.Name
+	if f.Short != 0 {
+		s += " -s " + string(f.Short)
+	}
+	return s
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
@@ -1,50 +1,601 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/kalafut/lightpatch"
+	"github.com/kalafut/lightpatch/benchmarks"
+	"github.com/kalafut/lightpatch/store/kv"
 )
 
 var CLI struct {
+	Verbose   bool   `short:"v" help:"Log timings and patch decisions to stderr."`
+	Quiet     bool   `short:"q" help:"Only log errors."`
+	LogFormat string `enum:"text,json" default:"text" help:"Format of diagnostic output (text, json)."`
+
 	Make struct {
-		BeforeFile *os.File      `arg help:"Before file"`
-		AfterFile  *os.File      `arg help:"After file"`
-		TimeLimit  time.Duration `name:"t" default:"5s" help:"Max time to build patch."`
+		BeforeFile  *os.File      `arg help:"Before file"`
+		AfterFile   *os.File      `arg help:"After file"`
+		TimeLimit   time.Duration `name:"t" default:"5s" help:"Max time to build patch."`
+		Summary     bool          `help:"Print a one-line summary of the patch to stderr."`
+		Algorithm   string        `enum:"myers,suffixarray,rollinghash,cdc" default:"myers" help:"Matching algorithm (myers, suffixarray, rollinghash, cdc)."`
+		Granularity string        `enum:"byte,sentence,markdown,xml" default:"byte" help:"Unit to diff by (byte, sentence, markdown, xml)."`
+		Checksum    string        `enum:"crc32,blake3,xxh3" default:"crc32" help:"Checksum of the output embedded in the patch (crc32, blake3, xxh3)."`
+		Armor       string        `enum:"none,base64,ascii85" default:"none" help:"Text encoding of the patch, for pasting into other documents (none, base64, ascii85)."`
+
+		Replace         bool     `help:"Write adjacent deletes and inserts as one replace op. Older versions of lightpatch can't apply such patches."`
+		CopyToEnd       bool     `help:"Write a final copy without its length. Older versions of lightpatch can't apply such patches."`
+		InsertChecksums bool     `help:"Checksum each insert, so the patch can be checked with 'verify'."`
+		BeforeChecksum  bool     `help:"Checksum 'before', so 'apply' refuses a different 'before' before writing anything. Older versions of lightpatch can't apply such patches."`
+		Checkpoints     int      `placeholder:"SIZE" help:"Checksum the output every SIZE bytes, so 'apply' stops soon after the output goes wrong. Older versions of lightpatch can't apply such patches."`
+		Gzip            bool     `help:"Compress the patch with gzip. Older versions of lightpatch can't apply such patches."`
+		Dict            string   `type:"path" help:"Compress the patch with this dictionary of content typical of the files, which 'apply --dict' then needs."`
+		Provenance      bool     `help:"Record the lightpatch version and options in the patch, shown by 'info'."`
+		NotAfter        string   `placeholder:"TIMESTAMP" help:"Record a time after which 'apply --strict' refuses the patch (RFC 3339, or YYYY-MM-DD [HH:MM[:SS]] in local time)."`
+		Annotate        []string `sep:"none" help:"Add an annotation to the patch. May be repeated."`
+		FEC             []int    `name:"fec" placeholder:"DATA,PARITY" help:"Add Reed-Solomon error correction with DATA data shards and PARITY parity shards."`
+		Chunks          string   `type:"path" help:"Store the data of large inserts in this directory, shared between patches, instead of in the patch."`
+		DedupMin        int      `default:"4096" help:"Minimum size of an insert stored with --chunks."`
+		BlockAlign      int      `placeholder:"SIZE" help:"Align insert data and pad the patch to blocks of SIZE bytes."`
+		MinSimilarity   float64  `placeholder:"FRACTION" help:"Fail instead of writing a patch if less than this fraction of 'after' is copied from 'before'."`
+		Deterministic   bool     `help:"Ignore the time limit, so the same files always give the same patch, e.g. for reproducible builds. May take much longer."`
+		MaxMemory       int      `placeholder:"BYTES" help:"Insert 'after' whole instead of diffing if the diff could need more than this much memory."`
+		Stream          bool     `help:"Diff the files a window at a time, for files too large to hold in memory. The patch may be larger."`
+		Tar             bool     `help:"Diff two tar archives member by member, matching members by name, so moved members and changed headers cost little. Apply with 'apply --tar'."`
+		Zip             bool     `help:"Diff the decompressed entries of two zip archives, writing a bundle of per-entry patches. Apply with 'apply --zip', or to an extracted directory with 'apply-tree'."`
+		JSON            bool     `name:"json" help:"Write an RFC 6902 JSON Patch of two JSON documents instead, which ignores whitespace and the order of object members. Other options don't apply."`
+		EditContext     int      `placeholder:"N" help:"Record N bytes of context around each change, so 'apply --fuzzy' can find it in a changed 'before'. Older versions of lightpatch can't apply such patches."`
 	} `cmd help:"Make a patch file to turn 'before' into 'after'."`
 
 	Apply struct {
-		BeforeFile *os.File `arg help:"Before filename"`
-		PatchFile  *os.File `arg help:"Patch filename"`
+		BeforeFile   *os.File `arg help:"Before filename"`
+		PatchFile    *os.File `arg help:"Patch filename"`
+		Ed           bool     `xor:"format" help:"The patch file is an ed script or RCS delta, as written by diff -e or diff -n."`
+		DMP          bool     `xor:"format" help:"The patch file is in the text format of diff-match-patch, as written by patch_toText."`
+		DMPDelta     bool     `xor:"format" name:"dmp-delta" help:"The patch file is a diff-match-patch delta, as written by diff_toDelta."`
+		JSON         bool     `xor:"format" name:"json" help:"The patch file is an RFC 6902 JSON Patch, as written by 'make --json'."`
+		Bsdiff       bool     `xor:"format" help:"The patch file is a bsdiff 4 patch, as written by bsdiff."`
+		Tar          bool     `xor:"format" help:"The patch file is a patch of tar archives, as written by 'make --tar'."`
+		Zip          bool     `xor:"format" help:"The patch file is a bundle of the entries of zip archives, as written by 'make --zip'."`
+		Unified      bool     `xor:"format" help:"The patch file is a unified diff of one file, as written by diff -u or git diff."`
+		Fuzzy        bool     `xor:"format" help:"If 'before' has changed since the patch was made, find the changes by the context recorded with 'make --edit-context'."`
+		Chunks       string   `type:"path" help:"Directory of insert data stored by 'make --chunks'."`
+		Dict         string   `type:"path" help:"Dictionary the patch was compressed with by 'make --dict'."`
+		Strict       bool     `help:"Refuse patches whose expiry time, set by 'make --not-after', has passed, and patches not in the form 'make' writes, e.g. with empty records, no checksum, or part of 'before' left unread."`
+		MaxOutput    int64    `placeholder:"BYTES" help:"Stop with an error before the output grows past this size."`
+		MaxExpansion float64  `placeholder:"RATIO" help:"Stop with an error once the output is more than this many times the size of 'before' and the patch read so far."`
 	} `cmd help:"Apply a patch file."`
+
+	MakeTree struct {
+		BeforeDir string `arg type:"existingdir" help:"Before directory"`
+		AfterDir  string `arg type:"existingdir" help:"After directory"`
+	} `cmd help:"Make a patch of every file added, deleted or changed between two directory trees."`
+
+	ApplyTree struct {
+		Dir       string   `arg type:"existingdir" help:"Directory to patch, in place"`
+		PatchFile *os.File `arg help:"Tree patch filename"`
+	} `cmd help:"Apply a patch made by 'make-tree' to a directory tree, changing all of its files or none."`
+
+	Diff struct {
+		BeforeFile *os.File `arg help:"Before file"`
+		AfterFile  *os.File `arg help:"After file"`
+		Context    int      `short:"U" default:"3" help:"Lines of context around each change."`
+	} `cmd help:"Print a unified diff of 'before' and 'after', for review tools and patch(1)."`
+
+	Replay struct {
+		BeforeFile *os.File `arg help:"Before filename"`
+	} `cmd help:"Apply the patches of a journal read from stdin in order, and write the final output."`
+
+	Journal struct {
+		PatchFiles []*os.File `arg help:"Patch filenames, in the order they apply"`
+	} `cmd help:"Write a journal of patch files, for replay, to stdout."`
+
+	Verify struct {
+		PatchFile *os.File `arg help:"Patch filename"`
+	} `cmd help:"Check a patch file for damage without applying it."`
+
+	Info struct {
+		PatchFile *os.File `arg help:"Patch filename"`
+	} `cmd help:"Print the metadata recorded in a patch file."`
+
+	Convert struct {
+		PatchFile *os.File `arg help:"Patch filename"`
+		To        string   `enum:"text,binary,ed,dmp,dmp-delta,bsdiff" default:"text" help:"Format to convert the patch to (text, binary, ed, dmp, dmp-delta, bsdiff)."`
+		Before    *os.File `help:"Before file, needed to convert to an ed script, diff-match-patch or bsdiff format."`
+	} `cmd help:"Convert a patch file between the binary and text formats, or to an ed script, diff-match-patch or bsdiff patch."`
+
+	Canonicalize struct {
+		File *os.File `arg help:"XML filename"`
+	} `cmd help:"Print the canonical form of an XML document, which patches made with --granularity xml apply to."`
+
+	Backup struct {
+		File string `arg type:"existingfile" help:"File to back up"`
+		Repo string `required type:"path" help:"Directory holding the backup history."`
+	} `cmd help:"Back up a file, storing only its changes since the last backup."`
+
+	Restore struct {
+		File string `arg help:"Backed up filename"`
+		Repo string `required type:"path" help:"Directory holding the backup history."`
+		At   string `placeholder:"TIMESTAMP" help:"Restore the last backup taken at or before this time (RFC 3339, or YYYY-MM-DD [HH:MM[:SS]] in local time). Defaults to the latest."`
+	} `cmd help:"Write a backed up version of a file to stdout."`
+
+	Bench struct {
+		Paths []string `arg help:"Corpus directory of <name>_in/<name>_out pairs, or a before and after file."`
+		Runs  int      `default:"1" help:"Number of runs per measurement; the fastest is reported."`
+	} `cmd help:"Compare patch size and speed against a naive full copy."`
+
+	Completions struct {
+		Shell string `arg enum:"bash,zsh,fish" help:"Shell to generate completions for (bash, zsh, fish)."`
+	} `cmd help:"Print a shell completion script."`
+}
+
+var algorithms = map[string]lightpatch.Algorithm{
+	"myers":       lightpatch.AlgorithmMyers,
+	"suffixarray": lightpatch.AlgorithmSuffixArray,
+	"rollinghash": lightpatch.AlgorithmRollingHash,
+	"cdc":         lightpatch.AlgorithmCDC,
+}
+
+var granularities = map[string]lightpatch.Granularity{
+	"byte":     lightpatch.GranularityByte,
+	"sentence": lightpatch.GranularitySentence,
+	"markdown": lightpatch.GranularityMarkdown,
+	"xml":      lightpatch.GranularityXML,
+}
+
+var armors = map[string]lightpatch.Armor{
+	"none":    lightpatch.ArmorNone,
+	"base64":  lightpatch.ArmorBase64,
+	"ascii85": lightpatch.ArmorASCII85,
+}
+
+var checksums = map[string]lightpatch.Checksum{
+	"crc32":  lightpatch.ChecksumCRC32,
+	"blake3": lightpatch.ChecksumBLAKE3,
+	"xxh3":   lightpatch.ChecksumXXH3,
 }
 
 func main() {
 	ctx := kong.Parse(&CLI)
+	log := newLogger(os.Stderr, CLI.Verbose, CLI.Quiet, CLI.LogFormat)
+
 	switch ctx.Command() {
 	case "make <before-file> <after-file>":
-		if err := lightpatch.MakePatchTimeout(
-			CLI.Make.BeforeFile,
-			CLI.Make.AfterFile,
-			os.Stdout,
-			CLI.Make.TimeLimit,
-		); err != nil {
-			fmt.Fprintf(os.Stderr, "error creating patch: %s\n", err)
+		if CLI.Make.JSON {
+			if err := lightpatch.MakeJSONPatch(CLI.Make.BeforeFile, CLI.Make.AfterFile, os.Stdout); err != nil {
+				log.Errorf(err, "error creating JSON patch")
+				os.Exit(1)
+			}
+			break
+		}
+		var stats lightpatch.Stats
+		opts := []lightpatch.Option{
+			lightpatch.WithStats(&stats),
+			lightpatch.WithAlgorithm(algorithms[CLI.Make.Algorithm]),
+			lightpatch.WithGranularity(granularities[CLI.Make.Granularity]),
+			lightpatch.WithChecksum(checksums[CLI.Make.Checksum]),
+			lightpatch.WithArmor(armors[CLI.Make.Armor]),
+			lightpatch.WithTimeout(CLI.Make.TimeLimit),
+		}
+		if CLI.Make.Replace {
+			opts = append(opts, lightpatch.WithReplace())
+		}
+		if CLI.Make.CopyToEnd {
+			opts = append(opts, lightpatch.WithCopyToEnd())
+		}
+		if CLI.Make.InsertChecksums {
+			opts = append(opts, lightpatch.WithInsertChecksums())
+		}
+		if CLI.Make.BeforeChecksum {
+			opts = append(opts, lightpatch.WithBeforeChecksum())
+		}
+		if CLI.Make.Checkpoints > 0 {
+			opts = append(opts, lightpatch.WithCheckpoints(CLI.Make.Checkpoints))
+		}
+		if CLI.Make.Gzip {
+			opts = append(opts, lightpatch.WithGzip())
+		}
+		if CLI.Make.Dict != "" {
+			dict, err := ioutil.ReadFile(CLI.Make.Dict)
+			if err != nil {
+				log.Errorf(err, "error reading dictionary")
+				os.Exit(1)
+			}
+			opts = append(opts, lightpatch.WithDictionary(dict))
+		}
+		if CLI.Make.Provenance {
+			opts = append(opts, lightpatch.WithProvenance())
+		}
+		if CLI.Make.NotAfter != "" {
+			t, err := parseTime(CLI.Make.NotAfter)
+			if err != nil {
+				ctx.Fatalf("invalid --not-after: %v", err)
+			}
+			opts = append(opts, lightpatch.WithNotAfter(t))
+		}
+		for _, note := range CLI.Make.Annotate {
+			opts = append(opts, lightpatch.WithAnnotation([]byte(note)))
+		}
+		if len(CLI.Make.FEC) > 0 {
+			if len(CLI.Make.FEC) != 2 {
+				ctx.Fatalf("--fec expects DATA,PARITY")
+			}
+			opts = append(opts, lightpatch.WithFEC(CLI.Make.FEC[0], CLI.Make.FEC[1]))
+		}
+		if CLI.Make.BlockAlign > 0 {
+			opts = append(opts, lightpatch.WithBlockAlign(CLI.Make.BlockAlign))
+		}
+		if CLI.Make.EditContext > 0 {
+			opts = append(opts, lightpatch.WithEditContext(CLI.Make.EditContext))
+		}
+		if CLI.Make.MinSimilarity > 0 {
+			opts = append(opts, lightpatch.WithMinSimilarity(CLI.Make.MinSimilarity))
+		}
+		if CLI.Make.Deterministic {
+			opts = append(opts, lightpatch.WithDeterministic())
+		}
+		if CLI.Make.MaxMemory > 0 {
+			opts = append(opts, lightpatch.WithMaxMemory(CLI.Make.MaxMemory))
+		}
+		if CLI.Make.Chunks != "" {
+			chunks, err := kv.NewDir(CLI.Make.Chunks)
+			if err != nil {
+				log.Errorf(err, "error opening chunk directory")
+				os.Exit(1)
+			}
+			opts = append(opts, lightpatch.WithDedup(chunks, CLI.Make.DedupMin))
+		}
+		var err error
+		out := bufio.NewWriter(os.Stdout)
+		switch {
+		case CLI.Make.Tar:
+			err = lightpatch.MakeTarPatch(CLI.Make.BeforeFile, CLI.Make.AfterFile, out, opts...)
+		case CLI.Make.Zip:
+			err = lightpatch.MakeZipPatch(CLI.Make.BeforeFile, CLI.Make.AfterFile, out, opts...)
+		case CLI.Make.Stream:
+			err = lightpatch.MakePatchStream(CLI.Make.BeforeFile, CLI.Make.AfterFile, out, opts...)
+		default:
+			err = makePatchMapped(CLI.Make.BeforeFile, CLI.Make.AfterFile, out, opts)
+		}
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			log.Errorf(err, "error creating patch")
 			os.Exit(1)
 		}
+		for _, w := range stats.Warnings {
+			switch w {
+			case lightpatch.WarningTimeout:
+				log.Warn(w.String(), "timeout", CLI.Make.TimeLimit)
+			case lightpatch.WarningNaive:
+				log.Info("diff larger than after file, using full insert", "after_bytes", stats.AfterSize)
+			default:
+				log.Warn(w.String())
+			}
+		}
+		log.Info("patch created",
+			"before_bytes", stats.BeforeSize,
+			"after_bytes", stats.AfterSize,
+			"patch_bytes", stats.PatchSize,
+			"ops", stats.Ops,
+			"duration", stats.Duration,
+		)
+		if CLI.Make.Summary {
+			printSummary(log, stats)
+		}
 	case "apply <before-file> <patch-file>":
-		if err := lightpatch.ApplyPatch(
+		start := time.Now()
+		bw := bufio.NewWriter(os.Stdout)
+		out := &countingWriter{w: bw}
+		foreign := CLI.Apply.Ed || CLI.Apply.DMP || CLI.Apply.DMPDelta || CLI.Apply.Bsdiff || CLI.Apply.Unified || CLI.Apply.JSON
+		if foreign && (CLI.Apply.Strict || CLI.Apply.MaxOutput > 0 || CLI.Apply.MaxExpansion > 0) {
+			ctx.Fatalf("--strict, --max-output and --max-expansion only apply to lightpatch patches")
+		}
+		var opts []lightpatch.Option
+		if CLI.Apply.Chunks != "" {
+			chunks, err := kv.NewDir(CLI.Apply.Chunks)
+			if err != nil {
+				log.Errorf(err, "error opening chunk directory")
+				os.Exit(1)
+			}
+			opts = append(opts, lightpatch.WithDedup(chunks, 0))
+		}
+		if CLI.Apply.Strict {
+			opts = append(opts, lightpatch.WithStrict())
+		}
+		if CLI.Apply.MaxOutput > 0 {
+			opts = append(opts, lightpatch.WithMaxOutputSize(CLI.Apply.MaxOutput))
+		}
+		if CLI.Apply.MaxExpansion > 0 {
+			opts = append(opts, lightpatch.WithMaxExpansion(CLI.Apply.MaxExpansion))
+		}
+		if CLI.Apply.Dict != "" {
+			dict, err := ioutil.ReadFile(CLI.Apply.Dict)
+			if err != nil {
+				log.Errorf(err, "error reading dictionary")
+				os.Exit(1)
+			}
+			lightpatch.RegisterDictionary(dict)
+		}
+		apply := func(before, patch io.Reader, after io.Writer) error {
+			return applyPatchFile(CLI.Apply.BeforeFile, patch, after, opts)
+		}
+		if CLI.Apply.Fuzzy {
+			apply = func(before, patch io.Reader, after io.Writer) error {
+				return lightpatch.ApplyPatchFuzzy(before, patch, after, opts...)
+			}
+		}
+		if CLI.Apply.Ed {
+			apply = lightpatch.ApplyEd
+		}
+		if CLI.Apply.DMP {
+			apply = lightpatch.ApplyDMP
+		}
+		if CLI.Apply.DMPDelta {
+			apply = lightpatch.ApplyDMPDelta
+		}
+		if CLI.Apply.Bsdiff {
+			apply = lightpatch.ApplyBsdiff
+		}
+		if CLI.Apply.Unified {
+			apply = lightpatch.ApplyUnifiedDiff
+		}
+		if CLI.Apply.JSON {
+			apply = lightpatch.ApplyJSONPatch
+		}
+		if CLI.Apply.Tar {
+			apply = func(before, patch io.Reader, after io.Writer) error {
+				return lightpatch.ApplyTarPatch(before, patch, after, opts...)
+			}
+		}
+		if CLI.Apply.Zip {
+			apply = func(before, patch io.Reader, after io.Writer) error {
+				return lightpatch.ApplyZipPatch(before, patch, after, opts...)
+			}
+		}
+		err := apply(
 			CLI.Apply.BeforeFile,
 			CLI.Apply.PatchFile,
-			os.Stdout,
-		); err != nil {
-			fmt.Fprintf(os.Stderr, "error applying patch: %s\n", err)
+			out,
+		)
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			log.Errorf(err, "error applying patch")
+			os.Exit(1)
+		}
+		log.Info("patch applied", "output_bytes", out.n, "duration", time.Since(start))
+	case "make-tree <before-dir> <after-dir>":
+		if err := lightpatch.MakeTreePatch(CLI.MakeTree.BeforeDir, CLI.MakeTree.AfterDir, os.Stdout); err != nil {
+			log.Errorf(err, "error making tree patch")
+			os.Exit(1)
+		}
+	case "apply-tree <dir> <patch-file>":
+		if err := lightpatch.ApplyBundle(CLI.ApplyTree.Dir, CLI.ApplyTree.PatchFile); err != nil {
+			log.Errorf(err, "error applying tree patch")
+			os.Exit(1)
+		}
+	case "diff <before-file> <after-file>":
+		diff := func(before, after []byte, w io.Writer) error {
+			return lightpatch.UnifiedDiff(before, after, CLI.Diff.BeforeFile.Name(), CLI.Diff.AfterFile.Name(), CLI.Diff.Context, w)
+		}
+		if err := convertWithBefore(diff, CLI.Diff.BeforeFile, CLI.Diff.AfterFile, os.Stdout); err != nil {
+			log.Errorf(err, "error writing diff")
+			os.Exit(1)
+		}
+	case "replay <before-file>":
+		start := time.Now()
+		out := &countingWriter{w: os.Stdout}
+		if err := lightpatch.Replay(CLI.Replay.BeforeFile, os.Stdin, out); err != nil {
+			log.Errorf(err, "error replaying journal")
+			os.Exit(1)
+		}
+		log.Info("journal replayed", "output_bytes", out.n, "duration", time.Since(start))
+	case "journal <patch-files>":
+		if err := writeJournal(CLI.Journal.PatchFiles, os.Stdout); err != nil {
+			log.Errorf(err, "error writing journal")
+			os.Exit(1)
+		}
+	case "verify <patch-file>":
+		if err := lightpatch.VerifyPatch(CLI.Verify.PatchFile); err != nil {
+			log.Errorf(err, "invalid patch")
+			os.Exit(1)
+		}
+	case "info <patch-file>":
+		m, err := lightpatch.ReadMetadata(CLI.Info.PatchFile)
+		if err != nil {
+			log.Errorf(err, "error reading patch")
+			os.Exit(1)
+		}
+		printMetadata(m)
+	case "convert <patch-file>":
+		convert := lightpatch.ToText
+		switch CLI.Convert.To {
+		case "binary":
+			convert = lightpatch.ToBinary
+		case "ed", "dmp", "dmp-delta", "bsdiff":
+			if CLI.Convert.Before == nil {
+				ctx.Fatalf("--to %s requires --before", CLI.Convert.To)
+			}
+			to := map[string]func(before, patch []byte, w io.Writer) error{
+				"ed":        lightpatch.ToEd,
+				"dmp":       lightpatch.ToDMP,
+				"dmp-delta": lightpatch.ToDMPDelta,
+				"bsdiff":    lightpatch.ToBsdiff,
+			}[CLI.Convert.To]
+			convert = func(patch io.Reader, w io.Writer) error {
+				return convertWithBefore(to, CLI.Convert.Before, patch, w)
+			}
+		}
+		if err := convert(CLI.Convert.PatchFile, os.Stdout); err != nil {
+			log.Errorf(err, "error converting patch")
+			os.Exit(1)
+		}
+	case "canonicalize <file>":
+		if err := lightpatch.CanonicalXML(CLI.Canonicalize.File, os.Stdout); err != nil {
+			log.Errorf(err, "error canonicalizing XML")
+			os.Exit(1)
+		}
+	case "backup <file>":
+		version, size, err := backup(CLI.Backup.File, CLI.Backup.Repo)
+		if err != nil {
+			log.Errorf(err, "error backing up file")
+			os.Exit(1)
+		}
+		log.Info("file backed up", "version", version, "bytes", size)
+	case "restore <file>":
+		version, size, err := restore(CLI.Restore.File, CLI.Restore.Repo, CLI.Restore.At, os.Stdout)
+		if err != nil {
+			log.Errorf(err, "error restoring file")
+			os.Exit(1)
+		}
+		log.Info("file restored", "version", version, "bytes", size)
+	case "bench <paths>":
+		if err := bench(CLI.Bench.Paths, CLI.Bench.Runs); err != nil {
+			log.Errorf(err, "error running benchmark")
+			os.Exit(1)
+		}
+	case "completions <shell>":
+		if err := writeCompletions(os.Stdout, ctx.Model, CLI.Completions.Shell); err != nil {
+			log.Errorf(err, "error generating completions")
 			os.Exit(1)
 		}
 	default:
 		panic(ctx.Command())
 	}
 }
+
+// convertWithBefore reads before and patch in full and converts the patch with to,
+// for formats that need before.
+func convertWithBefore(to func(before, patch []byte, w io.Writer) error, before, patch io.Reader, w io.Writer) error {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return err
+	}
+	patchBytes, err := ioutil.ReadAll(patch)
+	if err != nil {
+		return err
+	}
+	return to(beforeBytes, patchBytes, w)
+}
+
+// applyPatchFile applies patch to the file before, reading only the parts of before
+// that it copies if before is a regular file or a block device, which can be read at
+// any offset.
+func applyPatchFile(before *os.File, patch io.Reader, after io.Writer, opts []lightpatch.Option) error {
+	info, err := before.Stat()
+	if err != nil {
+		return err
+	}
+	mode := info.Mode()
+	if !mode.IsRegular() && (mode&os.ModeDevice == 0 || mode&os.ModeCharDevice != 0) {
+		return lightpatch.ApplyPatch(before, patch, after, opts...)
+	}
+	data, err := ioutil.ReadAll(patch)
+	if err != nil {
+		return err
+	}
+	return lightpatch.ApplyPatchAt(before, data, after, opts...)
+}
+
+// writeJournal writes a journal of patches to w.
+func writeJournal(patches []*os.File, w io.Writer) error {
+	j := lightpatch.NewJournalWriter(w)
+	for _, f := range patches {
+		patch, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		if err := j.Append(patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printMetadata(m lightpatch.Metadata) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("%s: %s\n", k, m[k])
+	}
+}
+
+func bench(paths []string, runs int) error {
+	var pairs []benchmarks.Pair
+
+	switch len(paths) {
+	case 1:
+		var err error
+		if pairs, err = benchmarks.LoadCorpus(paths[0]); err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			return fmt.Errorf("no <name>_in/<name>_out pairs found in %s", paths[0])
+		}
+	case 2:
+		pair, err := benchmarks.LoadPair(paths[0], paths[1])
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, pair)
+	default:
+		return errors.New("expected a corpus directory or a before and after file")
+	}
+
+	results, err := benchmarks.Harness{Runs: runs}.Run(pairs)
+	if err != nil {
+		return err
+	}
+
+	return benchmarks.WriteTable(os.Stdout, results)
+}
+
+// printSummary writes a one-line report of the patch efficiency, independent of verbosity.
+func printSummary(log *logger, stats lightpatch.Stats) {
+	var pct float64
+	if stats.AfterSize > 0 {
+		pct = 100 * float64(stats.PatchSize) / float64(stats.AfterSize)
+	}
+
+	if log.json {
+		log.emit(levelInfo, "summary",
+			"patch_bytes", stats.PatchSize,
+			"after_pct", pct,
+			"ops", stats.Ops,
+			"duration", stats.Duration,
+			"naive", stats.Naive,
+			"timed_out", stats.TimedOut,
+		)
+		return
+	}
+
+	fmt.Fprintf(log.w, "patch: %d bytes (%.1f%% of after), %d ops, %s, naive=%t, timeout=%t\n",
+		stats.PatchSize, pct, stats.Ops, stats.Duration.Round(time.Microsecond), stats.Naive, stats.TimedOut)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
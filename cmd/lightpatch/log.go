@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type level int
+
+const (
+	levelError level = iota
+	levelWarn
+	levelInfo
+)
+
+func (l level) String() string {
+	switch l {
+	case levelError:
+		return "error"
+	case levelWarn:
+		return "warn"
+	}
+	return "info"
+}
+
+// logger writes diagnostics to stderr, either as plain text or as one JSON object per line.
+type logger struct {
+	w     io.Writer
+	level level
+	json  bool
+}
+
+func newLogger(w io.Writer, verbose, quiet bool, format string) *logger {
+	l := &logger{w: w, level: levelWarn, json: format == "json"}
+	if verbose {
+		l.level = levelInfo
+	}
+	if quiet {
+		l.level = levelError
+	}
+	return l
+}
+
+// Errorf logs a failure. In text mode the output matches the CLI's historical
+// "<msg>: <err>" error lines.
+func (l *logger) Errorf(err error, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !l.json {
+		fmt.Fprintf(l.w, "%s: %s\n", msg, err)
+		return
+	}
+	l.log(levelError, msg, "error", err.Error())
+}
+
+func (l *logger) Warn(msg string, kv ...interface{}) {
+	l.log(levelWarn, msg, kv...)
+}
+
+func (l *logger) Info(msg string, kv ...interface{}) {
+	l.log(levelInfo, msg, kv...)
+}
+
+// log emits msg with the given key/value pairs if lvl is enabled.
+func (l *logger) log(lvl level, msg string, kv ...interface{}) {
+	if lvl > l.level {
+		return
+	}
+	l.emit(lvl, msg, kv...)
+}
+
+// emit writes an entry regardless of the configured level.
+func (l *logger) emit(lvl level, msg string, kv ...interface{}) {
+	if l.json {
+		entry := map[string]interface{}{
+			"time":  time.Now().Format(time.RFC3339Nano),
+			"level": lvl.String(),
+			"msg":   msg,
+		}
+		for i := 0; i+1 < len(kv); i += 2 {
+			v := kv[i+1]
+			if d, ok := v.(time.Duration); ok {
+				v = d.String()
+			}
+			entry[fmt.Sprint(kv[i])] = v
+		}
+		b, _ := json.Marshal(entry)
+		fmt.Fprintf(l.w, "%s\n", b)
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s", lvl, msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&sb, " %v=%v", kv[i], kv[i+1])
+	}
+	fmt.Fprintln(l.w, sb.String())
+}
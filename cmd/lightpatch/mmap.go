@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/kalafut/lightpatch"
+)
+
+// makePatchMapped makes a patch of the files before and after, mapped into memory
+// where possible rather than read.
+func makePatchMapped(before, after *os.File, patch io.Writer, opts []lightpatch.Option) error {
+	a, unmapBefore, err := mapFile(before)
+	if err != nil {
+		return err
+	}
+	defer unmapBefore()
+
+	b, unmapAfter, err := mapFile(after)
+	if err != nil {
+		return err
+	}
+	defer unmapAfter()
+
+	return lightpatch.MakePatchBytes(a, b, patch, opts...)
+}
+
+// readFile reads the rest of f, for mapFile.
+func readFile(f *os.File) ([]byte, func() error, error) {
+	data, err := ioutil.ReadAll(f)
+	return data, func() error { return nil }, err
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+// mapFile returns the content of the file f with a function that releases it. Memory
+// mapping isn't supported on this platform, so the file is read.
+func mapFile(f *os.File) ([]byte, func() error, error) {
+	return readFile(f)
+}
@@ -0,0 +1,286 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	errCompose      = errors.New("second patch reads more than the output of first")
+	errComposeChunk = errors.New("patches with chunks can't be composed")
+)
+
+// ComposePatches combines first, a patch from A to B, and second, a patch from B to
+// C, into a single patch from A to C, without needing any of the inputs. The result
+// carries the checksum of second, since it has the same output. Metadata and
+// annotations are dropped, and checked inserts become plain inserts after their
+// CRCs are verified.
+func ComposePatches(first, second io.Reader, patch io.Writer) error {
+	ops1, _, err := readPatchOps(first)
+	if err != nil {
+		return err
+	}
+	ops2, sum, err := readPatchOps(second)
+	if err != nil {
+		return err
+	}
+	for _, op := range append(ops1[:len(ops1):len(ops1)], ops2...) {
+		if op.op == OpChunk {
+			return errComposeChunk
+		}
+	}
+
+	var out []patchOp
+	emit := func(op patchOp) {
+		if op.n == 0 {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].op == op.op {
+			out[n-1].n += op.n
+			if op.op == OpInsert {
+				out[n-1].data = append(out[n-1].data, op.data...)
+			}
+			return
+		}
+		if op.op == OpInsert {
+			op.data = append([]byte{}, op.data...)
+		}
+		out = append(out, op)
+	}
+
+	// take consumes n bytes of the output of first, passing each piece to f, along
+	// with any deletes before them, which don't produce output.
+	take := func(n int, f func(patchOp)) error {
+		for n > 0 && len(ops1) > 0 {
+			op := &ops1[0]
+			if op.op == OpDelete {
+				emit(*op)
+				ops1 = ops1[1:]
+				continue
+			}
+
+			m := op.n
+			if m > n {
+				m = n
+			}
+			piece := patchOp{op: op.op, n: m}
+			if op.op == OpInsert {
+				piece.data, op.data = op.data[:m], op.data[m:]
+			}
+			f(piece)
+
+			op.n -= m
+			n -= m
+			if op.n == 0 {
+				ops1 = ops1[1:]
+			}
+		}
+		if n > 0 {
+			return errCompose
+		}
+		return nil
+	}
+
+	for _, op := range ops2 {
+		var err error
+		switch op.op {
+		case OpInsert:
+			emit(op)
+		case OpCopy:
+			err = take(op.n, emit)
+		case OpDelete:
+			// Copied bytes of A are deleted instead, and inserted bytes are dropped.
+			err = take(op.n, func(piece patchOp) {
+				if piece.op == OpCopy {
+					emit(patchOp{op: OpDelete, n: piece.n})
+				}
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// second needn't read all of B. What is left of first is output nothing reads, so
+	// its inserts are dropped, and its copies become deletes, to still read all of A.
+	for _, op := range ops1 {
+		switch op.op {
+		case OpDelete, OpCopy:
+			emit(patchOp{op: OpDelete, n: op.n})
+		}
+	}
+
+	w := bufio.NewWriter(patch)
+	if sum[0] != OpCRC {
+		w.Write(sum)
+	}
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+	for _, op := range out {
+		w.WriteByte(op.op)
+		w.Write(varintBuf[:binary.PutUvarint(varintBuf, uint64(op.n))])
+		w.Write(op.data)
+	}
+	if sum[0] == OpCRC {
+		w.Write(sum)
+	}
+
+	return w.Flush()
+}
+
+// patchOp is a copy, delete or insert of n bytes.
+type patchOp struct {
+	op   byte
+	n    int
+	data []byte // The data of an insert
+}
+
+// readPatchOps reads the copies, deletes, inserts and chunks of patch, along with its
+// checksum record. The data of a chunk is its digest.
+func readPatchOps(patch io.Reader) (ops []patchOp, sum []byte, err error) {
+	r, err := newOpReader(patch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		op, err := r.next()
+		if err == io.EOF {
+			return ops, r.sum, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		ops = append(ops, op...)
+	}
+}
+
+// opReader reads the operations of a patch one record at a time.
+type opReader struct {
+	pr    *countingReader
+	br    *bufio.Reader
+	sum   []byte // The checksum record, once read
+	toEnd bool   // Return a copy to the end as an op of length -1, not errCopyToEnd
+}
+
+// newOpReader returns an opReader of patch, having read any leading digest record.
+func newOpReader(patch io.Reader) (*opReader, error) {
+	patch, err := openPatch(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &opReader{pr: &countingReader{r: patch}}
+	r.br = bufio.NewReader(r.pr)
+	if op, err := r.br.Peek(1); err == nil && digestSize(op[0]) > 0 {
+		r.sum = make([]byte, 1+digestSize(op[0]))
+		if _, err := io.ReadFull(r.br, r.sum); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// next returns the operations of the next record that has any, two for a replace, or
+// io.EOF after the last record. A patch without a checksum record is incomplete.
+func (r *opReader) next() ([]patchOp, error) {
+	for {
+		offset := r.pr.n - int64(r.br.Buffered())
+		op, err := r.br.ReadByte()
+		if err == io.EOF {
+			if r.sum == nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		if op == OpCRC {
+			if r.sum != nil {
+				return nil, unexpectedOp(op)
+			}
+			sum := make([]byte, 5)
+			sum[0] = op
+			if _, err := io.ReadFull(r.br, sum[1:]); err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
+			}
+			if _, err := r.br.ReadByte(); err != io.EOF {
+				return nil, ErrExtraData
+			}
+			r.sum = sum
+			return nil, io.EOF
+		}
+
+		if op == OpCopyToEnd {
+			if r.toEnd {
+				return []patchOp{{op: OpCopyToEnd, n: -1}}, nil
+			}
+			return nil, errCopyToEnd
+		}
+		if op == OpBeforeCRC {
+			if _, err := io.ReadFull(r.br, make([]byte, 4)); err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		ops, err := r.record(op, offset)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil || ops != nil {
+			return ops, err
+		}
+	}
+}
+
+// record reads the rest of a record of op, which starts at offset in the patch, and
+// returns its operations.
+func (r *opReader) record(op byte, offset int64) ([]patchOp, error) {
+	tl, err := readLength(r.br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case OpCopy, OpDelete:
+		return []patchOp{{op: op, n: int(tl)}}, nil
+	case OpInsert, OpCheckedInsert:
+		var data bytes.Buffer
+		if op == OpCheckedInsert {
+			err = copyCheckedInsert(&data, r.br, tl, offset)
+		} else {
+			_, err = io.CopyN(&data, r.br, int64(tl))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []patchOp{{op: OpInsert, n: int(tl), data: data.Bytes()}}, nil
+	case OpReplace:
+		il, err := readLength(r.br)
+		if err != nil {
+			return nil, err
+		}
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, r.br, int64(il)); err != nil {
+			return nil, err
+		}
+		return []patchOp{{op: OpDelete, n: int(tl)}, {op: OpInsert, n: int(il), data: data.Bytes()}}, nil
+	case OpChunk:
+		digest := make([]byte, blake3Size)
+		if _, err := io.ReadFull(r.br, digest); err != nil {
+			return nil, err
+		}
+		return []patchOp{{op: op, n: int(tl), data: digest}}, nil
+	case OpMetadata, OpAnnotation, OpPadding, OpContext, OpCheckpoint:
+		_, err := io.CopyN(ioutil.Discard, r.br, int64(tl))
+		return nil, err
+	}
+	return nil, unexpectedOp(op)
+}
@@ -0,0 +1,86 @@
+package lightpatch
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposePatches(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mutate := func(text []byte) []byte {
+		out := append([]byte{}, text...)
+		for i := 0; i < 3; i++ {
+			at := r.Intn(len(out) + 1)
+			switch r.Intn(3) {
+			case 0:
+				out = append(out[:at], append([]byte("inserted "), out[at:]...)...)
+			case 1:
+				end := at + r.Intn(10)
+				if end > len(out) {
+					end = len(out)
+				}
+				out = append(out[:at], out[end:]...)
+			case 2:
+				if at < len(out) {
+					out[at] = 'X'
+				}
+			}
+		}
+		return out
+	}
+
+	for i := 0; i < 200; i++ {
+		a := []byte("The quick brown fox jumped over the lazy dog, twice.")
+		b := mutate(a)
+		c := mutate(b)
+
+		var opts []Option
+		if i%2 == 1 {
+			opts = append(opts, WithChecksum(ChecksumBLAKE3), WithInsertChecksums(), WithProvenance())
+		}
+
+		var p1, p2, composed bytes.Buffer
+		assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &p1, opts...))
+		assert.NoError(t, MakePatch(bytes.NewReader(b), bytes.NewReader(c), &p2, opts...))
+		assert.NoError(t, ComposePatches(&p1, &p2, &composed))
+
+		var out bytes.Buffer
+		assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(composed.Bytes()), &out, WithStrict()))
+		assert.Equal(t, string(c), out.String())
+	}
+
+	// A delete at the end of the first patch is kept.
+	{
+		a := []byte("hello world, and goodbye")
+		var p1, p2, composed, out bytes.Buffer
+		assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader([]byte("hello world")), &p1))
+		assert.NoError(t, MakePatch(bytes.NewReader([]byte("hello world")), bytes.NewReader([]byte("hello world!")), &p2))
+		assert.NoError(t, ComposePatches(&p1, &p2, &composed))
+		assert.NoError(t, ApplyPatch(bytes.NewReader(a), &composed, &out, WithStrict()))
+		assert.Equal(t, "hello world!", out.String())
+	}
+
+	// The patches must line up.
+	var p1, p2, composed bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader([]byte("a")), bytes.NewReader([]byte("b")), &p1))
+	assert.NoError(t, MakePatch(bytes.NewReader([]byte("The quick brown fox")), bytes.NewReader([]byte("The quick brown cat")), &p2))
+	assert.Equal(t, errCompose, ComposePatches(&p1, &p2, &composed))
+
+	// The second patch needn't read all of the output of the first, as a naive patch
+	// of unrelated inputs reads none of it.
+	{
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+		b := []byte("The quick brown cat jumped over the dog!")
+		c := []byte("Something else entirely")
+		var p1, p2, composed, out bytes.Buffer
+		assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &p1))
+		assert.NoError(t, MakePatch(bytes.NewReader(b), bytes.NewReader(c), &p2))
+		assert.Equal(t, byte(OpInsert), p2.Bytes()[0], "second patch isn't naive")
+		assert.NoError(t, ComposePatches(&p1, &p2, &composed))
+		assert.NoError(t, ApplyPatch(bytes.NewReader(a), &composed, &out, WithStrict()))
+		assert.Equal(t, string(c), out.String())
+	}
+}
@@ -0,0 +1,109 @@
+package lightpatch
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// ErrUnknownDictionary is wrapped by the error returned for a patch compressed with
+// a dictionary that hasn't been registered with RegisterDictionary.
+var ErrUnknownDictionary = errors.New("unknown dictionary")
+
+var (
+	dictionariesMu sync.RWMutex
+	dictionaries   = map[uint32][]byte{}
+)
+
+// WithGzip causes MakePatch to compress the whole patch with gzip, which suits
+// patches with large inserts of compressible data. The patch is a plain gzip
+// stream, inside any FEC envelope and armor, and is decompressed automatically by
+// ApplyPatch and the other readers of patches.
+func WithGzip() Option {
+	return func(c *config) {
+		c.gzip = true
+	}
+}
+
+// WithDictionary causes MakePatch to compress the whole patch with DEFLATE, primed
+// with dict, in place of WithGzip. Patches between documents of the same family,
+// such as configs made from one template, share much of their inserted text with a
+// dictionary of typical content, so even small patches shrink a lot. Only the last
+// 32 KiB of dict are used, and content that is more common should come later.
+// DEFLATE is used rather than zstd so that lightpatch needs only the standard
+// library to compress.
+//
+// The patch refers to dict by its ID, so ApplyPatch and the other readers of patches
+// need dict to be registered with RegisterDictionary.
+func WithDictionary(dict []byte) Option {
+	return func(c *config) {
+		c.dictionary = dict
+	}
+}
+
+// RegisterDictionary registers dict for reading patches compressed with it, and
+// returns its ID, the CRC-32 of dict. It panics if a different dictionary with the
+// same ID is registered.
+func RegisterDictionary(dict []byte) uint32 {
+	dictionariesMu.Lock()
+	defer dictionariesMu.Unlock()
+
+	id := crc32.ChecksumIEEE(dict)
+	if d, dup := dictionaries[id]; dup && !bytes.Equal(d, dict) {
+		panic(fmt.Sprintf("lightpatch: RegisterDictionary called with two dictionaries of ID %08x", id))
+	}
+	dictionaries[id] = append([]byte{}, dict...)
+	return id
+}
+
+// compressor returns a writer that compresses the patch written to it to w, as
+// selected by cfg, or nil if the patch isn't compressed.
+func compressor(w io.Writer, cfg config) (io.WriteCloser, error) {
+	switch {
+	case cfg.dictionary != nil:
+		header := []byte{OpDictionary, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(header[1:], crc32.ChecksumIEEE(cfg.dictionary))
+		if _, err := w.Write(header); err != nil {
+			return nil, err
+		}
+		return flate.NewWriterDict(w, flate.BestCompression, cfg.dictionary)
+	case cfg.gzip:
+		return gzip.NewWriter(w), nil
+	}
+	return nil, nil
+}
+
+// decodeGzip is the Decoder of gzipped patches.
+func decodeGzip(r io.Reader) (io.Reader, error) {
+	zr, err := gzip.NewReader(io.MultiReader(bytes.NewReader([]byte{OpGzip}), r))
+	if err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
+
+// decodeDictionary is the Decoder of patches compressed with a dictionary:
+//
+//	Y <dictionary ID> <DEFLATE stream>
+//
+// The ID is 4 bytes.
+func decodeDictionary(r io.Reader) (io.Reader, error) {
+	id := make([]byte, 4)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, err
+	}
+
+	dictionariesMu.RLock()
+	dict, ok := dictionaries[binary.BigEndian.Uint32(id)]
+	dictionariesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownDictionary, id)
+	}
+	return flate.NewReaderDict(r, dict), nil
+}
@@ -0,0 +1,91 @@
+package lightpatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzip(t *testing.T) {
+	a := strings.Repeat("The quick brown fox jumped over the lazy dog. ", 20)
+	b := strings.Repeat("The quick brown cat jumped over the dog! ", 30)
+
+	var plain, patch bytes.Buffer
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &plain))
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch, WithGzip()))
+	assert.Less(t, patch.Len(), plain.Len())
+
+	// The patch is a gzip file of the plain patch.
+	zr, err := gzip.NewReader(bytes.NewReader(patch.Bytes()))
+	assert.NoError(t, err)
+	inner, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Bytes(), inner)
+
+	var after bytes.Buffer
+	assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.String())
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	patch.Reset()
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch, WithGzip(), WithSigningKey(priv), WithFEC(4, 2), WithArmor(ArmorBase64)))
+	after.Reset()
+	assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after, WithPublicKey(pub)))
+	assert.Equal(t, b, after.String())
+
+	patch.Reset()
+	assert.NoError(t, MakePatchStream(strings.NewReader(a), strings.NewReader(b), &patch, WithGzip()))
+	after.Reset()
+	assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.String())
+
+	// A damaged stream fails.
+	damaged := append([]byte{}, patch.Bytes()[:patch.Len()-4]...)
+	err = ApplyPatch(strings.NewReader(a), bytes.NewReader(damaged), new(bytes.Buffer))
+	assert.Error(t, err)
+}
+
+func TestDictionary(t *testing.T) {
+	config := func(host string, port int) string {
+		return fmt.Sprintf("[server]\nhost = %q\nport = %d\ntimeout = \"30s\"\nlog_level = \"info\"\n\n[database]\ndriver = \"postgres\"\nmax_connections = 20\n", host, port)
+	}
+	dict := []byte(config("example.com", 8080) + config("localhost", 443))
+	a := config("alpha.example.com", 8080)
+	b := config("beta.example.com", 9090) + "\n[cache]\ndriver = \"postgres\"\nlog_level = \"info\"\n"
+
+	var plain, patch bytes.Buffer
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &plain, WithGzip()))
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch, WithDictionary(dict)))
+	assert.Less(t, patch.Len(), plain.Len())
+	assert.Equal(t, OpDictionary, patch.Bytes()[0])
+
+	// The dictionary must be registered to read the patch.
+	err := ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrUnknownDictionary), err)
+
+	id := RegisterDictionary(dict)
+	defer delete(dictionaries, id)
+	assert.Equal(t, crc32.ChecksumIEEE(dict), id)
+	assert.Equal(t, id, RegisterDictionary(dict))
+
+	var after bytes.Buffer
+	assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.String())
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+	patch.Reset()
+	assert.NoError(t, MakePatchStream(strings.NewReader(a), strings.NewReader(b), &patch, WithDictionary(dict)))
+	after.Reset()
+	assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.String())
+}
@@ -0,0 +1,62 @@
+package lightpatch
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// withContext causes MakePatch to give up with ctx's error, writing nothing, if ctx
+// is done once the diff is complete.
+func withContext(ctx context.Context) Option {
+	return func(c *config) {
+		c.ctx = ctx
+	}
+}
+
+// MakePatchContext is like MakePatch, but gives up with ctx's error if ctx is done
+// before the patch is written. Reading the inputs stops once ctx is done, and the diff
+// is cut short at ctx's deadline if that comes before the timeout, so a request
+// deadline bounds the whole call. A cancellation without a deadline is noticed once
+// the diff, which is still bounded by the timeout, is complete.
+func MakePatchContext(ctx context.Context, before, after io.Reader, patch io.Writer, opts ...Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cfg := newConfig(opts)
+	timeout := cfg.diffTimeout(DefaultTimeout)
+	if d, ok := ctx.Deadline(); ok {
+		// A timeout of 0 has no limit, so an expired deadline is handled here.
+		if left := time.Until(d); left <= 0 {
+			return context.DeadlineExceeded
+		} else if timeout == 0 || left < timeout {
+			timeout = left
+		}
+	}
+
+	opts = append(opts[:len(opts):len(opts)], WithTimeout(timeout), withContext(ctx))
+	return MakePatch(ctxReader{ctx, before}, ctxReader{ctx, after}, patch, opts...)
+}
+
+// ApplyPatchContext is like ApplyPatch, but stops with ctx's error once ctx is done.
+// Output written before then is not undone.
+func ApplyPatchContext(ctx context.Context, before, patch io.Reader, after io.Writer, opts ...Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ApplyPatch(ctxReader{ctx, before}, ctxReader{ctx, patch}, after, opts...)
+}
+
+// ctxReader is a reader whose reads fail with ctx's error once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,51 @@
+package lightpatch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cancelReader cancels a context when it is first read.
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c cancelReader) Read(p []byte) (int, error) {
+	c.cancel()
+	return c.r.Read(p)
+}
+
+func TestPatchContext(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var patch, after bytes.Buffer
+	assert.NoError(t, MakePatchContext(context.Background(), bytes.NewReader(a), bytes.NewReader(b), &patch))
+	assert.NoError(t, ApplyPatchContext(context.Background(), bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.Bytes())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	err := MakePatchContext(ctx, bytes.NewReader(a), cancelReader{bytes.NewReader(b), cancel}, &out)
+	assert.Equal(t, context.Canceled, err)
+	assert.Zero(t, out.Len())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	out.Reset()
+	err = ApplyPatchContext(ctx, bytes.NewReader(a), cancelReader{bytes.NewReader(patch.Bytes()), cancel}, &out)
+	assert.Equal(t, context.Canceled, err)
+	assert.Zero(t, out.Len())
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	err = MakePatchContext(ctx, bytes.NewReader(a), bytes.NewReader(b), &out)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	err = ApplyPatchContext(ctx, bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &out)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,39 @@
+package lightpatch
+
+// A CostModel prices the edits of a patch. MakePatch uses it to choose between the
+// diff and a naive patch of a single insert of after, keeping whichever costs less.
+// The default model is the exact number of bytes the edits take in the patch. A
+// model reflecting the transport, e.g. the compressed size, can be set with
+// WithCostModel.
+type CostModel interface {
+	Cost(edits []Edit) int
+}
+
+// CostFunc adapts a function to a CostModel.
+type CostFunc func(edits []Edit) int
+
+// Cost returns f(edits).
+func (f CostFunc) Cost(edits []Edit) int {
+	return f(edits)
+}
+
+// WithCostModel sets the CostModel MakePatch uses to decide whether to fall back to
+// a naive patch.
+func WithCostModel(m CostModel) Option {
+	return func(c *config) {
+		c.costModel = m
+	}
+}
+
+// cost returns the cost of diffs under the cost model of cfg.
+func cost(diffs []diff, cfg config) int {
+	if cfg.costModel == nil {
+		return encodedLen(diffs, cfg)
+	}
+
+	edits := make([]Edit, len(diffs))
+	for i, d := range diffs {
+		edits[i] = Edit{Op: d.Type, Text: d.Text}
+	}
+	return cfg.costModel.Cost(edits)
+}
@@ -0,0 +1,33 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCostModel(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var stats Stats
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithStats(&stats)))
+	assert.False(t, stats.Naive)
+
+	// The default model is exact.
+	edits, err := Edits(a, patch.Bytes())
+	assert.NoError(t, err)
+	diffs := make([]diff, len(edits))
+	for i, e := range edits {
+		diffs[i] = diff{Type: e.Op, Text: e.Text}
+	}
+	assert.Equal(t, patch.Len()-5, encodedLen(diffs, config{}))
+
+	// A model where every edit has a fixed cost prefers the single insert.
+	perEdit := CostFunc(func(edits []Edit) int { return len(edits) })
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), WithStats(&stats), WithCostModel(perEdit)))
+	assert.True(t, stats.Naive)
+	assert.Equal(t, 1, stats.Ops)
+}
@@ -0,0 +1,79 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A Decoder decodes a patch format identified by its leading byte. It is given the
+// patch following that byte, and returns a reader of the patch converted to the
+// binary format, which may itself begin with the byte of another registered format.
+type Decoder func(r io.Reader) (io.Reader, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[byte]Decoder{
+		OpFEC:        decodeFEC,
+		OpSignature:  decodeSignature,
+		OpGzip:       decodeGzip,
+		OpDictionary: decodeDictionary,
+	}
+)
+
+// RegisterDecoder registers d as the decoder of patches whose first byte is version,
+// so that ApplyPatch and the other readers of patches accept a new format alongside
+// every format written before it. Formats are identified by a byte that no record of
+// the binary format starts with, so plain patches are always read as they were
+// written. RegisterDecoder panics if version is already registered or is the byte of
+// a record.
+func RegisterDecoder(version byte, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+
+	if d == nil {
+		panic("lightpatch: RegisterDecoder decoder is nil")
+	}
+	if bytes.IndexByte(recordOps, version) >= 0 {
+		panic(fmt.Sprintf("lightpatch: RegisterDecoder called for record byte %q", version))
+	}
+	if _, dup := decoders[version]; dup {
+		panic(fmt.Sprintf("lightpatch: RegisterDecoder called twice for %q", version))
+	}
+	decoders[version] = d
+}
+
+// recordOps are the leading bytes of the records of the binary format.
+var recordOps = []byte{
+	OpCopy, OpInsert, OpDelete, OpCRC, OpBLAKE3, OpXXH3, OpCheckedInsert,
+	OpMetadata, OpAnnotation, OpChunk, OpPadding, OpReplace, OpCopyToEnd, OpContext,
+	OpBeforeCRC, OpCheckpoint,
+}
+
+// decoder returns the decoder registered for version, or nil.
+func decoder(version byte) Decoder {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	return decoders[version]
+}
+
+// decodeFEC is the Decoder of FEC envelopes.
+func decodeFEC(r io.Reader) (io.Reader, error) {
+	inner, err := readFEC(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(inner), nil
+}
+
+// decodeSignature is the Decoder of signature envelopes. It doesn't check the
+// signature, which ApplyPatch does when given WithPublicKey.
+func decodeSignature(r io.Reader) (io.Reader, error) {
+	inner, err := readSignature(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(inner), nil
+}
@@ -0,0 +1,72 @@
+package lightpatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConformance applies patches written by earlier versions, which must keep
+// applying as the format grows.
+func TestConformance(t *testing.T) {
+	patches, err := filepath.Glob("testdata/*.patch")
+	assert.NoError(t, err)
+	compat, err := filepath.Glob("testdata/compat/*.patch")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, compat)
+
+	for _, name := range append(patches, compat...) {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			// testdata/compat/unicode_fec.patch applies to testdata/unicode_in
+			pair := strings.SplitN(strings.TrimSuffix(filepath.Base(name), ".patch"), "_", 2)[0]
+			before, err := ioutil.ReadFile(filepath.Join("testdata", pair+"_in"))
+			assert.NoError(t, err)
+			want, err := ioutil.ReadFile(filepath.Join("testdata", pair+"_out"))
+			assert.NoError(t, err)
+			patch, err := ioutil.ReadFile(name)
+			assert.NoError(t, err)
+
+			var after bytes.Buffer
+			assert.NoError(t, ApplyPatch(bytes.NewReader(before), bytes.NewReader(patch), &after, WithStrict()))
+			assert.Equal(t, want, after.Bytes())
+			assert.NoError(t, VerifyPatch(bytes.NewReader(patch)))
+
+			edits, err := Edits(before, patch)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, edits)
+		})
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	const opGzip = 'G'
+	RegisterDecoder(opGzip, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	// A compressed FEC envelope
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithFEC(2, 1)))
+	var compressed bytes.Buffer
+	compressed.WriteByte(opGzip)
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(patch.Bytes())
+	assert.NoError(t, zw.Close())
+
+	var after bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(compressed.Bytes()), &after))
+	assert.Equal(t, b, after.Bytes())
+
+	assert.Panics(t, func() { RegisterDecoder(opGzip, decodeFEC) })
+	assert.Panics(t, func() { RegisterDecoder(OpFEC, decodeFEC) })
+	assert.Panics(t, func() { RegisterDecoder(OpCopy, decodeFEC) })
+}
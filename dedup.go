@@ -0,0 +1,80 @@
+package lightpatch
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"lukechampine.com/blake3"
+)
+
+var (
+	// ErrChunk is returned by ApplyPatch when a chunk doesn't match its digest.
+	ErrChunk = errors.New("chunk doesn't match its digest")
+
+	errNoChunkStore = errors.New("patch references chunks, but no chunk store was given")
+)
+
+// A ChunkStore holds the data of inserts shared between patches, keyed by the hex
+// BLAKE3-256 digest of the data. kv.Store in the store/kv package satisfies it.
+type ChunkStore interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+}
+
+type dedupParams struct {
+	store   ChunkStore
+	minSize int
+}
+
+// WithDedup causes MakePatch to store the data of inserts of at least minSize bytes
+// in cs, and reference it from the patch by its digest, so that patches sharing
+// large inserts, such as a common header, don't each carry a copy. Inserts no
+// longer than the digest are always kept in the patch.
+//
+// ApplyPatch needs the same option to resolve the references; minSize is ignored.
+func WithDedup(cs ChunkStore, minSize int) Option {
+	return func(c *config) {
+		c.dedup = &dedupParams{store: cs, minSize: minSize}
+	}
+}
+
+// stores reports whether the insert data is kept in the chunk store.
+func (d *dedupParams) stores(data []byte) bool {
+	return d != nil && len(data) >= d.minSize && len(data) > blake3Size
+}
+
+// writeChunk puts data in cs and writes a chunk record referencing it to patch.
+func writeChunk(patch io.Writer, cs ChunkStore, data []byte) error {
+	digest := blake3.Sum256(data)
+	if err := cs.Put(hex.EncodeToString(digest[:]), data); err != nil {
+		return err
+	}
+
+	rec := appendUvarint([]byte{OpChunk}, uint64(len(data)))
+	_, err := patch.Write(append(rec, digest[:]...))
+	return err
+}
+
+// readChunk reads the digest of a chunk record of n bytes from r, and returns its
+// data from the chunk store of d.
+func readChunk(r io.Reader, d *dedupParams, n uint64) ([]byte, error) {
+	digest := make([]byte, blake3Size)
+	if _, err := io.ReadFull(r, digest); err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, errNoChunkStore
+	}
+
+	data, err := d.store.Get(hex.EncodeToString(digest))
+	if err != nil {
+		return nil, err
+	}
+
+	if sum := blake3.Sum256(data); uint64(len(data)) != n || !bytes.Equal(sum[:], digest) {
+		return nil, ErrChunk
+	}
+	return data, nil
+}
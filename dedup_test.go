@@ -0,0 +1,74 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chunkMap is an in-memory ChunkStore.
+type chunkMap map[string][]byte
+
+func (m chunkMap) Get(key string) ([]byte, error) {
+	value, ok := m[key]
+	if !ok {
+		return nil, errors.New("no chunk " + key)
+	}
+	return value, nil
+}
+
+func (m chunkMap) Put(key string, value []byte) error {
+	m[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func TestDedup(t *testing.T) {
+	header := strings.Repeat("Licensed under the Apache License, Version 2.0. ", 40)
+	chunks := chunkMap{}
+
+	var patches [][]byte
+	for _, body := range []string{"package a\n\nfunc A() {}\n", "package b\n\nfunc B() {}\n"} {
+		var patch bytes.Buffer
+		err := MakePatch(strings.NewReader(body), strings.NewReader(header+body), &patch, WithDedup(chunks, 256))
+		assert.NoError(t, err)
+		patches = append(patches, patch.Bytes())
+
+		// The header is referenced, not embedded.
+		assert.Less(t, patch.Len(), 100)
+		assert.Equal(t, byte(OpChunk), patch.Bytes()[0])
+
+		var after bytes.Buffer
+		err = ApplyPatch(strings.NewReader(body), bytes.NewReader(patch.Bytes()), &after, WithDedup(chunks, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, header+body, after.String())
+	}
+	assert.Len(t, chunks, 1)
+
+	// Short inserts stay in the patch.
+	var patch bytes.Buffer
+	err := MakePatch(strings.NewReader("abc"), strings.NewReader("abcdef"), &patch, WithDedup(chunks, 0))
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 1)
+
+	// The chunk round trips through the text format.
+	var text, binary bytes.Buffer
+	assert.NoError(t, ToText(bytes.NewReader(patches[0]), &text))
+	assert.Contains(t, text.String(), "H 1920 ")
+	assert.NoError(t, ToBinary(&text, &binary))
+	assert.Equal(t, patches[0], binary.Bytes())
+
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patches[0])))
+
+	err = ApplyPatch(strings.NewReader("package a\n\nfunc A() {}\n"), bytes.NewReader(patches[0]), new(bytes.Buffer))
+	assert.Equal(t, errNoChunkStore, err)
+
+	for key, value := range chunks {
+		value[0] ^= 1
+		chunks[key] = value
+	}
+	err = ApplyPatch(strings.NewReader("package a\n\nfunc A() {}\n"), bytes.NewReader(patches[0]), new(bytes.Buffer), WithDedup(chunks, 0))
+	assert.Equal(t, ErrChunk, err)
+}
@@ -0,0 +1,13 @@
+package lightpatch
+
+// EditDistance returns the Levenshtein distance between a and b, in bytes, computed
+// over the same diff that MakePatch makes by default: each change counts the larger of
+// the bytes it deletes and inserts. It ranks candidate bases for a patch, or finds
+// near-duplicates, consistently with the patches that would be made from them.
+//
+// The diff minimizes deletes and inserts rather than substitutions, and is cut short
+// after DefaultTimeout, so the result may exceed the exact distance. It is 0 only if
+// a and b are equal.
+func EditDistance(a, b []byte) int {
+	return levenshtein(diffMain(a, b, DefaultTimeout))
+}
@@ -0,0 +1,26 @@
+package lightpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"The quick brown fox", "The quick brown cat", 3},
+		{"flaw", "lawn", 2},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, EditDistance([]byte(test.a), []byte(test.b)), "%q %q", test.a, test.b)
+		assert.Equal(t, test.want, EditDistance([]byte(test.b), []byte(test.a)), "%q %q", test.b, test.a)
+	}
+}
@@ -39,7 +39,7 @@ func diffMainBytes(text1, text2 []byte, deadline time.Time) []diff {
 	if bytes.Equal(text1, text2) {
 		diffs := []diff{}
 		if len(text1) > 0 {
-			diffs = append(diffs, diff{OpCopy, clone(text1)})
+			diffs = append(diffs, diff{OpCopy, text1})
 		}
 		return diffs
 	}
@@ -60,10 +60,10 @@ func diffMainBytes(text1, text2 []byte, deadline time.Time) []diff {
 
 	// Restore the prefix and suffix.
 	if len(commonprefix) != 0 {
-		diffs = append([]diff{diff{OpCopy, clone(commonprefix)}}, diffs...)
+		diffs = append([]diff{diff{OpCopy, commonprefix}}, diffs...)
 	}
 	if len(commonsuffix) != 0 {
-		diffs = append(diffs, diff{OpCopy, clone(commonsuffix)})
+		diffs = append(diffs, diff{OpCopy, commonsuffix})
 	}
 
 	return diffCleanupMerge(diffs)
@@ -74,10 +74,10 @@ func diffCompute(text1, text2 []byte, deadline time.Time) []diff {
 	diffs := []diff{}
 	if len(text1) == 0 {
 		// Just add some text (speedup).
-		return append(diffs, diff{OpInsert, clone(text2)})
+		return append(diffs, diff{OpInsert, text2})
 	} else if len(text2) == 0 {
 		// Just delete some text (speedup).
-		return append(diffs, diff{OpDelete, clone(text1)})
+		return append(diffs, diff{OpDelete, text1})
 	}
 
 	var longtext, shorttext []byte
@@ -97,16 +97,16 @@ func diffCompute(text1, text2 []byte, deadline time.Time) []diff {
 		}
 		// Shorter text is inside the longer text (speedup).
 		return []diff{
-			diff{op, clone(longtext[:i])},
-			diff{OpCopy, clone(shorttext)},
-			diff{op, clone(longtext[i+len(shorttext):])},
+			diff{op, longtext[:i]},
+			diff{OpCopy, shorttext},
+			diff{op, longtext[i+len(shorttext):]},
 		}
 	} else if len(shorttext) == 1 {
 		// Single character string.
 		// After the previous speedup, the character can't be an equality.
 		return []diff{
-			diff{OpDelete, clone(text1)},
-			diff{OpInsert, clone(text2)},
+			diff{OpDelete, text1},
+			diff{OpInsert, text2},
 		}
 		// Check to see if the problem can be split in two.
 	} else if hm := diffHalfMatch(text1, text2, deadline.IsZero()); hm != nil {
@@ -121,11 +121,14 @@ func diffCompute(text1, text2 []byte, deadline time.Time) []diff {
 		diffsB := diffMainBytes(text1B, text2B, deadline)
 		// Merge the results.
 		diffs := diffsA
-		diffs = append(diffs, diff{OpCopy, clone(midCommon)})
+		diffs = append(diffs, diff{OpCopy, midCommon})
 		diffs = append(diffs, diffsB...)
 		return diffs
 		//} else if checklines && len(text1) > 100 && len(text2) > 100 {
 		//	return dmp.diffLineMode(text1, text2, deadline)
+	} else if diffs := diffHashed(text1, text2, deadline); diffs != nil {
+		// Large, mostly similar segments were matched by block hashes.
+		return diffs
 	}
 	return diffBisect(text1, text2, deadline)
 }
@@ -140,8 +143,9 @@ func diffBisect(runes1, runes2 []byte, deadline time.Time) []diff {
 	vOffset := maxD
 	vLength := 2 * maxD
 
-	v1 := make([]int, vLength)
-	v2 := make([]int, vLength)
+	v := getInts(2 * vLength)
+	v1 := v[:vLength]
+	v2 := v[vLength:]
 	for i := range v1 {
 		v1[i] = -1
 		v2[i] = -1
@@ -196,6 +200,7 @@ func diffBisect(runes1, runes2 []byte, deadline time.Time) []diff {
 					x2 := runes1Len - v2[k2Offset]
 					if x1 >= x2 {
 						// Overlap detected.
+						putInts(v)
 						return diffBisectSplit(runes1, runes2, x1, y1, deadline)
 					}
 				}
@@ -234,16 +239,19 @@ func diffBisect(runes1, runes2 []byte, deadline time.Time) []diff {
 					x2 = runes1Len - x2
 					if x1 >= x2 {
 						// Overlap detected.
+						putInts(v)
 						return diffBisectSplit(runes1, runes2, x1, y1, deadline)
 					}
 				}
 			}
 		}
 	}
+	putInts(v)
+
 	// diff took too long and hit the deadline or number of diffs equals number of characters, no commonality at all.
 	return []diff{
-		diff{OpDelete, clone(runes1)},
-		diff{OpInsert, clone(runes2)},
+		diff{OpDelete, runes1},
+		diff{OpInsert, runes2},
 	}
 }
 
@@ -380,9 +388,7 @@ func diffHalfMatch(text1, text2 []byte, unlimitedTime bool) [][]byte {
 // diffHalfMatchI checks if a substring of shorttext exist within longtext such that the substring is at least half the length of longtext?
 // Returns a slice containing the prefix of longtext, the suffix of longtext, the prefix of shorttext, the suffix of shorttext and the common middle, or null if there was no match.
 func diffHalfMatchI(l, s []byte, i int) [][]byte {
-	var bestCommonA []byte
-	var bestCommonB []byte
-	var bestCommonLen int
+	var bestCommon []byte
 	var bestLongtextA []byte
 	var bestLongtextB []byte
 	var bestShorttextA []byte
@@ -395,10 +401,8 @@ func diffHalfMatchI(l, s []byte, i int) [][]byte {
 		prefixLength := commonPrefixLength(l[i:], s[j:])
 		suffixLength := commonSuffixLength(l[:i], s[:j])
 
-		if bestCommonLen < suffixLength+prefixLength {
-			bestCommonA = s[j-suffixLength : j]
-			bestCommonB = s[j : j+prefixLength]
-			bestCommonLen = len(bestCommonA) + len(bestCommonB)
+		if len(bestCommon) < suffixLength+prefixLength {
+			bestCommon = s[j-suffixLength : j+prefixLength]
 			bestLongtextA = l[:i-suffixLength]
 			bestLongtextB = l[i+prefixLength:]
 			bestShorttextA = s[:j-suffixLength]
@@ -406,7 +410,7 @@ func diffHalfMatchI(l, s []byte, i int) [][]byte {
 		}
 	}
 
-	if bestCommonLen*2 < len(l) {
+	if len(bestCommon)*2 < len(l) {
 		return nil
 	}
 
@@ -415,7 +419,7 @@ func diffHalfMatchI(l, s []byte, i int) [][]byte {
 		bestLongtextB,
 		bestShorttextA,
 		bestShorttextB,
-		append(bestCommonA, bestCommonB...),
+		bestCommon,
 	}
 }
 
@@ -441,7 +445,7 @@ func diffCleanupSemantic(diffs []diff) []diff {
 			lengthDeletions1 = lengthDeletions2
 			lengthInsertions2 = 0
 			lengthDeletions2 = 0
-			lastequality = clone(diffs[pointer].Text)
+			lastequality = diffs[pointer].Text
 		} else {
 			// An insertion or deletion.
 
@@ -566,7 +570,7 @@ func diffCleanupEfficiency(diffs []diff) []diff {
 				}
 				preIns = postIns
 				preDel = postDel
-				lastequality = clone(diffs[pointer].Text)
+				lastequality = diffs[pointer].Text
 			} else {
 				// Not a candidate, and can never become one.
 				equalities = nil
@@ -661,12 +665,12 @@ func diffCleanupMerge(diffs []diff) []diff {
 		switch diffs[pointer].Type {
 		case OpInsert:
 			countInsert++
-			textInsert = append(textInsert, diffs[pointer].Text...)
+			textInsert = cleanAppend(textInsert, diffs[pointer].Text)
 			pointer++
 			break
 		case OpDelete:
 			countDelete++
-			textDelete = append(textDelete, diffs[pointer].Text...)
+			textDelete = cleanAppend(textDelete, diffs[pointer].Text)
 			pointer++
 			break
 		case OpCopy:
@@ -678,9 +682,9 @@ func diffCleanupMerge(diffs []diff) []diff {
 					if commonlength != 0 {
 						x := pointer - countDelete - countInsert
 						if x > 0 && diffs[x-1].Type == OpCopy {
-							diffs[x-1].Text = append(diffs[x-1].Text, textInsert[:commonlength]...)
+							diffs[x-1].Text = cleanAppend(diffs[x-1].Text, textInsert[:commonlength])
 						} else {
-							diffs = append([]diff{diff{OpCopy, clone(textInsert[:commonlength])}}, diffs...)
+							diffs = append([]diff{diff{OpCopy, textInsert[:commonlength]}}, diffs...)
 							pointer++
 						}
 						textInsert = textInsert[commonlength:]
@@ -700,16 +704,16 @@ func diffCleanupMerge(diffs []diff) []diff {
 				if countDelete == 0 {
 					diffs = splice(diffs, pointer-countInsert,
 						countDelete+countInsert,
-						diff{OpInsert, clone(textInsert)})
+						diff{OpInsert, textInsert})
 				} else if countInsert == 0 {
 					diffs = splice(diffs, pointer-countDelete,
 						countDelete+countInsert,
-						diff{OpDelete, clone(textDelete)})
+						diff{OpDelete, textDelete})
 				} else {
 					diffs = splice(diffs, pointer-countDelete-countInsert,
 						countDelete+countInsert,
-						diff{OpDelete, clone(textDelete)},
-						diff{OpInsert, clone(textInsert)})
+						diff{OpDelete, textDelete},
+						diff{OpInsert, textInsert})
 				}
 
 				pointer = pointer - countDelete - countInsert + 1
@@ -810,24 +814,40 @@ func splice(slice []diff, index int, amount int, elements ...diff) []diff {
 	return slice
 }
 
-// clone makes a copy of a byte slice. The original go-diff library used strings
-// throughout, which are immutable. Subtle slice aliasing bug following a straight
-// string->[]byte conversion prompted lots of cloning.
-func clone(in []byte) []byte {
-	out := make([]byte, len(in))
-	copy(out, in)
-	return out
-}
+// Diff texts are ranges of the original inputs rather than copies. The original
+// go-diff library used strings throughout, which are immutable, so the same rule
+// applies here: a diff's Text must never be modified or appended to in place, since
+// it shares memory with the inputs and with other diffs. Use cleanAppend to join texts.
 
 // cleanAppend concatenates multiple byte slices into a single slice
-// while leaving the originals untouched.
+// while leaving the originals untouched. If the slices are adjacent ranges of the
+// same array, as is usual for texts split from one input, the joined range is
+// returned without copying.
 func cleanAppend(slices ...[]byte) []byte {
-	cap := 0
+	var joined []byte
+	adjacent := true
+	total := 0
 	for _, s := range slices {
-		cap += len(s)
+		if len(s) == 0 {
+			continue
+		}
+		total += len(s)
+		if !adjacent {
+			continue
+		}
+		if n := len(joined) + len(s); len(joined) == 0 {
+			joined = s
+		} else if n <= cap(joined) && &joined[:n][len(joined)] == &s[0] {
+			joined = joined[:n]
+		} else {
+			adjacent = false
+		}
+	}
+	if adjacent {
+		return joined[:len(joined):len(joined)]
 	}
 
-	ret := make([]byte, 0, cap)
+	ret := make([]byte, 0, total)
 	for _, s := range slices {
 		ret = append(ret, s...)
 	}
@@ -618,3 +618,21 @@ func TestDiffMainWithTimeout(t *testing.T) {
 	// Test that we didn't take forever (be very forgiving). Theoretically this test could fail very occasionally if the OS task swaps or locks up for a second at the wrong moment.
 	assert.True(t, delta < (timeout*100), fmt.Sprintf("%v !< %v", delta, timeout*100))
 }
+
+func TestCleanAppend(t *testing.T) {
+	text := []byte("abcdef")
+
+	// Adjacent ranges are joined without copying.
+	joined := cleanAppend(text[:2], text[2:4], nil, text[4:])
+	assert.Equal(t, "abcdef", string(joined))
+	assert.True(t, &joined[0] == &text[0])
+	assert.Equal(t, len(joined), cap(joined))
+
+	// Anything else is copied, leaving the originals untouched.
+	joined = cleanAppend(text[:2], text[3:])
+	assert.Equal(t, "abdef", string(joined))
+	assert.False(t, &joined[0] == &text[0])
+	assert.Equal(t, "abcdef", string(text))
+
+	assert.Empty(t, cleanAppend(nil, []byte{}))
+}
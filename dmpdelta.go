@@ -0,0 +1,128 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var errDMPDelta = errors.New("invalid diff-match-patch delta")
+
+// ToDMPDelta converts patch to a delta in the format of diff_toDelta from Google's
+// diff-match-patch library: tab-separated operations that copy ("=N") or delete
+// ("-N") the next N characters of before, or insert text ("+text", escaped as by
+// encodeURI). Like a lightpatch patch, a delta is applied to the before it was made
+// from. It returns ErrDMPText if before or the output isn't UTF-8.
+func ToDMPDelta(before, patch []byte, delta io.Writer) error {
+	edits, after, err := decodeEdits(before, patch)
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(before) || !utf8.Valid(after) {
+		return ErrDMPText
+	}
+
+	diffs := make([]diff, len(edits))
+	for i, e := range edits {
+		diffs[i] = diff{e.Op, e.Text}
+	}
+
+	var ops []string
+	for _, d := range alignRunes(diffCleanupMerge(diffs)) {
+		switch d.Type {
+		case OpCopy:
+			ops = append(ops, "="+strconv.Itoa(utf8.RuneCount(d.Text)))
+		case OpDelete:
+			ops = append(ops, "-"+strconv.Itoa(utf8.RuneCount(d.Text)))
+		case OpInsert:
+			ops = append(ops, "+"+dmpEscape(d.Text))
+		}
+	}
+
+	_, err = io.WriteString(delta, strings.Join(ops, "\t"))
+	return err
+}
+
+// FromDMPDelta converts a delta in the format of diff_toDelta, made from before, into
+// a patch written to patch with the options given. The delta must account for all of
+// before.
+func FromDMPDelta(before []byte, delta io.Reader, patch io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	start := time.Now()
+
+	text, err := ioutil.ReadAll(delta)
+	if err != nil {
+		return err
+	}
+
+	var diffs []diff
+	var after []byte
+	a := 0 // The next byte of before
+	for _, op := range strings.Split(string(text), "\t") {
+		if op == "" {
+			continue
+		}
+
+		switch op[0] {
+		case '+':
+			data, err := url.PathUnescape(op[1:])
+			if err != nil {
+				return errDMPDelta
+			}
+			diffs = append(diffs, diff{OpInsert, []byte(data)})
+			after = append(after, data...)
+		case '=', '-':
+			n, err := strconv.Atoi(op[1:])
+			if err != nil || n < 0 {
+				return errDMPDelta
+			}
+			end := a
+			for ; n > 0 && end < len(before); n-- {
+				_, size := utf8.DecodeRune(before[end:])
+				end += size
+			}
+			if n > 0 {
+				return fmt.Errorf("%w: longer than before", errDMPDelta)
+			}
+
+			if op[0] == '=' {
+				diffs = append(diffs, diff{OpCopy, before[a:end]})
+				after = append(after, before[a:end]...)
+			} else {
+				diffs = append(diffs, diff{OpDelete, before[a:end]})
+			}
+			a = end
+		default:
+			return errDMPDelta
+		}
+	}
+	if a != len(before) {
+		return fmt.Errorf("%w: shorter than before", errDMPDelta)
+	}
+
+	return writePatch(patch, before, after, diffCleanupMerge(diffs), cfg, start, 0)
+}
+
+// ApplyDMPDelta applies a delta in the format of diff_toDelta to before, writing the
+// output to after. The delta is converted with FromDMPDelta and applied with
+// ApplyPatch.
+func ApplyDMPDelta(before, delta io.Reader, after io.Writer) error {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return err
+	}
+
+	var patch bytes.Buffer
+	if err := FromDMPDelta(beforeBytes, delta, &patch); err != nil {
+		return err
+	}
+
+	return ApplyPatch(bytes.NewReader(beforeBytes), &patch, after)
+}
@@ -0,0 +1,51 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDMPDelta(t *testing.T) {
+	// From the diff_toDelta tests of diff-match-patch.
+	var after bytes.Buffer
+	delta := "=4\t-1\t+ed\t=6\t-3\t+a\t=5\t+old dog"
+	assert.NoError(t, ApplyDMPDelta(strings.NewReader("jumps over the lazy"), strings.NewReader(delta), &after))
+	assert.Equal(t, "jumped over a lazyold dog", after.String())
+
+	after.Reset()
+	delta = "=1\t-1\t+%C3%A8%09%25\t=1"
+	assert.NoError(t, ApplyDMPDelta(strings.NewReader("héé"), strings.NewReader(delta), &after))
+	assert.Equal(t, "hè\t%é", after.String())
+
+	a := []byte("The quick brown fox jumps over the lazy dog.")
+	b := []byte("That quick brown fox jumped over a lazy dog.")
+	var patch, text bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+	assert.NoError(t, ToDMPDelta(a, patch.Bytes(), &text))
+	assert.Equal(t, "=2\t-1\t+at\t=21\t-1\t+ed\t=6\t-3\t+a\t=10", text.String())
+
+	// Deltas survive conversion, including edits that split characters.
+	for _, tc := range []struct{ before, after string }{
+		{"a€b", "a£b"},
+		{"héllo wörld", "hëllo\twörld!"},
+		{"", "new\n"},
+		{"old", ""},
+	} {
+		patch.Reset()
+		text.Reset()
+		after.Reset()
+		assert.NoError(t, MakePatch(strings.NewReader(tc.before), strings.NewReader(tc.after), &patch))
+		assert.NoError(t, ToDMPDelta([]byte(tc.before), patch.Bytes(), &text))
+		assert.NoError(t, ApplyDMPDelta(strings.NewReader(tc.before), &text, &after))
+		assert.Equal(t, tc.after, after.String())
+	}
+
+	for _, delta := range []string{"=20", "=1", "*3", "=x", "+%zz"} {
+		err := ApplyDMPDelta(strings.NewReader("abc"), strings.NewReader(delta), &after)
+		assert.True(t, errors.Is(err, errDMPDelta), delta)
+	}
+}
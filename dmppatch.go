@@ -0,0 +1,648 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The matching parameters of diff-match-patch, at their defaults.
+const (
+	dmpMatchThreshold  = 0.5
+	dmpMatchDistance   = 1000
+	dmpDeleteThreshold = 0.5
+	dmpMatchMaxBits    = 32
+	dmpMargin          = 4
+)
+
+var (
+	errDMPSyntax = errors.New("invalid diff-match-patch patch")
+
+	// ErrDMPMismatch is wrapped by the error returned by FromDMP and ApplyDMP when a
+	// hunk can't be located in before, even approximately.
+	ErrDMPMismatch = errors.New("diff-match-patch hunk doesn't match")
+
+	// ErrDMPText is returned by ToDMP when before or the output of the patch isn't
+	// UTF-8 text, which diff-match-patch can't represent.
+	ErrDMPText = errors.New("diff-match-patch patches can only edit UTF-8 text")
+)
+
+var dmpHeader = regexp.MustCompile(`^@@ -(\d+),?(\d*) \+(\d+),?(\d*) @@$`)
+
+var dmpSigns = map[byte]byte{OpCopy: ' ', OpDelete: '-', OpInsert: '+'}
+
+// dmpHunk is a hunk of a diff-match-patch patch. Its starts and lengths count
+// characters, as in the patch text.
+type dmpHunk struct {
+	start1, length1 int
+	start2, length2 int
+	diffs           []diff
+}
+
+// text1 returns the text the hunk expects to find: its context and deletions.
+func (h dmpHunk) text1() []byte {
+	var text []byte
+	for _, d := range h.diffs {
+		if d.Type != OpInsert {
+			text = append(text, d.Text...)
+		}
+	}
+	return text
+}
+
+// text2 returns the text the hunk leaves: its context and insertions.
+func (h dmpHunk) text2() []byte {
+	var text []byte
+	for _, d := range h.diffs {
+		if d.Type != OpDelete {
+			text = append(text, d.Text...)
+		}
+	}
+	return text
+}
+
+// ToDMP converts patch to a patch in the text format of Google's diff-match-patch
+// library, which patch_fromText and patch_apply in its JavaScript and Python versions
+// accept. As in patch_make, each hunk carries enough context to be found again if
+// the text has changed, and offsets count characters. Edits that split a UTF-8
+// sequence are widened to whole characters.
+func ToDMP(before, patch []byte, text io.Writer) error {
+	edits, after, err := decodeEdits(before, patch)
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(before) || !utf8.Valid(after) {
+		return ErrDMPText
+	}
+
+	diffs := make([]diff, len(edits))
+	for i, e := range edits {
+		diffs[i] = diff{e.Op, e.Text}
+	}
+
+	w := bufio.NewWriter(text)
+	for _, h := range makeDMP(before, alignRunes(diffCleanupMerge(diffs))) {
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", dmpCoords(h.start1, h.length1), dmpCoords(h.start2, h.length2))
+		for _, d := range h.diffs {
+			w.WriteByte(dmpSigns[d.Type])
+			w.WriteString(dmpEscape(d.Text))
+			w.WriteByte('\n')
+		}
+	}
+
+	return w.Flush()
+}
+
+// alignRunes moves the bytes of a character split between a copy and an edit into
+// the edit, on both sides, so that every diff holds whole characters of UTF-8 text.
+func alignRunes(diffs []diff) []diff {
+	var aligned []diff
+	for i := 0; i < len(diffs); i++ {
+		d := diffs[i]
+		if d.Type != OpCopy {
+			aligned = append(aligned, d)
+			continue
+		}
+
+		// Continuation bytes at the start belong to the edit before.
+		head := 0
+		for len(aligned) > 0 && head < len(d.Text) && !utf8.RuneStart(d.Text[head]) {
+			head++
+		}
+		if head > 0 {
+			aligned = widenEdit(aligned, len(aligned), d.Text[:head], false)
+		}
+
+		// A partial character at the end belongs to the edit after.
+		tail := len(d.Text)
+		if i < len(diffs)-1 {
+			start := tail - 1
+			for start > head && !utf8.RuneStart(d.Text[start]) {
+				start--
+			}
+			if start >= head && !utf8.FullRune(d.Text[start:]) {
+				tail = start
+			}
+		}
+
+		if head < tail {
+			aligned = append(aligned, diff{OpCopy, d.Text[head:tail]})
+		}
+		if tail < len(d.Text) {
+			j := len(aligned)
+			for i+1 < len(diffs) && diffs[i+1].Type != OpCopy {
+				i++
+				aligned = append(aligned, diffs[i])
+			}
+			aligned = widenEdit(aligned, j, d.Text[tail:], true)
+		}
+	}
+	return aligned
+}
+
+// widenEdit adds text, which both inputs have, to the start or end of the delete and
+// insert that end at index j of diffs, creating them if needed.
+func widenEdit(diffs []diff, j int, text []byte, atStart bool) []diff {
+	i := j
+	for i > 0 && diffs[i-1].Type != OpCopy {
+		i--
+	}
+	if atStart {
+		i, j = j, len(diffs)
+	}
+
+	var del, ins []byte
+	for _, d := range diffs[i:j] {
+		if d.Type == OpDelete {
+			del = append(del, d.Text...)
+		} else {
+			ins = append(ins, d.Text...)
+		}
+	}
+	if atStart {
+		del = append(append([]byte(nil), text...), del...)
+		ins = append(append([]byte(nil), text...), ins...)
+	} else {
+		del = append(del, text...)
+		ins = append(ins, text...)
+	}
+
+	return append(diffs[:i], diff{OpDelete, del}, diff{OpInsert, ins})
+}
+
+// makeDMP groups diffs into hunks with context, like patch_make. The offsets of the
+// hunks count bytes until they are converted by dmpChars.
+func makeDMP(before []byte, diffs []diff) []dmpHunk {
+	var hunks []dmpHunk
+	var h dmpHunk
+
+	// Each hunk applies to the text left by the hunks before it.
+	prepatch := before
+	postpatch := append([]byte(nil), before...)
+	count1, count2 := 0, 0
+	for i, d := range diffs {
+		if len(h.diffs) == 0 && d.Type != OpCopy {
+			h.start1, h.start2 = count1, count2
+		}
+
+		switch d.Type {
+		case OpInsert:
+			h.diffs = append(h.diffs, d)
+			h.length2 += len(d.Text)
+			postpatch = append(append(append([]byte(nil), postpatch[:count2]...), d.Text...), postpatch[count2:]...)
+		case OpDelete:
+			h.diffs = append(h.diffs, d)
+			h.length1 += len(d.Text)
+			postpatch = append(append([]byte(nil), postpatch[:count2]...), postpatch[count2+len(d.Text):]...)
+		case OpCopy:
+			if len(d.Text) <= 2*dmpMargin && len(h.diffs) > 0 && i < len(diffs)-1 {
+				// A short copy stays inside the hunk.
+				h.diffs = append(h.diffs, d)
+				h.length1 += len(d.Text)
+				h.length2 += len(d.Text)
+			}
+			if len(d.Text) >= 2*dmpMargin && len(h.diffs) > 0 {
+				hunks = append(hunks, dmpChars(addDMPContext(h, prepatch), prepatch))
+				h = dmpHunk{}
+				prepatch = postpatch
+				count1 = count2
+			}
+		}
+
+		if d.Type != OpInsert {
+			count1 += len(d.Text)
+		}
+		if d.Type != OpDelete {
+			count2 += len(d.Text)
+		}
+	}
+	if len(h.diffs) > 0 {
+		hunks = append(hunks, dmpChars(addDMPContext(h, prepatch), prepatch))
+	}
+
+	return hunks
+}
+
+// addDMPContext surrounds h with copies of the text around it, growing them until
+// the text h expects is unique or as long as Bitap can match, like patch_addContext.
+func addDMPContext(h dmpHunk, text []byte) dmpHunk {
+	if len(text) == 0 {
+		return h
+	}
+
+	pattern := text[h.start2 : h.start2+h.length1]
+	padding := 0
+	for bytes.Index(text, pattern) != bytes.LastIndex(text, pattern) && len(pattern) < dmpMatchMaxBits-2*dmpMargin {
+		padding += dmpMargin
+		pattern = text[max(0, h.start2-padding):min(len(text), h.start2+h.length1+padding)]
+	}
+	padding += dmpMargin
+
+	start := max(0, h.start2-padding)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	end := min(len(text), h.start2+h.length1+padding)
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	prefix := text[start:h.start2]
+	suffix := text[h.start2+h.length1 : end]
+	if len(prefix) > 0 {
+		h.diffs = append([]diff{{OpCopy, prefix}}, h.diffs...)
+	}
+	if len(suffix) > 0 {
+		h.diffs = append(h.diffs, diff{OpCopy, suffix})
+	}
+	h.start1 -= len(prefix)
+	h.start2 -= len(prefix)
+	h.length1 += len(prefix) + len(suffix)
+	h.length2 += len(prefix) + len(suffix)
+	return h
+}
+
+// dmpChars converts the offsets and lengths of h from bytes to characters of text.
+func dmpChars(h dmpHunk, text []byte) dmpHunk {
+	start := utf8.RuneCount(text[:h.start2])
+	h.start1, h.start2 = start, start
+	h.length1 = utf8.RuneCount(h.text1())
+	h.length2 = utf8.RuneCount(h.text2())
+	return h
+}
+
+// dmpCoords formats a header range, the inverse of dmpRange.
+func dmpCoords(start, length int) string {
+	switch length {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// dmpEscape escapes text as JavaScript's encodeURI does, except for spaces, as
+// patch_toText does.
+func dmpEscape(text []byte) string {
+	const unescaped = "-_.!~*'();/?:@&=+$,# "
+
+	var b strings.Builder
+	for _, c := range text {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte(unescaped, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// FromDMP converts a patch in the text format of Google's diff-match-patch library,
+// as written by patch_toText, into a patch that turns before into the same output,
+// written to patch with the options given.
+//
+// As in diff-match-patch, the hunks are located approximately, so the patch still
+// applies when before has changed since it was made. The line numbers of the hunks
+// are taken as characters from the start of before, and only guide the search for
+// their context. FromDMP returns an error wrapping ErrDMPMismatch if a hunk isn't
+// found.
+func FromDMP(before []byte, text io.Reader, patch io.Writer, opts ...Option) error {
+	hunks, err := parseDMP(text)
+	if err != nil {
+		return err
+	}
+
+	after, err := applyDMP(before, hunks)
+	if err != nil {
+		return err
+	}
+
+	return MakePatch(bytes.NewReader(before), bytes.NewReader(after), patch, opts...)
+}
+
+// ApplyDMP applies a diff-match-patch patch to before, writing the output to after.
+// The patch is converted with FromDMP and applied with ApplyPatch.
+func ApplyDMP(before, text io.Reader, after io.Writer) error {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return err
+	}
+
+	var patch bytes.Buffer
+	if err := FromDMP(beforeBytes, text, &patch); err != nil {
+		return err
+	}
+
+	return ApplyPatch(bytes.NewReader(beforeBytes), &patch, after)
+}
+
+// parseDMP parses the hunks of a patch in the format of patch_toText.
+func parseDMP(text io.Reader) ([]dmpHunk, error) {
+	var hunks []dmpHunk
+
+	s := bufio.NewScanner(text)
+	s.Buffer(nil, math.MaxInt32)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '@' {
+			m := dmpHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, errDMPSyntax
+			}
+			var h dmpHunk
+			h.start1, h.length1 = dmpRange(m[1], m[2])
+			h.start2, h.length2 = dmpRange(m[3], m[4])
+			hunks = append(hunks, h)
+			continue
+		}
+
+		if len(hunks) == 0 {
+			return nil, errDMPSyntax
+		}
+		h := &hunks[len(hunks)-1]
+
+		// Unlike url.QueryUnescape, PathUnescape leaves "+" alone.
+		data, err := url.PathUnescape(line[1:])
+		if err != nil {
+			return nil, errDMPSyntax
+		}
+
+		switch line[0] {
+		case ' ':
+			h.diffs = append(h.diffs, diff{OpCopy, []byte(data)})
+		case '-':
+			h.diffs = append(h.diffs, diff{OpDelete, []byte(data)})
+		case '+':
+			h.diffs = append(h.diffs, diff{OpInsert, []byte(data)})
+		default:
+			return nil, errDMPSyntax
+		}
+	}
+
+	return hunks, s.Err()
+}
+
+// dmpRange decodes the start and length of a header range. Starts are written from
+// 1, except before an empty range.
+func dmpRange(start, length string) (int, int) {
+	s, _ := strconv.Atoi(start)
+	switch length {
+	case "":
+		return s - 1, 1
+	case "0":
+		return s, 0
+	}
+	l, _ := strconv.Atoi(length)
+	return s - 1, l
+}
+
+// applyDMP applies hunks to before the way patch_apply does, except that a hunk that
+// isn't found is an error rather than skipped. The hunks are expected at their
+// offsets in the output, which counts characters.
+func applyDMP(before []byte, hunks []dmpHunk) ([]byte, error) {
+	text := append([]byte(nil), before...)
+
+	// Hunks are in order, so each is expected at its offset counted on from where the
+	// last one ended, which takes account of the drift between them.
+	byteEnd, charEnd := 0, 0
+	for i, h := range hunks {
+		text1 := h.text1()
+		expected := charOffset(text, h.start2)
+		if h.start2 >= charEnd {
+			expected = byteEnd + charOffset(text[byteEnd:], h.start2-charEnd)
+		}
+
+		start, end := -1, -1
+		if len(text1) > dmpMatchMaxBits {
+			// Long hunks are located by their ends.
+			start = matchMain(text, text1[:dmpMatchMaxBits], expected)
+			if start != -1 {
+				end = matchMain(text, text1[len(text1)-dmpMatchMaxBits:], expected+len(text1)-dmpMatchMaxBits)
+				if end == -1 || start >= end {
+					start = -1
+				}
+			}
+		} else {
+			start = matchMain(text, text1, expected)
+		}
+		if start == -1 {
+			return nil, fmt.Errorf("%w: hunk %d", ErrDMPMismatch, i+1)
+		}
+
+		var found []byte
+		if end == -1 {
+			found = text[start:min(start+len(text1), len(text))]
+		} else {
+			found = text[start:min(end+dmpMatchMaxBits, len(text))]
+		}
+
+		var next []byte
+		if bytes.Equal(text1, found) {
+			next = append(append(append(next, text[:start]...), h.text2()...), text[start+len(text1):]...)
+		} else {
+			// Map the edits of the hunk onto the text that was found instead.
+			diffs := diffMain(text1, found, DefaultTimeout)
+			if len(text1) > dmpMatchMaxBits && float64(levenshtein(diffs))/float64(len(text1)) > dmpDeleteThreshold {
+				return nil, fmt.Errorf("%w: hunk %d", ErrDMPMismatch, i+1)
+			}
+
+			next = text
+			index1 := 0
+			for _, d := range h.diffs {
+				switch d.Type {
+				case OpInsert:
+					at := start + xIndex(diffs, index1)
+					next = append(append(append([]byte(nil), next[:at]...), d.Text...), next[at:]...)
+				case OpDelete:
+					from := start + xIndex(diffs, index1)
+					to := start + xIndex(diffs, index1+len(d.Text))
+					next = append(append([]byte(nil), next[:from]...), next[to:]...)
+				}
+				if d.Type != OpDelete {
+					index1 += len(d.Text)
+				}
+			}
+		}
+
+		byteEnd = min(start+len(found)+len(next)-len(text), len(next))
+		charEnd = h.start2 + h.length2
+		text = next
+	}
+
+	return text, nil
+}
+
+// charOffset returns the byte offset of the character n characters into text, or the
+// length of text if it is shorter.
+func charOffset(text []byte, n int) int {
+	offset := 0
+	for ; n > 0 && offset < len(text); n-- {
+		_, size := utf8.DecodeRune(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// matchMain returns the offset in text of the best match for pattern near loc, or -1
+// if there is none, like match_main.
+func matchMain(text, pattern []byte, loc int) int {
+	loc = max(0, min(loc, len(text)))
+	switch {
+	case bytes.Equal(text, pattern):
+		return 0
+	case len(text) == 0:
+		return -1
+	case loc+len(pattern) <= len(text) && bytes.Equal(text[loc:loc+len(pattern)], pattern):
+		return loc
+	}
+	return matchBitap(text, pattern, loc)
+}
+
+// matchBitap returns the offset in text of the best approximate match for pattern
+// near loc, or -1 if none scores below dmpMatchThreshold, using the Bitap algorithm.
+// Scores combine the edits in a match and its distance from loc. The pattern must be
+// no longer than dmpMatchMaxBits.
+func matchBitap(text, pattern []byte, loc int) int {
+	var alphabet [256]int
+	for i, c := range pattern {
+		alphabet[c] |= 1 << uint(len(pattern)-i-1)
+	}
+
+	// Exact matches bound the score worth searching for.
+	threshold := float64(dmpMatchThreshold)
+	if best := bytesIndexOf(text, pattern, loc); best != -1 {
+		threshold = math.Min(bitapScore(0, best, loc, pattern), threshold)
+		if best = bytes.LastIndex(text[:min(loc+len(pattern), len(text))], pattern); best != -1 {
+			threshold = math.Min(bitapScore(0, best, loc, pattern), threshold)
+		}
+	}
+
+	matchMask := 1 << uint(len(pattern)-1)
+	best := -1
+	binMax := len(pattern) + len(text)
+	var lastRd []int
+	for d := 0; d < len(pattern); d++ {
+		// Find how far from loc a match with d errors could still be good enough.
+		binMin, binMid := 0, binMax
+		for binMin < binMid {
+			if bitapScore(d, loc+binMid, loc, pattern) <= threshold {
+				binMin = binMid
+			} else {
+				binMax = binMid
+			}
+			binMid = (binMax-binMin)/2 + binMin
+		}
+		binMax = binMid
+
+		start := max(1, loc-binMid+1)
+		finish := min(loc+binMid, len(text)) + len(pattern)
+
+		rd := make([]int, finish+2)
+		rd[finish+1] = 1<<uint(d) - 1
+		for j := finish; j >= start; j-- {
+			charMatch := 0
+			if j-1 < len(text) {
+				charMatch = alphabet[text[j-1]]
+			}
+			if d == 0 {
+				rd[j] = (rd[j+1]<<1 | 1) & charMatch
+			} else {
+				rd[j] = (rd[j+1]<<1|1)&charMatch | ((lastRd[j+1]|lastRd[j])<<1 | 1) | lastRd[j+1]
+			}
+
+			if rd[j]&matchMask != 0 {
+				if score := bitapScore(d, j-1, loc, pattern); score <= threshold {
+					threshold = score
+					best = j - 1
+					if best <= loc {
+						break
+					}
+					// Past loc, don't search further away than the match found.
+					start = max(1, 2*loc-best)
+				}
+			}
+		}
+
+		if bitapScore(d+1, loc, loc, pattern) > threshold {
+			break
+		}
+		lastRd = rd
+	}
+
+	return best
+}
+
+// bitapScore scores a match at x with e errors, for a match expected at loc.
+func bitapScore(e, x, loc int, pattern []byte) float64 {
+	accuracy := float64(e) / float64(len(pattern))
+	proximity := math.Abs(float64(loc - x))
+	return accuracy + proximity/dmpMatchDistance
+}
+
+// xIndex maps the offset loc in the first text of diffs to the second, like
+// diff_xIndex. An offset inside a deletion maps to the start of the deletion.
+func xIndex(diffs []diff, loc int) int {
+	chars1, chars2 := 0, 0
+	last1, last2 := 0, 0
+	for _, d := range diffs {
+		if d.Type != OpInsert {
+			chars1 += len(d.Text)
+		}
+		if d.Type != OpDelete {
+			chars2 += len(d.Text)
+		}
+		if chars1 > loc {
+			if d.Type == OpDelete {
+				return last2
+			}
+			break
+		}
+		last1, last2 = chars1, chars2
+	}
+	return last2 + loc - last1
+}
+
+// levenshtein returns the number of bytes inserted, deleted or substituted by diffs.
+func levenshtein(diffs []diff) int {
+	var total, inserted, deleted int
+	for _, d := range diffs {
+		switch d.Type {
+		case OpInsert:
+			inserted += len(d.Text)
+		case OpDelete:
+			deleted += len(d.Text)
+		case OpCopy:
+			total += max(inserted, deleted)
+			inserted, deleted = 0, 0
+		}
+	}
+	return total + max(inserted, deleted)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
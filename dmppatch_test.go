@@ -0,0 +1,91 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDMP(t *testing.T) {
+	// Written by patch_toText(patch_make(
+	//   "The quick brown fox jumps over the lazy dog.",
+	//   "That quick brown fox jumped over a lazy dog."))
+	text := "@@ -1,8 +1,7 @@\n Th\n-e\n+at\n  quick b\n@@ -22,18 +22,17 @@\n jump\n-s\n+ed\n  over \n-the\n+a\n  laz\n"
+
+	for _, tc := range []struct{ before, after string }{
+		{"The quick brown fox jumps over the lazy dog.", "That quick brown fox jumped over a lazy dog."},
+		// The hunks are found in a text that has changed around them.
+		{"The quick red rabbit jumps over the tired tiger.", "That quick red rabbit jumped over a tired tiger."},
+		{"Well, the quick brown fox jumps over the lazy dog.", "Well, that quick brown fox jumped over a lazy dog."},
+	} {
+		var after bytes.Buffer
+		assert.NoError(t, ApplyDMP(strings.NewReader(tc.before), strings.NewReader(text), &after))
+		assert.Equal(t, tc.after, after.String())
+	}
+
+	var after bytes.Buffer
+	err := ApplyDMP(strings.NewReader("I am the very model of a modern major general."), strings.NewReader(text), &after)
+	assert.True(t, errors.Is(err, ErrDMPMismatch))
+
+	// Encoded characters, and offsets that count characters rather than bytes.
+	text = "@@ -0,0 +1,4 @@\n+test\n"
+	after.Reset()
+	assert.NoError(t, ApplyDMP(strings.NewReader(""), strings.NewReader(text), &after))
+	assert.Equal(t, "test", after.String())
+
+	text = "@@ -3,7 +3,9 @@\n llo \n-w\n+%C3%BC+%0A\n örld\n"
+	after.Reset()
+	assert.NoError(t, ApplyDMP(strings.NewReader("héllo wörld"), strings.NewReader(text), &after))
+	assert.Equal(t, "héllo ü+\nörld", after.String())
+
+	// The patch copies what the hunks don't change.
+	long := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog.\n"), 100)
+	var patch bytes.Buffer
+	assert.NoError(t, FromDMP(long, strings.NewReader("@@ -2236,13 +2236,12 @@\n  the \n-lazy\n+shy\n  dog\n"), &patch))
+	assert.Less(t, patch.Len(), 100)
+	after.Reset()
+	assert.NoError(t, ApplyPatch(bytes.NewReader(long), &patch, &after))
+	assert.Equal(t, 2235, bytes.Index(after.Bytes(), []byte(" the shy dog")))
+
+	for _, text := range []string{"Bad\n", "@@ -1 +1 @@\n*x\n", "@@ -1,2 +1,2\n", " orphan\n"} {
+		err := ApplyDMP(strings.NewReader("xy"), strings.NewReader(text), &after)
+		assert.Equal(t, errDMPSyntax, err, text)
+	}
+}
+
+func TestToDMP(t *testing.T) {
+	a := []byte("The quick brown fox jumps over the lazy dog.")
+	b := []byte("That quick brown fox jumped over a lazy dog.")
+
+	var patch, text bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+	assert.NoError(t, ToDMP(a, patch.Bytes(), &text))
+	assert.Equal(t, "@@ -1,11 +1,12 @@\n Th\n-e\n+at\n  quick b\n@@ -22,18 +22,17 @@\n jump\n-s\n+ed\n  over \n-the\n+a\n  laz\n", text.String())
+
+	// Patches survive conversion and application by diff-match-patch, including
+	// edits that split characters.
+	long := strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 20)
+	for _, tc := range []struct{ before, after string }{
+		{"a€b", "a£b"},
+		{"héllo wörld", "hëllo wörld!"},
+		{"", "new\n"},
+		{"100% sure?", "50% + 50% sure?"},
+		{long, strings.Replace(long, "lazy", "shy", 3) + "The end."},
+	} {
+		patch.Reset()
+		text.Reset()
+		assert.NoError(t, MakePatch(strings.NewReader(tc.before), strings.NewReader(tc.after), &patch))
+		assert.NoError(t, ToDMP([]byte(tc.before), patch.Bytes(), &text))
+
+		var after bytes.Buffer
+		assert.NoError(t, ApplyDMP(strings.NewReader(tc.before), &text, &after))
+		assert.Equal(t, tc.after, after.String())
+	}
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader([]byte("\xff")), &patch))
+	assert.Equal(t, ErrDMPText, ToDMP(a, patch.Bytes(), &text))
+}
@@ -0,0 +1,324 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errEdSyntax = errors.New("invalid ed script")
+
+// ErrEdNewline is returned by ToEd when the output of a patch changes a line that
+// doesn't end in a newline, which an ed script can't express.
+var ErrEdNewline = errors.New("ed script can't represent a missing final newline")
+
+// ToEd converts patch to an ed script that turns before into the output, in the
+// format of diff -e. The script edits whole lines, from the end of the file back,
+// so that the line numbers of each command refer to before. Inserted lines
+// consisting of a single "." are written as ".." and fixed with a substitution.
+func ToEd(before, patch []byte, script io.Writer) error {
+	hunks, err := Hunks(before, patch, 0)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(script)
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		if len(h.After) > 0 && h.After[len(h.After)-1] != '\n' {
+			return ErrEdNewline
+		}
+
+		last := h.BeforeLine + h.BeforeLines - 1
+		switch {
+		case h.BeforeLines == 0:
+			fmt.Fprintf(w, "%da\n", h.BeforeLine-1)
+		case h.AfterLines == 0:
+			fmt.Fprintf(w, "%sd\n", edRange(h.BeforeLine, last))
+			continue
+		default:
+			fmt.Fprintf(w, "%sc\n", edRange(h.BeforeLine, last))
+		}
+
+		// Appended and changed lines both start at the first line of the hunk.
+		var dots []int
+		for n, line := range bytes.SplitAfter(h.After, []byte{'\n'}) {
+			if bytes.Equal(line, []byte(".\n")) {
+				dots = append(dots, h.BeforeLine+n)
+				line = []byte("..\n")
+			}
+			w.Write(line)
+		}
+		w.WriteString(".\n")
+		for _, n := range dots {
+			fmt.Fprintf(w, "%ds/.//\n", n)
+		}
+	}
+
+	return w.Flush()
+}
+
+// edRange formats the line range first-last as an ed address.
+func edRange(first, last int) string {
+	if first == last {
+		return fmt.Sprint(first)
+	}
+	return fmt.Sprintf("%d,%d", first, last)
+}
+
+// FromEd converts an ed script that edits before into a patch, written to patch with
+// the options given. Both the output of diff -e, whose commands run from the end of
+// the file back, and RCS deltas, written by diff -n, are accepted. Of ed's commands,
+// a, i, c, d, the s/.// used to escape lines of a single dot, w and q are supported,
+// with numeric addresses.
+func FromEd(before []byte, script io.Reader, patch io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	start := time.Now()
+
+	beforeLines := bytes.SplitAfter(before, []byte{'\n'})
+	if len(beforeLines[len(beforeLines)-1]) == 0 {
+		beforeLines = beforeLines[:len(beforeLines)-1]
+	}
+	lines, err := runEd(beforeLines, script)
+	if err != nil {
+		return err
+	}
+
+	// The lines of before left in the buffer are copied, the rest are deleted.
+	var diffs []diff
+	var after []byte
+	a := 0 // The next line of before
+	for _, l := range lines {
+		after = append(after, l.text...)
+		if l.line < 0 {
+			diffs = append(diffs, diff{OpInsert, l.text})
+			continue
+		}
+		for ; a < l.line; a++ {
+			diffs = append(diffs, diff{OpDelete, beforeLines[a]})
+		}
+		diffs = append(diffs, diff{OpCopy, l.text})
+		a++
+	}
+	for ; a < len(beforeLines); a++ {
+		diffs = append(diffs, diff{OpDelete, beforeLines[a]})
+	}
+
+	return writePatch(patch, before, after, diffCleanupMerge(diffs), cfg, start, 0)
+}
+
+// ApplyEd applies an ed script to before, writing the output to after. The script is
+// converted to a patch with FromEd and applied with ApplyPatch, which verifies the
+// output against its CRC.
+func ApplyEd(before, script io.Reader, after io.Writer) error {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return err
+	}
+
+	var patch bytes.Buffer
+	if err := FromEd(beforeBytes, script, &patch); err != nil {
+		return err
+	}
+
+	return ApplyPatch(bytes.NewReader(beforeBytes), &patch, after)
+}
+
+// edLine is a line of the ed buffer, with the index of the line of before it came from,
+// or -1 if it was added by the script.
+type edLine struct {
+	line int
+	text []byte
+}
+
+// runEd runs script against the lines of before and returns the resulting buffer.
+func runEd(before [][]byte, script io.Reader) ([]edLine, error) {
+	buf := make([]edLine, len(before))
+	for i, text := range before {
+		buf[i] = edLine{i, text}
+	}
+
+	r := bufio.NewReader(script)
+	line := 0 // The line of the script, for errors
+	cur := len(buf)
+	shift := 0 // The offset of RCS line numbers, which refer to before
+
+	readLine := func() ([]byte, error) {
+		text, err := r.ReadBytes('\n')
+		if err == io.EOF && len(text) > 0 {
+			err = nil
+		}
+		line++
+		return text, err
+	}
+
+	// readText reads lines until a "." line, for a, i and c.
+	readText := func() ([]edLine, error) {
+		var text []edLine
+		for {
+			l, err := readLine()
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
+			}
+			// Only "\n" is trimmed, so that a ".\r\n" line is text.
+			if string(bytes.TrimSuffix(l, []byte("\n"))) == "." {
+				return text, nil
+			}
+			text = append(text, edLine{-1, l})
+		}
+	}
+
+	// readCount reads n lines, for an RCS a. The lines are appended as they are read,
+	// so that a huge n in a hostile script fails at the end of the script rather than
+	// allocating n lines up front.
+	readCount := func(n int) ([]edLine, error) {
+		var text []edLine
+		for i := 0; i < n; i++ {
+			l, err := readLine()
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
+			}
+			text = append(text, edLine{-1, l})
+		}
+		return text, nil
+	}
+
+	splice := func(first, last int, text []edLine) {
+		buf = append(buf[:first], append(text, buf[last:]...)...)
+	}
+
+	for {
+		cmd, err := readLine()
+		if err == io.EOF {
+			return buf, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		c := string(bytes.TrimRight(cmd, "\r\n"))
+		if c == "" {
+			continue
+		}
+
+		fail := func(err error) ([]edLine, error) {
+			return nil, fmt.Errorf("%v: line %d: %v", errEdSyntax, line, err)
+		}
+
+		// RCS commands address before and give a line count, e.g. "d3 2".
+		if (c[0] == 'a' || c[0] == 'd') && len(c) > 1 && c[1] >= '0' && c[1] <= '9' {
+			var n, count int
+			if _, err := fmt.Sscanf(c[1:], "%d %d", &n, &count); err != nil {
+				return fail(err)
+			}
+			if count < 1 {
+				return fail(errEdCount)
+			}
+			if c[0] == 'a' {
+				if n+shift < 0 || n+shift > len(buf) {
+					return fail(errEdRange)
+				}
+				text, err := readCount(count)
+				if err != nil {
+					return fail(err)
+				}
+				splice(n+shift, n+shift, text)
+				shift += count
+			} else {
+				if n+shift < 1 || n+shift > len(buf) || count > len(buf)-(n+shift-1) {
+					return fail(errEdRange)
+				}
+				splice(n+shift-1, n+shift-1+count, nil)
+				shift -= count
+			}
+			continue
+		}
+
+		first, last, rest, err := edAddress(c, cur)
+		if err != nil {
+			return fail(err)
+		}
+
+		switch rest {
+		case "a", "i", "c":
+			if rest == "i" && first > 0 {
+				first--
+				last--
+			}
+			lo, hi := first, first
+			if rest == "c" {
+				if first < 1 || last > len(buf) || first > last {
+					return fail(errEdRange)
+				}
+				lo, hi = first-1, last
+			} else if first < 0 || first > len(buf) {
+				return fail(errEdRange)
+			}
+			text, err := readText()
+			if err != nil {
+				return fail(err)
+			}
+			splice(lo, hi, text)
+			cur = lo + len(text)
+		case "d":
+			if first < 1 || last > len(buf) || first > last {
+				return fail(errEdRange)
+			}
+			splice(first-1, last, nil)
+			cur = first
+			if cur > len(buf) {
+				cur = len(buf)
+			}
+		case "s/.//", "s/^\\.//":
+			if first < 1 || first > len(buf) {
+				return fail(errEdRange)
+			}
+			buf[first-1] = edLine{-1, buf[first-1].text[1:]}
+			cur = first
+		case "w":
+		case "q", "wq":
+			return buf, nil
+		default:
+			return fail(fmt.Errorf("unsupported command %q", c))
+		}
+	}
+}
+
+var (
+	errEdRange = errors.New("line out of range")
+	errEdCount = errors.New("line count must be positive")
+)
+
+// edAddress splits the address from an ed command, returning the first and last lines
+// and the command. Without an address, both lines are cur.
+func edAddress(c string, cur int) (first, last int, cmd string, err error) {
+	i := 0
+	for i < len(c) && (c[i] >= '0' && c[i] <= '9' || c[i] == ',') {
+		i++
+	}
+	addr, cmd := c[:i], c[i:]
+	if addr == "" {
+		return cur, cur, cmd, nil
+	}
+
+	from, to := addr, addr
+	if j := strings.IndexByte(addr, ','); j >= 0 {
+		from, to = addr[:j], addr[j+1:]
+	}
+	if first, err = strconv.Atoi(from); err != nil {
+		return 0, 0, "", err
+	}
+	if last, err = strconv.Atoi(to); err != nil {
+		return 0, 0, "", err
+	}
+	return first, last, cmd, nil
+}
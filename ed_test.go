@@ -0,0 +1,88 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToEd(t *testing.T) {
+	a := []byte("one\ntwo\nthree\nfour\nfive\nsix\n")
+	b := []byte("zero\none\n2\nthree\n.\nfive\n")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+
+	var script bytes.Buffer
+	assert.NoError(t, ToEd(a, patch.Bytes(), &script))
+	assert.Equal(t, "6d\n4c\n..\n.\n4s/.//\n2c\n2\n.\n0a\nzero\n.\n", script.String())
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader([]byte("one\ntwo")), &patch))
+	assert.Equal(t, ErrEdNewline, ToEd(a, patch.Bytes(), &script))
+}
+
+func TestApplyEd(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\n"
+	b := "zero\none\n2\nthree\n.\nfive\nseven\neight\n"
+
+	for _, script := range []string{
+		// diff -e
+		"6c\nseven\neight\n.\n4c\n..\n.\ns/.//\n2c\n2\n.\n0a\nzero\n.\n",
+		// diff -n
+		"a0 1\nzero\nd2 1\na2 1\n2\nd4 1\na4 1\n.\nd6 1\na6 2\nseven\neight\n",
+	} {
+		var after bytes.Buffer
+		assert.NoError(t, ApplyEd(bytes.NewReader([]byte(a)), bytes.NewReader([]byte(script)), &after))
+		assert.Equal(t, b, after.String())
+	}
+
+	// The patch copies the lines that weren't changed.
+	long := bytes.Repeat([]byte("The quick brown fox jumped over the lazy dog.\n"), 100)
+	var patch bytes.Buffer
+	assert.NoError(t, FromEd(long, bytes.NewReader([]byte("50c\nchanged\n.\n")), &patch))
+	assert.Less(t, patch.Len(), 100)
+
+	// A patch survives conversion to an ed script and back.
+	var script, after bytes.Buffer
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader([]byte(a)), bytes.NewReader([]byte(b)), &patch))
+	assert.NoError(t, ToEd([]byte(a), patch.Bytes(), &script))
+	assert.NoError(t, ApplyEd(bytes.NewReader([]byte(a)), &script, &after))
+	assert.Equal(t, b, after.String())
+
+	// Lines ending in CRLF, including ".\r\n", are kept as they are.
+	for _, tc := range [][2]string{
+		{".", ".\r\n\\\r\n%.é\n"},
+		{"one\r\ntwo\r\n", "one\r\n.\r\ntwo\r\n.\n"},
+	} {
+		patch.Reset()
+		script.Reset()
+		after.Reset()
+		assert.NoError(t, MakePatch(bytes.NewReader([]byte(tc[0])), bytes.NewReader([]byte(tc[1])), &patch))
+		assert.NoError(t, ToEd([]byte(tc[0]), patch.Bytes(), &script))
+		assert.NoError(t, ApplyEd(bytes.NewReader([]byte(tc[0])), &script, &after))
+		assert.Equal(t, tc[1], after.String())
+	}
+
+	err := ApplyEd(bytes.NewReader([]byte(a)), bytes.NewReader([]byte("9d\n")), &after)
+	assert.EqualError(t, err, "invalid ed script: line 1: line out of range")
+
+	// Bad RCS line counts are errors, not panics or huge allocations.
+	for _, tc := range []struct {
+		script, err string
+	}{
+		{"a0 -1\n", "invalid ed script: line 1: line count must be positive"},
+		{"d1 -1\n", "invalid ed script: line 1: line count must be positive"},
+		{"d2 -1\n", "invalid ed script: line 1: line count must be positive"},
+		{"a0 0\n", "invalid ed script: line 1: line count must be positive"},
+		{"d1 0\n", "invalid ed script: line 1: line count must be positive"},
+		{"a0 999999999999\nzero\n", "invalid ed script: line 3: unexpected EOF"},
+		{"d1 999999999999\n", "invalid ed script: line 1: line out of range"},
+		{"d1 9223372036854775807\n", "invalid ed script: line 1: line out of range"},
+	} {
+		err := ApplyEd(bytes.NewReader([]byte(a)), bytes.NewReader([]byte(tc.script)), new(bytes.Buffer))
+		assert.EqualError(t, err, tc.err, tc.script)
+	}
+}
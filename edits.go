@@ -0,0 +1,123 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// An Edit is one operation of a patch, resolved against the before input.
+type Edit struct {
+	Op   byte   // OpCopy, OpDelete or OpInsert
+	Text []byte // The bytes copied or deleted from before, or inserted
+}
+
+// Edits applies patch to before, verifying it, and returns its copy, delete and
+// insert operations. Checked inserts are returned as OpInsert, and records that
+// don't affect the output are left out. Any of before that the patch doesn't
+// reach is returned as a final delete. The text of the edits refers to before and
+// to the output, which is not otherwise returned.
+func Edits(before, patch []byte) ([]Edit, error) {
+	edits, _, err := decodeEdits(before, patch)
+	return edits, err
+}
+
+// Diff diffs before and after and returns the copies, deletes and inserts that change
+// before into after, without encoding them as a patch, for applications that render
+// or post-process diffs. It uses the same diff as MakePatch, with the options that
+// choose it: WithAlgorithm, WithGranularity, WithTokenizer and WithTimeout. Unlike
+// MakePatch, GranularityXML doesn't canonicalize the inputs, so the edits apply to
+// them as they are. The text of the edits may refer to before and after.
+func Diff(before, after []byte, opts ...Option) []Edit {
+	cfg := newConfig(opts)
+	cfg.adjustGranularity(before, after)
+
+	diffs := cfg.diff(before, after, cfg.diffTimeout(DefaultTimeout))
+	edits := make([]Edit, 0, len(diffs))
+	for _, d := range diffs {
+		if len(d.Text) > 0 {
+			edits = append(edits, Edit{d.Type, d.Text})
+		}
+	}
+	return edits
+}
+
+// decodeEdits returns the edits of patch along with the output it produces.
+func decodeEdits(before, patch []byte) ([]Edit, []byte, error) {
+	var after bytes.Buffer
+	if err := ApplyPatch(bytes.NewReader(before), bytes.NewReader(patch), &after); err != nil {
+		return nil, nil, err
+	}
+
+	r, err := openPatch(bytes.NewReader(patch))
+	if err != nil {
+		return nil, nil, err
+	}
+	patchBR := bufio.NewReader(r)
+
+	var edits []Edit
+	var a, b int
+	for {
+		op, err := patchBR.ReadByte()
+		if err == io.EOF {
+			// ApplyPatch ignores the end of before when the patch doesn't consume it,
+			// e.g. for a naive patch, which is the same as deleting it.
+			if a < len(before) {
+				edits = append(edits, Edit{OpDelete, before[a:]})
+			}
+			return edits, after.Bytes(), nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		switch op {
+		case OpBLAKE3, OpXXH3:
+			patchBR.Discard(digestSize(op))
+			continue
+		case OpCRC, OpBeforeCRC:
+			patchBR.Discard(4)
+			continue
+		case OpCopyToEnd:
+			edits = append(edits, Edit{OpCopy, before[a:]})
+			b += len(before) - a
+			a = len(before)
+			continue
+		}
+
+		tl, err := readLength(patchBR)
+		if err != nil {
+			return nil, nil, err
+		}
+		n := int(tl)
+
+		switch op {
+		case OpCopy:
+			edits = append(edits, Edit{OpCopy, before[a : a+n]})
+			a += n
+			b += n
+		case OpDelete:
+			edits = append(edits, Edit{OpDelete, before[a : a+n]})
+			a += n
+		case OpInsert, OpCheckedInsert:
+			edits = append(edits, Edit{OpInsert, after.Bytes()[b : b+n]})
+			b += n
+			patchBR.Discard(n)
+			if op == OpCheckedInsert {
+				patchBR.Discard(4)
+			}
+		case OpReplace:
+			il, err := readLength(patchBR)
+			if err != nil {
+				return nil, nil, err
+			}
+			edits = append(edits, Edit{OpDelete, before[a : a+n]}, Edit{OpInsert, after.Bytes()[b : b+int(il)]})
+			a += n
+			b += int(il)
+			patchBR.Discard(int(il))
+		case OpMetadata, OpAnnotation, OpPadding, OpContext, OpCheckpoint:
+			patchBR.Discard(n)
+		default:
+			return nil, nil, unexpectedOp(op)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEdits(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog")
+	b := []byte("The quick brown fox leaped over the lazy dog.")
+
+	var patchr bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithInsertChecksums(), WithProvenance())
+	assert.NoError(t, err)
+
+	edits, err := Edits(a, patchr.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, []Edit{
+		{OpCopy, []byte("The quick brown fox ")},
+		{OpDelete, []byte("jum")},
+		{OpInsert, []byte("lea")},
+		{OpCopy, []byte("ped over the lazy dog")},
+		{OpInsert, []byte(".")},
+	}, edits)
+
+	_, err = Edits([]byte("The quick brown cat jumped over the lazy dog"), patchr.Bytes())
+	assert.Equal(t, ErrCRC, err)
+}
+
+func TestDiff(t *testing.T) {
+	a := []byte("The quick brown fox. It jumped over the lazy dog.")
+	b := []byte("The quick brown cat. It jumped over the lazy dog.")
+
+	assert.Equal(t, []Edit{
+		{OpCopy, []byte("The quick brown ")},
+		{OpDelete, []byte("fox")},
+		{OpInsert, []byte("cat")},
+		{OpCopy, []byte(". It jumped over the lazy dog.")},
+	}, Diff(a, b))
+
+	assert.Equal(t, []Edit{
+		{OpDelete, []byte("The quick brown fox. ")},
+		{OpInsert, []byte("The quick brown cat. ")},
+		{OpCopy, []byte("It jumped over the lazy dog.")},
+	}, Diff(a, b, WithGranularity(GranularitySentence)))
+
+	// The edits apply like those of a patch.
+	for _, opts := range [][]Option{nil, {WithAlgorithm(AlgorithmSuffixArray)}, {WithTokenizer(DelimiterTokenizer(" "))}} {
+		var out []byte
+		for _, e := range Diff(a, b, opts...) {
+			if e.Op != OpDelete {
+				out = append(out, e.Text...)
+			}
+		}
+		assert.Equal(t, b, out)
+	}
+
+	assert.Empty(t, Diff(nil, nil))
+}
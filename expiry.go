@@ -0,0 +1,62 @@
+package lightpatch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// notAfterKey is the metadata key of the expiry time set by WithNotAfter.
+const notAfterKey = "not-after"
+
+// ErrExpired is returned by ApplyPatch in strict mode for a patch applied after its
+// expiry time.
+var ErrExpired = errors.New("patch has expired")
+
+// WithNotAfter causes MakePatch to record t in the patch metadata as the time after
+// which the patch expires. Expiry is only enforced by ApplyPatch in strict mode, so
+// that a time-limited update can't be applied long after it was superseded.
+func WithNotAfter(t time.Time) Option {
+	return func(c *config) {
+		c.notAfter = t
+	}
+}
+
+// checkExpiry reads the l data bytes of a metadata record from r, and returns
+// ErrExpired if the expiry time it records has passed.
+func checkExpiry(r io.Reader, l uint64) error {
+	m := Metadata{}
+	if err := readMetadata(m, r, l); err != nil {
+		return err
+	}
+
+	s, ok := m[notAfterKey]
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("%w: bad %s time %q", ErrMalformed, notAfterKey, s)
+	}
+	if time.Now().After(t) {
+		return fmt.Errorf("%w at %s", ErrExpired, s)
+	}
+	return nil
+}
+
+// patchMetadata returns the metadata record MakePatch writes for cfg, or nil if
+// there is none.
+func patchMetadata(cfg config, timeout time.Duration, timedOut, naive bool) []byte {
+	m := Metadata{}
+	if cfg.provenance {
+		m = provenance(cfg, timeout, timedOut, naive)
+	}
+	if !cfg.notAfter.IsZero() {
+		m[notAfterKey] = cfg.notAfter.UTC().Format(time.RFC3339)
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m.record()
+}
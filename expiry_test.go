@@ -0,0 +1,48 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotAfter(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	expiry := time.Date(2021, 3, 1, 9, 0, 0, 0, time.FixedZone("", 3600))
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithNotAfter(expiry), WithProvenance()))
+	m, err := ReadMetadata(bytes.NewReader(patch.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "2021-03-01T08:00:00Z", m["not-after"])
+	assert.Equal(t, "myers", m["algorithm"])
+
+	// Expiry is only enforced in strict mode.
+	var c bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &c))
+	assert.Equal(t, b, c.Bytes())
+	c.Reset()
+	err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &c, WithStrict())
+	assert.True(t, errors.Is(err, ErrExpired))
+	assert.Zero(t, c.Len())
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithNotAfter(time.Now().Add(time.Hour))))
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &c, WithStrict()))
+	assert.Equal(t, b, c.Bytes())
+
+	// Patches without an expiry apply in strict mode.
+	patch.Reset()
+	c.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+	assert.Equal(t, OpCopy, patch.Bytes()[0])
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &c, WithStrict()))
+
+	bad := append(Metadata{"not-after": "soon"}.record(), patch.Bytes()...)
+	err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(bad), &c, WithStrict())
+	assert.True(t, errors.Is(err, ErrMalformed))
+}
@@ -0,0 +1,135 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// ErrFEC is returned when too many shards of a patch with forward error correction
+// are damaged or missing to reconstruct it.
+var ErrFEC = errors.New("too many damaged FEC shards")
+
+// fecParams are the shard counts of a FEC envelope.
+type fecParams struct {
+	data, parity int
+}
+
+// WithFEC wraps the patch in a Reed-Solomon envelope of dataShards data shards and
+// parityShards parity shards, each with its own CRC-32. ApplyPatch reconstructs the
+// patch as long as no more than parityShards shards are damaged or missing. This
+// suits lossy links, such as radio, where a segment that is dropped can be left as
+// a gap of the same size, e.g. zero-filled. Missing shards at the end of the patch
+// may simply be left out.
+//
+// The envelope is built once the patch is complete, so the patch is buffered in
+// memory. The output checksum is still verified once the patch is applied.
+func WithFEC(dataShards, parityShards int) Option {
+	return func(c *config) {
+		c.fec = &fecParams{data: dataShards, parity: parityShards}
+	}
+}
+
+// writeFEC writes patch to w in a FEC envelope:
+//
+//	F <data shards> <parity shards> <patch len> [<crc> <shard>]...
+//
+// The counts and length are varints, and all shards are the patch length divided by
+// the number of data shards, rounded up.
+func writeFEC(w io.Writer, patch []byte, p fecParams) error {
+	enc, err := reedsolomon.New(p.data, p.parity)
+	if err != nil {
+		return err
+	}
+
+	shards, err := enc.Split(patch)
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(shards); err != nil {
+		return err
+	}
+
+	header := []byte{OpFEC}
+	for _, v := range []int{p.data, p.parity, len(patch)} {
+		header = appendUvarint(header, uint64(v))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	crc := make([]byte, 4)
+	for _, shard := range shards {
+		binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(shard))
+		if _, err := w.Write(crc); err != nil {
+			return err
+		}
+		if _, err := w.Write(shard); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFEC reads a FEC envelope, following the op byte, and returns the patch inside.
+func readFEC(r *bufio.Reader) ([]byte, error) {
+	var params [3]uint64
+	for i := range params {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		params[i] = v
+	}
+
+	// Bound the counts before converting them, reedsolomon checks them further.
+	const maxShards = 1 << 8
+	if params[0] == 0 || params[0] > maxShards || params[1] > maxShards {
+		return nil, reedsolomon.ErrInvShardNum
+	}
+	dataShards, parityShards, size := int(params[0]), int(params[1]), params[2]
+
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	shardSize := (size + params[0] - 1) / params[0]
+	shards := make([][]byte, dataShards+parityShards)
+	crc := make([]byte, 4)
+	for i := range shards {
+		if _, err := io.ReadFull(r, crc); err != nil {
+			break
+		}
+
+		var shard bytes.Buffer
+		if _, err := io.CopyN(&shard, r, int64(shardSize)); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(shard.Bytes()) == binary.BigEndian.Uint32(crc) {
+			shards[i] = shard.Bytes()
+		}
+	}
+
+	if err := enc.ReconstructData(shards); err != nil {
+		return nil, ErrFEC
+	}
+
+	var patch bytes.Buffer
+	if err := enc.Join(&patch, shards, int(size)); err != nil {
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
@@ -0,0 +1,66 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFEC(t *testing.T) {
+	a := []byte(strings.Repeat("The quick brown fox jumped over the lazy dog. ", 20))
+	b := []byte(strings.Repeat("The quick brown cat jumped over the dog! ", 20))
+
+	var plain bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &plain)
+	assert.NoError(t, err)
+
+	var patchr bytes.Buffer
+	err = MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithFEC(4, 2))
+	assert.NoError(t, err)
+	patch := patchr.Bytes()
+
+	header := len(appendUvarint(appendUvarint(appendUvarint([]byte{OpFEC}, 4), 2), uint64(plain.Len())))
+	shardSize := (plain.Len() + 3) / 4
+	assert.Equal(t, header+6*(4+shardSize), len(patch))
+
+	apply := func(patch []byte) error {
+		var c bytes.Buffer
+		err := ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), &c)
+		if err == nil {
+			assert.Equal(t, b, c.Bytes())
+		}
+		return err
+	}
+	damage := func(shards ...int) []byte {
+		p := append([]byte(nil), patch...)
+		for _, i := range shards {
+			start := header + i*(4+shardSize)
+			for j := start; j < start+4+shardSize; j++ {
+				p[j] = 0
+			}
+		}
+		return p
+	}
+
+	assert.NoError(t, apply(patch))
+	assert.NoError(t, apply(damage(0)))
+	assert.NoError(t, apply(damage(1, 4)))
+	assert.NoError(t, apply(damage(2, 3)))
+	assert.Equal(t, ErrFEC, apply(damage(0, 2, 5)))
+
+	// Dropped parity shards at the end
+	assert.NoError(t, apply(patch[:header+4*(4+shardSize)]))
+	assert.NoError(t, apply(damage(1)[:header+5*(4+shardSize)]))
+	assert.Equal(t, ErrFEC, apply(damage(1)[:header+4*(4+shardSize)]))
+
+	// Combined with armor
+	patchr.Reset()
+	err = MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithFEC(4, 2), WithArmor(ArmorBase64))
+	assert.NoError(t, err)
+	assert.NoError(t, apply(patchr.Bytes()))
+
+	err = MakePatch(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), WithFEC(0, 2))
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+//go:build go1.18
+// +build go1.18
+
+package lightpatch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// fuzzSeeds adds patches exercising every record type to f.
+func fuzzSeeds(f *testing.F) {
+	before := []byte("The quick brown fox jumped over the lazy dog.")
+	after := []byte("The quick brown cat jumped over the dog!")
+
+	for _, opts := range [][]Option{
+		nil,
+		{WithInsertChecksums(), WithProvenance(), WithAnnotation([]byte("note"))},
+		{WithChecksum(ChecksumBLAKE3), WithReplace(), WithCopyToEnd()},
+		{WithBlockAlign(16)},
+		{WithFEC(4, 2)},
+		{WithArmor(ArmorBase64)},
+		{WithDedup(chunkMap{}, 0)},
+	} {
+		var patch bytes.Buffer
+		if err := MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, opts...); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(before, patch.Bytes())
+	}
+}
+
+func FuzzApplyPatch(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, before, patch []byte) {
+		ApplyPatch(bytes.NewReader(before), bytes.NewReader(patch), ioutil.Discard)
+		Edits(before, patch)
+	})
+}
+
+func FuzzReadPatch(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, before, patch []byte) {
+		VerifyPatch(bytes.NewReader(patch))
+		ReadMetadata(bytes.NewReader(patch))
+		ToText(bytes.NewReader(patch), ioutil.Discard)
+		ComposePatches(bytes.NewReader(patch), bytes.NewReader(patch), ioutil.Discard)
+		Segments(bytes.NewReader(patch))
+	})
+}
@@ -0,0 +1,248 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ErrNoContext is returned by ApplyPatchFuzzy when a patch that doesn't apply as it
+// is has a change without a context record.
+var ErrNoContext = errors.New("patch has no context to locate a change")
+
+var (
+	errBadContext = fmt.Errorf("%w: bad context record", ErrMalformed)
+	errFuzzyChunk = errors.New("chunked inserts can't be applied fuzzily")
+)
+
+// WithEditContext causes MakePatch to precede each change, i.e. each run of deletes
+// and inserts, with a context record holding the bytes it deletes and up to n bytes
+// of the unchanged text on either side. ApplyPatchFuzzy uses them to locate the
+// changes in a before that has changed since the patch was made.
+func WithEditContext(n int) Option {
+	return func(c *config) {
+		c.editContext = n
+	}
+}
+
+// contextRecord returns the context record of the change starting at diffs[i], with
+// up to n bytes of the copies around it.
+func contextRecord(diffs []diff, i, n int) []byte {
+	var prefix, deleted, suffix []byte
+	if i > 0 {
+		c := diffs[i-1].Text
+		prefix = c[max(0, len(c)-n):]
+	}
+	j := i
+	for ; j < len(diffs) && diffs[j].Type != OpCopy; j++ {
+		if diffs[j].Type == OpDelete {
+			deleted = append(deleted, diffs[j].Text...)
+		}
+	}
+	if j < len(diffs) {
+		c := diffs[j].Text
+		suffix = c[:min(n, len(c))]
+	}
+
+	body := appendString(appendString(appendString(nil, string(prefix)), string(deleted)), string(suffix))
+	return append(appendUvarint([]byte{OpContext}, uint64(len(body))), body...)
+}
+
+// contextFields returns the prefix, deleted text and suffix encoded in the data of a
+// context record.
+func contextFields(body []byte) (fields [3][]byte, err error) {
+	for i := range fields {
+		l, n := binary.Uvarint(body)
+		if n <= 0 || l > uint64(len(body)-n) {
+			return fields, errBadContext
+		}
+		fields[i] = body[n : n+int(l)]
+		body = body[n+int(l):]
+	}
+	if len(body) > 0 {
+		return fields, errBadContext
+	}
+	return fields, nil
+}
+
+// readContext reads the data of a context record of length l and returns its fields.
+func readContext(r io.Reader, l uint64) ([3][]byte, error) {
+	var body bytes.Buffer
+	if _, err := io.CopyN(&body, r, int64(l)); err != nil {
+		return [3][]byte{}, err
+	}
+	return contextFields(body.Bytes())
+}
+
+// ApplyPatchFuzzy applies patch to before like ApplyPatch, writing the output to
+// after. If the patch doesn't apply because before has changed since the patch was
+// made, the changes are instead located by the context records written with
+// WithEditContext, and applied wherever their context and deleted text are found, as
+// diff-match-patch does. The output then can't be checked against the patch's
+// checksum. It returns an error wrapping ErrDMPMismatch if a change isn't found, and
+// ErrNoContext if a change has no context.
+//
+// Both inputs are read into memory.
+func ApplyPatchFuzzy(before, patch io.Reader, after io.Writer, opts ...Option) error {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return err
+	}
+	patchBytes, err := ioutil.ReadAll(patch)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	err = ApplyPatch(bytes.NewReader(beforeBytes), bytes.NewReader(patchBytes), &out, opts...)
+	if errors.Is(err, ErrMalformed) {
+		return err
+	} else if err != nil {
+		hunks, err := fuzzyHunks(bytes.NewReader(patchBytes))
+		if err != nil {
+			return err
+		}
+		text, err := applyDMP(beforeBytes, hunks)
+		if err != nil {
+			return err
+		}
+		out.Reset()
+		out.Write(text)
+	}
+
+	_, err = out.WriteTo(after)
+	return err
+}
+
+// fuzzyHunks returns the changes of patch as diff-match-patch hunks, made from their
+// context records. The offsets of the hunks count bytes of the output rather than
+// characters, which only guide the search for them.
+func fuzzyHunks(patch io.Reader) ([]dmpHunk, error) {
+	patch, err := openPatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	patchBR := bufio.NewReader(patch)
+
+	var hunks []dmpHunk
+	var h *dmpHunk
+	var suffix []byte
+	var want, deleted int // Bytes the change deletes, according to its context and records
+	b := 0                // Offset in the output
+	finish := func() error {
+		if h == nil {
+			return nil
+		}
+		if deleted != want {
+			return errBadContext
+		}
+		if len(suffix) > 0 {
+			h.diffs = append(h.diffs, diff{OpCopy, suffix})
+		}
+		h.length1, h.length2 = len(h.text1()), len(h.text2())
+		hunks = append(hunks, *h)
+		h = nil
+		return nil
+	}
+
+	for {
+		op, err := patchBR.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case OpBLAKE3, OpXXH3, OpCRC, OpBeforeCRC:
+			size := digestSize(op)
+			if size == 0 {
+				size = 4
+			}
+			if _, err := io.CopyN(ioutil.Discard, patchBR, int64(size)); err != nil {
+				return nil, err
+			}
+			continue
+		case OpCopyToEnd:
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		tl, err := readLength(patchBR)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case OpCopy, OpContext:
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			if op == OpCopy {
+				b += int(tl)
+				continue
+			}
+
+			fields, err := readContext(patchBR, tl)
+			if err != nil {
+				return nil, err
+			}
+			h = &dmpHunk{start1: b - len(fields[0]), start2: b - len(fields[0])}
+			if len(fields[0]) > 0 {
+				h.diffs = append(h.diffs, diff{OpCopy, fields[0]})
+			}
+			if len(fields[1]) > 0 {
+				h.diffs = append(h.diffs, diff{OpDelete, fields[1]})
+			}
+			suffix, want, deleted = fields[2], len(fields[1]), 0
+		case OpDelete, OpInsert, OpCheckedInsert, OpReplace:
+			if h == nil {
+				return nil, ErrNoContext
+			}
+
+			il := tl
+			if op == OpDelete || op == OpReplace {
+				deleted += int(tl)
+				il = 0
+			}
+			if op == OpReplace {
+				if il, err = readLength(patchBR); err != nil {
+					return nil, err
+				}
+			}
+
+			var data bytes.Buffer
+			if op == OpCheckedInsert {
+				err = copyCheckedInsert(&data, patchBR, tl, 0)
+			} else {
+				_, err = io.CopyN(&data, patchBR, int64(il))
+			}
+			if err != nil {
+				return nil, err
+			}
+			if data.Len() > 0 {
+				h.diffs = append(h.diffs, diff{OpInsert, data.Bytes()})
+				b += data.Len()
+			}
+		case OpChunk:
+			return nil, errFuzzyChunk
+		case OpMetadata, OpAnnotation, OpPadding, OpCheckpoint:
+			if _, err := io.CopyN(ioutil.Discard, patchBR, int64(tl)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, unexpectedOp(op)
+		}
+	}
+
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return hunks, nil
+}
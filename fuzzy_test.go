@@ -0,0 +1,54 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyPatchFuzzy(t *testing.T) {
+	a := []byte("The quick brown fox jumps over the lazy dog. It was not amused, and went back to sleep.")
+	b := []byte("The quick brown cat jumps over the dog. It was not amused, and went back to bed!")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithEditContext(8)))
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+	var text, binary bytes.Buffer
+	assert.NoError(t, ToText(bytes.NewReader(patch.Bytes()), &text))
+	assert.Contains(t, text.String(), "L \"k brown \" \"fox\" \" jumps o\"\n")
+	assert.NoError(t, ToBinary(&text, &binary))
+	assert.Equal(t, patch.Bytes(), binary.Bytes())
+
+	// The context records are skipped by ApplyPatch, and by Edits.
+	var after bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.Bytes())
+	edits, err := Edits(a, patch.Bytes())
+	assert.NoError(t, err)
+	assert.Len(t, edits, 11)
+
+	// A before that has changed elsewhere
+	drifted := "Story time! " + strings.Replace(string(a), "not amused", "unimpressed", 1)
+	after.Reset()
+	assert.NoError(t, ApplyPatchFuzzy(strings.NewReader(drifted), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, "Story time! The quick brown cat jumps over the dog. It was unimpressed, and went back to bed!", after.String())
+
+	after.Reset()
+	assert.NoError(t, ApplyPatchFuzzy(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.Bytes())
+
+	err = ApplyPatchFuzzy(strings.NewReader("Something else entirely, with no foxes or dogs in sight."), bytes.NewReader(patch.Bytes()), &after)
+	assert.True(t, errors.Is(err, ErrDMPMismatch), err)
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+	err = ApplyPatchFuzzy(strings.NewReader(drifted), bytes.NewReader(patch.Bytes()), &after)
+	assert.Equal(t, ErrNoContext, err)
+
+	_, err = contextFields([]byte("\x01a\x01b"))
+	assert.True(t, errors.Is(err, ErrMalformed))
+}
@@ -3,6 +3,13 @@ module github.com/kalafut/lightpatch
 go 1.14
 
 require (
+	github.com/alecthomas/chroma v0.10.0
 	github.com/alecthomas/kong v0.2.12-0.20200908034623-88ecc9c4e977
-	github.com/stretchr/testify v1.6.1
+	github.com/klauspost/cpuid v1.3.1 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.11 // indirect
+	github.com/klauspost/reedsolomon v1.9.3
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/stretchr/testify v1.7.0
+	github.com/zeebo/xxh3 v1.0.2
+	lukechampine.com/blake3 v1.1.6
 )
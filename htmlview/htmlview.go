@@ -0,0 +1,284 @@
+// Package htmlview renders lightpatch patches as side-by-side HTML, for embedding in
+// review tools that store patches rather than unified diffs.
+package htmlview
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/kalafut/lightpatch"
+)
+
+// An Option configures Render and WriteCSS.
+type Option func(*config)
+
+type config struct {
+	lexer chroma.Lexer
+	style *chroma.Style
+}
+
+// WithHighlighting enables syntax highlighting with chroma. language is a language
+// name, alias or file name, such as "go", "yaml" or "main.c", and style is a chroma
+// style name, such as "github" or "monokai". Unknown languages are not highlighted,
+// and unknown styles fall back to chroma's default.
+func WithHighlighting(language, style string) Option {
+	return func(c *config) {
+		c.lexer = lexers.Get(language)
+		c.style = styles.Get(style)
+	}
+}
+
+const baseCSS = `.lightpatch { border-collapse: collapse; font-family: monospace; width: 100%; }
+.lightpatch td { padding: 0 4px; vertical-align: top; white-space: pre-wrap; }
+.lightpatch td.ln { color: #999; text-align: right; user-select: none; width: 1%; }
+.lightpatch td.del { background: #ffeef0; }
+.lightpatch td.ins { background: #e6ffed; }
+.lightpatch td.del del { background: #fdb8c0; text-decoration: none; }
+.lightpatch td.ins ins { background: #acf2bd; text-decoration: none; }
+`
+
+// WriteCSS writes the stylesheet used by the output of Render, including the
+// highlighting style if one is selected.
+func WriteCSS(w io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+
+	if _, err := io.WriteString(w, baseCSS); err != nil {
+		return err
+	}
+	if cfg.lexer != nil {
+		return chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(w, cfg.style)
+	}
+	return nil
+}
+
+// Render writes an HTML table showing before and the result of applying patch to
+// it side by side, with unchanged lines aligned and changed bytes marked with del
+// and ins elements. Style it with WriteCSS.
+func Render(w io.Writer, before, patch []byte, opts ...Option) error {
+	cfg := newConfig(opts)
+
+	edits, err := lightpatch.Edits(before, patch)
+	if err != nil {
+		return err
+	}
+
+	var after []byte
+	for _, e := range edits {
+		if e.Op != lightpatch.OpDelete {
+			after = append(after, e.Text...)
+		}
+	}
+
+	left := newSide(before, cfg)
+	right := newSide(after, cfg)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`<table class="lightpatch chroma">` + "\n")
+
+	// Lines are aligned at sync points, where a line starts on both sides within
+	// copied text. Between them, changed lines are paired up in order.
+	var a, b, syncA, syncB int
+	sync := func(a, b int) {
+		if a == syncA && b == syncB {
+			return
+		}
+		writeRows(bw, left, right, syncA, a, syncB, b)
+		syncA, syncB = a, b
+	}
+	for _, e := range edits {
+		n := len(e.Text)
+		switch e.Op {
+		case lightpatch.OpCopy:
+			if left.lineStart(a) && right.lineStart(b) {
+				sync(a, b)
+			}
+			for k := 1; k <= n; k++ {
+				if before[a+k-1] == '\n' {
+					sync(a+k, b+k)
+				}
+			}
+			a += n
+			b += n
+		case lightpatch.OpDelete:
+			left.mark(a, n)
+			a += n
+		case lightpatch.OpInsert:
+			right.mark(b, n)
+			b += n
+		}
+	}
+	sync(len(before), len(after))
+
+	bw.WriteString("</table>\n")
+	return bw.Flush()
+}
+
+func newConfig(opts []Option) config {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.lexer != nil {
+		cfg.lexer = chroma.Coalesce(cfg.lexer)
+	}
+	return cfg
+}
+
+// side is one column of the view.
+type side struct {
+	text    []byte
+	changed []bool   // Whether each byte was deleted or inserted
+	classes []string // The highlighting class of each byte, if highlighting
+	starts  []int    // The offset of each line
+}
+
+func newSide(text []byte, cfg config) *side {
+	s := &side{
+		text:    text,
+		changed: make([]bool, len(text)),
+		starts:  []int{0},
+	}
+	for i, c := range text {
+		if c == '\n' && i+1 < len(text) {
+			s.starts = append(s.starts, i+1)
+		}
+	}
+
+	if cfg.lexer != nil {
+		// Keep line endings as they are, so that the tokens line up with text.
+		opts := &chroma.TokeniseOptions{State: "root"}
+		if it, err := cfg.lexer.Tokenise(opts, string(text)); err == nil {
+			s.classes = make([]string, 0, len(text))
+			for _, tok := range it.Tokens() {
+				cls := class(tok.Type)
+				for i := 0; i < len(tok.Value); i++ {
+					s.classes = append(s.classes, cls)
+				}
+			}
+			// Lexers may still add a final newline.
+			if len(s.classes) < len(text) {
+				s.classes = append(s.classes, make([]string, len(text)-len(s.classes))...)
+			}
+		}
+	}
+
+	return s
+}
+
+func (s *side) lineStart(i int) bool {
+	return i == 0 || i == len(s.text) || s.text[i-1] == '\n'
+}
+
+func (s *side) mark(i, n int) {
+	for ; n > 0; n-- {
+		s.changed[i] = true
+		i++
+	}
+}
+
+// lines returns the indexes of the lines starting in text[from:to].
+func (s *side) lines(from, to int) []int {
+	var idx []int
+	for i, start := range s.starts {
+		if start >= from && start < to {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// line returns the bounds of line i, without its line ending.
+func (s *side) line(i int) (int, int) {
+	start, end := s.starts[i], len(s.text)
+	if i+1 < len(s.starts) {
+		end = s.starts[i+1]
+	}
+	return start, end
+}
+
+func writeRows(w *bufio.Writer, left, right *side, fromA, toA, fromB, toB int) {
+	ll, rl := left.lines(fromA, toA), right.lines(fromB, toB)
+	for i := 0; i < len(ll) || i < len(rl); i++ {
+		w.WriteString("<tr>")
+		writeCell(w, left, ll, i, "del")
+		writeCell(w, right, rl, i, "ins")
+		w.WriteString("</tr>\n")
+	}
+}
+
+func writeCell(w *bufio.Writer, s *side, lines []int, i int, mark string) {
+	if i >= len(lines) {
+		w.WriteString(`<td class="ln"></td><td></td>`)
+		return
+	}
+
+	start, end := s.line(lines[i])
+
+	// Trim the line ending, which is shown by the row itself. It only counts as a
+	// change for an otherwise empty line.
+	content := end
+	for content > start && (s.text[content-1] == '\n' || s.text[content-1] == '\r') {
+		content--
+	}
+	if content > start {
+		end = content
+	}
+	changed := false
+	for _, c := range s.changed[start:end] {
+		changed = changed || c
+	}
+	end = content
+
+	fmt.Fprintf(w, `<td class="ln">%d</td>`, lines[i]+1)
+	if changed {
+		fmt.Fprintf(w, `<td class="%s">`, mark)
+	} else {
+		w.WriteString("<td>")
+	}
+
+	// Write runs of bytes with the same class and change state.
+	for j := start; j < end; {
+		k := j + 1
+		for k < end && (!utf8.RuneStart(s.text[k]) || s.changed[k] == s.changed[j] && s.class(k) == s.class(j)) {
+			k++
+		}
+		if s.changed[j] {
+			fmt.Fprintf(w, "<%s>", mark)
+		}
+		if cls := s.class(j); cls != "" {
+			fmt.Fprintf(w, `<span class="%s">%s</span>`, cls, html.EscapeString(string(s.text[j:k])))
+		} else {
+			w.WriteString(html.EscapeString(string(s.text[j:k])))
+		}
+		if s.changed[j] {
+			fmt.Fprintf(w, "</%s>", mark)
+		}
+		j = k
+	}
+
+	w.WriteString("</td>")
+}
+
+func (s *side) class(i int) string {
+	if s.classes == nil {
+		return ""
+	}
+	return s.classes[i]
+}
+
+// class returns the CSS class chroma's HTML formatter uses for t.
+func class(t chroma.TokenType) string {
+	for ; t != 0; t = t.Parent() {
+		if cls, ok := chroma.StandardTypes[t]; ok {
+			return cls
+		}
+	}
+	return chroma.StandardTypes[t]
+}
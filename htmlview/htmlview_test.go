@@ -0,0 +1,55 @@
+package htmlview
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kalafut/lightpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func makePatch(t *testing.T, before, after string) []byte {
+	var patch bytes.Buffer
+	err := lightpatch.MakePatch(strings.NewReader(before), strings.NewReader(after), &patch)
+	assert.NoError(t, err)
+	return patch.Bytes()
+}
+
+func TestRender(t *testing.T) {
+	before := "one\ntwo\nthree <3\nfour\n"
+	after := "one\n2\nthree <3\nthree and a half\nfour\n\n"
+
+	var out bytes.Buffer
+	err := Render(&out, []byte(before), makePatch(t, before, after))
+	assert.NoError(t, err)
+
+	assert.Equal(t, `<table class="lightpatch chroma">
+<tr><td class="ln">1</td><td>one</td><td class="ln">1</td><td>one</td></tr>
+<tr><td class="ln">2</td><td class="del"><del>two</del></td><td class="ln">2</td><td class="ins"><ins>2</ins></td></tr>
+<tr><td class="ln">3</td><td>three &lt;3</td><td class="ln">3</td><td>three &lt;3</td></tr>
+<tr><td class="ln"></td><td></td><td class="ln">4</td><td class="ins"><ins>three and a half</ins></td></tr>
+<tr><td class="ln">4</td><td>four</td><td class="ln">5</td><td>four</td></tr>
+<tr><td class="ln"></td><td></td><td class="ln">6</td><td class="ins"></td></tr>
+</table>
+`, out.String())
+
+	err = Render(new(bytes.Buffer), []byte("other"), makePatch(t, before, after))
+	assert.Error(t, err)
+}
+
+func TestRenderHighlighting(t *testing.T) {
+	before := "package main\n\nvar x = 1\n"
+	after := "package main\n\nvar y = 1\n"
+
+	var out bytes.Buffer
+	err := Render(&out, []byte(before), makePatch(t, before, after), WithHighlighting("go", "github"))
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), `<td class="del"><span class="kd">var</span> <del><span class="nx">x</span></del> <span class="p">=</span>`)
+	assert.Contains(t, out.String(), `<ins><span class="nx">y</span></ins>`)
+
+	var css bytes.Buffer
+	assert.NoError(t, WriteCSS(&css, WithHighlighting("go", "github")))
+	assert.Contains(t, css.String(), ".lightpatch td.del")
+	assert.Contains(t, css.String(), ".chroma .kd {")
+}
@@ -0,0 +1,222 @@
+package lightpatch
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A Hunk is a group of nearby edits along with the unchanged lines around them, like
+// a hunk of a unified diff. A hunk always covers whole lines of before and after.
+type Hunk struct {
+	BeforeOffset int // Offset of the hunk in before
+	BeforeLine   int // 1-based number of the first line of the hunk in before
+	BeforeLines  int // Number of lines of before covered by the hunk
+	AfterOffset  int // Offset of the hunk in the output
+	AfterLine    int // 1-based number of the first line of the hunk in the output
+	AfterLines   int // Number of lines of the output covered by the hunk
+
+	Before []byte // The lines of before covered by the hunk
+	After  []byte // The lines of the output replacing Before
+
+	// Edits change Before into After. The context lines are included as copies.
+	Edits []Edit
+}
+
+// String returns the unified diff header of the hunk, e.g. "@@ -3,7 +3,8 @@". As in
+// diff, the line of an empty range is the one preceding it.
+func (h Hunk) String() string {
+	header := func(line, lines int) string {
+		if lines == 0 {
+			line--
+		}
+		if lines == 1 {
+			return fmt.Sprint(line)
+		}
+		return fmt.Sprintf("%d,%d", line, lines)
+	}
+	return fmt.Sprintf("@@ -%s +%s @@", header(h.BeforeLine, h.BeforeLines), header(h.AfterLine, h.AfterLines))
+}
+
+// Hunks applies patch to before, verifying it, and groups its edits into hunks with
+// context lines of unchanged text on either side. Hunks whose context would touch or
+// overlap are merged. A negative context is treated as 0.
+func Hunks(before, patch []byte, context int) ([]Hunk, error) {
+	edits, after, err := decodeEdits(before, patch)
+	if err != nil {
+		return nil, err
+	}
+	if context < 0 {
+		context = 0
+	}
+
+	// Find the changes, i.e. the runs of inserts and deletes between copies.
+	type change struct {
+		first, last int // Indexes of the first and last edit of the change
+		a0, a1      int // Range of the change in before
+		b0, b1      int // Range of the change in after
+	}
+	var changes []change
+	var a, b int
+	for i, e := range edits {
+		if e.Op == OpCopy {
+			a += len(e.Text)
+			b += len(e.Text)
+			continue
+		}
+		if len(e.Text) == 0 {
+			continue
+		}
+
+		if n := len(changes); n > 0 && changes[n-1].a1 == a && changes[n-1].b1 == b {
+			changes[n-1].last = i
+		} else {
+			changes = append(changes, change{first: i, last: i, a0: a, a1: a, b0: b, b1: b})
+		}
+		c := &changes[len(changes)-1]
+
+		if e.Op == OpDelete {
+			a += len(e.Text)
+		} else {
+			b += len(e.Text)
+		}
+		c.a1, c.b1 = a, b
+	}
+
+	var hunks []Hunk
+	var lines, afterLines lineCounter
+	var k, ka int // The next edit to collect, and its offset in before
+
+	for i := 0; i < len(changes); {
+		// The text around a change is copied, so the context is the same in both
+		// inputs. It is limited by the end of the previous hunk.
+		c := changes[i]
+		bound := 0
+		if len(hunks) > 0 {
+			h := hunks[len(hunks)-1]
+			bound = h.BeforeOffset + len(h.Before)
+		}
+		start := contextStart(before, c.a0, context, bound)
+		bstart := c.b0 - (c.a0 - start)
+
+		// Extend forward in the same way, merging the following changes while their
+		// context would meet this one.
+		first := c.first
+		var end int
+		for {
+			c = changes[i]
+			i++
+
+			bound := len(before)
+			if i < len(changes) {
+				bound = changes[i].a0
+			}
+
+			// Finish the last line of the change if it is incomplete on either side.
+			end = c.a1
+			if (c.a1 > 0 && before[c.a1-1] != '\n') || (c.b1 > 0 && after[c.b1-1] != '\n') {
+				end = contextEnd(before, end, 1, bound)
+			}
+			end = contextEnd(before, end, context, bound)
+
+			if i == len(changes) || (end < bound && contextStart(before, bound, context, end) > end) {
+				break
+			}
+		}
+		bend := c.b1 + (end - c.a1)
+
+		h := Hunk{
+			BeforeOffset: start,
+			BeforeLine:   lines.line(before, start),
+			BeforeLines:  countLines(before[start:end]),
+			AfterOffset:  bstart,
+			AfterLine:    afterLines.line(after, bstart),
+			AfterLines:   countLines(after[bstart:bend]),
+			Before:       before[start:end],
+			After:        after[bstart:bend],
+		}
+
+		// Collect the edits of the changes, trimming the copies around them to the
+		// context. A copy running past the hunk is left for the next one.
+		for ; k < len(edits); k++ {
+			e := edits[k]
+			if k > c.last && ka >= end {
+				break
+			}
+
+			if e.Op == OpCopy {
+				lo, hi := ka, ka+len(e.Text)
+				if lo < start {
+					lo = start
+				}
+				if hi > end {
+					hi = end
+				}
+				if lo < hi {
+					h.Edits = append(h.Edits, Edit{OpCopy, before[lo:hi]})
+				}
+				if ka+len(e.Text) > end {
+					break
+				}
+			} else if k >= first && len(e.Text) > 0 {
+				h.Edits = append(h.Edits, e)
+			}
+
+			if e.Op != OpInsert {
+				ka += len(e.Text)
+			}
+		}
+
+		hunks = append(hunks, h)
+	}
+
+	return hunks, nil
+}
+
+// contextStart returns the start of the line containing offset in text, moved back by
+// another n lines, but not before bound.
+func contextStart(text []byte, offset, n, bound int) int {
+	for offset > bound && text[offset-1] != '\n' {
+		offset--
+	}
+	for ; n > 0 && offset > bound; n-- {
+		offset--
+		for offset > bound && text[offset-1] != '\n' {
+			offset--
+		}
+	}
+	return offset
+}
+
+// contextEnd returns the offset following the next n newlines of text from offset, or
+// bound if that is reached first.
+func contextEnd(text []byte, offset, n, bound int) int {
+	for ; n > 0 && offset < bound; n-- {
+		for offset < bound && text[offset] != '\n' {
+			offset++
+		}
+		if offset < bound {
+			offset++
+		}
+	}
+	return offset
+}
+
+// lineCounter finds line numbers for increasing offsets of a text.
+type lineCounter struct {
+	offset, newlines int
+}
+
+func (l *lineCounter) line(text []byte, offset int) int {
+	l.newlines += bytes.Count(text[l.offset:offset], []byte{'\n'})
+	l.offset = offset
+	return l.newlines + 1
+}
+
+// countLines returns the number of lines in p, counting a final line without a newline.
+func countLines(p []byte) int {
+	n := bytes.Count(p, []byte{'\n'})
+	if len(p) > 0 && p[len(p)-1] != '\n' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package lightpatch
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHunks(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+	a := []byte(strings.Join(lines, ""))
+	lines[2] = "line three\n"
+	lines[15] = ""
+	b := []byte(strings.Join(lines, "") + "line 21\n")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+
+	hunks, err := Hunks(a, patch.Bytes(), 2)
+	assert.NoError(t, err)
+	assert.Len(t, hunks, 2)
+	var headers []string
+	for _, h := range hunks {
+		headers = append(headers, h.String())
+
+		// The edits change the before lines of each hunk into the after lines.
+		var before, after []byte
+		for _, e := range h.Edits {
+			if e.Op != OpInsert {
+				before = append(before, e.Text...)
+			}
+			if e.Op != OpDelete {
+				after = append(after, e.Text...)
+			}
+		}
+		assert.Equal(t, string(h.Before), string(before))
+		assert.Equal(t, string(h.After), string(after))
+		assert.Equal(t, string(a[h.BeforeOffset:h.BeforeOffset+len(h.Before)]), string(h.Before))
+		assert.Equal(t, string(b[h.AfterOffset:h.AfterOffset+len(h.After)]), string(h.After))
+	}
+	assert.Equal(t, []string{"@@ -1,5 +1,5 @@", "@@ -14,7 +14,7 @@"}, headers)
+	assert.Equal(t, "line 1\nline 2\nline 3\nline 4\nline 5\n", string(hunks[0].Before))
+	assert.Equal(t, "line 1\nline 2\nline three\nline 4\nline 5\n", string(hunks[0].After))
+	assert.Equal(t, "line 18\nline 19\nline 20\n", string(hunks[1].Before[len(hunks[1].Before)-24:]))
+	assert.Equal(t, "line 19\nline 20\nline 21\n", string(hunks[1].After[len(hunks[1].After)-24:]))
+
+	// Wider context merges the hunks.
+	hunks, err = Hunks(a, patch.Bytes(), 6)
+	assert.NoError(t, err)
+	assert.Len(t, hunks, 1)
+	assert.Equal(t, "@@ -1,20 +1,20 @@", hunks[0].String())
+
+	// Without context, each change is a hunk covering the lines it touches. The
+	// deleted line is found as "6\nline 1", which spans two lines.
+	hunks, err = Hunks(a, patch.Bytes(), 0)
+	assert.NoError(t, err)
+	headers = nil
+	for _, h := range hunks {
+		headers = append(headers, h.String())
+	}
+	assert.Equal(t, []string{"@@ -3 +3 @@", "@@ -16,2 +16 @@", "@@ -20,0 +20 @@"}, headers)
+
+	_, err = Hunks(a[1:], patch.Bytes(), 3)
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package lightpatch
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Index is a reusable hash index of a before file. It lets many after versions be
+// diffed against the same base without rescanning it each time. An Index is safe
+// for concurrent use.
+type Index struct {
+	blocks *blockIndex
+}
+
+// BuildIndex reads before and indexes it for use with Index.MakePatch.
+func BuildIndex(before io.Reader) (*Index, error) {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{blocks: newBlockIndex(beforeBytes)}, nil
+}
+
+// MakePatch generates a diff to change the indexed before file into after, writing
+// the output to patch. Matches are found with the index, as with AlgorithmRollingHash,
+// regardless of any WithAlgorithm or WithGranularity option. The patch can be applied
+// with ApplyPatch.
+func (ix *Index) MakePatch(after io.Reader, patch io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	cfg.algorithm, cfg.granularity = AlgorithmRollingHash, GranularityByte
+	start := time.Now()
+
+	afterBytes, err := ioutil.ReadAll(after)
+	if err != nil {
+		return err
+	}
+
+	timeout := cfg.diffTimeout(DefaultTimeout)
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = start.Add(timeout)
+	}
+	before := ix.blocks.text
+	diffs := diffAnchors(before, afterBytes, ix.blocks.matches(afterBytes, deadline), deadline)
+
+	return writePatch(patch, before, afterBytes, diffs, cfg, start, timeout)
+}
@@ -0,0 +1,41 @@
+package lightpatch
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+
+	before := make([]byte, 1<<15)
+	rng.Read(before)
+
+	idx, err := BuildIndex(bytes.NewReader(before))
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		after := scatteredEdits(rand.New(rand.NewSource(int64(i))), before)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var patch bytes.Buffer
+			err := idx.MakePatch(bytes.NewReader(after), &patch)
+			assert.NoError(t, err)
+			assert.True(t, patch.Len() < len(after)/20, "patch too large: %d", patch.Len())
+
+			var out bytes.Buffer
+			err = ApplyPatch(bytes.NewReader(before), &patch, &out)
+			assert.NoError(t, err)
+			assert.Equal(t, after, out.Bytes())
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,114 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// journalMagic starts every journal.
+const journalMagic = "LPJ1"
+
+// ErrNotJournal is returned when reading a journal that doesn't start with the
+// journal header.
+var ErrNotJournal = errors.New("not a patch journal")
+
+// A JournalWriter writes a journal: a stream of patches, each applying to the output
+// of the one before, which can be replayed with Replay. After a header, each patch is
+// written as its uvarint length followed by its bytes, so a journal can be appended
+// to and read as it arrives.
+type JournalWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// NewJournalWriter returns a JournalWriter that writes a new journal to w.
+func NewJournalWriter(w io.Writer) *JournalWriter {
+	return &JournalWriter{w: w}
+}
+
+// Append writes patch as the next entry of the journal.
+func (j *JournalWriter) Append(patch []byte) error {
+	rec := appendUvarint(nil, uint64(len(patch)))
+	if !j.started {
+		rec = append([]byte(journalMagic), rec...)
+	}
+	if _, err := j.w.Write(rec); err != nil {
+		return err
+	}
+	j.started = true
+
+	_, err := j.w.Write(patch)
+	return err
+}
+
+// A JournalReader reads the patches of a journal in order.
+type JournalReader struct {
+	r       *bufio.Reader
+	started bool
+}
+
+// NewJournalReader returns a JournalReader that reads a journal from r.
+func NewJournalReader(r io.Reader) *JournalReader {
+	return &JournalReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next patch of the journal, or io.EOF after the last one. An empty
+// stream is an empty journal.
+func (j *JournalReader) Next() ([]byte, error) {
+	if !j.started {
+		if _, err := j.r.Peek(1); err == io.EOF {
+			return nil, io.EOF
+		}
+		if err := readMagic(j.r, journalMagic, ErrNotJournal); err != nil {
+			return nil, err
+		}
+		j.started = true
+	}
+
+	n, err := readLength(j.r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Grow the patch as it arrives rather than trusting its length up front.
+	var patch bytes.Buffer
+	if _, err := io.CopyN(&patch, j.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
+
+// Replay applies the patches of journal to before in order, and writes the final
+// output to after. Each output is held in memory until the next patch is applied, and
+// nothing is written if any patch fails.
+func Replay(before, journal io.Reader, after io.Writer, opts ...Option) error {
+	state := new(bytes.Buffer)
+	if _, err := state.ReadFrom(before); err != nil {
+		return err
+	}
+
+	j := NewJournalReader(journal)
+	for n := 1; ; n++ {
+		patch, err := j.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("journal entry %d: %w", n, err)
+		}
+
+		next := new(bytes.Buffer)
+		if err := ApplyPatch(state, bytes.NewReader(patch), next, opts...); err != nil {
+			return fmt.Errorf("journal entry %d: %w", n, err)
+		}
+		state = next
+	}
+
+	_, err := state.WriteTo(after)
+	return err
+}
@@ -0,0 +1,59 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	versions := []string{
+		"The quick brown fox jumped over the lazy dog.",
+		"The quick brown cat jumped over the dog!",
+		"The quick brown cat jumped over the dog! Twice.",
+		"",
+	}
+
+	var journal bytes.Buffer
+	j := NewJournalWriter(&journal)
+	for i := 1; i < len(versions); i++ {
+		var patch bytes.Buffer
+		assert.NoError(t, MakePatch(strings.NewReader(versions[i-1]), strings.NewReader(versions[i]), &patch,
+			WithChecksum(Checksum(i%2))))
+		assert.NoError(t, j.Append(patch.Bytes()))
+	}
+	assert.Equal(t, journalMagic, journal.String()[:4])
+
+	for n := 0; n < len(versions); n++ {
+		// A prefix of the journal replays the versions it covers.
+		var prefix bytes.Buffer
+		r := NewJournalReader(bytes.NewReader(journal.Bytes()))
+		w := NewJournalWriter(&prefix)
+		for i := 0; i < n; i++ {
+			patch, err := r.Next()
+			assert.NoError(t, err)
+			assert.NoError(t, w.Append(patch))
+		}
+
+		var after bytes.Buffer
+		assert.NoError(t, Replay(strings.NewReader(versions[0]), &prefix, &after))
+		assert.Equal(t, versions[n], after.String())
+	}
+
+	// Replaying against the wrong before fails on the first patch, and writes nothing.
+	var after bytes.Buffer
+	err := Replay(strings.NewReader(strings.ToLower(versions[0])), bytes.NewReader(journal.Bytes()), &after)
+	assert.EqualError(t, err, "journal entry 1: "+ErrChecksum.Error())
+	assert.Zero(t, after.Len())
+
+	err = Replay(strings.NewReader(versions[0]), bytes.NewReader(journal.Bytes()[:journal.Len()-1]), &after)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	err = Replay(strings.NewReader(versions[0]), strings.NewReader("C\x01"), &after)
+	assert.True(t, errors.Is(err, ErrNotJournal))
+	err = Replay(strings.NewReader(versions[0]), strings.NewReader("LPJ2\x00"), &after)
+	assert.True(t, errors.Is(err, ErrUnknownVersion), err)
+}
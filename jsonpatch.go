@@ -0,0 +1,468 @@
+package lightpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	errJSONPatch = errors.New("invalid JSON patch")
+	errJSONPath  = errors.New("JSON patch path not found")
+
+	// ErrJSONPatchTest is wrapped by the error returned by ApplyJSONPatch when a test
+	// operation of the patch fails.
+	ErrJSONPatchTest = errors.New("JSON patch test failed")
+)
+
+// jsonOp is an operation of an RFC 6902 JSON Patch.
+type jsonOp struct {
+	Op    string          `json:"op"`
+	From  string          `json:"from,omitempty"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MakeJSONPatch writes an RFC 6902 JSON Patch that turns the JSON document in before
+// into the one in after. Unlike a patch made by MakePatch, it edits the values of the
+// documents rather than their bytes, so it is unaffected by whitespace and the order
+// of object members, and still applies to a before that has been reformatted.
+//
+// Objects are compared member by member, and arrays element by element after the
+// elements they start and end with in common, so an insert or removal in the middle
+// of an array is a single operation. Other changes replace the value. Numbers with
+// the same value are equal however they are written.
+func MakeJSONPatch(before, after io.Reader, patch io.Writer) error {
+	a, err := readJSON(before)
+	if err != nil {
+		return err
+	}
+	b, err := readJSON(after)
+	if err != nil {
+		return err
+	}
+
+	var ops []jsonOp
+	if err := diffJSON("", a, b, &ops); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, op := range ops {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  ")
+		data, err := marshalJSON(op)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	if len(ops) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+
+	_, err = buf.WriteTo(patch)
+	return err
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to the JSON document in before, and
+// writes the result to after as compact JSON, with the members of objects sorted. All
+// six operations are supported. It returns an error wrapping ErrJSONPatchTest if a
+// test operation fails, and nothing is written if any operation fails.
+func ApplyJSONPatch(before, patch io.Reader, after io.Writer) error {
+	doc, err := readJSON(before)
+	if err != nil {
+		return err
+	}
+
+	var ops []jsonOp
+	if err := json.NewDecoder(patch).Decode(&ops); err != nil {
+		return fmt.Errorf("%w: %v", errJSONPatch, err)
+	}
+
+	for i, op := range ops {
+		if doc, err = applyJSONOp(doc, op); err != nil {
+			return fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	data, err := marshalJSON(doc)
+	if err != nil {
+		return err
+	}
+	_, err = after.Write(append(data, '\n'))
+	return err
+}
+
+// readJSON reads a single JSON value, keeping numbers as written.
+func readJSON(r io.Reader) (interface{}, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: data after the top-level value")
+	}
+	return v, nil
+}
+
+// marshalJSON marshals v without escaping HTML characters.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+// diffJSON appends the operations that change a into b, at path, to ops.
+func diffJSON(path string, a, b interface{}, ops *[]jsonOp) error {
+	add := func(op, path string, v interface{}) error {
+		value, err := marshalJSON(v)
+		if err != nil {
+			return err
+		}
+		*ops = append(*ops, jsonOp{Op: op, Path: path, Value: value})
+		return nil
+	}
+
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := path + "/" + escapePointer(k)
+			av, inA := a[k]
+			bv, inB := b[k]
+			var err error
+			switch {
+			case !inB:
+				*ops = append(*ops, jsonOp{Op: "remove", Path: p})
+			case !inA:
+				err = add("add", p, bv)
+			default:
+				err = diffJSON(p, av, bv, ops)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		start := 0
+		for start < len(a) && start < len(b) && jsonEqual(a[start], b[start]) {
+			start++
+		}
+		end := 0
+		for end < len(a)-start && end < len(b)-start && jsonEqual(a[len(a)-1-end], b[len(b)-1-end]) {
+			end++
+		}
+
+		// Pair up the differing elements, then remove or add the rest. Removals run
+		// from the end back, so that the index of each is the same as in a.
+		n := min(len(a), len(b)) - start - end
+		for i := start; i < start+n; i++ {
+			if err := diffJSON(path+"/"+strconv.Itoa(i), a[i], b[i], ops); err != nil {
+				return err
+			}
+		}
+		for i := len(a) - end - 1; i >= start+n; i-- {
+			*ops = append(*ops, jsonOp{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := start + n; i < len(b)-end; i++ {
+			if err := add("add", path+"/"+strconv.Itoa(i), b[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if jsonEqual(a, b) {
+		return nil
+	}
+	return add("replace", path, b)
+}
+
+// jsonEqual reports whether a and b are the same JSON value. Numbers are equal if
+// they are written the same, or have the same value as float64s.
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			if bv, ok := b[k]; !ok || !jsonEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		af, errA := a.Float64()
+		bf, errB := b.Float64()
+		return errA == nil && errB == nil && af == bf
+	}
+	return a == b
+}
+
+// applyJSONOp applies op to doc and returns the result. doc may be modified.
+func applyJSONOp(doc interface{}, op jsonOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", errJSONPatch)
+		}
+		if value, err = readJSON(bytes.NewReader(op.Value)); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("%w: can't move a value into itself", errJSONPatch)
+			}
+			doc, value, err = jsonRemove(doc, from)
+		} else {
+			value, err = jsonGet(doc, from)
+			value = jsonClone(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return jsonAdd(doc, path, value)
+	case "remove":
+		doc, _, err = jsonRemove(doc, path)
+		return doc, err
+	case "replace":
+		if doc, _, err = jsonRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return jsonAdd(doc, path, value)
+	case "test":
+		v, err := jsonGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(v, value) {
+			return nil, ErrJSONPatchTest
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", errJSONPatch, op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("%w: bad path %q", errJSONPatch, p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// escapePointer escapes a member name as a reference token of a JSON Pointer.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// jsonIndex returns the array index of token, which may be n, the length of the
+// array, if end is true.
+func jsonIndex(token string, n int, end bool) (int, error) {
+	if end && token == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > n || (i == n && !end) || (len(token) > 1 && token[0] == '0') {
+		return 0, errJSONPath
+	}
+	return i, nil
+}
+
+// jsonGet returns the value at path in doc.
+func jsonGet(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+			if !ok {
+				return nil, errJSONPath
+			}
+			doc = v
+		case []interface{}:
+			i, err := jsonIndex(t, len(d), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, errJSONPath
+		}
+	}
+	return doc, nil
+}
+
+// jsonAdd adds v to doc at path, replacing a member of the same name or inserting an
+// array element, and returns the result.
+func jsonAdd(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	parent, err := jsonGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	t := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[t] = v
+		return doc, nil
+	case []interface{}:
+		i, err := jsonIndex(t, len(p), true)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = v
+		return jsonSet(doc, path[:len(path)-1], p), nil
+	}
+	return nil, errJSONPath
+}
+
+// jsonRemove removes the value at path from doc, and returns the result and the value.
+func jsonRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := jsonGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		v, ok := p[t]
+		if !ok {
+			return nil, nil, errJSONPath
+		}
+		delete(p, t)
+		return doc, v, nil
+	case []interface{}:
+		i, err := jsonIndex(t, len(p), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := p[i]
+		p = append(p[:i:i], p[i+1:]...)
+		return jsonSet(doc, path[:len(path)-1], p), v, nil
+	}
+	return nil, nil, errJSONPath
+}
+
+// jsonSet replaces the value at path, which exists, with v, and returns the result.
+// It is needed for arrays, which change when they grow or shrink.
+func jsonSet(doc interface{}, path []string, v interface{}) interface{} {
+	if len(path) == 0 {
+		return v
+	}
+	parent, _ := jsonGet(doc, path[:len(path)-1])
+	t := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[t] = v
+	case []interface{}:
+		i, _ := strconv.Atoi(t)
+		p[i] = v
+	}
+	return doc
+}
+
+// jsonClone returns a deep copy of v.
+func jsonClone(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			c[k] = jsonClone(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = jsonClone(e)
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPatch(t *testing.T) {
+	a := `{"name": "lightpatch", "tags": ["diff", "patch", "crc"], "a/b": 1, "meta": {"stars": 10, "old": true}}`
+	b := `{
+  "meta": {"stars": 11.0e0, "forks": 2},
+  "name": "lightpatch",
+  "tags": ["diff", "binary", "patch", "crc"],
+  "a/b": 1.0
+}`
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakeJSONPatch(strings.NewReader(a), strings.NewReader(b), &patch))
+	assert.Equal(t, `[
+  {"op":"add","path":"/meta/forks","value":2},
+  {"op":"remove","path":"/meta/old"},
+  {"op":"replace","path":"/meta/stars","value":11.0e0},
+  {"op":"add","path":"/tags/1","value":"binary"}
+]
+`, patch.String())
+
+	var after bytes.Buffer
+	assert.NoError(t, ApplyJSONPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, `{"a/b":1,"meta":{"forks":2,"stars":11.0e0},"name":"lightpatch","tags":["diff","binary","patch","crc"]}`+"\n", after.String())
+
+	pairs := [][2]string{
+		{`[1, 2, 3, 4, 5]`, `[1, 5]`},
+		{`[1, 2, 3]`, `[0, 1, 2, 3, 4]`},
+		{`[[1, 2], {"x": [3]}]`, `[[1, 3], {"x": []}, null]`},
+		{`{"~": {"/": 1}}`, `{"~": {"/": 2}}`},
+		{`"text"`, `{"text": true}`},
+		{`{}`, `{}`},
+	}
+	for _, p := range pairs {
+		patch.Reset()
+		after.Reset()
+		assert.NoError(t, MakeJSONPatch(strings.NewReader(p[0]), strings.NewReader(p[1]), &patch))
+		assert.NoError(t, ApplyJSONPatch(strings.NewReader(p[0]), bytes.NewReader(patch.Bytes()), &after), patch.String())
+		want, err := readJSON(strings.NewReader(p[1]))
+		assert.NoError(t, err)
+		got, err := readJSON(&after)
+		assert.NoError(t, err)
+		assert.True(t, jsonEqual(want, got), "%s: %s", p, patch.String())
+	}
+
+	// Examples from RFC 6902, appendix A.
+	apply := func(doc, patch string) (string, error) {
+		var after bytes.Buffer
+		err := ApplyJSONPatch(strings.NewReader(doc), strings.NewReader(patch), &after)
+		return after.String(), err
+	}
+	out, err := apply(`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+		`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`+"\n", out)
+
+	out, err = apply(`{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"foo":["all","cows","eat","grass"]}`+"\n", out)
+
+	out, err = apply(`{"baz": "qux", "foo": ["a", 2, "c"]}`, `[
+		{"op": "test", "path": "/baz", "value": "qux"},
+		{"op": "test", "path": "/foo/1", "value": 2},
+		{"op": "copy", "from": "/foo", "path": "/bar"},
+		{"op": "add", "path": "/foo/-", "value": ["x"]}
+	]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"bar":["a",2,"c"],"baz":"qux","foo":["a",2,"c",["x"]]}`+"\n", out)
+
+	out, err = apply(`{"baz": "qux"}`, `[{"op": "test", "path": "/baz", "value": "bar"}]`)
+	assert.True(t, errors.Is(err, ErrJSONPatchTest), err)
+	assert.Empty(t, out)
+
+	for _, bad := range []string{
+		`[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+		`[{"op": "remove", "path": "/nope"}]`,
+		`[{"op": "add", "path": "/list/3", "value": 1}]`,
+		`[{"op": "add", "path": "/list/01", "value": 1}]`,
+		`[{"op": "move", "from": "/list", "path": "/list/0"}]`,
+		`[{"op": "add", "path": "/x"}]`,
+		`[{"op": "frobnicate", "path": "/x"}]`,
+		`[{"op": "add", "path": "x", "value": 1}]`,
+		`{"op": "add"}`,
+	} {
+		_, err = apply(`{"list": [1, 2]}`, bad)
+		assert.Error(t, err, bad)
+	}
+	_, err = apply(`{"list": [1, 2]} {}`, `[]`)
+	assert.Error(t, err)
+}
@@ -5,13 +5,19 @@ package lightpatch
 import (
 	"bufio"
 	"bytes"
+	"crypto/ed25519"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"strconv"
 	"time"
+
+	"github.com/zeebo/xxh3"
+	"lukechampine.com/blake3"
 )
 
 const (
@@ -19,53 +25,508 @@ const (
 	OpInsert byte = 'I'
 	OpDelete byte = 'D'
 	OpCRC    byte = 'K'
+	OpBLAKE3 byte = 'B'
+	OpXXH3   byte = 'X'
+
+	// The ops below were added to the format later. Decoders older than an op can't
+	// read patches that use it, so the options that make MakePatch write them are all
+	// off by default.
+
+	// OpCheckedInsert is an insert followed by the CRC-32 of its data. See
+	// WithInsertChecksums.
+	OpCheckedInsert byte = 'Q'
+
+	// OpAnnotation carries arbitrary bytes, such as a comment or a job ID, and is
+	// skipped when the patch is applied. See WithAnnotation.
+	OpAnnotation byte = 'N'
+
+	// OpReplace deletes len bytes of before and inserts new bytes in their place. It is
+	// followed by the varint length of the insert and its data, and saves an op byte
+	// over a delete and an insert. See WithReplace.
+	OpReplace byte = 'R'
+
+	// OpCopyToEnd copies the rest of before. It has no length, so the patch applies to
+	// any before that matches up to the start of the copy. See WithCopyToEnd.
+	OpCopyToEnd byte = 'T'
+
+	// OpContext carries the text around a change, so that the change can be found
+	// again in a before that has shifted. It is written before the records of the
+	// change, and skipped by ApplyPatch. See WithEditContext.
+	OpContext byte = 'L'
+
+	// OpCheckpoint carries the checksum of the output so far, computed with the hash
+	// of the patch's own checksum: CRC-32, BLAKE3 or XXH3. See WithCheckpoints.
+	OpCheckpoint byte = 'P'
+
+	// OpBeforeCRC is followed by the CRC-32 of the before the patch was made from. It
+	// comes before any record that reads before. See WithBeforeChecksum.
+	OpBeforeCRC byte = 'S'
+
+	// OpPadding is len zero bytes that are skipped when the patch is applied. It
+	// aligns the records that follow it. See WithBlockAlign.
+	OpPadding byte = 'Z'
+
+	// OpChunk is an insert whose data is kept in a ChunkStore instead of the patch.
+	// Its length is that of the data, and it is followed by the BLAKE3-256 digest of
+	// the data. See WithDedup.
+	OpChunk byte = 'H'
+
+	// OpMetadata is a record of key/value pairs describing the patch. It has no
+	// effect on the output.
+	OpMetadata byte = 'M'
+
+	// OpSignature introduces an Ed25519 signature envelope around a patch. See
+	// WithSigningKey.
+	OpSignature byte = 'E'
+
+	// OpFEC introduces a Reed-Solomon envelope around a patch. See WithFEC.
+	OpFEC byte = 'F'
+
+	// OpGzip is the first byte of a gzip stream, which identifies a patch compressed
+	// with WithGzip.
+	OpGzip byte = 0x1f
+
+	// OpDictionary introduces a patch compressed with a dictionary. See
+	// WithDictionary.
+	OpDictionary byte = 'Y'
 
 	DefaultTimeout = 5 * time.Second
+
+	blake3Size = 32
+	xxh3Size   = 8
 )
 
 var (
 	ErrCRC       = errors.New("CRC mismatch")
+	ErrChecksum  = errors.New("checksum mismatch")
 	ErrExtraData = errors.New("unexpected data following CRC")
+
+	// ErrMalformed is wrapped by the errors returned for patches that can't be
+	// parsed, such as an unknown operation byte or a length that doesn't fit in an
+	// int. A patch that ends early returns io.EOF or io.ErrUnexpectedEOF instead.
+	ErrMalformed = errors.New("malformed patch")
+
+	// ErrTooDifferent is returned by MakePatch when after is less similar to before
+	// than allowed by WithMinSimilarity.
+	ErrTooDifferent = errors.New("inputs too different")
+
+	// ErrTruncatedPatch is the reason of a DecodeError for a patch that ends within a
+	// record.
+	ErrTruncatedPatch = errors.New("patch ends early")
+
+	// ErrSourceTooShort is the reason of a DecodeError for a record that reads past
+	// the end of before.
+	ErrSourceTooShort = errors.New("patch reads past the end of before")
+
+	// ErrUnknownOp is wrapped by the errors returned for a record with an unknown or
+	// misplaced operation byte. It wraps ErrMalformed.
+	ErrUnknownOp = fmt.Errorf("%w: unexpected operation byte", ErrMalformed)
+
+	// ErrUnknownVersion is wrapped by the errors returned for a tree, tar or journal
+	// patch of a version of its format that this package doesn't know, such as one
+	// written by a newer release.
+	ErrUnknownVersion = errors.New("unknown patch format version")
 )
 
+// DecodeError reports a record that ApplyPatch or VerifyPatch couldn't decode. The
+// record of Op starts Offset bytes into the binary patch, once any armor or envelope
+// is removed, and Source bytes into before, which is 0 for VerifyPatch. Err is the
+// reason: ErrTruncatedPatch, ErrSourceTooShort, or an error wrapping ErrMalformed,
+// such as ErrUnknownOp.
+type DecodeError struct {
+	Offset int64
+	Source int64
+	Op     byte
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v in %q record at patch offset %d, before offset %d", e.Err, e.Op, e.Offset, e.Source)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError returns err as a *DecodeError of the record of op at offset in the patch
+// and source in before, if it is an error decoding the record. An early end is taken
+// to be of the patch; sourceError marks those of before. Other errors, such as
+// checksum mismatches, are returned as they are.
+func decodeError(err error, offset, source int64, op byte) error {
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		err = ErrTruncatedPatch
+	case err != ErrSourceTooShort && !errors.Is(err, ErrMalformed):
+		return err
+	}
+	return &DecodeError{Offset: offset, Source: source, Op: op, Err: err}
+}
+
+// sourceError returns ErrSourceTooShort for err if it is the early end of before.
+func sourceError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrSourceTooShort
+	}
+	return err
+}
+
+// Stats describes a patch generated by MakePatch. See WithStats.
+type Stats struct {
+	BeforeSize int           // Length of the before input
+	AfterSize  int           // Length of the after input
+	PatchSize  int           // Length of the encoded patch, including the CRC
+	Ops        int           // Number of copy, insert and delete operations
+	Naive      bool          // The diff was replaced by a single insert of after
+	TimedOut   bool          // The timeout was reached and the diff may be suboptimal
+	Duration   time.Duration // Time spent generating the patch
+	Warnings   []Warning     // Reasons the patch may be worse than expected, if any
+}
+
+// A Warning is a non-fatal condition met by MakePatch that may make the patch larger
+// or coarser than expected. See Stats.
+type Warning int
+
+const (
+	// WarningTimeout means the timeout was reached and the diff may be suboptimal.
+	WarningTimeout Warning = iota + 1
+
+	// WarningNaive means the diff was replaced by a single insert of after, since
+	// that was shorter. It is expected when the inputs are unrelated.
+	WarningNaive
+
+	// WarningBinary means a text granularity was requested but an input looks
+	// binary, so it was diffed by byte instead.
+	WarningBinary
+
+	// WarningMemory means the diff was projected to need more memory than allowed by
+	// WithMaxMemory, so after was inserted whole instead.
+	WarningMemory
+)
+
+func (w Warning) String() string {
+	switch w {
+	case WarningTimeout:
+		return "timeout reached, patch may be larger than necessary"
+	case WarningNaive:
+		return "diff larger than after, used a single insert"
+	case WarningBinary:
+		return "binary input, diffed by byte"
+	case WarningMemory:
+		return "memory limit reached, used a single insert"
+	}
+	return "Warning(" + strconv.Itoa(int(w)) + ")"
+}
+
 // MatchPatch generates a diff to change before into after, writing the output to patch.
-func MakePatch(before, after io.Reader, output io.Writer) error {
-	return MakePatchTimeout(before, after, output, DefaultTimeout)
+func MakePatch(before, after io.Reader, output io.Writer, opts ...Option) error {
+	return MakePatchTimeout(before, after, output, DefaultTimeout, opts...)
 }
 
 // MatchPatchTimeout generates a diff to change before into after, writing the output to
 // patch. timeout is the max time to try to make an efficient patch. The operation will
 // still succeed even if timeout is reached, with perhaps a less compact patch. If timeout
 // is 0 the function will take as long as it needs to complete.
-func MakePatchTimeout(before, after io.Reader, patch io.Writer, timeout time.Duration) error {
-	beforeBytes, err := ioutil.ReadAll(before)
-	if err != nil {
+func MakePatchTimeout(before, after io.Reader, patch io.Writer, timeout time.Duration, opts ...Option) error {
+	cfg := newConfig(opts)
+	timeout = cfg.diffTimeout(timeout)
+	start := time.Now()
+
+	// The diffs reference the input buffers, which are only returned to the pool
+	// once the patch has been written.
+	beforeBuf, afterBuf := getBuffer(), getBuffer()
+	defer putBuffer(beforeBuf)
+	defer putBuffer(afterBuf)
+
+	if _, err := beforeBuf.ReadFrom(before); err != nil {
 		return err
 	}
-	afterBytes, err := ioutil.ReadAll(after)
-	if err != nil {
+	if _, err := afterBuf.ReadFrom(after); err != nil {
 		return err
 	}
+	return makePatch(beforeBuf.Bytes(), afterBuf.Bytes(), patch, cfg, start, timeout)
+}
+
+// MakePatchBytes is like MakePatch, but diffs before and after in place rather than
+// reading copies of them, e.g. for inputs mapped into memory that are larger than RAM.
+// The inputs must not change until it returns.
+func MakePatchBytes(before, after []byte, patch io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	return makePatch(before, after, patch, cfg, time.Now(), cfg.diffTimeout(DefaultTimeout))
+}
 
-	diffs := diffMain(beforeBytes, afterBytes, timeout)
+// makePatch diffs beforeBytes and afterBytes and writes the patch, as configured by
+// cfg.
+func makePatch(beforeBytes, afterBytes []byte, patch io.Writer, cfg config, start time.Time, timeout time.Duration) error {
+	binaryInput := cfg.adjustGranularity(beforeBytes, afterBytes)
+
+	if cfg.granularity == GranularityXML {
+		var err error
+		if beforeBytes, err = canonicalXML(beforeBytes); err != nil {
+			return err
+		}
+		if afterBytes, err = canonicalXML(afterBytes); err != nil {
+			return err
+		}
+	}
+
+	// Without a diff, the delete and insert lose to the naive patch of a single insert.
+	overMemory := cfg.maxMemory > 0 && cfg.diffMemory(beforeBytes, afterBytes) > cfg.maxMemory
+	diffs := []diff{{OpDelete, beforeBytes}, {OpInsert, afterBytes}}
+	if !overMemory {
+		diffs = cfg.diff(beforeBytes, afterBytes, timeout)
+	}
+
+	if cfg.ctx != nil && cfg.ctx.Err() != nil {
+		return cfg.ctx.Err()
+	}
+
+	if err := writePatch(patch, beforeBytes, afterBytes, diffs, cfg, start, timeout); err != nil {
+		return err
+	}
+
+	if binaryInput && cfg.stats != nil {
+		cfg.stats.Warnings = append(cfg.stats.Warnings, WarningBinary)
+	}
+	if overMemory && cfg.stats != nil {
+		cfg.stats.Warnings = append(cfg.stats.Warnings, WarningMemory)
+	}
+	return nil
+}
+
+// writePatch encodes diffs to patch, falling back to a single insert if that is shorter.
+// start and timeout are used to fill in the stats, if requested.
+func writePatch(patch io.Writer, beforeBytes, afterBytes []byte, diffs []diff, cfg config, start time.Time, timeout time.Duration) error {
+	timedOut := timeout > 0 && time.Since(start) >= timeout
 
 	// If inputs are very different, the total size of the encoded diffs can be greater than just
 	// outputting after bytes. We'll check whether this "naive" diff is actually shorter.
-	naiveDiff := []diff{
-		{
-			Type: OpInsert,
-			Text: afterBytes,
-		},
+	// An empty after needs no insert at all.
+	var naiveDiff []diff
+	if len(afterBytes) > 0 {
+		naiveDiff = []diff{
+			{
+				Type: OpInsert,
+				Text: afterBytes,
+			},
+		}
 	}
 
-	if encodedLen(naiveDiff) < encodedLen(diffs) {
+	naive := cost(naiveDiff, cfg) < cost(diffs, cfg)
+	if naive {
 		diffs = naiveDiff
 	}
 
+	if cfg.minSimilarity > 0 && similarity(diffs, len(afterBytes)) < cfg.minSimilarity {
+		return ErrTooDifferent
+	}
+
+	pc := &countingWriter{w: patch}
+	patch = pc
+
+	var aw *armorWriter
+	if cfg.armor != ArmorNone {
+		var err error
+		if aw, err = newArmorWriter(pc, cfg.armor); err != nil {
+			return err
+		}
+		patch = aw
+	}
+
+	// The envelope needs the complete patch.
+	var fecBuf *bytes.Buffer
+	if cfg.fec != nil {
+		fecBuf = new(bytes.Buffer)
+		patch = fecBuf
+	}
+
+	// The patch is compressed inside the envelope, and signed before it's compressed.
+	zw, err := compressor(patch, cfg)
+	if err != nil {
+		return err
+	}
+	if zw != nil {
+		patch = zw
+	}
+
+	// The signature needs the complete patch too.
+	var sigBuf *bytes.Buffer
+	signed := patch
+	if cfg.signingKey != nil {
+		sigBuf = new(bytes.Buffer)
+		patch = sigBuf
+	}
+
+	// Alignment counts from the start of the binary patch.
+	var blocks *countingWriter
+	if cfg.blockSize > 1 {
+		blocks = &countingWriter{w: patch}
+		patch = blocks
+	}
+
+	switch cfg.checksum {
+	case ChecksumBLAKE3:
+		digest := blake3.Sum256(afterBytes)
+		if _, err := patch.Write(append([]byte{OpBLAKE3}, digest[:]...)); err != nil {
+			return err
+		}
+	case ChecksumXXH3:
+		rec := make([]byte, 1+xxh3Size)
+		rec[0] = OpXXH3
+		binary.BigEndian.PutUint64(rec[1:], xxh3.Hash(afterBytes))
+		if _, err := patch.Write(rec); err != nil {
+			return err
+		}
+	}
+
+	if rec := patchMetadata(cfg, timeout, timedOut, naive); rec != nil {
+		if _, err := patch.Write(rec); err != nil {
+			return err
+		}
+	}
+
+	if err := writeAnnotations(patch, cfg.annotations); err != nil {
+		return err
+	}
+	if cfg.beforeChecksum {
+		if _, err := patch.Write(beforeCRCRecord(beforeBytes)); err != nil {
+			return err
+		}
+	}
+	if err := writeRecords(patch, diffs, cfg, blocks, newCheckpointer(cfg)); err != nil {
+		return err
+	}
+
+	if err := writeTrailer(patch, crc32.ChecksumIEEE(afterBytes), cfg, blocks); err != nil {
+		return err
+	}
+
+	if sigBuf != nil {
+		if err := writeSignature(signed, sigBuf.Bytes(), cfg.signingKey); err != nil {
+			return err
+		}
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+
+	if fecBuf != nil {
+		var w io.Writer = pc
+		if aw != nil {
+			w = aw
+		}
+		if err := writeFEC(w, fecBuf.Bytes(), *cfg.fec); err != nil {
+			return err
+		}
+	}
+
+	if aw != nil {
+		if err := aw.Close(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.stats != nil {
+		var warnings []Warning
+		if timedOut {
+			warnings = append(warnings, WarningTimeout)
+		}
+		if naive {
+			warnings = append(warnings, WarningNaive)
+		}
+
+		*cfg.stats = Stats{
+			BeforeSize: len(beforeBytes),
+			AfterSize:  len(afterBytes),
+			PatchSize:  pc.n,
+			Ops:        len(diffs),
+			Naive:      naive,
+			TimedOut:   timedOut,
+			Duration:   time.Since(start),
+			Warnings:   warnings,
+		}
+	}
+
+	return nil
+}
+
+// writeAnnotations writes an annotation record for each of notes.
+func writeAnnotations(patch io.Writer, notes [][]byte) error {
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+
+	for _, note := range notes {
+		n := binary.PutUvarint(varintBuf, uint64(len(note)))
+		if _, err := patch.Write(append(append([]byte{OpAnnotation}, varintBuf[:n]...), note...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeRecords encodes diffs to patch as records. If blocks is not nil, it counts the
+// bytes written to patch so far, and inserts are aligned to cfg.blockSize. If cp is
+// not nil, checkpoints are written as the output passes them.
+func writeRecords(patch io.Writer, diffs []diff, cfg config, blocks *countingWriter, cp *checkpointer) error {
 	varintBuf := make([]byte, binary.MaxVarintLen64)
 
-	for _, diff := range diffs {
-		if _, err := patch.Write([]byte{diff.Type}); err != nil {
+	diffs = cp.split(diffs)
+
+	for i := 0; i < len(diffs); i++ {
+		if cfg.editContext > 0 && diffs[i].Type != OpCopy && (i == 0 || diffs[i-1].Type == OpCopy) {
+			if _, err := patch.Write(contextRecord(diffs, i, cfg.editContext)); err != nil {
+				return err
+			}
+		}
+
+		if deleted, inserted, ok := replacePair(diffs, i, cfg); ok {
+			rec := replaceRecord(deleted, inserted)
+			if blocks != nil {
+				if _, err := patch.Write(padding(blocks.n+len(rec), cfg.blockSize)); err != nil {
+					return err
+				}
+			}
+			if _, err := patch.Write(append(rec, inserted...)); err != nil {
+				return err
+			}
+			if err := cp.output(patch, inserted); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+
+		if isCopyToEnd(diffs, i, cfg) {
+			if _, err := patch.Write([]byte{OpCopyToEnd}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		diff := diffs[i]
+		if diff.Type == OpInsert && cfg.dedup.stores(diff.Text) {
+			if err := writeChunk(patch, cfg.dedup.store, diff.Text); err != nil {
+				return err
+			}
+			if err := cp.output(patch, diff.Text); err != nil {
+				return err
+			}
+			continue
+		}
+
+		op := diff.Type
+		if op == OpInsert && cfg.insertChecksums {
+			op = OpCheckedInsert
+		}
+		if blocks != nil && diff.Type == OpInsert {
+			header := 1 + uvarintLen(uint64(len(diff.Text)))
+			if _, err := patch.Write(padding(blocks.n+header, cfg.blockSize)); err != nil {
+				return err
+			}
+		}
+		if _, err := patch.Write([]byte{op}); err != nil {
 			return err
 		}
 
@@ -79,29 +540,111 @@ func MakePatchTimeout(before, after io.Reader, patch io.Writer, timeout time.Dur
 				return err
 			}
 		}
+
+		if op == OpCheckedInsert {
+			binary.BigEndian.PutUint32(varintBuf, crc32.ChecksumIEEE(diff.Text))
+			if _, err := patch.Write(varintBuf[:4]); err != nil {
+				return err
+			}
+		}
+
+		if diff.Type != OpDelete {
+			if err := cp.output(patch, diff.Text); err != nil {
+				return err
+			}
+		}
 	}
 
-	n := crc32.NewIEEE()
-	n.Write(afterBytes)
+	return nil
+}
 
-	if _, err := patch.Write(n.Sum([]byte{OpCRC})); err != nil {
-		return err
+// writeTrailer ends the records of a patch with the CRC record of the output, whose
+// CRC-32 is crc, if the patch has one, and any padding.
+func writeTrailer(patch io.Writer, crc uint32, cfg config, blocks *countingWriter) error {
+	// The CRC must be the final record, so the padding goes before it.
+	if blocks != nil {
+		crcSize := 0
+		if cfg.checksum == ChecksumCRC32 {
+			crcSize = 5
+		}
+		if _, err := patch.Write(padding(blocks.n+crcSize, cfg.blockSize)); err != nil {
+			return err
+		}
+	}
+
+	if cfg.checksum == ChecksumCRC32 {
+		rec := []byte{OpCRC, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(rec[1:], crc)
+		if _, err := patch.Write(rec); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 // ApplyPatch reads before, applies the edits from patch, and writes
-// the output to after.
-func ApplyPatch(before, patch io.Reader, after io.Writer) error {
+// the output to after. Armor, FEC envelopes, signatures and the formats registered
+// with RegisterDecoder are decoded automatically. Signatures are only checked with
+// WithPublicKey.
+//
+// ApplyPatch doesn't panic on any input. A patch that can't be parsed returns an
+// error wrapping ErrMalformed.
+func ApplyPatch(before, patch io.Reader, after io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	raw := &countingReader{r: patch}
+	patch, err := openSignedPatch(raw, cfg.publicKey)
+	if err != nil {
+		return err
+	}
+
+	br := &countingReader{r: before}
+	return applyPatch(&streamSource{Reader: bufio.NewReader(br), c: br}, raw, patch, after, cfg)
+}
+
+// applyPatch applies patch, decoded from raw, to before and writes the output to
+// after.
+func applyPatch(before beforeSource, raw *countingReader, patch io.Reader, after io.Writer, cfg config) error {
 	var crcRead bool
-	var n = crc32.NewIEEE()
+	var n hash.Hash = crc32.NewIEEE()
+	var digest []byte
+
+	pr := &countingReader{r: patch}
+	patchBR := bufio.NewReader(pr)
+
+	// A leading digest record replaces the CRC, and is verified once the patch ends.
+	if p, err := patchBR.Peek(1); err == nil && digestSize(p[0]) > 0 {
+		op := p[0]
+		if op == OpBLAKE3 {
+			n = blake3.New(blake3Size, nil)
+		} else {
+			n = xxh3.New()
+		}
+		digest = make([]byte, digestSize(op))
+		patchBR.Discard(1)
+		if _, err := io.ReadFull(patchBR, digest); err != nil {
+			return decodeError(err, 0, 0, op)
+		}
+	}
+
+	var sw *sizeWriter
+	if cfg.expectedSize != nil {
+		sw = newSizeWriter(after, *cfg.expectedSize)
+		after = sw
+	}
+	if cfg.maxOutputSize > 0 || cfg.maxExpansion > 0 {
+		after = &limitWriter{
+			w:     after,
+			max:   cfg.maxOutputSize,
+			ratio: cfg.maxExpansion,
+			input: func() int64 { return before.read() + raw.n },
+		}
+	}
 
 	after = io.MultiWriter(after, n)
-	beforeBR := bufio.NewReader(before)
-	patchBR := bufio.NewReader(patch)
 
-	for {
+	for ops := 1; ; ops++ {
+		offset, source := pr.n-int64(patchBR.Buffered()), before.read()
 		op, err := patchBR.ReadByte()
 		if err == io.EOF {
 			break
@@ -112,36 +655,111 @@ func ApplyPatch(before, patch io.Reader, after io.Writer) error {
 		if crcRead {
 			return ErrExtraData
 		}
+		if cfg.maxOps > 0 && ops > cfg.maxOps {
+			return ErrTooManyOps
+		}
 
 		var tl uint64
-		if op != OpCRC {
-			tl, err = binary.ReadUvarint(patchBR)
+		if op != OpCRC && op != OpCopyToEnd && op != OpBeforeCRC {
+			tl, err = readLength(patchBR)
 			if err != nil {
-				return err
+				return decodeError(err, offset, source, op)
+			}
+			if cfg.strict {
+				if err := checkStrictLength(op, tl); err != nil {
+					return decodeError(err, offset, source, op)
+				}
 			}
 		}
 
 		switch op {
 		case OpCopy:
-			_, err := io.CopyN(after, beforeBR, int64(tl))
+			_, err := io.CopyN(after, before, int64(tl))
 			if err != nil {
-				return err
+				return decodeError(sourceError(err), offset, source, op)
 			}
 		case OpInsert:
 			_, err := io.CopyN(after, patchBR, int64(tl))
 			if err != nil {
-				return err
+				return decodeError(err, offset, source, op)
+			}
+		case OpCheckedInsert:
+			if err := copyCheckedInsert(after, patchBR, tl, offset); err != nil {
+				return decodeError(err, offset, source, op)
+			}
+		case OpCopyToEnd:
+			if _, err := io.Copy(after, before); err != nil {
+				return decodeError(err, offset, source, op)
+			}
+		case OpReplace:
+			if _, err := before.Discard(int(tl)); err != nil {
+				return decodeError(sourceError(err), offset, source, op)
+			}
+			il, err := readLength(patchBR)
+			if err != nil {
+				return decodeError(err, offset, source, op)
+			}
+			if cfg.strict {
+				if err := checkStrictLength(op, il); err != nil {
+					return decodeError(err, offset, source, op)
+				}
+			}
+			if _, err := io.CopyN(after, patchBR, int64(il)); err != nil {
+				return decodeError(err, offset, source, op)
+			}
+		case OpChunk:
+			data, err := readChunk(patchBR, cfg.dedup, tl)
+			if err != nil {
+				return decodeError(err, offset, source, op)
+			}
+			if _, err := after.Write(data); err != nil {
+				return decodeError(err, offset, source, op)
 			}
 		case OpDelete:
-			_, err := beforeBR.Discard(int(tl))
+			_, err := before.Discard(int(tl))
 			if err != nil {
-				return err
+				return decodeError(sourceError(err), offset, source, op)
+			}
+		case OpMetadata:
+			if cfg.strict {
+				err = checkExpiry(patchBR, tl)
+			} else {
+				_, err = io.CopyN(ioutil.Discard, patchBR, int64(tl))
+			}
+			if err != nil {
+				return decodeError(err, offset, source, op)
+			}
+		case OpAnnotation, OpPadding, OpContext:
+			if _, err := io.CopyN(ioutil.Discard, patchBR, int64(tl)); err != nil {
+				return decodeError(err, offset, source, op)
+			}
+		case OpCheckpoint:
+			if tl != uint64(n.Size()) {
+				return decodeError(errBadCheckpoint, offset, source, op)
+			}
+			sum := make([]byte, tl)
+			if _, err := io.ReadFull(patchBR, sum); err != nil {
+				return decodeError(err, offset, source, op)
+			}
+			if !bytes.Equal(sum, n.Sum(nil)) {
+				return ErrCheckpoint
+			}
+		case OpBeforeCRC:
+			if before.read() > 0 {
+				return decodeError(unexpectedOp(op), offset, source, op)
+			}
+			if err := before.check(patchBR); err != nil {
+				return decodeError(err, offset, source, op)
 			}
 		case OpCRC:
+			if digest != nil {
+				return decodeError(unexpectedOp(op), offset, source, op)
+			}
+
 			patchCRC := make([]byte, 4)
 			_, err := io.ReadFull(patchBR, patchCRC)
 			if err != nil {
-				return err
+				return decodeError(err, offset, source, op)
 			}
 
 			if !bytes.Equal(patchCRC, n.Sum(nil)) {
@@ -150,17 +768,226 @@ func ApplyPatch(before, patch io.Reader, after io.Writer) error {
 			crcRead = true
 
 		default:
-			return fmt.Errorf("unexpected operation byte: %x", op)
+			return decodeError(unexpectedOp(op), offset, source, op)
 		}
 	}
 
+	if sw != nil && sw.n != sw.limit {
+		return ErrAfterSize
+	}
+
+	if cfg.strict {
+		if err := checkStrictEnd(before, crcRead || digest != nil); err != nil {
+			return err
+		}
+	}
+
+	if digest != nil && !bytes.Equal(digest, n.Sum(nil)) {
+		return ErrChecksum
+	}
+
 	return nil
 }
 
-func encodedLen(diffs []diff) int {
+// digestSize returns the size of the digest in a leading checksum record of op, or 0
+// if op doesn't begin one.
+func digestSize(op byte) int {
+	switch op {
+	case OpBLAKE3:
+		return blake3Size
+	case OpXXH3:
+		return xxh3Size
+	}
+	return 0
+}
+
+// IsNaive reports whether patch is a naive patch, which copies nothing from before
+// and so carries all of after, as MakePatch writes when the inputs have little in
+// common. It returns false if patch can't be read.
+func IsNaive(patch []byte) bool {
+	ops, _, err := readPatchOps(bytes.NewReader(patch))
+	if err != nil {
+		return false
+	}
+
+	for _, op := range ops {
+		if op.op == OpCopy {
+			return false
+		}
+	}
+	return true
+}
+
+// openPatch returns a reader of the binary patch in patch, removing any armor and
+// decoding the formats registered with RegisterDecoder, such as FEC envelopes.
+func openPatch(patch io.Reader) (io.Reader, error) {
+	return openSignedPatch(patch, nil)
+}
+
+// openSignedPatch is like openPatch, but if key is not nil, the patch must have a
+// signature envelope whose signature verifies with key.
+func openSignedPatch(patch io.Reader, key ed25519.PublicKey) (io.Reader, error) {
+	patch, err := dearmor(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := false
+	for {
+		br := bufio.NewReader(patch)
+		op, err := br.Peek(1)
+		if err == nil && op[0] == OpSignature && key != nil {
+			br.Discard(1)
+			inner, err := readSignature(br, key)
+			if err != nil {
+				return nil, err
+			}
+			patch, signed = bytes.NewReader(inner), true
+			continue
+		}
+
+		var d Decoder
+		if err == nil {
+			d = decoder(op[0])
+		}
+		if d == nil {
+			if key != nil && !signed {
+				return nil, ErrUnsigned
+			}
+			return br, nil
+		}
+
+		br.Discard(1)
+		if patch, err = d(br); err != nil {
+			return nil, err
+		}
+	}
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// readLength reads the uvarint length of a record, rejecting lengths that don't fit
+// in an int so that they can't turn negative when converted.
+func readLength(r io.ByteReader) (uint64, error) {
+	l, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, err
+	} else if err != nil || l > uint64(maxInt) {
+		return 0, fmt.Errorf("%w: bad record length", ErrMalformed)
+	}
+	return l, nil
+}
+
+// unexpectedOp returns the error for a record with an unknown or misplaced
+// operation byte.
+func unexpectedOp(op byte) error {
+	return fmt.Errorf("%w: %x", ErrUnknownOp, op)
+}
+
+// readMagic reads the magic of a patch format from r, which must be want, whose last
+// byte is the version of the format. It returns notFormat for the magic of another
+// format, or an error wrapping ErrUnknownVersion for another version of this one.
+func readMagic(r io.Reader, want string, notFormat error) error {
+	magic := make([]byte, len(want))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic[:len(want)-1]) != want[:len(want)-1] {
+		return notFormat
+	}
+	if string(magic) != want {
+		return fmt.Errorf("%w: %q", ErrUnknownVersion, magic)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// beforeSource is the before a patch is applied to, read in order.
+type beforeSource interface {
+	io.Reader
+
+	// Discard skips the next n bytes, returning an error if there are fewer.
+	Discard(n int) (int, error)
+
+	// read returns the number of bytes of before read or skipped, the offset of the
+	// next byte.
+	read() int64
+
+	// more reports whether before has bytes left to read.
+	more() (bool, error)
+
+	// check reads the CRC of an OpBeforeCRC record from patch, and returns
+	// ErrBeforeMismatch if it isn't the CRC of before.
+	check(patch io.Reader) error
+}
+
+// streamSource is a beforeSource read from a stream, which is read whole to check its
+// CRC.
+type streamSource struct {
+	*bufio.Reader
+	c *countingReader
+}
+
+func (s *streamSource) read() int64 {
+	return s.c.n - int64(s.Buffered())
+}
+
+func (s *streamSource) more() (bool, error) {
+	if _, err := s.Peek(1); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *streamSource) check(patch io.Reader) error {
+	data, err := checkBefore(s.Reader, patch)
+	if err != nil {
+		return err
+	}
+	s.c = &countingReader{r: bytes.NewReader(data)}
+	s.Reader = bufio.NewReader(s.c)
+	return nil
+}
+
+// encodedLen returns the number of bytes diffs take in a patch made with cfg. It is
+// the default cost model.
+func encodedLen(diffs []diff, cfg config) int {
 	var total int
 
-	for _, d := range diffs {
+	for i := 0; i < len(diffs); i++ {
+		if deleted, inserted, ok := replacePair(diffs, i, cfg); ok {
+			total += len(replaceRecord(deleted, inserted)) + len(inserted)
+			i++
+			continue
+		}
+		if isCopyToEnd(diffs, i, cfg) {
+			total++
+			continue
+		}
+		d := diffs[i]
+
 		// Op bytes
 		total++
 
@@ -172,9 +999,13 @@ func encodedLen(diffs []diff) int {
 		}
 		total++
 
-		// Data
+		// Data. Inserts are counted in full even if they are stored as chunks, so
+		// that the naive fallback doesn't turn a shared insert into a unique one.
 		if d.Type == OpInsert {
 			total += len(d.Text)
+			if cfg.insertChecksums {
+				total += 4
+			}
 		}
 	}
 
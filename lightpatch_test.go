@@ -2,8 +2,12 @@ package lightpatch
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -86,4 +90,286 @@ func Test_lightpatch(t *testing.T) {
 		err = ApplyPatch(ar, &patchr, new(bytes.Buffer))
 		assert.EqualError(t, err, ErrExtraData.Error())
 	})
+	t.Run("malformed", func(t *testing.T) {
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+
+		for _, patch := range [][]byte{
+			[]byte("W\x01"),
+			[]byte("C\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"), // 2^64-1
+			[]byte("C\xff\xff\xff\xff\xff\xff\xff\xff\xff\x02"), // overflows
+		} {
+			err := ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), new(bytes.Buffer))
+			assert.True(t, errors.Is(err, ErrMalformed), err)
+			_, err = Edits(a, patch)
+			assert.True(t, errors.Is(err, ErrMalformed), err)
+			assert.True(t, errors.Is(VerifyPatch(bytes.NewReader(patch)), ErrMalformed))
+		}
+	})
+	t.Run("max ops", func(t *testing.T) {
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+		b := []byte("The quick brown cat jumped over the dog!")
+
+		var patch bytes.Buffer
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch)
+		assert.NoError(t, err)
+
+		// 8 ops and the CRC
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), new(bytes.Buffer), WithMaxOps(9))
+		assert.NoError(t, err)
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), new(bytes.Buffer), WithMaxOps(8))
+		assert.Equal(t, ErrTooManyOps, err)
+
+		// A patch of single byte copies
+		a = make([]byte, DefaultMaxOps+1)
+		tiny := bytes.Repeat([]byte{OpCopy, 1}, len(a))
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(tiny), new(bytes.Buffer))
+		assert.Equal(t, ErrTooManyOps, err)
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(tiny), new(bytes.Buffer), WithMaxOps(0))
+		assert.NoError(t, err)
+	})
+	t.Run("stats", func(t *testing.T) {
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+		b := []byte("The quick brown cat jumped over the dog!")
+
+		var patchr bytes.Buffer
+		var stats Stats
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithStats(&stats))
+		assert.NoError(t, err)
+
+		assert.Equal(t, len(a), stats.BeforeSize)
+		assert.Equal(t, len(b), stats.AfterSize)
+		assert.Equal(t, patchr.Len(), stats.PatchSize)
+		assert.Equal(t, 8, stats.Ops)
+		assert.False(t, stats.Naive)
+		assert.False(t, stats.TimedOut)
+		assert.Empty(t, stats.Warnings)
+
+		// Random inputs fall back to a naive diff
+		a = make([]byte, 100)
+		b = make([]byte, 100)
+		rand.Read(a)
+		rand.Read(b)
+		err = MakePatch(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), WithStats(&stats))
+		assert.NoError(t, err)
+		assert.True(t, stats.Naive)
+		assert.Equal(t, 1, stats.Ops)
+		assert.Equal(t, []Warning{WarningNaive}, stats.Warnings)
+
+		// Binary inputs are diffed by byte, whatever the granularity.
+		a = []byte("The quick brown fox jumped\x00 over the lazy dog. It barked.")
+		b = []byte("The quick brown fox jumped\x00 over the lazy cat. It barked.")
+		var patch bytes.Buffer
+		err = MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithStats(&stats), WithGranularity(GranularitySentence))
+		assert.NoError(t, err)
+		assert.Equal(t, []Warning{WarningBinary}, stats.Warnings)
+		var bytePatch bytes.Buffer
+		assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &bytePatch))
+		assert.Equal(t, bytePatch.Bytes(), patch.Bytes())
+	})
+	t.Run("timeout", func(t *testing.T) {
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+		b := []byte("The quick brown cat jumped over the dog!")
+
+		var stats Stats
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), WithStats(&stats), WithTimeout(time.Nanosecond))
+		assert.NoError(t, err)
+		assert.True(t, stats.TimedOut)
+
+		// The option overrides the argument
+		err = MakePatchTimeout(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), time.Hour, WithStats(&stats), WithTimeout(time.Nanosecond))
+		assert.NoError(t, err)
+		assert.True(t, stats.TimedOut)
+
+		err = MakePatchTimeout(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), time.Nanosecond, WithStats(&stats), WithTimeout(0))
+		assert.NoError(t, err)
+		assert.False(t, stats.TimedOut)
+	})
+	t.Run("blake3", func(t *testing.T) {
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+		b := []byte("The quick brown cat jumped over the dog!")
+
+		var patchr bytes.Buffer
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithChecksum(ChecksumBLAKE3))
+		assert.NoError(t, err)
+		assert.Equal(t, OpBLAKE3, patchr.Bytes()[0])
+		assert.NotEqual(t, OpCRC, patchr.Bytes()[patchr.Len()-5])
+		patch := patchr.Bytes()
+
+		var c bytes.Buffer
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), &c)
+		assert.NoError(t, err)
+		assert.Equal(t, b, c.Bytes())
+
+		// alter a to change the digest
+		a[0] = 't'
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), new(bytes.Buffer))
+		assert.Equal(t, ErrChecksum, err)
+	})
+	t.Run("xxh3", func(t *testing.T) {
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+		b := []byte("The quick brown cat jumped over the dog!")
+
+		var patchr bytes.Buffer
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithXXH3())
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{OpXXH3, 0x30, 0x48, 0xe2, 0x9e, 0xaa, 0xe3, 0x44, 0x2d}, patchr.Bytes()[:9])
+		assert.NotEqual(t, OpCRC, patchr.Bytes()[patchr.Len()-5])
+		patch := patchr.Bytes()
+		assert.NoError(t, VerifyPatch(bytes.NewReader(patch)))
+
+		var c bytes.Buffer
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), &c)
+		assert.NoError(t, err)
+		assert.Equal(t, b, c.Bytes())
+
+		var text, bin bytes.Buffer
+		assert.NoError(t, ToText(bytes.NewReader(patch), &text))
+		assert.True(t, strings.HasPrefix(text.String(), "X 3048e29eaae3442d\n"), text.String())
+		assert.NoError(t, ToBinary(&text, &bin))
+		assert.Equal(t, patch, bin.Bytes())
+
+		err = MakePatchStream(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), WithXXH3())
+		assert.Equal(t, ErrNotStreamable, err)
+
+		a[0] = 't'
+		err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), new(bytes.Buffer))
+		assert.Equal(t, ErrChecksum, err)
+	})
+	t.Run("annotations", func(t *testing.T) {
+		a := []byte("The quick brown fox jumped over the lazy dog.")
+		b := []byte("The quick brown cat jumped over the dog!")
+
+		var patchr bytes.Buffer
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr,
+			WithAnnotation([]byte("generated by job 123")),
+			WithAnnotation(nil),
+		)
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(patchr.Bytes(), []byte("N\x14generated by job 123N\x00")))
+		assert.NoError(t, VerifyPatch(bytes.NewReader(patchr.Bytes())))
+
+		var c bytes.Buffer
+		err = ApplyPatch(bytes.NewReader(a), &patchr, &c)
+		assert.NoError(t, err)
+		assert.Equal(t, b, c.Bytes())
+	})
+}
+
+func TestIsNaive(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+	assert.False(t, IsNaive(patch.Bytes()))
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader([]byte("Something else entirely")), &patch, WithArmor(ArmorBase64)))
+	assert.True(t, IsNaive(patch.Bytes()))
+
+	assert.False(t, IsNaive([]byte("garbage")))
+}
+
+func TestMinSimilarity(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithMinSimilarity(0.8)))
+	assert.NotZero(t, patch.Len())
+
+	patch.Reset()
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithMinSimilarity(0.95))
+	assert.Equal(t, ErrTooDifferent, err)
+	assert.Zero(t, patch.Len())
+
+	err = MakePatch(bytes.NewReader(a), bytes.NewReader([]byte("Something else entirely")), &patch, WithMinSimilarity(0.01))
+	assert.Equal(t, ErrTooDifferent, err)
+
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(nil), &patch, WithMinSimilarity(1)))
+}
+
+func TestMakePatchBytes(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var want, patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &want))
+	assert.NoError(t, MakePatchBytes(a, b, &patch))
+	assert.Equal(t, want.Bytes(), patch.Bytes())
+
+	var out bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), &patch, &out))
+	assert.Equal(t, b, out.Bytes())
+
+	assert.Equal(t, ErrTooDifferent, MakePatchBytes(a, b, new(bytes.Buffer), WithMinSimilarity(0.95)))
+}
+
+func TestDecodeError(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+	p := patch.Bytes()
+
+	// The patch starts with a copy of "The quick brown ", then deletes "fox".
+	bad := append(append([]byte{}, p[:2]...), 'W', 3)
+	err := ApplyPatch(bytes.NewReader(a), bytes.NewReader(bad), new(bytes.Buffer))
+	assert.Equal(t, &DecodeError{Offset: 2, Source: 16, Op: 'W', Err: unexpectedOp('W')}, err)
+	assert.True(t, errors.Is(err, ErrUnknownOp))
+	assert.True(t, errors.Is(err, ErrMalformed))
+	assert.Equal(t, `malformed patch: unexpected operation byte: 57 in 'W' record at patch offset 2, before offset 16`, err.Error())
+
+	err = VerifyPatch(bytes.NewReader(bad))
+	assert.Equal(t, &DecodeError{Offset: 2, Op: 'W', Err: unexpectedOp('W')}, err)
+
+	bad = append(append([]byte{}, p[:2]...), OpInsert, 5, 'x')
+	err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(bad), new(bytes.Buffer))
+	assert.Equal(t, &DecodeError{Offset: 2, Source: 16, Op: OpInsert, Err: ErrTruncatedPatch}, err)
+
+	err = ApplyPatch(bytes.NewReader(a[:10]), bytes.NewReader(p), new(bytes.Buffer))
+	assert.Equal(t, &DecodeError{Op: OpCopy, Err: ErrSourceTooShort}, err)
+
+	// A patch cut off in its leading digest.
+	err = ApplyPatch(bytes.NewReader(a), bytes.NewReader([]byte{OpBLAKE3, 1, 2}), new(bytes.Buffer))
+	assert.Equal(t, &DecodeError{Op: OpBLAKE3, Err: ErrTruncatedPatch}, err)
+	assert.True(t, errors.Is(err, ErrTruncatedPatch))
+
+	// Checksum mismatches aren't decoding errors.
+	err = ApplyPatch(bytes.NewReader(bytes.Replace(a, []byte("The"), []byte("A  "), 1)), bytes.NewReader(p), new(bytes.Buffer))
+	assert.Equal(t, ErrCRC, err)
+}
+
+func TestDeterministic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	before := make([]byte, 64<<10)
+	rng.Read(before)
+	after := append([]byte{}, before...)
+	for i := 0; i < 200; i++ {
+		after[rng.Intn(len(after))] = byte(rng.Intn(256))
+	}
+
+	var want bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &want, WithTimeout(0)))
+
+	// A timeout too short to finish doesn't change the patch.
+	var patch bytes.Buffer
+	var stats Stats
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, WithTimeout(time.Nanosecond), WithDeterministic(), WithStats(&stats)))
+	assert.Equal(t, want.Bytes(), patch.Bytes())
+	assert.False(t, stats.TimedOut)
+
+	patch.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	assert.NoError(t, MakePatchContext(ctx, bytes.NewReader(before), bytes.NewReader(after), &patch, WithDeterministic()))
+	assert.Equal(t, want.Bytes(), patch.Bytes())
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, WithDeterministic(), WithProvenance()))
+	m, err := ReadMetadata(bytes.NewReader(patch.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "true", m["deterministic"])
+	assert.Equal(t, "0s", m["timeout"])
 }
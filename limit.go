@@ -0,0 +1,52 @@
+package lightpatch
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrOutputTooLarge is returned by ApplyPatch when the output would exceed a limit
+// set with WithMaxOutputSize or WithMaxExpansion.
+var ErrOutputTooLarge = errors.New("patch output exceeds the size limit")
+
+// WithMaxOutputSize limits the output of ApplyPatch to n bytes. ApplyPatch returns
+// ErrOutputTooLarge before writing more, so that an untrusted patch can't fill memory
+// or disk. Unlike WithExpectedAfterSize, a shorter output is fine. A limit of zero or
+// less, the default, removes it.
+func WithMaxOutputSize(n int64) Option {
+	return func(c *config) {
+		c.maxOutputSize = n
+	}
+}
+
+// WithMaxExpansion limits the output of ApplyPatch to r times its input, the bytes of
+// before and of the patch as given, before decoding. ApplyPatch returns
+// ErrOutputTooLarge as soon as the output outgrows the input read so far, which stops
+// a small patch that decompresses to a huge insert long before its end. Data of
+// chunks from a dedup store isn't input, so patches made WithDedup need a larger r. A
+// ratio of zero or less, the default, removes the limit.
+func WithMaxExpansion(r float64) Option {
+	return func(c *config) {
+		c.maxExpansion = r
+	}
+}
+
+// limitWriter fails writes that would take the output past the limits of
+// WithMaxOutputSize and WithMaxExpansion. input returns the bytes of input read.
+type limitWriter struct {
+	w     io.Writer
+	n     int64
+	max   int64
+	ratio float64
+	input func() int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	n := l.n + int64(len(p))
+	if l.max > 0 && n > l.max || l.ratio > 0 && float64(n) > l.ratio*float64(l.input()) {
+		return 0, ErrOutputTooLarge
+	}
+	m, err := l.w.Write(p)
+	l.n += int64(m)
+	return m, err
+}
@@ -0,0 +1,42 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxOutput(t *testing.T) {
+	// A small patch of a large insert, which gzip shrinks to a few kilobytes.
+	after := make([]byte, 10<<20)
+	var bomb bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(nil), bytes.NewReader(after), &bomb, WithGzip()))
+	assert.True(t, bomb.Len() < 100<<10, "patch too large: %d", bomb.Len())
+
+	var out bytes.Buffer
+	err := ApplyPatch(bytes.NewReader(nil), bytes.NewReader(bomb.Bytes()), &out, WithMaxOutputSize(1<<20))
+	assert.Equal(t, ErrOutputTooLarge, err)
+	assert.True(t, out.Len() <= 1<<20)
+
+	out.Reset()
+	err = ApplyPatch(bytes.NewReader(nil), bytes.NewReader(bomb.Bytes()), &out, WithMaxExpansion(100))
+	assert.Equal(t, ErrOutputTooLarge, err)
+	assert.True(t, out.Len() < 1<<20)
+
+	err = ApplyPatchAt(bytes.NewReader(nil), bomb.Bytes(), new(bytes.Buffer), WithMaxExpansion(100))
+	assert.Equal(t, ErrOutputTooLarge, err)
+
+	out.Reset()
+	assert.NoError(t, ApplyPatch(bytes.NewReader(nil), bytes.NewReader(bomb.Bytes()), &out, WithMaxOutputSize(int64(len(after)))))
+	assert.Equal(t, after, out.Bytes())
+
+	// An ordinary patch copies most of its output from before.
+	a := bytes.Repeat([]byte("The quick brown fox jumped over the lazy dog. "), 1000)
+	b := append(append([]byte{}, a...), "The end."...)
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch))
+	out.Reset()
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &out, WithMaxExpansion(1)))
+	assert.Equal(t, b, out.Bytes())
+}
@@ -0,0 +1,60 @@
+package lightpatch
+
+import "bytes"
+
+// A LineDiff is a run of whole lines that are unchanged, deleted or inserted between
+// two texts.
+type LineDiff struct {
+	Op byte // OpCopy, OpDelete or OpInsert
+
+	// 1-based numbers of the first line of the run in a and b. A run that isn't in
+	// one of the texts, i.e. a delete in b or an insert in a, is numbered by the line
+	// that follows it.
+	BeforeLine int
+	AfterLine  int
+
+	Lines int    // Number of lines in the run
+	Text  []byte // The lines, including their newlines
+}
+
+// DiffLines diffs a and b by lines, returning runs of lines that are copied, deleted
+// and inserted to change a into b. The deletes of a change come before its inserts.
+// It is meant for tools that only need to know which lines changed, such as blame
+// or per-line metrics, without making and decoding a patch. Lines end after '\n',
+// and a final line without one is compared as is.
+func DiffLines(a, b []byte) []LineDiff {
+	var lines []LineDiff
+	beforeLine, afterLine := 1, 1
+	for _, d := range diffTokens(a, b, splitLines, 0) {
+		n := countLines(d.Text)
+		lines = append(lines, LineDiff{
+			Op:         d.Type,
+			BeforeLine: beforeLine,
+			AfterLine:  afterLine,
+			Lines:      n,
+			Text:       d.Text,
+		})
+		if d.Type != OpInsert {
+			beforeLine += n
+		}
+		if d.Type != OpDelete {
+			afterLine += n
+		}
+	}
+	return lines
+}
+
+// splitLines is a tokenizer of lines ending after '\n'.
+func splitLines(text []byte) []int {
+	var ends []int
+	for i := 0; i < len(text); {
+		n := bytes.IndexByte(text[i:], '\n')
+		if n < 0 {
+			i = len(text)
+		} else {
+			i += n + 1
+		}
+		ends = append(ends, i)
+	}
+	return ends
+}
@@ -0,0 +1,32 @@
+package lightpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := []byte("one\ntwo\nthree\nfour\nfive")
+	b := []byte("one\n2\nthree\nthree and a half\nfour\nfive\n")
+
+	assert.Equal(t, []LineDiff{
+		{OpCopy, 1, 1, 1, []byte("one\n")},
+		{OpDelete, 2, 2, 1, []byte("two\n")},
+		{OpInsert, 3, 2, 1, []byte("2\n")},
+		{OpCopy, 3, 3, 1, []byte("three\n")},
+		{OpInsert, 4, 4, 1, []byte("three and a half\n")},
+		{OpCopy, 4, 5, 1, []byte("four\n")},
+		{OpDelete, 5, 6, 1, []byte("five")},
+		{OpInsert, 6, 6, 1, []byte("five\n")},
+	}, DiffLines(a, b))
+
+	assert.Equal(t, []LineDiff{
+		{OpInsert, 1, 1, 2, []byte("new\nlines\n")},
+	}, DiffLines(nil, []byte("new\nlines\n")))
+	assert.Equal(t, []LineDiff{
+		{OpCopy, 1, 1, 2, a[:8]},
+		{OpDelete, 3, 3, 3, a[8:]},
+	}, DiffLines(a, a[:8]))
+	assert.Empty(t, DiffLines(nil, nil))
+}
@@ -0,0 +1,97 @@
+package lightpatch
+
+import (
+	"errors"
+	"io"
+)
+
+var errBadRange = errors.New("range is empty or outside the output of the patch")
+
+// MapRange maps the range [start, end) of the output of patch back to the input.
+// If the range was copied from before unchanged, it returns the range it was
+// copied from. Otherwise changed is true: some of it was inserted, or a delete
+// falls inside it.
+func MapRange(patch io.Reader, start, end int) (beforeStart, beforeEnd int, changed bool, err error) {
+	ops, _, err := readPatchOps(patch)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	size := 0
+	for _, op := range ops {
+		if op.op != OpDelete {
+			size += op.n
+		}
+	}
+	if start < 0 || start >= end || end > size {
+		return 0, 0, false, errBadRange
+	}
+
+	// a and b are the offsets of the next op in before and after.
+	var a, b int
+	for _, op := range ops {
+		switch op.op {
+		case OpCopy:
+			if start >= b && start < b+op.n {
+				beforeStart = a + start - b
+			}
+			if end > b && end <= b+op.n {
+				beforeEnd = a + end - b
+				return beforeStart, beforeEnd, false, nil
+			}
+			a += op.n
+			b += op.n
+		case OpInsert, OpChunk:
+			if start < b+op.n && end > b {
+				return 0, 0, true, nil
+			}
+			b += op.n
+		case OpDelete:
+			if start < b && end > b {
+				return 0, 0, true, nil
+			}
+			a += op.n
+		}
+	}
+
+	return 0, 0, false, errBadRange
+}
+
+// A Segment is a run of the output of a patch that was either inserted by the
+// patch, or copied from the input starting at BeforeOffset.
+type Segment struct {
+	Offset       int
+	Length       int
+	Copied       bool
+	BeforeOffset int
+}
+
+// Segments returns the segments making up the output of patch, in order.
+// Consecutive inserts are merged into one segment.
+func Segments(patch io.Reader) ([]Segment, error) {
+	ops, _, err := readPatchOps(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []Segment
+	var a, b int
+	for _, op := range ops {
+		switch op.op {
+		case OpCopy:
+			segs = append(segs, Segment{Offset: b, Length: op.n, Copied: true, BeforeOffset: a})
+			a += op.n
+			b += op.n
+		case OpInsert, OpChunk:
+			if n := len(segs); n > 0 && !segs[n-1].Copied {
+				segs[n-1].Length += op.n
+			} else {
+				segs = append(segs, Segment{Offset: b, Length: op.n})
+			}
+			b += op.n
+		case OpDelete:
+			a += op.n
+		}
+	}
+
+	return segs, nil
+}
@@ -0,0 +1,63 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapRange(t *testing.T) {
+	before := "The quick brown fox jumped over the lazy dog"
+	after := "The quick brown fox leaped over the lazy dog."
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewBufferString(before), bytes.NewBufferString(after), &patch))
+
+	tests := []struct {
+		start, end int
+		expStart   int
+		changed    bool
+	}{
+		{0, 9, 0, false},    // "The quick"
+		{4, 19, 4, false},   // "quick brown fox"
+		{27, 31, 27, false}, // "over"
+		{20, 23, 0, true},   // "lea"
+		{16, 31, 0, true},   // "fox leaped over"
+		{36, 45, 0, true},   // "lazy dog."
+		{19, 20, 19, false}, // " " before the delete
+	}
+
+	for _, test := range tests {
+		s, e, changed, err := MapRange(bytes.NewReader(patch.Bytes()), test.start, test.end)
+		assert.NoError(t, err)
+		assert.Equal(t, test.changed, changed, after[test.start:test.end])
+		if !test.changed {
+			assert.Equal(t, after[test.start:test.end], before[s:e])
+			assert.Equal(t, test.expStart, s)
+		}
+	}
+
+	for _, r := range [][2]int{{5, 5}, {40, 46}, {-1, 3}} {
+		_, _, _, err := MapRange(bytes.NewReader(patch.Bytes()), r[0], r[1])
+		assert.Equal(t, errBadRange, err)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(
+		bytes.NewBufferString("The quick brown fox jumped over the lazy dog"),
+		bytes.NewBufferString("The quick brown fox leaped over the lazy dog."),
+		&patch,
+	))
+
+	segs, err := Segments(&patch)
+	assert.NoError(t, err)
+	assert.Equal(t, []Segment{
+		{Offset: 0, Length: 20, Copied: true, BeforeOffset: 0},
+		{Offset: 20, Length: 3},
+		{Offset: 23, Length: 21, Copied: true, BeforeOffset: 23},
+		{Offset: 44, Length: 1},
+	}, segs)
+}
@@ -0,0 +1,158 @@
+package lightpatch
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// splitMarkdown splits Markdown into tokens that edits must not split: fence lines,
+// lines of fenced code, inline code spans, link targets and autolinks. The rest of
+// the text is split into words, runs of spaces and single punctuation characters,
+// so that prose is still diffed finely.
+func splitMarkdown(text []byte) []int {
+	var ends []int
+	var fence []byte // The opening fence while inside a fenced block.
+
+	for start := 0; start < len(text); {
+		end := bytes.IndexByte(text[start:], '\n') + 1
+		if end == 0 {
+			end = len(text)
+		} else {
+			end += start
+		}
+		line := text[start:end]
+
+		if f := fenceMarker(line); f != nil && (fence == nil || (f[0] == fence[0] && len(f) >= len(fence) && isBlank(line[bytes.Index(line, f)+len(f):]))) {
+			if fence == nil {
+				fence = f
+			} else {
+				fence = nil
+			}
+			ends = append(ends, end)
+		} else if fence != nil {
+			ends = append(ends, end)
+		} else {
+			ends = splitMarkdownInline(ends, text[:end], start)
+		}
+
+		start = end
+	}
+
+	return ends
+}
+
+// splitMarkdownInline appends the ends of the tokens in text[start:], a line outside
+// of a fenced block, to ends.
+func splitMarkdownInline(ends []int, text []byte, start int) []int {
+	for i := start; i < len(text); {
+		j := i + markdownSpan(text[i:])
+		if j == i {
+			r, size := utf8.DecodeRune(text[i:])
+			j += size
+			switch {
+			case isWordRune(r):
+				for j < len(text) {
+					r, size := utf8.DecodeRune(text[j:])
+					if !isWordRune(r) {
+						break
+					}
+					j += size
+				}
+			case r == ' ' || r == '\t':
+				for j < len(text) && (text[j] == ' ' || text[j] == '\t') {
+					j++
+				}
+			}
+		}
+		ends = append(ends, j)
+		i = j
+	}
+	return ends
+}
+
+// markdownSpan returns the length of the inline code span, link target or autolink
+// at the start of s, or 0 if there is none.
+func markdownSpan(s []byte) int {
+	switch s[0] {
+	case '`':
+		n := 0
+		for n < len(s) && s[n] == '`' {
+			n++
+		}
+		// The span ends at the next run of exactly n backticks.
+		for i := n; i < len(s); {
+			if s[i] != '`' {
+				i++
+				continue
+			}
+			j := i
+			for j < len(s) && s[j] == '`' {
+				j++
+			}
+			if j-i == n {
+				return j
+			}
+			i = j
+		}
+		return n
+	case ']':
+		// A link or image target: "](url "title")", with balanced parentheses.
+		if len(s) < 2 || s[1] != '(' {
+			return 0
+		}
+		depth := 0
+		for i := 1; i < len(s) && s[i] != '\n'; i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+	case '<':
+		// An autolink, such as <https://example.com> or <user@example.com>.
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '>':
+				if i > 1 && bytes.ContainsAny(s[1:i], ":@") {
+					return i + 1
+				}
+				return 0
+			case c == '<' || c <= ' ':
+				return 0
+			}
+		}
+	}
+	return 0
+}
+
+// fenceMarker returns the run of backticks or tildes opening a code fence line, or
+// nil if line isn't a fence.
+func fenceMarker(line []byte) []byte {
+	trimmed := bytes.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) < 3 || (trimmed[0] != '`' && trimmed[0] != '~') {
+		return nil
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == trimmed[0] {
+		n++
+	}
+	if n < 3 {
+		return nil
+	}
+	return trimmed[:n]
+}
+
+func isBlank(b []byte) bool {
+	return len(bytes.TrimSpace(b)) == 0
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
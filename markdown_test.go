@@ -0,0 +1,64 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitMarkdown(t *testing.T) {
+	split := func(text string) []string {
+		var out []string
+		start := 0
+		for _, end := range splitMarkdown([]byte(text)) {
+			out = append(out, text[start:end])
+			start = end
+		}
+		return out
+	}
+
+	for _, test := range []struct {
+		text     string
+		expected []string
+	}{
+		{"", nil},
+		{"Hello,  world", []string{"Hello", ",", "  ", "world"}},
+		{"Run `go test ./...` now", []string{"Run", " ", "`go test ./...`", " ", "now"}},
+		{"``a ` b`` `x", []string{"``a ` b``", " ", "`", "x"}},
+		{"See [the docs](https://x.io/a_(b) \"T\").", []string{"See", " ", "[", "the", " ", "docs", "](https://x.io/a_(b) \"T\")", "."}},
+		{"Mail <me@x.io> or a < b", []string{"Mail", " ", "<me@x.io>", " ", "or", " ", "a", " ", "<", " ", "b"}},
+		{
+			// A longer fence closes the block, and an unclosed one runs to the end.
+			"Text\n```go\nfunc f() {}\n````\n~~~\n```\nin code\n",
+			[]string{"Text", "\n", "```go\n", "func f() {}\n", "````\n", "~~~\n", "```\n", "in code\n"},
+		},
+	} {
+		assert.Equal(t, test.expected, split(test.text), test.text)
+	}
+}
+
+func TestDiffMarkdown(t *testing.T) {
+	a := []byte("Install with `go get example.com/tool` and read [the guide](https://example.com/v1/guide).\n")
+	b := []byte("Install with `go install example.com/tool` and read [the guide](https://example.com/v2/guide).\n")
+
+	diffs := diffTokens(a, b, splitMarkdown, 0)
+	assert.Equal(t, asDiffs([]diffTest{
+		{OpCopy, "Install with "},
+		{OpDelete, "`go get example.com/tool`"},
+		{OpInsert, "`go install example.com/tool`"},
+		{OpCopy, " and read [the guide"},
+		{OpDelete, "](https://example.com/v1/guide)"},
+		{OpInsert, "](https://example.com/v2/guide)"},
+		{OpCopy, ".\n"},
+	}), diffs)
+
+	var patchr bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithGranularity(GranularityMarkdown))
+	assert.NoError(t, err)
+
+	var c bytes.Buffer
+	err = ApplyPatch(bytes.NewReader(a), &patchr, &c)
+	assert.NoError(t, err)
+	assert.Equal(t, b, c.Bytes())
+}
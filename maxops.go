@@ -0,0 +1,21 @@
+package lightpatch
+
+import "errors"
+
+// DefaultMaxOps is the number of records ApplyPatch processes before failing with
+// ErrTooManyOps, unless changed with WithMaxOps. Patches made by MakePatch from
+// inputs of a few hundred megabytes stay well within it.
+const DefaultMaxOps = 1 << 24
+
+// ErrTooManyOps is returned by ApplyPatch for a patch with more records than allowed
+// by WithMaxOps.
+var ErrTooManyOps = errors.New("patch has too many operations")
+
+// WithMaxOps limits the number of records ApplyPatch processes to n, so that a small
+// crafted patch of many tiny operations can't tie up a server applying it. A limit
+// of zero or less removes it. The default is DefaultMaxOps.
+func WithMaxOps(n int) Option {
+	return func(c *config) {
+		c.maxOps = n
+	}
+}
@@ -0,0 +1,36 @@
+package lightpatch
+
+import "math/bits"
+
+const intSize = bits.UintSize / 8
+
+// WithMaxMemory limits the memory MakePatch may allocate to diff its inputs to about
+// n bytes, not counting the inputs themselves. If the diff is projected to need
+// more, the inputs aren't diffed, and the patch is a single insert of after, with
+// WarningMemory. The projection is of the worst case, in which bisect runs over all
+// of the inputs but their common prefix and suffix, so inputs that would have fit
+// may fall back too. A limit of zero or less, the default, removes it.
+func WithMaxMemory(n int) Option {
+	return func(c *config) {
+		c.maxMemory = n
+	}
+}
+
+// diffMemory returns the number of bytes c's diff of before and after is projected to
+// allocate at most.
+func (c config) diffMemory(before, after []byte) int {
+	n1, n2 := len(before), len(after)
+	prefix := commonPrefixLength(before, after)
+	suffix := commonSuffixLength(before[prefix:], after[prefix:])
+
+	// diffBisect holds two vectors of ints, spanning every diagonal of the texts left
+	// once the common prefix and suffix are trimmed. They are released before it
+	// recurses, so only the largest counts.
+	m := 2 * (n1 + n2 - 2*(prefix+suffix) + 2) * intSize
+
+	if c.tokenizer == nil && c.granularity == GranularityByte && c.algorithm == AlgorithmSuffixArray {
+		// The suffix array of before, and the two arrays it is sorted with.
+		m += 3 * n1 * intSize
+	}
+	return m
+}
@@ -0,0 +1,39 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxMemory(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	// Only the differing middles count.
+	need := newConfig(nil).diffMemory(a, b)
+	assert.Equal(t, 2*(len("fox jumped over the lazy dog.")+len("cat jumped over the dog!")+2)*intSize, need)
+	assert.True(t, newConfig([]Option{WithAlgorithm(AlgorithmSuffixArray)}).diffMemory(a, b) > need)
+
+	var want, patch bytes.Buffer
+	var stats Stats
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &want))
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithMaxMemory(need), WithStats(&stats)))
+	assert.Equal(t, want.Bytes(), patch.Bytes())
+	assert.Empty(t, stats.Warnings)
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithMaxMemory(need-1), WithStats(&stats)))
+	assert.True(t, stats.Naive)
+	assert.Equal(t, []Warning{WarningNaive, WarningMemory}, stats.Warnings)
+
+	var out bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), &patch, &out))
+	assert.Equal(t, b, out.Bytes())
+
+	// Identical inputs need almost nothing.
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(a), &patch, WithMaxMemory(64), WithStats(&stats)))
+	assert.False(t, stats.Naive)
+}
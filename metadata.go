@@ -0,0 +1,171 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const modulePath = "github.com/kalafut/lightpatch"
+
+var errBadMetadata = fmt.Errorf("%w: bad metadata record", ErrMalformed)
+
+// Metadata holds the key/value pairs of a patch's metadata records. See
+// WithProvenance and ReadMetadata.
+type Metadata map[string]string
+
+// WithProvenance causes MakePatch to record how the patch was produced in a metadata
+// record at the start of the patch: the lightpatch version, the effective options,
+// and whether the timeout was reached or the diff was replaced by a naive insert.
+func WithProvenance() Option {
+	return func(c *config) {
+		c.provenance = true
+	}
+}
+
+// provenance returns the metadata recorded by WithProvenance.
+func provenance(cfg config, timeout time.Duration, timedOut, naive bool) Metadata {
+	m := Metadata{
+		"lightpatch":       version(),
+		"algorithm":        cfg.algorithm.String(),
+		"granularity":      cfg.granularity.String(),
+		"checksum":         cfg.checksum.String(),
+		"insert-checksums": strconv.FormatBool(cfg.insertChecksums),
+		"timeout":          timeout.String(),
+		"timed-out":        strconv.FormatBool(timedOut),
+		"naive":            strconv.FormatBool(naive),
+	}
+	if cfg.tokenizer != nil {
+		m["granularity"] = "tokenizer"
+	}
+	if cfg.dedup != nil {
+		m["dedup-min-size"] = strconv.Itoa(cfg.dedup.minSize)
+	}
+	if cfg.deterministic {
+		m["deterministic"] = "true"
+	}
+	return m
+}
+
+// version returns the version of this module from the build info, or "(devel)" if
+// it isn't known.
+func version() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Path == modulePath {
+			return bi.Main.Version
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				return dep.Version
+			}
+		}
+	}
+	return "(devel)"
+}
+
+// record encodes m as a complete metadata record. Keys are sorted so that the
+// encoding is deterministic.
+func (m Metadata) record() []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var body []byte
+	for _, k := range keys {
+		body = appendString(body, k)
+		body = appendString(body, m[k])
+	}
+
+	rec := append([]byte{OpMetadata}, make([]byte, binary.MaxVarintLen64)...)
+	n := binary.PutUvarint(rec[1:], uint64(len(body)))
+	return append(rec[:1+n], body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUvarint(b, uint64(len(s))), s...)
+}
+
+// decodeMetadata adds the pairs encoded in body, the data of a metadata record, to m.
+func decodeMetadata(m Metadata, body []byte) error {
+	fields, err := metadataFields(body)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(fields); i += 2 {
+		m[fields[i]] = fields[i+1]
+	}
+	return nil
+}
+
+// metadataFields returns the keys and values encoded in the data of a metadata
+// record, in order.
+func metadataFields(body []byte) ([]string, error) {
+	var fields []string
+	for len(body) > 0 {
+		l, n := binary.Uvarint(body)
+		if n <= 0 || l > uint64(len(body)-n) {
+			return nil, errBadMetadata
+		}
+		fields = append(fields, string(body[n:n+int(l)]))
+		body = body[n+int(l):]
+	}
+	if len(fields)%2 != 0 {
+		return nil, errBadMetadata
+	}
+	return fields, nil
+}
+
+// ReadMetadata reads the metadata records at the start of patch. It returns an empty
+// Metadata if there are none. Only the leading records are read, so patch can be a
+// prefix of the full patch.
+func ReadMetadata(patch io.Reader) (Metadata, error) {
+	m := Metadata{}
+	patch, err := openPatch(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	patchBR := bufio.NewReader(patch)
+
+	if op, err := patchBR.Peek(1); err == nil && digestSize(op[0]) > 0 {
+		if _, err := patchBR.Discard(1 + digestSize(op[0])); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		op, err := patchBR.Peek(1)
+		if err == io.EOF || (err == nil && op[0] != OpMetadata) {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+		patchBR.Discard(1)
+
+		l, err := readLength(patchBR)
+		if err != nil {
+			return nil, err
+		}
+		if err := readMetadata(m, patchBR, l); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readMetadata reads the l data bytes of a metadata record into m.
+func readMetadata(m Metadata, r io.Reader, l uint64) error {
+	var body bytes.Buffer
+	if _, err := io.CopyN(&body, r, int64(l)); err != nil {
+		return err
+	}
+
+	return decodeMetadata(m, body.Bytes())
+}
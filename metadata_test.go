@@ -0,0 +1,62 @@
+package lightpatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvenance(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var patchr bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr,
+		WithProvenance(),
+		WithAlgorithm(AlgorithmSuffixArray),
+		WithChecksum(ChecksumBLAKE3),
+	)
+	assert.NoError(t, err)
+	patch := patchr.Bytes()
+
+	m, err := ReadMetadata(bytes.NewReader(patch))
+	assert.NoError(t, err)
+	assert.Equal(t, Metadata{
+		"lightpatch":       "(devel)",
+		"algorithm":        "suffixarray",
+		"granularity":      "byte",
+		"checksum":         "blake3",
+		"insert-checksums": "false",
+		"timeout":          "5s",
+		"timed-out":        "false",
+		"naive":            "false",
+	}, m)
+
+	var c bytes.Buffer
+	err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), &c)
+	assert.NoError(t, err)
+	assert.Equal(t, b, c.Bytes())
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patch)))
+
+	// Patches without metadata
+	patchr.Reset()
+	err = MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr)
+	assert.NoError(t, err)
+	m, err = ReadMetadata(&patchr)
+	assert.NoError(t, err)
+	assert.Empty(t, m)
+}
+
+func TestMetadataRecord(t *testing.T) {
+	m := Metadata{"a": "1", "empty": "", "": "no key"}
+	rec := m.record()
+	assert.Equal(t, OpMetadata, rec[0])
+
+	got, err := ReadMetadata(bytes.NewReader(rec))
+	assert.NoError(t, err)
+	assert.Equal(t, m, got)
+
+	// A truncated pair
+	assert.Equal(t, errBadMetadata, decodeMetadata(Metadata{}, []byte{1, 'a', 5, 'b'}))
+}
@@ -0,0 +1,269 @@
+package lightpatch
+
+import (
+	"context"
+	"crypto/ed25519"
+	"strconv"
+	"time"
+)
+
+// An Option configures optional behavior of MakePatch, or of ApplyPatch for the
+// options that say so.
+type Option func(*config)
+
+type config struct {
+	stats     *Stats
+	algorithm Algorithm
+	checksum  Checksum
+
+	insertChecksums bool
+	beforeChecksum  bool
+	provenance      bool
+	annotations     [][]byte
+	armor           Armor
+	fec             *fecParams
+	granularity     Granularity
+	dedup           *dedupParams
+	expectedSize    *int64
+	minSimilarity   float64
+	costModel       CostModel
+	blockSize       int
+	replace         bool
+	copyToEnd       bool
+	notAfter        time.Time
+	strict          bool
+	maxOps          int
+	maxMemory       int
+	maxOutputSize   int64
+	maxExpansion    float64
+	timeout         *time.Duration
+	deterministic   bool
+	ctx             context.Context
+	editContext     int
+	checkpoints     int
+	signingKey      ed25519.PrivateKey
+	publicKey       ed25519.PublicKey
+	gzip            bool
+	dictionary      []byte
+	tokenizer       Tokenizer
+}
+
+// Algorithm selects how MakePatch searches for matches between before and after.
+type Algorithm int
+
+const (
+	// AlgorithmMyers is the default byte-level diff. It produces compact patches
+	// for text and lightly edited inputs.
+	AlgorithmMyers Algorithm = iota
+
+	// AlgorithmSuffixArray anchors the diff on long matches found with a suffix
+	// array of before, similar to bsdiff. It copes much better than AlgorithmMyers
+	// with large binaries containing many scattered changes, at the cost of
+	// indexing before.
+	AlgorithmSuffixArray
+
+	// AlgorithmRollingHash anchors the diff on blocks of before located in after
+	// with a Rabin-Karp rolling hash. It runs in linear time, which makes it much
+	// faster than AlgorithmMyers when inputs are large but mostly similar.
+	AlgorithmRollingHash
+
+	// AlgorithmCDC splits the inputs into chunks at boundaries chosen by their
+	// content, with FastCDC, and diffs only the chunks of after not found in before.
+	// The boundaries realign right after an edit, so it is the fastest choice for
+	// inputs of many gigabytes with few changes, though edits are found to within a
+	// chunk of a few kilobytes rather than a block.
+	AlgorithmCDC
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmMyers:
+		return "myers"
+	case AlgorithmSuffixArray:
+		return "suffixarray"
+	case AlgorithmRollingHash:
+		return "rollinghash"
+	case AlgorithmCDC:
+		return "cdc"
+	}
+	return "Algorithm(" + strconv.Itoa(int(a)) + ")"
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{maxOps: DefaultMaxOps}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithStats causes MakePatch to record information about the generated patch in s.
+func WithStats(s *Stats) Option {
+	return func(c *config) {
+		c.stats = s
+	}
+}
+
+// WithTimeout bounds the time MakePatch spends searching for a compact diff to d.
+// Once it is reached, the rest of the inputs are diffed coarsely, and the patch still
+// succeeds, perhaps larger than it could be. A d of 0 removes the limit. It overrides
+// the timeout argument of MakePatchTimeout. The default is DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = &d
+	}
+}
+
+// WithDeterministic causes MakePatch to diff without a time limit, ignoring
+// WithTimeout, the timeout argument of MakePatchTimeout and the deadline of
+// MakePatchContext, and to skip the shortcuts it takes to meet one. The same inputs
+// and options then give a byte-identical patch on every run and machine, as
+// reproducible builds need, where a timeout makes the patch depend on how fast the
+// machine is. Diffing large, very different inputs can take much longer.
+func WithDeterministic() Option {
+	return func(c *config) {
+		c.deterministic = true
+	}
+}
+
+// diffTimeout returns the timeout set with WithTimeout, or def, or 0 for no limit
+// with WithDeterministic.
+func (c config) diffTimeout(def time.Duration) time.Duration {
+	if c.deterministic {
+		return 0
+	}
+	if c.timeout != nil {
+		return *c.timeout
+	}
+	return def
+}
+
+// WithAlgorithm selects the matching algorithm used by MakePatch.
+func WithAlgorithm(a Algorithm) Option {
+	return func(c *config) {
+		c.algorithm = a
+	}
+}
+
+// Checksum selects the integrity check MakePatch embeds in the patch.
+type Checksum int
+
+const (
+	// ChecksumCRC32 appends the CRC-32 of the output as the final record. It is
+	// the default.
+	ChecksumCRC32 Checksum = iota
+
+	// ChecksumBLAKE3 writes a BLAKE3-256 digest of the output as the first record.
+	// It is faster than SHA-256 on large outputs and strong enough to identify
+	// content, e.g. in a dedup store.
+	ChecksumBLAKE3
+
+	// ChecksumXXH3 writes the 64-bit XXH3 hash of the output as the first record.
+	// It is several times faster to compute than the CRC-32 on large outputs, but
+	// isn't cryptographic.
+	ChecksumXXH3
+)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumBLAKE3:
+		return "blake3"
+	case ChecksumXXH3:
+		return "xxh3"
+	}
+	return "Checksum(" + strconv.Itoa(int(c)) + ")"
+}
+
+// WithChecksum selects the integrity check embedded by MakePatch.
+func WithChecksum(c Checksum) Option {
+	return func(cfg *config) {
+		cfg.checksum = c
+	}
+}
+
+// WithXXH3 selects the XXH3 checksum. It is the same as WithChecksum(ChecksumXXH3).
+func WithXXH3() Option {
+	return WithChecksum(ChecksumXXH3)
+}
+
+// WithInsertChecksums causes MakePatch to follow the data of every insert with its
+// CRC-32, so that a damaged patch can be detected by VerifyPatch, and the damaged
+// range fetched again, before it is applied.
+func WithInsertChecksums() Option {
+	return func(c *config) {
+		c.insertChecksums = true
+	}
+}
+
+// WithAnnotation adds note to the start of the patch as an annotation, which
+// ApplyPatch skips. It may be given more than once.
+func WithAnnotation(note []byte) Option {
+	return func(c *config) {
+		c.annotations = append(c.annotations, note)
+	}
+}
+
+// WithMinSimilarity causes MakePatch to fail with ErrTooDifferent, writing nothing,
+// if less than a fraction r of after would be copied from before, instead of
+// writing a patch that carries most of after. This suits callers that would rather
+// send the whole file through another channel, e.g. compressed.
+func WithMinSimilarity(r float64) Option {
+	return func(c *config) {
+		c.minSimilarity = r
+	}
+}
+
+// adjustGranularity changes the granularity where it doesn't apply to before and
+// after: a tokenizer takes its place, and binary data is diffed by bytes, as text
+// tokenizers give poor diffs of it. It reports whether binary data was found.
+func (c *config) adjustGranularity(before, after []byte) bool {
+	if c.tokenizer != nil {
+		c.granularity = GranularityByte
+	}
+
+	binaryInput := (c.granularity == GranularitySentence || c.granularity == GranularityMarkdown) &&
+		(isBinary(before) || isBinary(after))
+	if binaryInput {
+		c.granularity = GranularityByte
+	}
+	return binaryInput
+}
+
+// diff diffs before and after with the tokenizer, granularity and algorithm of c.
+func (c config) diff(before, after []byte, timeout time.Duration) []diff {
+	switch {
+	case c.tokenizer != nil:
+		return diffTokens(before, after, tokenizerOf(c.tokenizer), timeout)
+	case c.granularity == GranularitySentence:
+		return diffTokens(before, after, splitSentences, timeout)
+	case c.granularity == GranularityMarkdown:
+		return diffTokens(before, after, splitMarkdown, timeout)
+	case c.granularity == GranularityXML:
+		return diffTokens(before, after, splitXML, timeout)
+	case c.algorithm == AlgorithmSuffixArray:
+		return diffSuffixArray(before, after, timeout)
+	case c.algorithm == AlgorithmRollingHash:
+		return diffRollingHash(before, after, timeout)
+	case c.algorithm == AlgorithmCDC:
+		return diffCDC(before, after, timeout)
+	}
+	return diffMain(before, after, timeout)
+}
+
+// similarity returns the fraction of an output of size bytes copied by diffs. An
+// empty output is fully similar.
+func similarity(diffs []diff, size int) float64 {
+	if size == 0 {
+		return 1
+	}
+
+	var copied int
+	for _, d := range diffs {
+		if d.Type == OpCopy {
+			copied += len(d.Text)
+		}
+	}
+	return float64(copied) / float64(size)
+}
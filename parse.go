@@ -0,0 +1,43 @@
+package lightpatch
+
+import (
+	"bytes"
+	"io"
+)
+
+// An Op is an operation of a patch, as read by ParsePatch.
+type Op struct {
+	Type byte   // OpCopy, OpDelete, OpInsert, OpChunk or OpCopyToEnd
+	Len  int    // Number of bytes copied, deleted or inserted, or -1 for OpCopyToEnd
+	Data []byte // The data of an insert, or the BLAKE3 digest of the data of a chunk
+}
+
+// ParsePatch decodes patch into its operations, in order, for tools such as viewers
+// and converters that need them without before. A replace is returned as a delete
+// followed by an insert, a checked insert as an insert, and records that don't
+// change the output, such as metadata, are skipped. Chunks, written with WithDedup,
+// are returned as they are, since their data is stored elsewhere. The operations
+// aren't checked against before, which is needed to verify the patch's checksum.
+//
+// The final copy of a patch written WithCopyToEnd has no length without before, so
+// it is returned as an OpCopyToEnd of length -1; Edits decodes it along with before.
+func ParsePatch(patch []byte) ([]Op, error) {
+	r, err := newOpReader(bytes.NewReader(patch))
+	if err != nil {
+		return nil, err
+	}
+	r.toEnd = true
+
+	var ops []Op
+	for {
+		patchOps, err := r.next()
+		if err == io.EOF {
+			return ops, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, op := range patchOps {
+			ops = append(ops, Op{Type: op.op, Len: op.n, Data: op.data})
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build go1.23
+
+package lightpatch
+
+import (
+	"bytes"
+	"io"
+	"iter"
+)
+
+// Ops returns an iterator over the operations of patch, as returned by ParsePatch,
+// which decodes each record as the iteration reaches it. Only the data of the current
+// operation is held, so large patches can be inspected without decoding all of their
+// inserts at once. If the patch is damaged, the operations before the damage are
+// yielded, followed by the error.
+func Ops(patch []byte) iter.Seq2[Op, error] {
+	return func(yield func(Op, error) bool) {
+		r, err := newOpReader(bytes.NewReader(patch))
+		if err != nil {
+			yield(Op{}, err)
+			return
+		}
+		r.toEnd = true
+
+		for {
+			ops, err := r.next()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				yield(Op{}, err)
+				return
+			}
+			for _, op := range ops {
+				if !yield(Op{Type: op.op, Len: op.n, Data: op.data}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build go1.23
+
+package lightpatch
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOps(t *testing.T) {
+	for _, name := range []string{"simple", "unicode", "angular"} {
+		p, _ := ioutil.ReadFile("testdata/" + name + ".patch")
+		want, err := ParsePatch(p)
+		assert.NoError(t, err)
+
+		var got []Op
+		for op, err := range Ops(p) {
+			assert.NoError(t, err)
+			got = append(got, op)
+		}
+		assert.Equal(t, want, got, name)
+	}
+
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithReplace()))
+
+	// Stopping early.
+	var n int
+	for op := range Ops(patch.Bytes()) {
+		assert.Equal(t, OpCopy, op.Type)
+		n++
+		break
+	}
+	assert.Equal(t, 1, n)
+
+	// A truncated patch yields its first operations, then an error.
+	var errs []error
+	n = 0
+	for _, err := range Ops(patch.Bytes()[:patch.Len()-5]) {
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			n++
+		}
+	}
+	assert.Equal(t, []error{io.ErrUnexpectedEOF}, errs)
+	assert.Equal(t, 8, n)
+}
@@ -0,0 +1,79 @@
+package lightpatch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePatch(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithReplace(), WithInsertChecksums(), WithProvenance()))
+	ops, err := ParsePatch(patch.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, []Op{
+		{OpCopy, 16, nil},
+		{OpDelete, 3, nil},
+		{OpInsert, 3, []byte("cat")},
+		{OpCopy, 17, nil},
+		{OpDelete, 5, nil},
+		{OpCopy, 3, nil},
+		{OpDelete, 1, nil},
+		{OpInsert, 1, []byte("!")},
+	}, ops)
+
+	// The golden patches parse, and their operations account for both files.
+	for _, name := range []string{"simple", "unicode", "angular"} {
+		p, _ := ioutil.ReadFile("testdata/" + name + ".patch")
+		before, _ := ioutil.ReadFile("testdata/" + name + "_in")
+		after, _ := ioutil.ReadFile("testdata/" + name + "_out")
+		ops, err := ParsePatch(p)
+		assert.NoError(t, err)
+		var nb, na int
+		for _, op := range ops {
+			if op.Type != OpInsert {
+				nb += op.Len
+			}
+			if op.Type != OpDelete {
+				na += op.Len
+			}
+		}
+		assert.Equal(t, len(before), nb, name)
+		assert.Equal(t, len(after), na, name)
+	}
+
+	// A copy to the end is returned without a length.
+	patch.Reset()
+	grown := append(b[:20:20], a[20:]...)
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(grown), &patch, WithCopyToEnd()))
+	ops, err = ParsePatch(patch.Bytes())
+	assert.NoError(t, err)
+	last := ops[len(ops)-1]
+	assert.Equal(t, Op{Type: OpCopyToEnd, Len: -1}, last)
+
+	// The ops it returns rebuild the patch.
+	var rebuilt []byte
+	for _, op := range ops {
+		rebuilt = append(rebuilt, op.Type)
+		switch op.Type {
+		case OpCopyToEnd:
+		case OpInsert:
+			rebuilt = appendUvarint(rebuilt, uint64(op.Len))
+			rebuilt = append(rebuilt, op.Data...)
+		default:
+			rebuilt = appendUvarint(rebuilt, uint64(op.Len))
+		}
+	}
+	rebuilt = append(rebuilt, patch.Bytes()[len(patch.Bytes())-5:]...)
+	var out bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(rebuilt), &out))
+	assert.Equal(t, grown, out.Bytes())
+
+	_, err = ParsePatch([]byte("C"))
+	assert.Error(t, err)
+}
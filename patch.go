@@ -0,0 +1,94 @@
+package lightpatch
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// Patch is an encoded patch, as written by MakePatch. It implements the encoding
+// interfaces so that patches can be embedded in values serialized with encoding/json,
+// encoding/gob and the like. The binary form is the patch itself, and the text form
+// is the text format produced by ToText. ToText removes envelopes such as gzip and
+// signatures, so a patch in one is marshalled as text in base64 armor instead, which
+// keeps its bytes.
+type Patch []byte
+
+// MarshalBinary returns a copy of p.
+func (p Patch) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), p...), nil
+}
+
+// UnmarshalBinary sets p to a copy of data.
+func (p *Patch) UnmarshalBinary(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+// MarshalText converts p to the text format, or to base64 armor if p is in an
+// envelope or armored already.
+func (p Patch) MarshalText() ([]byte, error) {
+	var text bytes.Buffer
+	if !p.enveloped() {
+		if err := ToText(bytes.NewReader(p), &text); err != nil {
+			return nil, err
+		}
+		return text.Bytes(), nil
+	}
+
+	aw, err := newArmorWriter(&text, ArmorBase64)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := aw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return text.Bytes(), nil
+}
+
+// UnmarshalText sets p to the binary form of a patch in the text format, or to the
+// patch in armored text as it is, without removing its envelopes.
+func (p *Patch) UnmarshalText(text []byte) error {
+	if bytes.HasPrefix(bytes.TrimLeft(text, " \t\r\n"), []byte("-----")) {
+		r, err := dearmor(bytes.NewReader(text))
+		if err != nil {
+			return err
+		}
+		bin, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		*p = bin
+		return nil
+	}
+
+	var bin bytes.Buffer
+	if err := ToBinary(bytes.NewReader(text), &bin); err != nil {
+		return err
+	}
+	*p = bin.Bytes()
+	return nil
+}
+
+// enveloped reports whether ToText would change p before converting it: whether p
+// is armored or starts with a decoder's envelope, such as gzip or a signature.
+func (p Patch) enveloped() bool {
+	return len(p) > 0 && (isSpace(p[0]) || p[0] == '-' || decoder(p[0]) != nil)
+}
+
+// WriteTo writes p to w.
+func (p Patch) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(p)
+	return int64(n), err
+}
+
+// ReadFrom sets p to the data read from r until EOF, reusing the capacity of p.
+func (p *Patch) ReadFrom(r io.Reader) (int64, error) {
+	buf := bytes.NewBuffer((*p)[:0])
+	n, err := buf.ReadFrom(r)
+	*p = buf.Bytes()
+	return n, err
+}
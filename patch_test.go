@@ -0,0 +1,107 @@
+package lightpatch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = Patch(nil)
+	_ encoding.BinaryUnmarshaler = (*Patch)(nil)
+	_ encoding.TextMarshaler     = Patch(nil)
+	_ encoding.TextUnmarshaler   = (*Patch)(nil)
+	_ io.WriterTo                = Patch(nil)
+	_ io.ReaderFrom              = (*Patch)(nil)
+)
+
+func TestPatchEncoding(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog")
+	b := []byte("The quick brown fox leaped over the lazy dog.")
+
+	var patchr bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr)
+	assert.NoError(t, err)
+
+	type doc struct {
+		Name  string
+		Patch Patch
+	}
+	in := doc{Name: "fox", Patch: patchr.Bytes()}
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(in)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"C 20\nD 3\nI \"lea\"\n`)
+
+		var out doc
+		assert.NoError(t, json.Unmarshal(data, &out))
+		assert.Equal(t, in, out)
+
+		assert.Error(t, json.Unmarshal([]byte(`{"Patch":"X 1"}`), &out))
+	})
+
+	t.Run("envelopes", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+
+		for _, opts := range [][]Option{
+			{WithGzip()},
+			{WithSigningKey(priv)},
+			{WithArmor(ArmorASCII85)},
+		} {
+			var patch bytes.Buffer
+			assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, opts...))
+			in := doc{Name: "fox", Patch: patch.Bytes()}
+
+			data, err := json.Marshal(in)
+			assert.NoError(t, err)
+			assert.Contains(t, string(data), "-----BEGIN LIGHTPATCH")
+
+			var out doc
+			assert.NoError(t, json.Unmarshal(data, &out))
+			assert.Equal(t, in, out)
+		}
+
+		var patch, after bytes.Buffer
+		assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithSigningKey(priv)))
+		data, err := json.Marshal(Patch(patch.Bytes()))
+		assert.NoError(t, err)
+		var out Patch
+		assert.NoError(t, json.Unmarshal(data, &out))
+		assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(out), &after, WithPublicKey(pub)))
+		assert.Equal(t, b, after.Bytes())
+	})
+
+	t.Run("gob", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, gob.NewEncoder(&buf).Encode(in))
+
+		var out doc
+		assert.NoError(t, gob.NewDecoder(&buf).Decode(&out))
+		assert.Equal(t, in, out)
+	})
+}
+
+func TestPatchIO(t *testing.T) {
+	data := []byte("C\x14D\x03I\x03leaC\x15I\x01.K\x96\xf6\xb7\x6c")
+
+	p := make(Patch, 0, 1024)
+	n, err := p.ReadFrom(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, Patch(data), p)
+	assert.Equal(t, 1024, cap(p))
+
+	var out bytes.Buffer
+	n, err = p.WriteTo(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, out.Bytes())
+}
@@ -0,0 +1,36 @@
+package lightpatch
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Services that generate patches continuously would otherwise allocate a fresh set of
+// large, short-lived buffers for every patch. The pools below let them be reused
+// across calls. Nothing taken from a pool may be referenced after it is returned.
+
+var (
+	intsPool   sync.Pool
+	bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// getInts returns an int slice of length n with unspecified contents.
+func getInts(n int) []int {
+	if p, ok := intsPool.Get().(*[]int); ok && cap(*p) >= n {
+		return (*p)[:n]
+	}
+	return make([]int, n)
+}
+
+func putInts(s []int) {
+	intsPool.Put(&s)
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	b.Reset()
+	bufferPool.Put(b)
+}
@@ -0,0 +1,32 @@
+package lightpatch
+
+// WithReplace causes MakePatch to write adjacent deletes and inserts as a single
+// replace.
+func WithReplace() Option {
+	return func(c *config) {
+		c.replace = true
+	}
+}
+
+// replacePair reports whether diffs[i] and diffs[i+1] are a delete and an insert, in
+// either order, to be written as a replace, and returns the deleted length and the
+// inserted bytes. Checked and chunked inserts aren't fused.
+func replacePair(diffs []diff, i int, cfg config) (deleted int, inserted []byte, ok bool) {
+	if !cfg.replace || cfg.insertChecksums || i+1 >= len(diffs) {
+		return 0, nil, false
+	}
+
+	d, ins := diffs[i], diffs[i+1]
+	if d.Type == OpInsert {
+		d, ins = ins, d
+	}
+	if d.Type != OpDelete || ins.Type != OpInsert || cfg.dedup.stores(ins.Text) {
+		return 0, nil, false
+	}
+	return len(d.Text), ins.Text, true
+}
+
+// replaceRecord returns the record of a replace, up to the inserted data.
+func replaceRecord(deleted int, inserted []byte) []byte {
+	return appendUvarint(appendUvarint([]byte{OpReplace}, uint64(deleted)), uint64(len(inserted)))
+}
@@ -0,0 +1,50 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplace(t *testing.T) {
+	a := []byte(strings.Repeat("The quick brown fox jumped over the lazy dog. ", 20))
+	b := []byte(strings.Repeat("The quick brown cat jumped over the dog! ", 20))
+
+	var plain, fused bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &plain))
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &fused, WithReplace()))
+	assert.Less(t, fused.Len(), plain.Len())
+
+	var after bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(fused.Bytes()), &after))
+	assert.Equal(t, b, after.Bytes())
+	assert.NoError(t, VerifyPatch(bytes.NewReader(fused.Bytes())))
+
+	plainEdits, err := Edits(a, plain.Bytes())
+	assert.NoError(t, err)
+	fusedEdits, err := Edits(a, fused.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, len(plainEdits), len(fusedEdits))
+
+	var text, bin bytes.Buffer
+	assert.NoError(t, ToText(bytes.NewReader(fused.Bytes()), &text))
+	assert.Contains(t, text.String(), "\nR 3 \"cat\"\n")
+	assert.NoError(t, ToBinary(&text, &bin))
+	assert.Equal(t, fused.Bytes(), bin.Bytes())
+
+	// Checked inserts aren't fused.
+	var checked bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &checked, WithReplace(), WithInsertChecksums()))
+	assert.NotContains(t, checked.String(), "R\x03")
+
+	// Replaces compose like a delete and an insert.
+	var second, composed bytes.Buffer
+	c := []byte(strings.Repeat("The quick brown cow jumped over the dog! ", 20))
+	assert.NoError(t, MakePatch(bytes.NewReader(b), bytes.NewReader(c), &second, WithReplace()))
+	assert.NoError(t, ComposePatches(bytes.NewReader(fused.Bytes()), &second, &composed))
+	after.Reset()
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), &composed, &after))
+	assert.Equal(t, c, after.Bytes())
+}
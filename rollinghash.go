@@ -0,0 +1,157 @@
+package lightpatch
+
+import (
+	"bytes"
+	"time"
+)
+
+const (
+	// rhBlockSize is the block length indexed by the rolling hash matcher.
+	rhBlockSize = 32
+
+	// rhMaxCandidates limits how many offsets are kept for a repeated block.
+	rhMaxCandidates = 4
+
+	rhBase = 1099511628211 // The 64-bit FNV prime, used as the polynomial base
+
+	// hashedMinSize is the combined input size above which diffHashed is tried.
+	hashedMinSize = 1 << 20
+)
+
+// blockIndex maps the hashes of fixed-size, non-overlapping blocks of a text to
+// their offsets.
+type blockIndex struct {
+	text   []byte
+	blocks map[uint64][]int
+}
+
+func newBlockIndex(text []byte) *blockIndex {
+	idx := &blockIndex{
+		text:   text,
+		blocks: make(map[uint64][]int, len(text)/rhBlockSize),
+	}
+
+	for i := 0; i+rhBlockSize <= len(text); i += rhBlockSize {
+		h := rhHash(text[i : i+rhBlockSize])
+		if len(idx.blocks[h]) < rhMaxCandidates {
+			idx.blocks[h] = append(idx.blocks[h], i)
+		}
+	}
+
+	return idx
+}
+
+// matches slides a Rabin-Karp hash over text2, looking up every window in the index
+// and extending verified hits in both directions. It runs in time linear in the
+// length of text2 plus the length of the matches.
+func (idx *blockIndex) matches(text2 []byte, deadline time.Time) []anchor {
+	text1 := idx.text
+	if len(idx.blocks) == 0 || len(text2) < rhBlockSize {
+		return nil
+	}
+
+	// pow is rhBase^(rhBlockSize-1), used to remove the outgoing byte.
+	pow := uint64(1)
+	for i := 0; i < rhBlockSize-1; i++ {
+		pow *= rhBase
+	}
+
+	var anchors []anchor
+	var lastA, lastB int // End of the previous anchor in text1 and text2.
+
+	b := 0
+	h := rhHash(text2[:rhBlockSize])
+	for {
+		if !deadline.IsZero() && b%4096 == 0 && time.Now().After(deadline) {
+			break
+		}
+
+		if a, ok := idx.lookup(h, text2[b:b+rhBlockSize], lastA); ok {
+			// Extend backward, without reaching into the previous match.
+			for a > 0 && b > lastB && text1[a-1] == text2[b-1] {
+				a--
+				b--
+			}
+			n := commonPrefixLength(text1[a:], text2[b:])
+
+			anchors = append(anchors, anchor{a: a, b: b, n: n})
+			lastA, lastB = a+n, b+n
+
+			b += n
+			if b+rhBlockSize > len(text2) {
+				break
+			}
+			h = rhHash(text2[b : b+rhBlockSize])
+			continue
+		}
+
+		if b+rhBlockSize >= len(text2) {
+			break
+		}
+		h = (h-uint64(text2[b])*pow)*rhBase + uint64(text2[b+rhBlockSize])
+		b++
+	}
+
+	return anchors
+}
+
+// lookup returns the offset of a block equal to window, preferring one at or after min
+// so that the match can extend the current chain.
+func (idx *blockIndex) lookup(h uint64, window []byte, min int) (int, bool) {
+	found := -1
+	for _, a := range idx.blocks[h] {
+		if !bytes.Equal(idx.text[a:a+rhBlockSize], window) {
+			continue
+		}
+		if a >= min {
+			return a, true
+		}
+		if found == -1 {
+			found = a
+		}
+	}
+
+	return found, found != -1
+}
+
+func rhHash(p []byte) uint64 {
+	var h uint64
+	for _, c := range p {
+		h = h*rhBase + uint64(c)
+	}
+	return h
+}
+
+// diffRollingHash anchors the diff on blocks of before found in after with a rolling
+// hash. It is much faster than bisect when large inputs are mostly similar.
+func diffRollingHash(text1, text2 []byte, timeout time.Duration) []diff {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	return diffAnchors(text1, text2, newBlockIndex(text1).matches(text2, deadline), deadline)
+}
+
+// diffHashed is a shortcut for large segments. Identical blocks are located by hash in
+// linear time, and only the gaps between them are diffed, rather than running bisect
+// over the whole segment. It returns nil if the inputs are too small, or if they don't
+// share enough blocks to cover at least half of the shorter one. Like half-match, it
+// may produce a non-optimal diff, so it is only used when there is a deadline.
+func diffHashed(text1, text2 []byte, deadline time.Time) []diff {
+	if deadline.IsZero() || len(text1)+len(text2) < hashedMinSize {
+		return nil
+	}
+
+	anchors := newBlockIndex(text1).matches(text2, deadline)
+
+	covered := 0
+	for _, an := range monotoneChain(anchors) {
+		covered += an.n
+	}
+	if covered*2 < len(text1) || covered*2 < len(text2) {
+		return nil
+	}
+
+	return diffAnchors(text1, text2, anchors, deadline)
+}
@@ -0,0 +1,82 @@
+package lightpatch
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	// ErrSignature is returned by ApplyPatch when the signature of a patch doesn't
+	// verify with the key given with WithPublicKey.
+	ErrSignature = errors.New("signature mismatch")
+
+	// ErrUnsigned is returned by ApplyPatch when a key is given with WithPublicKey
+	// but the patch isn't signed.
+	ErrUnsigned = errors.New("patch is not signed")
+
+	errKeySize = errors.New("invalid Ed25519 key size")
+)
+
+// WithSigningKey causes MakePatch to sign the patch with key, so that consumers
+// holding the public key can check with WithPublicKey that it was made by the holder
+// of key. The signature is written in an envelope around the patch, inside any FEC
+// envelope and armor, so the patch is buffered in memory.
+//
+// Readers of patches skip the signature without checking it, unless ApplyPatch is
+// given WithPublicKey.
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(c *config) {
+		c.signingKey = key
+	}
+}
+
+// WithPublicKey causes ApplyPatch to require a patch signed with the private key of
+// key, returning ErrUnsigned if it isn't signed and ErrSignature if the signature
+// doesn't verify. The patch is read into memory and checked before any output is
+// written.
+func WithPublicKey(key ed25519.PublicKey) Option {
+	return func(c *config) {
+		c.publicKey = key
+	}
+}
+
+// writeSignature writes patch to w in a signature envelope:
+//
+//	E <signature> <patch>
+//
+// The signature is the 64 byte Ed25519 signature of the patch, which runs to the end.
+func writeSignature(w io.Writer, patch []byte, key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return errKeySize
+	}
+
+	if _, err := w.Write(append([]byte{OpSignature}, ed25519.Sign(key, patch)...)); err != nil {
+		return err
+	}
+	_, err := w.Write(patch)
+	return err
+}
+
+// readSignature reads a signature envelope, following the op byte, and returns the
+// patch inside. The signature is checked if key is not nil.
+func readSignature(r io.Reader, key ed25519.PublicKey) ([]byte, error) {
+	if key != nil && len(key) != ed25519.PublicKeySize {
+		return nil, errKeySize
+	}
+
+	sig := make([]byte, ed25519.SignatureSize)
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, err
+	}
+	patch, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil && !ed25519.Verify(key, patch, sig) {
+		return nil, ErrSignature
+	}
+	return patch, nil
+}
@@ -0,0 +1,61 @@
+package lightpatch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedPatch(t *testing.T) {
+	a := "The quick brown fox jumped over the lazy dog."
+	b := "The quick brown cat jumped over the dog!"
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	other, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	for _, opts := range [][]Option{
+		{WithSigningKey(priv)},
+		{WithSigningKey(priv), WithFEC(4, 2), WithArmor(ArmorBase64)},
+	} {
+		var patch bytes.Buffer
+		assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch, opts...))
+
+		var after bytes.Buffer
+		assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after, WithPublicKey(pub)))
+		assert.Equal(t, b, after.String())
+
+		// Without a key the signature is skipped.
+		after.Reset()
+		assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+		assert.Equal(t, b, after.String())
+		assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+		err := ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), new(bytes.Buffer), WithPublicKey(other))
+		assert.Equal(t, ErrSignature, err)
+	}
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch, WithSigningKey(priv)))
+	assert.Equal(t, OpSignature, patch.Bytes()[0])
+
+	// Changing any byte of the patch breaks the signature.
+	tampered := append([]byte{}, patch.Bytes()...)
+	tampered[len(tampered)-8]++
+	err = ApplyPatch(strings.NewReader(a), bytes.NewReader(tampered), new(bytes.Buffer), WithPublicKey(pub))
+	assert.Equal(t, ErrSignature, err)
+
+	patch.Reset()
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch))
+	err = ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), new(bytes.Buffer), WithPublicKey(pub))
+	assert.Equal(t, ErrUnsigned, err)
+
+	err = MakePatch(strings.NewReader(a), strings.NewReader(b), new(bytes.Buffer), WithSigningKey(priv[:10]))
+	assert.Equal(t, errKeySize, err)
+	err = MakePatchStream(strings.NewReader(a), strings.NewReader(b), new(bytes.Buffer), WithSigningKey(priv))
+	assert.Equal(t, ErrNotStreamable, err)
+}
@@ -0,0 +1,44 @@
+package lightpatch
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrAfterSize is returned by ApplyPatch when the output isn't the size given with
+// WithExpectedAfterSize.
+var ErrAfterSize = errors.New("output size mismatch")
+
+// WithExpectedAfterSize tells ApplyPatch that the output should be n bytes. If the
+// output writer has a Grow method, such as a bytes.Buffer, it is grown to n bytes
+// up front. ApplyPatch returns ErrAfterSize as soon as the output exceeds n bytes,
+// or at the end if it is shorter, which catches a patch applied to the wrong
+// version of before before it is fully written.
+func WithExpectedAfterSize(n int64) Option {
+	return func(c *config) {
+		c.expectedSize = &n
+	}
+}
+
+// sizeWriter fails writes that would take the output past limit bytes.
+type sizeWriter struct {
+	w     io.Writer
+	n     int64
+	limit int64
+}
+
+func newSizeWriter(w io.Writer, limit int64) *sizeWriter {
+	if g, ok := w.(interface{ Grow(int) }); ok && limit > 0 && int64(int(limit)) == limit {
+		g.Grow(int(limit))
+	}
+	return &sizeWriter{w: w, limit: limit}
+}
+
+func (s *sizeWriter) Write(p []byte) (int, error) {
+	if s.n+int64(len(p)) > s.limit {
+		return 0, ErrAfterSize
+	}
+	n, err := s.w.Write(p)
+	s.n += int64(n)
+	return n, err
+}
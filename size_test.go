@@ -0,0 +1,32 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectedAfterSize(t *testing.T) {
+	a := strings.Repeat("The quick brown fox jumped over the lazy dog. ", 20)
+	b := strings.Repeat("The quick brown cat jumped over the dog! ", 20)
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch))
+
+	var after bytes.Buffer
+	err := ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after, WithExpectedAfterSize(int64(len(b))))
+	assert.NoError(t, err)
+	assert.Equal(t, b, after.String())
+
+	// Too small: the output stops at the expected size.
+	after.Reset()
+	err = ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after, WithExpectedAfterSize(100))
+	assert.Equal(t, ErrAfterSize, err)
+	assert.True(t, after.Len() <= 100)
+
+	// Too large.
+	err = ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), new(bytes.Buffer), WithExpectedAfterSize(int64(len(b)+1)))
+	assert.Equal(t, ErrAfterSize, err)
+}
@@ -0,0 +1,48 @@
+package lightpatch
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// ErrBeforeMismatch is returned by ApplyPatch when before doesn't have the checksum
+// recorded with WithBeforeChecksum, before any output is written.
+var ErrBeforeMismatch = errors.New("patch does not match this source")
+
+// WithBeforeChecksum causes MakePatch to record the CRC-32 of before in the patch.
+// ApplyPatch then reads all of before up front and returns ErrBeforeMismatch if it
+// isn't the before the patch was made from, instead of an ErrCRC once the output has
+// been written.
+func WithBeforeChecksum() Option {
+	return func(c *config) {
+		c.beforeChecksum = true
+	}
+}
+
+// beforeCRCRecord returns the OpBeforeCRC record of before.
+func beforeCRCRecord(before []byte) []byte {
+	rec := make([]byte, 5)
+	rec[0] = OpBeforeCRC
+	binary.BigEndian.PutUint32(rec[1:], crc32.ChecksumIEEE(before))
+	return rec
+}
+
+// checkBefore reads the CRC of an OpBeforeCRC record from patch and all of before, and
+// returns before if its CRC matches.
+func checkBefore(before, patch io.Reader) ([]byte, error) {
+	crc := make([]byte, 4)
+	if _, err := io.ReadFull(patch, crc); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(before)
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(crc) != crc32.ChecksumIEEE(data) {
+		return nil, ErrBeforeMismatch
+	}
+	return data, nil
+}
@@ -0,0 +1,49 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeforeChecksum(t *testing.T) {
+	a := "The quick brown fox jumped over the lazy dog."
+	b := "The quick brown cat jumped over the dog!"
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(strings.NewReader(a), strings.NewReader(b), &patch, WithBeforeChecksum()))
+	assert.True(t, bytes.HasPrefix(patch.Bytes(), []byte{OpBeforeCRC, 0x82, 0xa3, 0x46, 0x42}))
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+	var after bytes.Buffer
+	assert.NoError(t, ApplyPatch(strings.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.String())
+
+	// The wrong before fails before anything is written.
+	after.Reset()
+	err := ApplyPatch(strings.NewReader("the quick brown fox jumped over the lazy dog."), bytes.NewReader(patch.Bytes()), &after)
+	assert.Equal(t, ErrBeforeMismatch, err)
+	assert.Zero(t, after.Len())
+
+	// The record is skipped by the other readers.
+	edits, err := Edits([]byte(a), patch.Bytes())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, edits)
+	var text, bin bytes.Buffer
+	assert.NoError(t, ToText(bytes.NewReader(patch.Bytes()), &text))
+	assert.True(t, strings.HasPrefix(text.String(), "S 82a34642\n"))
+	assert.NoError(t, ToBinary(&text, &bin))
+	assert.Equal(t, patch.Bytes(), bin.Bytes())
+	_, err = ParsePatch(patch.Bytes())
+	assert.NoError(t, err)
+
+	// It must come before the records that read before.
+	bad := append([]byte{OpCopy, 4}, patch.Bytes()...)
+	err = ApplyPatch(strings.NewReader(a), bytes.NewReader(bad), new(bytes.Buffer))
+	assert.Equal(t, &DecodeError{Offset: 2, Source: 4, Op: OpBeforeCRC, Err: unexpectedOp(OpBeforeCRC)}, err)
+
+	err = MakePatchStream(strings.NewReader(a), strings.NewReader(b), new(bytes.Buffer), WithBeforeChecksum())
+	assert.Equal(t, ErrNotStreamable, err)
+}
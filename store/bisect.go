@@ -0,0 +1,101 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/kalafut/lightpatch"
+)
+
+// Bisect returns the first version of the document key for which match reports
+// true, by binary search, so only a few versions are read. match must be false for
+// every version before that one and true for every version after it. If it is true
+// for no version, Bisect returns ErrNotFound.
+func (h *history) Bisect(key string, match func(content []byte) bool) (version int, err error) {
+	err = h.b.view(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+
+		lo, hi := 0, len(vs)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			content, err := read(r, key, vs, mid)
+			if err != nil {
+				return err
+			}
+			if match(content) {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		if lo == len(vs) {
+			return ErrNotFound
+		}
+
+		version = vs[lo].n
+		return nil
+	})
+	return version, err
+}
+
+// LastChange returns the version in which bytes [start, end) of the latest version
+// of the document key last changed, or the oldest version if they haven't changed
+// since. The range is mapped back through the stored patches, so versions are only
+// read where a snapshot interrupts the chain.
+func (h *history) LastChange(key string, start, end int) (version int, err error) {
+	err = h.b.view(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+		if len(vs) == 0 {
+			return ErrNotFound
+		}
+
+		for i := len(vs) - 1; i > 0; i-- {
+			var patch []byte
+			if vs[i].snapshot {
+				patch, err = diffVersions(r, key, vs, i)
+			} else {
+				patch, err = r.data(key, vs[i].n)
+			}
+			if err != nil {
+				return err
+			}
+
+			var changed bool
+			start, end, changed, err = lightpatch.MapRange(bytes.NewReader(patch), start, end)
+			if err != nil {
+				return err
+			}
+			if changed {
+				version = vs[i].n
+				return nil
+			}
+		}
+
+		version = vs[0].n
+		return nil
+	})
+	return version, err
+}
+
+// diffVersions returns a patch from vs[i-1] to vs[i].
+func diffVersions(r records, key string, vs []version, i int) ([]byte, error) {
+	prev, err := read(r, key, vs, i-1)
+	if err != nil {
+		return nil, err
+	}
+	content, err := read(r, key, vs, i)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch bytes.Buffer
+	if err := lightpatch.MakePatch(bytes.NewReader(prev), bytes.NewReader(content), &patch); err != nil {
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
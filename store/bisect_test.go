@@ -0,0 +1,58 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kalafut/lightpatch/store/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBisect(t *testing.T) {
+	c := NewChain(kv.NewMemory(), WithSnapshotInterval(3))
+
+	var latest string
+	for i := 1; i <= 12; i++ {
+		port := 80
+		if i >= 4 {
+			port = 8080
+		}
+		latest = fmt.Sprintf("# revision %d\nhost = example.com\nport = %d\ntimeout = %ds\n", i, port, 10+i/5)
+		_, err := c.Put("config", []byte(latest))
+		assert.NoError(t, err)
+	}
+
+	v, err := c.Bisect("config", func(content []byte) bool {
+		return bytes.Contains(content, []byte("port = 8080"))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, v)
+
+	_, err = c.Bisect("config", func(content []byte) bool { return false })
+	assert.Equal(t, ErrNotFound, err)
+
+	line := func(prefix string) (int, int) {
+		start := strings.Index(latest, prefix)
+		return start, start + strings.Index(latest[start:], "\n")
+	}
+
+	for _, test := range []struct {
+		prefix  string
+		version int
+	}{
+		{"host", 1},
+		{"port", 4},
+		{"timeout", 10},
+		{"# revision", 12},
+	} {
+		start, end := line(test.prefix)
+		v, err := c.LastChange("config", start, end)
+		assert.NoError(t, err)
+		assert.Equal(t, test.version, v, test.prefix)
+	}
+
+	_, err = c.LastChange("config", 0, len(latest)+1)
+	assert.Error(t, err)
+}
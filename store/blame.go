@@ -0,0 +1,99 @@
+package store
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/kalafut/lightpatch"
+)
+
+// A BlameSpan attributes bytes [Start, End) of the latest version of a document to
+// the version that introduced them.
+type BlameSpan struct {
+	Start, End int
+	Version    int
+}
+
+// Blame attributes every byte of the latest version of the document key to the
+// version that introduced it, by mapping the bytes back through the stored patches.
+// Bytes present since the oldest version kept are attributed to it. The spans are
+// in order, and adjacent spans have different versions.
+func (h *history) Blame(key string) (spans []BlameSpan, err error) {
+	err = h.b.view(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+		if len(vs) == 0 {
+			return ErrNotFound
+		}
+
+		// pending are the unattributed ranges of the latest version, along with
+		// their offset in vs[i], in order of both.
+		type piece struct{ start, end, offset int }
+		pending := []piece{{0, vs[len(vs)-1].size, 0}}
+
+		for i := len(vs) - 1; i > 0 && len(pending) > 0; i-- {
+			var patch []byte
+			if vs[i].snapshot {
+				patch, err = diffVersions(r, key, vs, i)
+			} else {
+				patch, err = r.data(key, vs[i].n)
+			}
+			if err != nil {
+				return err
+			}
+			segs, err := lightpatch.Segments(bytes.NewReader(patch))
+			if err != nil {
+				return err
+			}
+
+			var next []piece
+			j := 0
+			for _, p := range pending {
+				for p.start < p.end {
+					for j < len(segs) && segs[j].Offset+segs[j].Length <= p.offset {
+						j++
+					}
+					if j == len(segs) {
+						return ErrCorrupt
+					}
+					seg := segs[j]
+					n := seg.Offset + seg.Length - p.offset
+					if n > p.end-p.start {
+						n = p.end - p.start
+					}
+
+					if seg.Copied {
+						next = append(next, piece{p.start, p.start + n, seg.BeforeOffset + p.offset - seg.Offset})
+					} else {
+						spans = append(spans, BlameSpan{Start: p.start, End: p.start + n, Version: vs[i].n})
+					}
+					p.start += n
+					p.offset += n
+				}
+			}
+			pending = next
+		}
+
+		for _, p := range pending {
+			spans = append(spans, BlameSpan{Start: p.start, End: p.end, Version: vs[0].n})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && merged[n-1].Version == s.Version && merged[n-1].End == s.Start {
+			merged[n-1].End = s.End
+		} else if s.Start < s.End {
+			merged = append(merged, s)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/kalafut/lightpatch/store/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlame(t *testing.T) {
+	c := NewChain(kv.NewMemory(), WithSnapshotInterval(2))
+
+	for _, content := range []string{
+		"host = example.com\nport = 80\n",
+		"host = example.com\nport = 80\ntimeout = 10s\n",
+		"host = example.com\nport = 8080\ntimeout = 10s\n",
+		"# Production\nhost = example.com\nport = 8080\ntimeout = 10s\n",
+	} {
+		_, err := c.Put("config", []byte(content))
+		assert.NoError(t, err)
+	}
+
+	spans, err := c.Blame("config")
+	assert.NoError(t, err)
+	assert.Equal(t, []BlameSpan{
+		{Start: 0, End: 13, Version: 4},  // "# Production\n"
+		{Start: 13, End: 41, Version: 1}, // "host = example.com\nport = 80"
+		{Start: 41, End: 43, Version: 3}, // "80"
+		{Start: 43, End: 44, Version: 1}, // "\n"
+		{Start: 44, End: 58, Version: 2}, // "timeout = 10s\n"
+	}, spans)
+
+	_, err = c.Blame("missing")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = c.Put("empty", nil)
+	assert.NoError(t, err)
+	spans, err = c.Blame("empty")
+	assert.NoError(t, err)
+	assert.Empty(t, spans)
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kalafut/lightpatch/store/kv"
+)
+
+const hashSize = 32 // BLAKE3-256
+
+var errBadIndex = errors.New("bad version index")
+
+// A Chain is a revision history of documents kept in a key-value store, with the
+// same layout of snapshots and patches as Store. The versions of document key are
+// listed under "key/versions", and the snapshot or patch of each version n is kept
+// under "key/n".
+//
+// A Chain is safe for concurrent use, but only one Chain should write to a backend
+// at a time, since the key-value store has no transactions.
+type Chain struct {
+	history
+}
+
+// NewChain returns a Chain that keeps history in backend.
+func NewChain(backend kv.Store, opts ...Option) *Chain {
+	return &Chain{history{b: &kvBackend{kv: backend}, cfg: newConfig(opts)}}
+}
+
+// kvBackend keeps versions in a key-value store. The data of a version is written
+// before it is listed, and unlisted before it is removed, so that an interrupted
+// update leaves a readable history.
+type kvBackend struct {
+	mu sync.RWMutex
+	kv kv.Store
+}
+
+func (b *kvBackend) update(fn func(r records) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return fn(kvRecords{b.kv})
+}
+
+func (b *kvBackend) view(fn func(r records) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return fn(kvRecords{b.kv})
+}
+
+type kvRecords struct {
+	kv kv.Store
+}
+
+// versions decodes the index of key. Each version is listed as varints of its
+// number, creation time in Unix nanoseconds and size, followed by a snapshot flag
+// byte and its hash.
+func (r kvRecords) versions(key string) ([]version, error) {
+	index, err := r.kv.Get(key + "/versions")
+	if err == kv.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var vs []version
+	for len(index) > 0 {
+		var fields [3]int64
+		for i := range fields {
+			x, n := binary.Varint(index)
+			if n <= 0 {
+				return nil, errBadIndex
+			}
+			fields[i], index = x, index[n:]
+		}
+		if len(index) < 1+hashSize {
+			return nil, errBadIndex
+		}
+
+		vs = append(vs, version{
+			n:        int(fields[0]),
+			created:  time.Unix(0, fields[1]),
+			size:     int(fields[2]),
+			snapshot: index[0] != 0,
+			hash:     index[1 : 1+hashSize],
+		})
+		index = index[1+hashSize:]
+	}
+
+	return vs, nil
+}
+
+func (r kvRecords) writeIndex(key string, vs []version) error {
+	if len(vs) == 0 {
+		return r.kv.Delete(key + "/versions")
+	}
+
+	var index []byte
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+	for _, v := range vs {
+		for _, x := range []int64{int64(v.n), v.created.UnixNano(), int64(v.size)} {
+			index = append(index, varintBuf[:binary.PutVarint(varintBuf, x)]...)
+		}
+		flag := byte(0)
+		if v.snapshot {
+			flag = 1
+		}
+		index = append(append(index, flag), v.hash...)
+	}
+
+	return r.kv.Put(key+"/versions", index)
+}
+
+func (r kvRecords) data(key string, n int) ([]byte, error) {
+	data, err := r.kv.Get(key + "/" + strconv.Itoa(n))
+	if err == kv.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (r kvRecords) put(key string, v version, data []byte) error {
+	vs, err := r.versions(key)
+	if err != nil {
+		return err
+	}
+
+	if err := r.kv.Put(key+"/"+strconv.Itoa(v.n), data); err != nil {
+		return err
+	}
+
+	i := len(vs)
+	for i > 0 && vs[i-1].n >= v.n {
+		i--
+	}
+	if i < len(vs) && vs[i].n == v.n {
+		vs[i] = v
+	} else {
+		vs = append(vs[:i], append([]version{v}, vs[i:]...)...)
+	}
+	return r.writeIndex(key, vs)
+}
+
+func (r kvRecords) remove(key string, n int) error {
+	vs, err := r.versions(key)
+	if err != nil {
+		return err
+	}
+
+	for i, v := range vs {
+		if v.n == n {
+			if err := r.writeIndex(key, append(vs[:i], vs[i+1:]...)); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return r.kv.Delete(key + "/" + strconv.Itoa(n))
+}
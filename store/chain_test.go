@@ -0,0 +1,99 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kalafut/lightpatch/store/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dirStore, err := kv.NewDir(dir)
+	assert.NoError(t, err)
+
+	for _, backend := range []kv.Store{kv.NewMemory(), dirStore} {
+		c := NewChain(backend, WithSnapshotInterval(4))
+
+		_, _, err = c.Get("docs/a")
+		assert.Equal(t, ErrNotFound, err)
+
+		var versions []string
+		for i := 1; i <= 10; i++ {
+			content := fmt.Sprintf("The quick brown fox jumped over the lazy dog %d times.\n", i)
+			versions = append(versions, content)
+			v, err := c.Put("docs/a", []byte(content))
+			assert.NoError(t, err)
+			assert.Equal(t, i, v)
+		}
+
+		v, err := c.Put("docs/a", []byte(versions[9]))
+		assert.NoError(t, err)
+		assert.Equal(t, 10, v)
+
+		// A new Chain over the same backend sees the history.
+		c = NewChain(backend)
+		content, v, err := c.Get("docs/a")
+		assert.NoError(t, err)
+		assert.Equal(t, 10, v)
+		assert.Equal(t, versions[9], string(content))
+
+		for i, expected := range versions {
+			content, err := c.At("docs/a", i+1)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(content))
+		}
+		_, err = c.At("docs/a", 11)
+		assert.Equal(t, ErrNotFound, err)
+
+		infos, err := c.Versions("docs/a")
+		assert.NoError(t, err)
+		assert.Len(t, infos, 10)
+		assert.Equal(t, 10, infos[9].Version)
+		assert.Equal(t, len(versions[9]), infos[9].Size)
+		assert.False(t, infos[9].Created.Before(infos[0].Created))
+
+		content, v, err = c.AtTime("docs/a", infos[3].Created)
+		assert.NoError(t, err)
+		assert.Equal(t, versions[v-1], string(content))
+		assert.Equal(t, infos[3].Created, infos[v-1].Created)
+		_, v, err = c.AtTime("docs/a", time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 10, v)
+		_, _, err = c.AtTime("docs/a", infos[0].Created.Add(-time.Second))
+		assert.Equal(t, ErrNotFound, err)
+
+		// Versions between snapshots are patches.
+		vs, err := kvRecords{backend}.versions("docs/a")
+		assert.NoError(t, err)
+		var snapshots []int
+		for _, v := range vs {
+			if v.snapshot {
+				snapshots = append(snapshots, v.n)
+			}
+		}
+		assert.Equal(t, []int{1, 5, 9}, snapshots)
+
+		// Damage is detected.
+		record, err := backend.Get("docs/a/9")
+		assert.NoError(t, err)
+		record[len(record)-2] ^= 1
+		assert.NoError(t, backend.Put("docs/a/9", record))
+		_, err = c.At("docs/a", 9)
+		assert.Equal(t, ErrCorrupt, err)
+
+		assert.NoError(t, c.Delete("docs/a"))
+		_, _, err = c.Get("docs/a")
+		assert.Equal(t, ErrNotFound, err)
+		_, err = backend.Get("docs/a/1")
+		assert.Equal(t, kv.ErrNotFound, err)
+		_, err = backend.Get("docs/a/versions")
+		assert.Equal(t, kv.ErrNotFound, err)
+	}
+}
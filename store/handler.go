@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kalafut/lightpatch"
+	"lukechampine.com/blake3"
+)
+
+// DeltaIM is the instance manipulation a client names in an A-IM request header to
+// accept a lightpatch patch from Handler, as in RFC 3229 delta encoding.
+const DeltaIM = "lightpatch"
+
+// Handler returns an http.Handler that serves documents from the history, taking the
+// request path, without its leading slash, as the key. The latest version is served,
+// or the one given by a version query parameter, e.g. "/docs/a?version=3". Only the
+// versions requested are rebuilt, from the nearest snapshot.
+//
+// Responses carry an ETag of the version and its hash, and conditional and range
+// requests are handled by http.ServeContent. A client that has a version and sends
+// its ETag in If-None-Match, along with "A-IM: lightpatch", receives a patch from
+// that version instead, with status 226 IM Used, when it is smaller than the content.
+// Mount the handler with http.StripPrefix to serve keys below a path.
+func (h *history) Handler() http.Handler {
+	return http.HandlerFunc(h.serveHTTP)
+}
+
+func (h *history) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	infos, err := h.Versions(key)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	info := infos[len(infos)-1]
+	if s := r.URL.Query().Get("version"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		found := false
+		for _, i := range infos {
+			if i.Version == n {
+				info, found = i, true
+			}
+		}
+		if !found {
+			httpError(w, ErrNotFound)
+			return
+		}
+	}
+
+	content, err := h.At(key, info.Version)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	tag := etag(info.Version, content)
+	w.Header().Set("Vary", "A-IM")
+	w.Header().Set("ETag", tag)
+
+	if patch, base := h.delta(r, key, content, tag); patch != nil {
+		w.Header().Set("IM", DeltaIM)
+		w.Header().Set("Delta-Base", base)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(patch)))
+		w.WriteHeader(http.StatusIMUsed)
+		if r.Method != http.MethodHead {
+			w.Write(patch)
+		}
+		return
+	}
+
+	http.ServeContent(w, r, key, info.Created, bytes.NewReader(content))
+}
+
+// delta returns a patch to content, whose ETag is current, from the version of key
+// the client names in If-None-Match, and that version's ETag, if the client accepts
+// patches and the patch is smaller than content.
+func (h *history) delta(r *http.Request, key string, content []byte, current string) (patch []byte, base string) {
+	if !strings.Contains(r.Header.Get("A-IM"), DeltaIM) {
+		return nil, ""
+	}
+
+	tags := strings.Split(r.Header.Get("If-None-Match"), ",")
+	for i := range tags {
+		tags[i] = strings.TrimSpace(tags[i])
+		if tags[i] == current {
+			// Served as 304 Not Modified.
+			return nil, ""
+		}
+	}
+
+	for _, tag := range tags {
+		version, err := strconv.Atoi(strings.SplitN(strings.Trim(tag, `"`), ".", 2)[0])
+		if err != nil {
+			continue
+		}
+
+		// The ETag must match too, in case the history was rewritten.
+		prev, err := h.At(key, version)
+		if err != nil || etag(version, prev) != tag {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := lightpatch.MakePatch(bytes.NewReader(prev), bytes.NewReader(content), &buf); err != nil || buf.Len() >= len(content) {
+			return nil, ""
+		}
+		return buf.Bytes(), tag
+	}
+
+	return nil, ""
+}
+
+// etag returns the ETag of a version with the given content.
+func etag(version int, content []byte) string {
+	sum := blake3.Sum256(content)
+	return fmt.Sprintf(`"%d.%x"`, version, sum[:8])
+}
+
+// httpError replies to a request that failed with err.
+func httpError(w http.ResponseWriter, err error) {
+	if err == ErrNotFound {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
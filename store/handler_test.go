@@ -0,0 +1,74 @@
+package store
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kalafut/lightpatch"
+	"github.com/kalafut/lightpatch/store/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	c := NewChain(kv.NewMemory(), WithSnapshotInterval(2))
+	long := strings.Repeat("The quick brown fox jumped over the lazy dog.\n", 20)
+	for _, s := range []string{long, long + "v2\n", long + "v3\n"} {
+		_, err := c.Put("docs/a.txt", []byte(s))
+		assert.NoError(t, err)
+	}
+	h := http.StripPrefix("/files", c.Handler())
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/files/docs/a.txt")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, long+"v3\n", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	latest := w.Header().Get("ETag")
+
+	w = get("/files/docs/a.txt?version=2")
+	assert.Equal(t, long+"v2\n", w.Body.String())
+	v2 := w.Header().Get("ETag")
+	assert.NotEqual(t, latest, v2)
+
+	w = get("/files/docs/a.txt", "If-None-Match", latest)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = get("/files/docs/a.txt", "If-None-Match", latest, "A-IM", DeltaIM)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// A client with version 2 that accepts patches gets one.
+	w = get("/files/docs/a.txt", "If-None-Match", v2, "A-IM", DeltaIM)
+	assert.Equal(t, http.StatusIMUsed, w.Code)
+	assert.Equal(t, DeltaIM, w.Header().Get("IM"))
+	assert.Equal(t, v2, w.Header().Get("Delta-Base"))
+	assert.Equal(t, latest, w.Header().Get("ETag"))
+	var after bytes.Buffer
+	assert.NoError(t, lightpatch.ApplyPatch(strings.NewReader(long+"v2\n"), w.Body, &after))
+	assert.Equal(t, long+"v3\n", after.String())
+
+	// Without A-IM, or with an ETag that isn't the version's, the content is sent.
+	w = get("/files/docs/a.txt", "If-None-Match", v2)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = get("/files/docs/a.txt", "If-None-Match", `"2.0000000000000000"`, "A-IM", DeltaIM)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, long+"v3\n", w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("/files/docs/b.txt").Code)
+	assert.Equal(t, http.StatusNotFound, get("/files/docs/a.txt?version=9").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/files/docs/a.txt?version=x").Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/files/docs/a.txt", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/kalafut/lightpatch"
+	"lukechampine.com/blake3"
+)
+
+// version describes a stored version of a document.
+type version struct {
+	n        int
+	created  time.Time
+	hash     []byte
+	size     int
+	snapshot bool
+}
+
+// records is the storage of a backend, as seen from inside a transaction.
+type records interface {
+	// versions returns the versions of key in order.
+	versions(key string) ([]version, error)
+
+	// data returns the snapshot or patch of version n of key.
+	data(key string, n int) ([]byte, error)
+
+	// put adds version v of key, or replaces it if it exists.
+	put(key string, v version, data []byte) error
+
+	// remove deletes version n of key.
+	remove(key string, n int) error
+}
+
+// backend runs functions against its records, with exclusive access for update.
+type backend interface {
+	update(fn func(r records) error) error
+	view(fn func(r records) error) error
+}
+
+// history implements the revision history of Store and Chain over a backend.
+type history struct {
+	b   backend
+	cfg config
+}
+
+// Put records content as the next version of the document key and returns its
+// version number, starting at 1. If content is the same as the latest version, no
+// version is added and the latest version number is returned. Any policy set with
+// WithPolicy is applied afterwards.
+func (h *history) Put(key string, content []byte) (version int, err error) {
+	err = h.b.update(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+
+		hash := blake3.Sum256(content)
+		if len(vs) > 0 && bytes.Equal(vs[len(vs)-1].hash, hash[:]) {
+			version = vs[len(vs)-1].n
+			return nil
+		}
+
+		v := newVersion(vs, hash[:], len(content))
+		version = v.n
+
+		// Patch against the previous version, unless a snapshot is due.
+		data := content
+		if !v.snapshot && chainLength(vs, len(vs)) >= h.cfg.snapshotInterval {
+			v.snapshot = true
+		}
+		if !v.snapshot {
+			prev, err := read(r, key, vs, len(vs)-1)
+			if err != nil {
+				return err
+			}
+			if data, v.snapshot, err = delta(prev, content); err != nil {
+				return err
+			}
+		}
+		if err := r.put(key, v, data); err != nil {
+			return err
+		}
+
+		if h.cfg.policy != nil {
+			return consolidate(r, key, *h.cfg.policy, time.Now())
+		}
+		return nil
+	})
+	return version, err
+}
+
+// Get returns the latest version of the document key and its version number.
+func (h *history) Get(key string) (content []byte, version int, err error) {
+	err = h.b.view(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+		if len(vs) == 0 {
+			return ErrNotFound
+		}
+
+		version = vs[len(vs)-1].n
+		content, err = read(r, key, vs, len(vs)-1)
+		return err
+	})
+	return content, version, err
+}
+
+// At returns the given version of the document key. Versions removed by a policy
+// are not found.
+func (h *history) At(key string, version int) (content []byte, err error) {
+	err = h.b.view(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+
+		for i, v := range vs {
+			if v.n == version {
+				content, err = read(r, key, vs, i)
+				return err
+			}
+		}
+		return ErrNotFound
+	})
+	return content, err
+}
+
+// AtTime returns the version of the document key that was current at t, which is
+// the last version created at or before t, and its version number. Like At, it
+// reads from the nearest snapshot, so the cost doesn't grow with the age of t.
+func (h *history) AtTime(key string, t time.Time) (content []byte, version int, err error) {
+	err = h.b.view(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+
+		i := len(vs) - 1
+		for i >= 0 && vs[i].created.After(t) {
+			i--
+		}
+		if i < 0 {
+			return ErrNotFound
+		}
+
+		version = vs[i].n
+		content, err = read(r, key, vs, i)
+		return err
+	})
+	return content, version, err
+}
+
+// A VersionInfo describes a version of a document.
+type VersionInfo struct {
+	Version int
+	Created time.Time
+	Size    int
+}
+
+// Versions lists the versions of the document key, oldest first.
+func (h *history) Versions(key string) (infos []VersionInfo, err error) {
+	err = h.b.view(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+		if len(vs) == 0 {
+			return ErrNotFound
+		}
+
+		for _, v := range vs {
+			infos = append(infos, VersionInfo{Version: v.n, Created: v.created, Size: v.size})
+		}
+		return nil
+	})
+	return infos, err
+}
+
+// Delete removes the history of the document key.
+func (h *history) Delete(key string) error {
+	return h.b.update(func(r records) error {
+		vs, err := r.versions(key)
+		if err != nil {
+			return err
+		}
+
+		// Remove the latest versions first, so that an interrupted delete leaves a
+		// readable history.
+		for i := len(vs) - 1; i >= 0; i-- {
+			if err := r.remove(key, vs[i].n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Consolidate applies p to the history of the document key.
+func (h *history) Consolidate(key string, p Policy) error {
+	return h.b.update(func(r records) error {
+		return consolidate(r, key, p, time.Now())
+	})
+}
+
+func newVersion(vs []version, hash []byte, size int) version {
+	v := version{n: 1, created: time.Now(), hash: hash, size: size, snapshot: true}
+	if len(vs) > 0 {
+		v.n = vs[len(vs)-1].n + 1
+		v.snapshot = false
+	}
+	return v
+}
+
+// chainLength returns the number of versions before vs[i] that are read to rebuild
+// it, starting from the latest snapshot.
+func chainLength(vs []version, i int) int {
+	n := 0
+	for j := i - 1; j >= 0; j-- {
+		n++
+		if vs[j].snapshot {
+			break
+		}
+	}
+	return n
+}
+
+// read rebuilds vs[i] from the nearest snapshot at or before it, and checks its hash.
+func read(r records, key string, vs []version, i int) ([]byte, error) {
+	first := i
+	for first > 0 && !vs[first].snapshot {
+		first--
+	}
+	if !vs[first].snapshot {
+		return nil, ErrCorrupt
+	}
+
+	var content []byte
+	for j := first; j <= i; j++ {
+		data, err := r.data(key, vs[j].n)
+		if err != nil {
+			return nil, err
+		}
+		if j == first {
+			content = data
+		} else if content, err = applyDelta(content, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if sum := blake3.Sum256(content); !bytes.Equal(sum[:], vs[i].hash) {
+		return nil, ErrCorrupt
+	}
+	return content, nil
+}
+
+// delta returns a patch from prev to content, or content itself as a snapshot if the
+// patch would be no smaller.
+func delta(prev, content []byte) (data []byte, snapshot bool, err error) {
+	var patch bytes.Buffer
+	if err := lightpatch.MakePatch(bytes.NewReader(prev), bytes.NewReader(content), &patch); err != nil {
+		return nil, false, err
+	}
+	if patch.Len() >= len(content) {
+		return content, true, nil
+	}
+	return patch.Bytes(), false, nil
+}
+
+// applyDelta applies the patch data to content.
+func applyDelta(content, data []byte) ([]byte, error) {
+	var after bytes.Buffer
+	if err := lightpatch.ApplyPatch(bytes.NewReader(content), bytes.NewReader(data), &after); err != nil {
+		return nil, err
+	}
+	return after.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+// Package kv defines the minimal key-value interface that store.Chain keeps revision
+// history in, with in-memory and filesystem implementations. Other databases, such
+// as Badger, Redis or S3, can be used by implementing Store.
+package kv
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by Get when a key isn't present.
+var ErrNotFound = errors.New("key not found")
+
+// Store is a key-value store. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value of key, or ErrNotFound.
+	Get(key string) ([]byte, error)
+
+	// Put sets the value of key, replacing any previous value.
+	Put(key string, value []byte) error
+
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(key string) error
+}
+
+// Memory is a Store held in memory.
+type Memory struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	return &Memory{values: make(map[string][]byte)}
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (m *Memory) Put(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.values, key)
+	return nil
+}
+
+// Dir is a Store that keeps each value in a file of a directory, named by the
+// escaped key. Values are written to a temporary file and renamed into place, so a
+// reader never sees a partial value.
+type Dir struct {
+	root string
+}
+
+// NewDir returns a Store in the directory root, creating it if needed.
+func NewDir(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{root: root}, nil
+}
+
+// path returns the file name of key. Names never start with a dot, so they can't
+// refer to the directory itself or clash with temporary files, and no other key
+// escapes to "%", which is used for the empty key.
+func (d *Dir) path(key string) string {
+	name := url.PathEscape(key)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	if name == "" {
+		name = "%"
+	}
+	return filepath.Join(d.root, name)
+}
+
+func (d *Dir) Get(key string) ([]byte, error) {
+	value, err := ioutil.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (d *Dir) Put(key string, value []byte) error {
+	f, err := ioutil.TempFile(d.root, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), d.path(key))
+}
+
+func (d *Dir) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package kv
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	d, err := NewDir(dir)
+	assert.NoError(t, err)
+
+	for _, s := range []Store{NewMemory(), d} {
+		for _, key := range []string{"a", "a/b", "..", ".tmp-x", "", "%"} {
+			_, err := s.Get(key)
+			assert.Equal(t, ErrNotFound, err, key)
+
+			assert.NoError(t, s.Put(key, []byte("value of "+key)))
+		}
+		for _, key := range []string{"a", "a/b", "..", ".tmp-x", "", "%"} {
+			value, err := s.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, "value of "+key, string(value))
+		}
+
+		assert.NoError(t, s.Put("a", nil))
+		value, err := s.Get("a")
+		assert.NoError(t, err)
+		assert.Empty(t, value)
+
+		assert.NoError(t, s.Delete("a"))
+		assert.NoError(t, s.Delete("a"))
+		_, err = s.Get("a")
+		assert.Equal(t, ErrNotFound, err)
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/kalafut/lightpatch"
+)
+
+// A Policy bounds the growth of a document's history, so that reading old or recent
+// versions doesn't get slower as the history grows. It is applied by Consolidate,
+// or after every Put with WithPolicy. The zero Policy changes nothing.
+type Policy struct {
+	// MaxVersions keeps at most this many versions, removing the oldest.
+	MaxVersions int
+
+	// CollapseAfter removes versions older than this, other than snapshots and the
+	// latest version. The patch of each removed version is composed with that of
+	// the next version, which then applies to the version before.
+	CollapseAfter time.Duration
+
+	// SnapshotEvery stores a snapshot every this many versions and patches in
+	// between, converting existing versions as needed. It is applied after the
+	// versions are removed, so that the remaining chains are evenly spaced.
+	SnapshotEvery int
+}
+
+// WithPolicy applies p after every Put.
+func WithPolicy(p Policy) Option {
+	return func(c *config) {
+		c.policy = &p
+	}
+}
+
+func consolidate(r records, key string, p Policy, now time.Time) error {
+	vs, err := r.versions(key)
+	if err != nil {
+		return err
+	}
+
+	for p.MaxVersions > 0 && len(vs) > p.MaxVersions {
+		if vs, err = removeVersion(r, key, vs, 0); err != nil {
+			return err
+		}
+	}
+
+	if p.CollapseAfter > 0 {
+		cutoff := now.Add(-p.CollapseAfter)
+		for i := 0; i < len(vs)-1; {
+			if vs[i].snapshot || !vs[i].created.Before(cutoff) {
+				i++
+				continue
+			}
+			if vs, err = removeVersion(r, key, vs, i); err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.SnapshotEvery > 0 {
+		for i := range vs {
+			snapshot := i == 0 || chainLength(vs, i) >= p.SnapshotEvery
+			if snapshot == vs[i].snapshot {
+				continue
+			}
+			if err := restore(r, key, vs, i, snapshot); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeVersion removes vs[i], which must not be the latest version, and returns the
+// remaining versions. The following version is rewritten to apply to the version
+// before, by composing the two patches, or becomes a snapshot if there is none.
+func removeVersion(r records, key string, vs []version, i int) ([]version, error) {
+	next := vs[i+1]
+	if !next.snapshot {
+		if vs[i].snapshot {
+			if err := restore(r, key, vs, i+1, true); err != nil {
+				return nil, err
+			}
+		} else {
+			first, err := r.data(key, vs[i].n)
+			if err != nil {
+				return nil, err
+			}
+			second, err := r.data(key, next.n)
+			if err != nil {
+				return nil, err
+			}
+
+			var composed bytes.Buffer
+			if err := lightpatch.ComposePatches(bytes.NewReader(first), bytes.NewReader(second), &composed); err != nil {
+				return nil, err
+			}
+			if err := r.put(key, next, composed.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := r.remove(key, vs[i].n); err != nil {
+		return nil, err
+	}
+	return append(vs[:i], vs[i+1:]...), nil
+}
+
+// restore rewrites vs[i] as a snapshot, or as a patch against the version before, and
+// updates vs to match.
+func restore(r records, key string, vs []version, i int, snapshot bool) error {
+	var data []byte
+	var err error
+	if snapshot {
+		data, err = read(r, key, vs, i)
+	} else {
+		data, err = diffVersions(r, key, vs, i)
+	}
+	if err != nil {
+		return err
+	}
+
+	vs[i].snapshot = snapshot
+	return r.put(key, vs[i], data)
+}
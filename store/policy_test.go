@@ -0,0 +1,95 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kalafut/lightpatch/store/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	var versions []string
+	for i := 1; i <= 10; i++ {
+		versions = append(versions, fmt.Sprintf("The quick brown fox jumped over the lazy dog %d times.\n", i))
+	}
+
+	fill := func(c *Chain) {
+		for _, content := range versions {
+			_, err := c.Put("doc", []byte(content))
+			assert.NoError(t, err)
+		}
+	}
+
+	// check expects exactly the listed versions, with snapshots where given.
+	check := func(c *Chain, present, snapshots []int) {
+		vs, err := kvRecords{c.b.(*kvBackend).kv}.versions("doc")
+		assert.NoError(t, err)
+
+		var ns, snaps []int
+		for _, v := range vs {
+			ns = append(ns, v.n)
+			if v.snapshot {
+				snaps = append(snaps, v.n)
+			}
+		}
+		assert.Equal(t, present, ns)
+		assert.Equal(t, snapshots, snaps)
+
+		for i, expected := range versions {
+			content, err := c.At("doc", i+1)
+			if contains(present, i+1) {
+				assert.NoError(t, err)
+				assert.Equal(t, expected, string(content))
+			} else {
+				assert.Equal(t, ErrNotFound, err)
+			}
+		}
+	}
+
+	t.Run("MaxVersions", func(t *testing.T) {
+		c := NewChain(kv.NewMemory(), WithSnapshotInterval(4), WithPolicy(Policy{MaxVersions: 3}))
+		fill(c)
+		check(c, []int{8, 9, 10}, []int{8})
+
+		content, v, err := c.Get("doc")
+		assert.NoError(t, err)
+		assert.Equal(t, 10, v)
+		assert.Equal(t, versions[9], string(content))
+	})
+
+	t.Run("CollapseAfter", func(t *testing.T) {
+		c := NewChain(kv.NewMemory(), WithSnapshotInterval(4))
+		fill(c)
+
+		// Nothing is old enough yet.
+		assert.NoError(t, c.Consolidate("doc", Policy{CollapseAfter: time.Hour}))
+		check(c, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []int{1, 5, 9})
+
+		assert.NoError(t, c.b.update(func(r records) error {
+			return consolidate(r, "doc", Policy{CollapseAfter: time.Hour}, time.Now().Add(2*time.Hour))
+		}))
+		check(c, []int{1, 5, 9, 10}, []int{1, 5, 9})
+	})
+
+	t.Run("SnapshotEvery", func(t *testing.T) {
+		c := NewChain(kv.NewMemory(), WithSnapshotInterval(4))
+		fill(c)
+
+		assert.NoError(t, c.Consolidate("doc", Policy{SnapshotEvery: 3}))
+		check(c, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []int{1, 4, 7, 10})
+
+		assert.NoError(t, c.Consolidate("doc", Policy{MaxVersions: 8, SnapshotEvery: 5}))
+		check(c, []int{3, 4, 5, 6, 7, 8, 9, 10}, []int{3, 8})
+	})
+}
+
+func contains(ns []int, n int) bool {
+	for _, x := range ns {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
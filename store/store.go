@@ -0,0 +1,185 @@
+// Package store keeps the revision history of documents in a single SQLite file, as
+// chains of lightpatch patches, for small applications that want durable history
+// without designing their own schema.
+//
+// Each version of a document is stored either as a snapshot of its content or as a
+// patch against the previous version. A snapshot is taken every few versions, so
+// that reading any version applies a bounded number of patches. The BLAKE3 hash of
+// every version is recorded and checked when it is read.
+//
+// Store keeps history in SQLite, and Chain in any key-value store. Both can bound
+// the growth of a history with a Policy.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver
+)
+
+// DefaultSnapshotInterval is the number of versions between snapshots.
+const DefaultSnapshotInterval = 16
+
+var (
+	// ErrNotFound is returned when a document or version isn't in the store.
+	ErrNotFound = errors.New("not found")
+
+	// ErrCorrupt is returned when a version doesn't match its recorded hash.
+	ErrCorrupt = errors.New("content hash mismatch")
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS versions (
+	key     TEXT    NOT NULL,
+	version INTEGER NOT NULL,
+	hash    BLOB    NOT NULL,
+	size    INTEGER NOT NULL,
+	created INTEGER NOT NULL,
+	PRIMARY KEY (key, version)
+);
+CREATE TABLE IF NOT EXISTS patches (
+	key      TEXT    NOT NULL,
+	version  INTEGER NOT NULL,
+	snapshot INTEGER NOT NULL,
+	data     BLOB    NOT NULL,
+	PRIMARY KEY (key, version)
+);
+`
+
+// An Option configures a Store.
+type Option func(*config)
+
+type config struct {
+	snapshotInterval int
+	policy           *Policy
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{snapshotInterval: DefaultSnapshotInterval}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.snapshotInterval < 1 {
+		cfg.snapshotInterval = 1
+	}
+	return cfg
+}
+
+// WithSnapshotInterval sets the number of versions between snapshots. Smaller
+// intervals make older versions faster to read, at the cost of a larger file.
+func WithSnapshotInterval(n int) Option {
+	return func(c *config) {
+		c.snapshotInterval = n
+	}
+}
+
+// A Store is a revision history of documents, identified by key, in a SQLite file.
+// It is safe for concurrent use.
+type Store struct {
+	history
+	db *sql.DB
+}
+
+// Open opens the store in the SQLite file at path, creating it if needed.
+func Open(path string, opts ...Option) (*Store, error) {
+	cfg := newConfig(opts)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows one writer at a time, and Put reads before it writes.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{history: history{b: sqlBackend{db}, cfg: cfg}, db: db}, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// sqlBackend keeps versions in the versions and patches tables.
+type sqlBackend struct {
+	db *sql.DB
+}
+
+func (b sqlBackend) update(fn func(r records) error) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(sqlRecords{tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b sqlBackend) view(fn func(r records) error) error {
+	return b.update(fn)
+}
+
+type sqlRecords struct {
+	tx *sql.Tx
+}
+
+func (r sqlRecords) versions(key string) ([]version, error) {
+	rows, err := r.tx.Query(`
+		SELECT version, created, hash, size, snapshot FROM versions JOIN patches USING (key, version)
+		WHERE key = ? ORDER BY version`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vs []version
+	for rows.Next() {
+		var v version
+		var created int64
+		if err := rows.Scan(&v.n, &created, &v.hash, &v.size, &v.snapshot); err != nil {
+			return nil, err
+		}
+		v.created = time.Unix(0, created)
+		vs = append(vs, v)
+	}
+	return vs, rows.Err()
+}
+
+func (r sqlRecords) data(key string, n int) ([]byte, error) {
+	var data []byte
+	err := r.tx.QueryRow(`SELECT data FROM patches WHERE key = ? AND version = ?`, key, n).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (r sqlRecords) put(key string, v version, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+	if _, err := r.tx.Exec(`INSERT OR REPLACE INTO versions (key, version, hash, size, created) VALUES (?, ?, ?, ?, ?)`,
+		key, v.n, v.hash, v.size, v.created.UnixNano()); err != nil {
+		return err
+	}
+	_, err := r.tx.Exec(`INSERT OR REPLACE INTO patches (key, version, snapshot, data) VALUES (?, ?, ?, ?)`,
+		key, v.n, v.snapshot, data)
+	return err
+}
+
+func (r sqlRecords) remove(key string, n int) error {
+	if _, err := r.tx.Exec(`DELETE FROM versions WHERE key = ? AND version = ?`, key, n); err != nil {
+		return err
+	}
+	_, err := r.tx.Exec(`DELETE FROM patches WHERE key = ? AND version = ?`, key, n)
+	return err
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.db")
+
+	s, err := Open(path, WithSnapshotInterval(4))
+	assert.NoError(t, err)
+
+	_, _, err = s.Get("doc")
+	assert.Equal(t, ErrNotFound, err)
+
+	var versions []string
+	for i := 1; i <= 10; i++ {
+		content := fmt.Sprintf("The quick brown fox jumped over the lazy dog %d times.\n", i)
+		versions = append(versions, content)
+		v, err := s.Put("doc", []byte(content))
+		assert.NoError(t, err)
+		assert.Equal(t, i, v)
+	}
+
+	// Unchanged content doesn't add a version.
+	v, err := s.Put("doc", []byte(versions[9]))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, v)
+
+	_, err = s.Put("empty", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	// The history survives reopening.
+	s, err = Open(path)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	content, v, err := s.Get("doc")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, v)
+	assert.Equal(t, versions[9], string(content))
+
+	for i, expected := range versions {
+		content, err := s.At("doc", i+1)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	content, _, err = s.Get("empty")
+	assert.NoError(t, err)
+	assert.Empty(t, content)
+
+	_, err = s.At("doc", 11)
+	assert.Equal(t, ErrNotFound, err)
+
+	// Versions between snapshots are stored as patches.
+	var patches int
+	assert.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM patches WHERE key = 'doc' AND NOT snapshot`).Scan(&patches))
+	assert.Equal(t, 7, patches)
+
+	// Damage is detected.
+	_, err = s.db.Exec(`UPDATE patches SET data = 'The quick brown cat' WHERE key = 'doc' AND version = 5`)
+	assert.NoError(t, err)
+	_, err = s.At("doc", 5)
+	assert.Equal(t, ErrCorrupt, err)
+}
@@ -0,0 +1,226 @@
+package lightpatch
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// streamWindow is the number of bytes of each input that MakePatchStream diffs at a
+// time.
+const streamWindow = 4 << 20
+
+// ErrNotStreamable is returned by MakePatchStream for an option that needs the whole
+// output or patch before the patch can be written.
+var ErrNotStreamable = errors.New("option can't be used with a streamed patch")
+
+// MakePatchStream generates a patch to change before into after, like MakePatch, for
+// inputs too large to hold in memory. The inputs are read in windows of a few
+// megabytes, each diffed within DefaultTimeout or the time set by WithTimeout, and
+// the edits in the first half of a window are written out before the window moves
+// on, so memory use is bounded and the patch is written as the inputs are read.
+// Matches are only found between parts of the inputs that are no further apart than
+// a window, so moved data and large inserts or deletes make a larger patch than
+// MakePatch would.
+//
+// The BLAKE3 and XXH3 checksums, WithBeforeChecksum, FEC envelopes, signatures and
+// WithMinSimilarity need a whole input or the patch up front, and return
+// ErrNotStreamable. The granularity and algorithm are ignored, and the patch is never
+// replaced by a naive one.
+func MakePatchStream(before, after io.Reader, patch io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	if cfg.checksum != ChecksumCRC32 || cfg.beforeChecksum || cfg.fec != nil || cfg.signingKey != nil || cfg.minSimilarity > 0 {
+		return ErrNotStreamable
+	}
+	timeout := cfg.diffTimeout(DefaultTimeout)
+	start := time.Now()
+
+	pc := &countingWriter{w: patch}
+	patch = pc
+
+	var aw *armorWriter
+	if cfg.armor != ArmorNone {
+		var err error
+		if aw, err = newArmorWriter(pc, cfg.armor); err != nil {
+			return err
+		}
+		patch = aw
+	}
+
+	zw, err := compressor(patch, cfg)
+	if err != nil {
+		return err
+	}
+	if zw != nil {
+		patch = zw
+	}
+
+	var blocks *countingWriter
+	if cfg.blockSize > 1 {
+		blocks = &countingWriter{w: patch}
+		patch = blocks
+	}
+
+	if rec := patchMetadata(cfg, timeout, false, false); rec != nil {
+		if _, err := patch.Write(rec); err != nil {
+			return err
+		}
+	}
+	if err := writeAnnotations(patch, cfg.annotations); err != nil {
+		return err
+	}
+
+	a, b := &window{r: before}, &window{r: after}
+	crc := crc32.NewIEEE()
+	cp := newCheckpointer(cfg)
+	var ops int
+	var timedOut bool
+	for {
+		if err := a.fill(); err != nil {
+			return err
+		}
+		if err := b.fill(); err != nil {
+			return err
+		}
+		final := a.eof && b.eof
+
+		diffStart := time.Now()
+		diffs := diffMain(a.buf, b.buf, timeout)
+		timedOut = timedOut || timeout > 0 && time.Since(diffStart) >= timeout
+
+		// The edits near the end of a window may change once more of the inputs is
+		// read, so they are left for the next window.
+		diffs = cutDiffs(diffs, a.limit(), b.limit())
+
+		// A copy to the end is only possible at the end of the inputs.
+		c := cfg
+		c.copyToEnd = c.copyToEnd && final
+		if err := writeRecords(patch, diffs, c, blocks, cp); err != nil {
+			return err
+		}
+		ops += len(diffs)
+
+		var n1, n2 int
+		for _, d := range diffs {
+			if d.Type != OpInsert {
+				n1 += len(d.Text)
+			}
+			if d.Type != OpDelete {
+				n2 += len(d.Text)
+				crc.Write(d.Text)
+			}
+		}
+		a.advance(n1)
+		b.advance(n2)
+
+		if final {
+			break
+		}
+	}
+
+	if err := writeTrailer(patch, crc.Sum32(), cfg, blocks); err != nil {
+		return err
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if aw != nil {
+		if err := aw.Close(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.stats != nil {
+		var warnings []Warning
+		if timedOut {
+			warnings = append(warnings, WarningTimeout)
+		}
+
+		*cfg.stats = Stats{
+			BeforeSize: a.n,
+			AfterSize:  b.n,
+			PatchSize:  pc.n,
+			Ops:        ops,
+			TimedOut:   timedOut,
+			Duration:   time.Since(start),
+			Warnings:   warnings,
+		}
+	}
+
+	return nil
+}
+
+// cutDiffs returns diffs up to the point where they reach n1 bytes of the first text
+// or n2 bytes of the second, splitting the diff that crosses it.
+func cutDiffs(diffs []diff, n1, n2 int) []diff {
+	var cut []diff
+	var a, b int
+	for _, d := range diffs {
+		n := len(d.Text)
+		if d.Type != OpInsert {
+			n = min(n, n1-a)
+		}
+		if d.Type != OpDelete {
+			n = min(n, n2-b)
+		}
+
+		if n > 0 {
+			cut = append(cut, diff{d.Type, d.Text[:n]})
+			if d.Type != OpInsert {
+				a += n
+			}
+			if d.Type != OpDelete {
+				b += n
+			}
+		}
+		if n < len(d.Text) {
+			break
+		}
+	}
+	return cut
+}
+
+// A window holds the next bytes of an input being streamed.
+type window struct {
+	r   io.Reader
+	buf []byte
+	eof bool
+	n   int // Bytes read so far
+}
+
+// fill reads from the input until the window is full or the input ends.
+func (w *window) fill() error {
+	if w.buf == nil {
+		w.buf = make([]byte, 0, streamWindow)
+	}
+	if w.eof {
+		return nil
+	}
+
+	n, err := io.ReadFull(w.r, w.buf[len(w.buf):cap(w.buf)])
+	w.buf = w.buf[:len(w.buf)+n]
+	w.n += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		w.eof = true
+		return nil
+	}
+	return err
+}
+
+// limit returns how much of the window may be written out: half of it, or all of it
+// once the input has ended.
+func (w *window) limit() int {
+	if w.eof {
+		return len(w.buf)
+	}
+	return len(w.buf) / 2
+}
+
+// advance drops the first n bytes of the window.
+func (w *window) advance(n int) {
+	w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+}
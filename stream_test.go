@@ -0,0 +1,72 @@
+package lightpatch
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakePatchStream(t *testing.T) {
+	// Inputs spanning several windows, with edits at and across their boundaries.
+	before := make([]byte, 3*streamWindow+12345)
+	rand.New(rand.NewSource(1)).Read(before)
+	after := append([]byte(nil), before[:100]...)
+	after = append(after, "inserted"...)
+	after = append(after, before[200:streamWindow-10]...)
+	after = append(after, bytes.Repeat([]byte("x"), 5000)...)
+	after = append(after, before[streamWindow+20:2*streamWindow]...)
+	after = append(after, before[2*streamWindow+100000:]...)
+	after = append(after, "the end"...)
+
+	var patch bytes.Buffer
+	var stats Stats
+	assert.NoError(t, MakePatchStream(bytes.NewReader(before), bytes.NewReader(after), &patch, WithStats(&stats)))
+	assert.Less(t, patch.Len(), 6000)
+	assert.Equal(t, len(before), stats.BeforeSize)
+	assert.Equal(t, len(after), stats.AfterSize)
+	assert.Equal(t, patch.Len(), stats.PatchSize)
+
+	var out bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(before), &patch, &out))
+	assert.True(t, bytes.Equal(after, out.Bytes()))
+
+	// Small inputs, with options
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+	for _, opts := range [][]Option{
+		nil,
+		{WithArmor(ArmorBase64), WithAnnotation([]byte("streamed"))},
+		{WithReplace(), WithCopyToEnd(), WithInsertChecksums()},
+		{WithBlockAlign(16), WithProvenance()},
+	} {
+		patch.Reset()
+		assert.NoError(t, MakePatchStream(bytes.NewReader(a), bytes.NewReader(b), &patch, opts...))
+		out.Reset()
+		assert.NoError(t, ApplyPatch(bytes.NewReader(a), &patch, &out))
+		assert.Equal(t, b, out.Bytes())
+	}
+
+	patch.Reset()
+	assert.NoError(t, MakePatchStream(bytes.NewReader(nil), bytes.NewReader(nil), &patch))
+	assert.Equal(t, []byte{OpCRC, 0, 0, 0, 0}, patch.Bytes())
+
+	for _, opt := range []Option{WithChecksum(ChecksumBLAKE3), WithFEC(2, 1), WithMinSimilarity(0.5)} {
+		err := MakePatchStream(bytes.NewReader(a), bytes.NewReader(b), new(bytes.Buffer), opt)
+		assert.Equal(t, ErrNotStreamable, err)
+	}
+}
+
+func TestCutDiffs(t *testing.T) {
+	diffs := []diff{
+		{OpCopy, []byte("abc")},
+		{OpDelete, []byte("de")},
+		{OpInsert, []byte("xyz")},
+		{OpCopy, []byte("fgh")},
+	}
+	assert.Equal(t, diffs, cutDiffs(diffs, 8, 9))
+	assert.Equal(t, []diff{{OpCopy, []byte("abc")}, {OpDelete, []byte("d")}}, cutDiffs(diffs, 4, 9))
+	assert.Equal(t, []diff{{OpCopy, []byte("abc")}, {OpDelete, []byte("de")}, {OpInsert, []byte("x")}}, cutDiffs(diffs, 8, 4))
+	assert.Equal(t, diffs[:3], cutDiffs(diffs, 5, 6))
+}
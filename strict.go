@@ -0,0 +1,56 @@
+package lightpatch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotStrict is wrapped by the errors returned by ApplyPatch in strict mode for a
+// patch that would apply, but isn't in the form MakePatch writes.
+var ErrNotStrict = errors.New("patch fails strict checks")
+
+// WithStrict causes ApplyPatch to refuse patches that an honest MakePatch wouldn't
+// write, for services that accept patches from untrusted clients. In strict mode,
+// ApplyPatch enforces the expiry time of the patch, failing with ErrExpired once it
+// has passed, and fails with an error wrapping ErrNotStrict for a patch with a copy,
+// insert, delete, replace or chunk of zero bytes, a patch without a checksum of the
+// output, or one that reads before but leaves some of it unread. A naive patch,
+// which only inserts, needn't read before. Data following the final CRC is refused
+// in any mode.
+func WithStrict() Option {
+	return func(c *config) {
+		c.strict = true
+	}
+}
+
+// checkStrictLength returns an error if a record of op must not have a length of l
+// in strict mode.
+func checkStrictLength(op byte, l uint64) error {
+	if l > 0 {
+		return nil
+	}
+	switch op {
+	case OpCopy, OpInsert, OpCheckedInsert, OpDelete, OpReplace, OpChunk:
+		return fmt.Errorf("%w: empty %q record", ErrNotStrict, op)
+	}
+	return nil
+}
+
+// checkStrictEnd returns an error if a patch that has ended, with a checksum of its
+// output if checked is true, has no checksum, or read some but not all of before.
+func checkStrictEnd(before beforeSource, checked bool) error {
+	if !checked {
+		return fmt.Errorf("%w: no checksum of the output", ErrNotStrict)
+	}
+	if before.read() == 0 {
+		return nil
+	}
+	more, err := before.more()
+	if err != nil {
+		return err
+	}
+	if more {
+		return fmt.Errorf("%w: before has bytes the patch doesn't read", ErrNotStrict)
+	}
+	return nil
+}
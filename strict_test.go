@@ -0,0 +1,74 @@
+package lightpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrict(t *testing.T) {
+	fox := "The quick brown fox jumped over the lazy dog."
+
+	// Patches made by MakePatch apply in strict mode.
+	for _, tc := range [][2]string{
+		{"", ""},
+		{"", "abc"},
+		{"abc", ""},
+		{strings.Repeat(fox, 10), ""},
+		{fox, fox},
+		{fox, "The quick brown cat jumped over the dog!"},
+		{fox, "Something else entirely"},
+	} {
+		for _, opts := range [][]Option{
+			nil,
+			{WithReplace(), WithCopyToEnd()},
+			{WithChecksum(ChecksumBLAKE3), WithInsertChecksums()},
+			{WithBlockAlign(16), WithCheckpoints(8), WithEditContext(4)},
+		} {
+			var patch, out bytes.Buffer
+			assert.NoError(t, MakePatch(bytes.NewReader([]byte(tc[0])), bytes.NewReader([]byte(tc[1])), &patch, opts...))
+			p := patch.Bytes()
+			assert.NoError(t, ApplyPatch(bytes.NewReader([]byte(tc[0])), bytes.NewReader(p), &out, WithStrict()))
+			assert.Equal(t, tc[1], out.String())
+			out.Reset()
+			assert.NoError(t, ApplyPatchAt(bytes.NewReader([]byte(tc[0])), p, &out, WithStrict()))
+			assert.Equal(t, tc[1], out.String())
+		}
+	}
+
+	// record returns a patch of the records given and the CRC of out.
+	record := func(out string, recs ...string) []byte {
+		var patch []byte
+		for _, r := range recs {
+			patch = append(patch, r...)
+		}
+		patch = append(patch, OpCRC, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(patch[len(patch)-4:], crc32.ChecksumIEEE([]byte(out)))
+		return patch
+	}
+
+	for _, tc := range []struct {
+		before string
+		patch  []byte
+	}{
+		{"abc", record("abc", "C\x00", "C\x03")},
+		{"abc", record("abcd", "C\x03", "I\x00", "I\x01d")},
+		{"abc", record("bc", "D\x00", "D\x01", "C\x02")},
+		{"abc", record("xbc", "R\x01\x00", "I\x01x", "C\x02")},
+		{"abc", record("ab", "C\x02")},
+		{"abc", []byte("C\x03")},
+	} {
+		var out bytes.Buffer
+		assert.NoError(t, ApplyPatch(bytes.NewReader([]byte(tc.before)), bytes.NewReader(tc.patch), &out))
+
+		err := ApplyPatch(bytes.NewReader([]byte(tc.before)), bytes.NewReader(tc.patch), new(bytes.Buffer), WithStrict())
+		assert.True(t, errors.Is(err, ErrNotStrict), err)
+		err = ApplyPatchAt(bytes.NewReader([]byte(tc.before)), tc.patch, new(bytes.Buffer), WithStrict())
+		assert.True(t, errors.Is(err, ErrNotStrict), err)
+	}
+}
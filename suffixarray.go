@@ -0,0 +1,139 @@
+package lightpatch
+
+import (
+	"bytes"
+	"time"
+)
+
+// saMinMatch is the shortest match the suffix array matcher will use as an anchor.
+// Shorter matches are left to the byte-level differ working on the gaps.
+const saMinMatch = 16
+
+// diffSuffixArray finds long matches of after in before using a suffix array, in the
+// spirit of bsdiff, and diffs only the gaps between them. Unlike bisect, the cost does
+// not grow with the number of edits, which suits binaries with many scattered changes.
+func diffSuffixArray(text1, text2 []byte, timeout time.Duration) []diff {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	return diffAnchors(text1, text2, suffixArrayMatches(text1, text2, deadline), deadline)
+}
+
+// suffixArrayMatches greedily scans text2 for the longest matches in text1.
+func suffixArrayMatches(text1, text2 []byte, deadline time.Time) []anchor {
+	if len(text1) < saMinMatch || len(text2) < saMinMatch {
+		return nil
+	}
+
+	sa := suffixArray(text1)
+
+	var anchors []anchor
+	for b := 0; b+saMinMatch <= len(text2); {
+		if !deadline.IsZero() && b%1024 == 0 && time.Now().After(deadline) {
+			break
+		}
+
+		a, n := saSearch(sa, text1, text2[b:])
+		if n < saMinMatch {
+			b++
+			continue
+		}
+		anchors = append(anchors, anchor{a: a, b: b, n: n})
+		b += n
+	}
+
+	return anchors
+}
+
+// saSearch returns the offset and length of the longest prefix of s found in text.
+func saSearch(sa []int, text, s []byte) (int, int) {
+	lo, hi := 0, len(sa)-1
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if bytes.Compare(text[sa[mid]:], s) < 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	nlo := commonPrefixLength(text[sa[lo]:], s)
+	nhi := commonPrefixLength(text[sa[hi]:], s)
+	if nlo > nhi {
+		return sa[lo], nlo
+	}
+	return sa[hi], nhi
+}
+
+// suffixArray returns the sorted suffix offsets of text, built by prefix doubling
+// with radix sorted rank pairs.
+func suffixArray(text []byte) []int {
+	n := len(text)
+	if n == 0 {
+		return nil
+	}
+
+	sa := make([]int, n)
+	rank := make([]int, n)
+	tmp := make([]int, n)
+
+	for i := range sa {
+		sa[i] = i
+		rank[i] = int(text[i])
+	}
+
+	// Keys are a byte value or a rank plus one.
+	buckets := 257
+	if n+1 > buckets {
+		buckets = n + 1
+	}
+	count := make([]int, buckets+1)
+
+	for k := 1; ; k <<= 1 {
+		second := func(i int) int {
+			if i+k < n {
+				return rank[i+k] + 1
+			}
+			return 0
+		}
+
+		// Stable counting sort by the second key, then by the first.
+		countingSort(sa, tmp, count, second)
+		countingSort(tmp, sa, count, func(i int) int { return rank[i] })
+
+		tmp[sa[0]] = 0
+		for i := 1; i < n; i++ {
+			tmp[sa[i]] = tmp[sa[i-1]]
+			if rank[sa[i]] != rank[sa[i-1]] || second(sa[i]) != second(sa[i-1]) {
+				tmp[sa[i]]++
+			}
+		}
+		rank, tmp = tmp, rank
+
+		if rank[sa[n-1]] == n-1 {
+			break
+		}
+	}
+
+	return sa
+}
+
+// countingSort stably sorts src into dst by key, which must be less than len(count)-1.
+func countingSort(src, dst, count []int, key func(int) int) {
+	for i := range count {
+		count[i] = 0
+	}
+	for _, v := range src {
+		count[key(v)+1]++
+	}
+	for i := 1; i < len(count); i++ {
+		count[i] += count[i-1]
+	}
+	for _, v := range src {
+		k := key(v)
+		dst[count[k]] = v
+		count[k]++
+	}
+}
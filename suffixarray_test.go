@@ -0,0 +1,32 @@
+package lightpatch
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuffixArray(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"a",
+		"banana",
+		"mississippi",
+		"aaaaaaaaaaaaaaaa",
+		"The quick brown fox jumped over the lazy dog.",
+	} {
+		expected := make([]int, len(text))
+		for i := range expected {
+			expected[i] = i
+		}
+		sort.Slice(expected, func(i, j int) bool {
+			return text[expected[i]:] < text[expected[j]:]
+		})
+		if len(expected) == 0 {
+			expected = nil
+		}
+
+		assert.Equal(t, expected, suffixArray([]byte(text)), text)
+	}
+}
@@ -0,0 +1,22 @@
+package lightpatch
+
+import "errors"
+
+// errCopyToEnd is returned where the length of a copy to the end is needed but
+// before isn't available.
+var errCopyToEnd = errors.New("patch copies to the end of before, whose length is unknown")
+
+// WithCopyToEnd causes MakePatch to write a final copy as a copy to the end, which
+// saves its length and lets the patch apply to a before that has since grown at
+// the end, if the output checksum is ignored or the patch is checked by other
+// means.
+func WithCopyToEnd() Option {
+	return func(c *config) {
+		c.copyToEnd = true
+	}
+}
+
+// isCopyToEnd reports whether diffs[i] is written as a copy to the end.
+func isCopyToEnd(diffs []diff, i int, cfg config) bool {
+	return cfg.copyToEnd && i == len(diffs)-1 && diffs[i].Type == OpCopy
+}
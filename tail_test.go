@@ -0,0 +1,45 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyToEnd(t *testing.T) {
+	a := []byte(strings.Repeat("The quick brown fox jumped over the lazy dog. ", 20))
+	b := []byte("Preface. " + string(a))
+
+	var plain, patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &plain))
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithCopyToEnd()))
+	assert.Equal(t, plain.Len()-2, patch.Len())
+	assert.Equal(t, OpCopyToEnd, patch.Bytes()[patch.Len()-6])
+
+	var after bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &after))
+	assert.Equal(t, b, after.Bytes())
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patch.Bytes())))
+
+	edits, err := Edits(a, patch.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, []Edit{{OpInsert, []byte("Preface. ")}, {OpCopy, a}}, edits)
+
+	var text, bin bytes.Buffer
+	assert.NoError(t, ToText(bytes.NewReader(patch.Bytes()), &text))
+	assert.Contains(t, text.String(), "\nT\n")
+	assert.NoError(t, ToBinary(&text, &bin))
+	assert.Equal(t, patch.Bytes(), bin.Bytes())
+
+	// The edits apply to a before that has grown, although the output no longer
+	// matches the checksum.
+	after.Reset()
+	err = ApplyPatch(bytes.NewReader(append(a, "More."...)), bytes.NewReader(patch.Bytes()), &after)
+	assert.Equal(t, ErrCRC, err)
+	assert.Equal(t, string(b)+"More.", after.String())
+
+	err = ComposePatches(bytes.NewReader(patch.Bytes()), bytes.NewReader(plain.Bytes()), new(bytes.Buffer))
+	assert.Equal(t, errCopyToEnd, err)
+}
@@ -0,0 +1,228 @@
+package lightpatch
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// tarMagic starts every tar patch.
+	tarMagic = "LPA1"
+
+	// tarBlockSize is the size of the blocks of a tar archive.
+	tarBlockSize = 512
+)
+
+// The ops of the records of a tar patch.
+const (
+	tarMember  byte = 'M'
+	tarTrailer byte = 'T'
+)
+
+var (
+	// ErrNotTar is wrapped by the error returned by MakeTarPatch and ApplyTarPatch
+	// when an input isn't a tar archive.
+	ErrNotTar = errors.New("not a tar archive")
+
+	// ErrNotTarPatch is returned by ApplyTarPatch for a patch that doesn't start with
+	// the tar patch header.
+	ErrNotTarPatch = errors.New("not a tar patch")
+)
+
+// A tarEntry is the bytes of a member of a tar archive: its header blocks, content
+// and padding.
+type tarEntry struct {
+	name string
+	data []byte
+}
+
+// MakeTarPatch writes a patch that turns the tar archive in before into the one in
+// after, member by member. A byte patch of a tar archive copes badly with members that
+// have moved, and with changes to their headers; instead, each member of after is
+// diffed against the member of before with the same name, wherever it is, with the
+// options given. Members new to after are diffed against nothing, so stored whole.
+// If a name occurs more than once, the occurrences are matched in order. Stats
+// requested with WithStats are the totals over the members.
+//
+// A tar patch is a header followed by a record for each member of after, in order,
+// and a final record for the end-of-archive blocks and anything following them. The
+// records have the same form as those of a tree patch (see MakeTreePatch), with the
+// member name as the path and its patch as the data.
+func MakeTarPatch(before, after io.Reader, patch io.Writer, opts ...Option) error {
+	a, aTrailer, err := readTar(before)
+	if err != nil {
+		return err
+	}
+	b, bTrailer, err := readTar(after)
+	if err != nil {
+		return err
+	}
+
+	match := newTarMatcher(a)
+	jobs := make([]PatchJob, len(b)+1)
+	for i, m := range b {
+		jobs[i] = PatchJob{
+			Before:  bytes.NewReader(match.take(m.name)),
+			After:   bytes.NewReader(m.data),
+			Patch:   new(bytes.Buffer),
+			Options: opts,
+		}
+	}
+	jobs[len(b)] = PatchJob{
+		Before:  bytes.NewReader(aTrailer),
+		After:   bytes.NewReader(bTrailer),
+		Patch:   new(bytes.Buffer),
+		Options: opts,
+	}
+
+	start := time.Now()
+	var total Stats
+	pc := &countingWriter{w: patch}
+	bw := bufio.NewWriter(pc)
+	bw.WriteString(tarMagic)
+	for i, res := range MakePatchBatch(context.Background(), jobs, 0) {
+		op, name := tarTrailer, ""
+		if i < len(b) {
+			op, name = tarMember, b[i].name
+		}
+		if res.Err != nil {
+			return fmt.Errorf("%s: %w", name, res.Err)
+		}
+		writeTreeRecord(bw, op, name, jobs[i].Patch.(*bytes.Buffer).Bytes())
+		total.Ops += res.Stats.Ops
+		total.TimedOut = total.TimedOut || res.Stats.TimedOut
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if s := newConfig(opts).stats; s != nil {
+		total.BeforeSize = len(aTrailer)
+		for _, m := range a {
+			total.BeforeSize += len(m.data)
+		}
+		total.AfterSize = len(bTrailer)
+		for _, m := range b {
+			total.AfterSize += len(m.data)
+		}
+		total.PatchSize = pc.n
+		total.Duration = time.Since(start)
+		if total.TimedOut {
+			total.Warnings = []Warning{WarningTimeout}
+		}
+		*s = total
+	}
+	return nil
+}
+
+// ApplyTarPatch applies a patch made by MakeTarPatch to the tar archive in before,
+// writing the resulting archive to after, with the options given to ApplyPatch. The
+// patch of each member is checked as it is applied, so the output may be incomplete if
+// it returns an error.
+func ApplyTarPatch(before, patch io.Reader, after io.Writer, opts ...Option) error {
+	a, aTrailer, err := readTar(before)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(patch)
+	if err := readMagic(br, tarMagic, ErrNotTarPatch); err != nil {
+		return err
+	}
+
+	match := newTarMatcher(a)
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		if op != tarMember && op != tarTrailer {
+			return unexpectedOp(op)
+		}
+		name, data, err := readTreeRecord(br)
+		if err != nil {
+			return err
+		}
+
+		base := aTrailer
+		if op == tarMember {
+			base = match.take(name)
+		}
+		if err := ApplyPatch(bytes.NewReader(base), bytes.NewReader(data), after, opts...); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if op == tarTrailer {
+			if _, err := br.ReadByte(); err != io.EOF {
+				return ErrExtraData
+			}
+			return nil
+		}
+	}
+}
+
+// readTar reads a tar archive and splits it into its members, returning them with
+// the trailer that follows the last: the end-of-archive blocks and anything after
+// them. Together they are all of the archive.
+func readTar(r io.Reader) ([]tarEntry, []byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cr := &countingReader{r: bytes.NewReader(data)}
+	tr := tar.NewReader(cr)
+	var members []tarEntry
+	var start int
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrNotTar, err)
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrNotTar, err)
+		}
+
+		// The member ends with the padding of its content to a whole block.
+		end := int(cr.n+tarBlockSize-1) / tarBlockSize * tarBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		members = append(members, tarEntry{name: hdr.Name, data: data[start:end]})
+		start = end
+	}
+	return members, data[start:], nil
+}
+
+// A tarMatcher matches members of after to the members of before with the same name,
+// in order.
+type tarMatcher map[string][][]byte
+
+func newTarMatcher(members []tarEntry) tarMatcher {
+	m := tarMatcher{}
+	for _, member := range members {
+		m[member.name] = append(m[member.name], member.data)
+	}
+	return m
+}
+
+// take returns the next unmatched member named name, or nil if there is none.
+func (m tarMatcher) take(name string) []byte {
+	data := m[name]
+	if len(data) == 0 {
+		return nil
+	}
+	m[name] = data[1:]
+	return data[0]
+}
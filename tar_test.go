@@ -0,0 +1,111 @@
+package lightpatch
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tarFile struct {
+	name, content string
+	modTime       time.Time
+}
+
+func makeTar(t *testing.T, files ...tarFile) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    0644,
+			Size:    int64(len(f.content)),
+			ModTime: f.modTime,
+		}))
+		_, err := tw.Write([]byte(f.content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestTarPatch(t *testing.T) {
+	t1 := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	fox := strings.Repeat("The quick brown fox jumped over the lazy dog. ", 50)
+	lorem := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 50)
+
+	before := makeTar(t,
+		tarFile{"a.txt", fox, t1},
+		tarFile{"b.txt", lorem, t1},
+		tarFile{"c.txt", "removed", t1},
+		tarFile{"dup", "first", t1},
+		tarFile{"dup", "second", t1},
+	)
+	after := makeTar(t,
+		tarFile{"b.txt", lorem, t2},
+		tarFile{"new.txt", "added", t2},
+		tarFile{"a.txt", strings.Replace(fox, "fox", "cat", 1), t2},
+		tarFile{"dup", "first!", t1},
+		tarFile{"dup", "second", t1},
+	)
+
+	var patch bytes.Buffer
+	var stats Stats
+	assert.NoError(t, MakeTarPatch(bytes.NewReader(before), bytes.NewReader(after), &patch, WithStats(&stats)))
+	assert.Equal(t, len(before), stats.BeforeSize)
+	assert.Equal(t, len(after), stats.AfterSize)
+	assert.Equal(t, patch.Len(), stats.PatchSize)
+
+	var out bytes.Buffer
+	assert.NoError(t, ApplyTarPatch(bytes.NewReader(before), bytes.NewReader(patch.Bytes()), &out))
+	assert.Equal(t, after, out.Bytes())
+
+	// Moving the members doesn't cost a copy of them, as it does in a byte patch.
+	var bytePatch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &bytePatch))
+	assert.Less(t, patch.Len(), bytePatch.Len()/2)
+
+	// The members of a patch each check their own part of the output.
+	changed := append([]byte{}, before...)
+	copy(changed[tarBlockSize:], "The slow")
+	err := ApplyTarPatch(bytes.NewReader(changed), bytes.NewReader(patch.Bytes()), ioutil.Discard)
+	assert.True(t, errors.Is(err, ErrCRC), err)
+	assert.True(t, strings.HasPrefix(err.Error(), "a.txt: "), err)
+}
+
+func TestTarPatchErrors(t *testing.T) {
+	archive := makeTar(t, tarFile{"a.txt", "hello", time.Time{}})
+
+	err := MakeTarPatch(strings.NewReader("not a tar archive"), bytes.NewReader(archive), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrNotTar), err)
+	err = MakeTarPatch(bytes.NewReader(archive), bytes.NewReader(archive[:tarBlockSize+2]), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrNotTar), err)
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(archive), bytes.NewReader(archive), &patch))
+	err = ApplyTarPatch(bytes.NewReader(archive), &patch, new(bytes.Buffer))
+	assert.Equal(t, ErrNotTarPatch, err)
+	err = ApplyTarPatch(bytes.NewReader(archive), strings.NewReader("LPA2"), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrUnknownVersion), err)
+
+	patch.Reset()
+	assert.NoError(t, MakeTarPatch(bytes.NewReader(archive), bytes.NewReader(archive), &patch))
+	p := patch.Bytes()
+
+	err = ApplyTarPatch(bytes.NewReader(archive), bytes.NewReader(p[:len(p)-1]), new(bytes.Buffer))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	err = ApplyTarPatch(bytes.NewReader(archive), bytes.NewReader(append(p, 'M')), new(bytes.Buffer))
+	assert.Equal(t, ErrExtraData, err)
+
+	bad := append([]byte(tarMagic), 'W')
+	err = ApplyTarPatch(bytes.NewReader(archive), bytes.NewReader(bad), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrMalformed), err)
+}
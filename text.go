@@ -0,0 +1,342 @@
+package lightpatch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The text format is a line-per-op rendering of the same op stream as the binary
+// format, for reading and editing patches by hand. Each line is an op letter
+// followed by its arguments:
+//
+//	C <len>                     copy
+//	D <len>                     delete
+//	R <len> <data>              replace
+//	H <len> <digest>            chunk reference
+//	Z <len>                     padding
+//	I <data>                    insert
+//	Q <data> <crc>              checked insert
+//	N <data>                    annotation
+//	M [<key> <value>]...        metadata
+//	L <prefix> <data> <suffix>  context of a change, and the data it deletes
+//	T                           copy to the end
+//	P <checksum>                checksum of the output so far
+//	S <crc>                     CRC-32 of before
+//	K <crc>                     CRC-32 of the output
+//	B <digest>                  BLAKE3 digest of the output
+//	X <digest>                  XXH3 digest of the output
+//
+// data, keys and values are Go quoted strings, and checksums are lowercase hex.
+// A bare patch converts into the text format and back without loss with ToText and
+// ToBinary, but ToText removes envelopes, such as gzip and signatures, and armor.
+
+var errTextSyntax = errors.New("invalid text patch")
+
+// ToText converts a binary patch to the text format.
+func ToText(patch io.Reader, text io.Writer) error {
+	patch, err := openPatch(patch)
+	if err != nil {
+		return err
+	}
+
+	patchBR := bufio.NewReader(patch)
+	w := bufio.NewWriter(text)
+
+	for first := true; ; first = false {
+		op, err := patchBR.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch op {
+		case OpBLAKE3, OpXXH3:
+			if !first {
+				return unexpectedOp(op)
+			}
+			digest := make([]byte, digestSize(op))
+			if _, err := io.ReadFull(patchBR, digest); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%c %x\n", op, digest)
+			continue
+		case OpCRC, OpBeforeCRC:
+			crc := make([]byte, 4)
+			if _, err := io.ReadFull(patchBR, crc); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%c %x\n", op, crc)
+			continue
+		case OpCopyToEnd:
+			w.WriteString("T\n")
+			continue
+		}
+
+		tl, err := readLength(patchBR)
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case OpCopy, OpDelete:
+			fmt.Fprintf(w, "%c %d\n", op, tl)
+			continue
+		case OpReplace:
+			il, err := readLength(patchBR)
+			if err != nil {
+				return err
+			}
+			data := new(strings.Builder)
+			if _, err := io.CopyN(data, patchBR, int64(il)); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "R %d %s\n", tl, strconv.Quote(data.String()))
+			continue
+		case OpPadding:
+			if _, err := patchBR.Discard(int(tl)); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Z %d\n", tl)
+			continue
+		case OpChunk:
+			digest := make([]byte, blake3Size)
+			if _, err := io.ReadFull(patchBR, digest); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "H %d %x\n", tl, digest)
+			continue
+		case OpCheckpoint:
+			sum := new(strings.Builder)
+			if _, err := io.CopyN(sum, patchBR, int64(tl)); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "P %x\n", sum.String())
+			continue
+		case OpContext:
+			fields, err := readContext(patchBR, tl)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "L %s %s %s\n", strconv.Quote(string(fields[0])), strconv.Quote(string(fields[1])), strconv.Quote(string(fields[2])))
+			continue
+		case OpInsert, OpCheckedInsert, OpAnnotation, OpMetadata:
+		default:
+			return unexpectedOp(op)
+		}
+
+		data := new(strings.Builder)
+		if _, err := io.CopyN(data, patchBR, int64(tl)); err != nil {
+			return err
+		}
+
+		switch op {
+		case OpCheckedInsert:
+			crc := make([]byte, 4)
+			if _, err := io.ReadFull(patchBR, crc); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Q %s %x\n", strconv.Quote(data.String()), crc)
+		case OpMetadata:
+			fields, err := metadataFields([]byte(data.String()))
+			if err != nil {
+				return err
+			}
+			w.WriteByte(OpMetadata)
+			for _, f := range fields {
+				fmt.Fprintf(w, " %s", strconv.Quote(f))
+			}
+			w.WriteByte('\n')
+		default:
+			fmt.Fprintf(w, "%c %s\n", op, strconv.Quote(data.String()))
+		}
+	}
+
+	return w.Flush()
+}
+
+// ToBinary converts a patch in the text format to the binary format.
+func ToBinary(text io.Reader, patch io.Writer) error {
+	s := bufio.NewScanner(text)
+	s.Buffer(nil, 1<<30)
+	w := bufio.NewWriter(patch)
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+
+	writeRecord := func(op byte, data []byte) {
+		n := binary.PutUvarint(varintBuf, uint64(len(data)))
+		w.WriteByte(op)
+		w.Write(varintBuf[:n])
+		w.Write(data)
+	}
+
+	for line := 1; s.Scan(); line++ {
+		if len(s.Text()) == 0 {
+			continue
+		}
+
+		op, args := s.Text()[0], strings.TrimPrefix(s.Text()[1:], " ")
+		var err error
+
+		switch op {
+		case OpCopy, OpDelete:
+			var n uint64
+			if n, err = strconv.ParseUint(args, 10, 64); err == nil {
+				w.WriteByte(op)
+				w.Write(varintBuf[:binary.PutUvarint(varintBuf, n)])
+			}
+		case OpReplace:
+			fields := strings.SplitN(args, " ", 2)
+			var n uint64
+			var data []string
+			if len(fields) != 2 {
+				err = errors.New("expected length and data")
+			} else if n, err = strconv.ParseUint(fields[0], 10, 64); err == nil {
+				if data, err = quotedFields(fields[1], 1); err == nil {
+					w.Write(replaceRecord(int(n), []byte(data[0])))
+					w.WriteString(data[0])
+				}
+			}
+		case OpCopyToEnd:
+			if args != "" {
+				err = errors.New("unexpected arguments")
+			} else {
+				w.WriteByte(op)
+			}
+		case OpPadding:
+			var n uint64
+			if n, err = strconv.ParseUint(args, 10, 64); err == nil {
+				writeRecord(op, make([]byte, n))
+			}
+		case OpChunk:
+			fields := strings.SplitN(args, " ", 2)
+			var n uint64
+			var digest []byte
+			if len(fields) != 2 {
+				err = errors.New("expected length and digest")
+			} else if n, err = strconv.ParseUint(fields[0], 10, 64); err == nil {
+				if digest, err = hexField(" "+fields[1], blake3Size); err == nil {
+					w.WriteByte(op)
+					w.Write(varintBuf[:binary.PutUvarint(varintBuf, n)])
+					w.Write(digest)
+				}
+			}
+		case OpCheckpoint:
+			var sum []byte
+			if sum, err = hex.DecodeString(args); err == nil {
+				writeRecord(op, sum)
+			}
+		case OpInsert, OpAnnotation:
+			var data []string
+			if data, err = quotedFields(args, 1); err == nil {
+				writeRecord(op, []byte(data[0]))
+			}
+		case OpCheckedInsert:
+			data, rest, qerr := splitQuoted(args)
+			var crc []byte
+			if err = qerr; err == nil {
+				if crc, err = hexField(rest, 4); err == nil {
+					writeRecord(op, []byte(data))
+					w.Write(crc)
+				}
+			}
+		case OpMetadata:
+			var fields []string
+			if fields, err = quotedFields(args, -1); err == nil && len(fields)%2 != 0 {
+				err = errBadMetadata
+			}
+			if err == nil {
+				var body []byte
+				for _, f := range fields {
+					body = appendString(body, f)
+				}
+				writeRecord(op, body)
+			}
+		case OpContext:
+			var fields []string
+			if fields, err = quotedFields(args, 3); err == nil {
+				var body []byte
+				for _, f := range fields {
+					body = appendString(body, f)
+				}
+				writeRecord(op, body)
+			}
+		case OpCRC, OpBeforeCRC, OpBLAKE3, OpXXH3:
+			size := digestSize(op)
+			if size == 0 {
+				size = 4
+			}
+			var sum []byte
+			if sum, err = hexField(" "+args, size); err == nil {
+				w.WriteByte(op)
+				w.Write(sum)
+			}
+		default:
+			err = fmt.Errorf("unexpected operation %q", op)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%v: line %d: %v", errTextSyntax, line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// quotedFields parses a space separated list of quoted strings. If n is not
+// negative, exactly n are expected.
+func quotedFields(s string, n int) ([]string, error) {
+	var fields []string
+	for s != "" {
+		if len(fields) > 0 {
+			if s[0] != ' ' {
+				return nil, errors.New("expected space")
+			}
+			s = s[1:]
+		}
+		f, rest, err := splitQuoted(s)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		s = rest
+	}
+	if n >= 0 && len(fields) != n {
+		return nil, fmt.Errorf("expected %d quoted strings, found %d", n, len(fields))
+	}
+	return fields, nil
+}
+
+// splitQuoted unquotes the Go quoted string at the start of s, returning it and the
+// remainder of s.
+func splitQuoted(s string) (string, string, error) {
+	if len(s) == 0 || s[0] != '"' {
+		return "", "", errors.New("expected quoted string")
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			return v, s[i+1:], err
+		}
+	}
+	return "", "", errors.New("unterminated quoted string")
+}
+
+// hexField decodes s, which must be a space followed by size hex encoded bytes.
+func hexField(s string, size int) ([]byte, error) {
+	if len(s) != 1+2*size || s[0] != ' ' {
+		return nil, fmt.Errorf("expected %d hex bytes", size)
+	}
+	return hex.DecodeString(s[1:])
+}
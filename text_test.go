@@ -0,0 +1,68 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextFormat(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog")
+	b := []byte("The quick brown fox leaped over the lazy dog.")
+
+	var patch bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch)
+	assert.NoError(t, err)
+
+	var text bytes.Buffer
+	assert.NoError(t, ToText(bytes.NewReader(patch.Bytes()), &text))
+	assert.Equal(t, `C 20
+D 3
+I "lea"
+C 21
+I "."
+K 96f6b76c
+`, text.String())
+
+	var bin bytes.Buffer
+	assert.NoError(t, ToBinary(&text, &bin))
+	assert.Equal(t, patch.Bytes(), bin.Bytes())
+
+	t.Run("all ops", func(t *testing.T) {
+		var patch bytes.Buffer
+		err := MakePatch(bytes.NewReader(a), bytes.NewReader(append(b, 0, '"', 0xff, '\n')), &patch,
+			WithChecksum(ChecksumBLAKE3),
+			WithProvenance(),
+			WithAnnotation([]byte("job \"123\"")),
+			WithInsertChecksums(),
+		)
+		assert.NoError(t, err)
+
+		var text bytes.Buffer
+		assert.NoError(t, ToText(bytes.NewReader(patch.Bytes()), &text))
+		for _, op := range []string{"B ", "M ", "N ", "Q ", "C ", "D "} {
+			assert.Contains(t, "\n"+text.String(), "\n"+op)
+		}
+
+		var bin bytes.Buffer
+		assert.NoError(t, ToBinary(&text, &bin))
+		assert.Equal(t, patch.Bytes(), bin.Bytes())
+	})
+
+	t.Run("syntax errors", func(t *testing.T) {
+		for _, text := range []string{
+			"C x\n",
+			"I lea\n",
+			"I \"lea\" \"x\"\n",
+			"Q \"lea\"\n",
+			"M \"key\"\n",
+			"K 96f6\n",
+			"X 1\n",
+		} {
+			err := ToBinary(strings.NewReader(text), new(bytes.Buffer))
+			assert.Error(t, err, text)
+		}
+	})
+}
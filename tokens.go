@@ -0,0 +1,413 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Granularity selects the unit that MakePatch diffs by.
+type Granularity int
+
+const (
+	// GranularityByte diffs individual bytes, with the selected algorithm. It
+	// produces the smallest patches and is the default.
+	GranularityByte Granularity = iota
+
+	// GranularitySentence diffs whole sentences, so that every edit replaces
+	// complete sentences. This matches how prose is usually edited, and gives
+	// patches that are easier to read and that stay valid when unrelated
+	// sentences change.
+	GranularitySentence
+
+	// GranularityMarkdown diffs Markdown by words, while keeping fence lines,
+	// lines of fenced code, inline code spans, link targets and autolinks whole,
+	// so that rendered previews of partially applied or reviewed edits don't
+	// show broken syntax.
+	GranularityMarkdown
+
+	// GranularityXML diffs the canonical form of XML documents, written by
+	// CanonicalXML, by start tag names, attributes, end tags and words of text.
+	// Since the canonical form sorts attributes and drops indentation, reordering
+	// attributes or reindenting a document doesn't add to the patch. The patch
+	// applies to the canonical form of before. MakePatch fails if either input
+	// isn't well-formed.
+	GranularityXML
+)
+
+// isBinary reports whether b looks like binary data rather than text, by the same
+// test as git: a NUL byte in the first 8000 bytes.
+func isBinary(b []byte) bool {
+	if len(b) > 8000 {
+		b = b[:8000]
+	}
+	return bytes.IndexByte(b, 0) >= 0
+}
+
+// WithGranularity selects the unit that MakePatch diffs by. Any granularity other
+// than GranularityByte takes the place of the matching algorithm. Inputs that look
+// binary are diffed by byte instead of by sentence or Markdown, with WarningBinary.
+func WithGranularity(g Granularity) Option {
+	return func(c *config) {
+		c.granularity = g
+	}
+}
+
+// A Tokenizer splits text into the tokens that MakePatch diffs by. See WithTokenizer.
+type Tokenizer interface {
+	// Split returns the offset of the end of each token of text, in increasing
+	// order. Tokens are contiguous runs of bytes, so together they make up text.
+	Split(text []byte) []int
+}
+
+// TokenizerFunc adapts a function to a Tokenizer.
+type TokenizerFunc func(text []byte) []int
+
+// Split calls f(text).
+func (f TokenizerFunc) Split(text []byte) []int {
+	return f(text)
+}
+
+// DelimiterTokenizer returns a Tokenizer whose tokens end after any of the bytes in
+// delims, e.g. ",\n" for the fields of CSV records.
+func DelimiterTokenizer(delims string) Tokenizer {
+	return TokenizerFunc(func(text []byte) []int {
+		var ends []int
+		for i := 0; i < len(text); {
+			n := bytes.IndexAny(text[i:], delims)
+			if n < 0 {
+				i = len(text)
+			} else {
+				i += n + 1
+			}
+			ends = append(ends, i)
+		}
+		return ends
+	})
+}
+
+// WithTokenizer causes MakePatch to diff by the tokens of t, such as sentences, JSON
+// tokens or fields, so that no edit starts or ends inside a token. It takes the place
+// of the granularity and the matching algorithm, and the patch is encoded and applied
+// like any other. Offsets returned by t that aren't increasing or are beyond the end
+// of the text are ignored, and a final token ends at the end of the text.
+func WithTokenizer(t Tokenizer) Option {
+	return func(c *config) {
+		c.tokenizer = t
+	}
+}
+
+// tokenizerOf returns the tokenizer that splits text into the tokens of t, ignoring
+// any invalid offsets.
+func tokenizerOf(t Tokenizer) tokenizer {
+	return func(text []byte) []int {
+		var ends []int
+		last := 0
+		for _, end := range t.Split(text) {
+			if end > last && end <= len(text) {
+				ends = append(ends, end)
+				last = end
+			}
+		}
+		if last < len(text) {
+			ends = append(ends, len(text))
+		}
+		return ends
+	}
+}
+
+func (g Granularity) String() string {
+	switch g {
+	case GranularityByte:
+		return "byte"
+	case GranularitySentence:
+		return "sentence"
+	case GranularityMarkdown:
+		return "markdown"
+	case GranularityXML:
+		return "xml"
+	}
+	return "Granularity(" + strconv.Itoa(int(g)) + ")"
+}
+
+// tokenizer splits text into contiguous tokens, returning the offset of the end of
+// each one.
+type tokenizer func(text []byte) []int
+
+// diffTokens diffs text1 and text2 as sequences of the tokens found by split, so
+// that no edit starts or ends inside a token.
+func diffTokens(text1, text2 []byte, split tokenizer, timeout time.Duration) []diff {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	ends1, ends2 := split(text1), split(text2)
+
+	// Number the distinct tokens, so they can be compared as ints.
+	ids := make(map[string]int)
+	number := func(text []byte, ends []int) []int {
+		seq := make([]int, len(ends))
+		start := 0
+		for i, end := range ends {
+			tok := string(text[start:end])
+			id, ok := ids[tok]
+			if !ok {
+				id = len(ids)
+				ids[tok] = id
+			}
+			seq[i] = id
+			start = end
+		}
+		return seq
+	}
+	seq1, seq2 := number(text1, ends1), number(text2, ends2)
+
+	// Convert the token edits back to byte ranges. The edits between two copies are
+	// gathered into one delete and one insert. Unlike the byte-level diffs, these are
+	// not passed to diffCleanupMerge, which would move edit boundaries into tokens.
+	offset := func(ends []int, i int) int {
+		if i == 0 {
+			return 0
+		}
+		return ends[i-1]
+	}
+
+	var diffs []diff
+	var i1, i2, d1, d2 int // Token positions, and the start of the pending edits.
+	flush := func() {
+		if d1 < i1 {
+			diffs = append(diffs, diff{OpDelete, text1[offset(ends1, d1):offset(ends1, i1)]})
+		}
+		if d2 < i2 {
+			diffs = append(diffs, diff{OpInsert, text2[offset(ends2, d2):offset(ends2, i2)]})
+		}
+	}
+	for _, e := range tokenDiff(seq1, seq2, deadline) {
+		switch e.op {
+		case OpCopy:
+			flush()
+			diffs = append(diffs, diff{OpCopy, text1[offset(ends1, i1):offset(ends1, i1+e.n)]})
+			i1 += e.n
+			i2 += e.n
+			d1, d2 = i1, i2
+		case OpDelete:
+			i1 += e.n
+		case OpInsert:
+			i2 += e.n
+		}
+	}
+	flush()
+
+	return diffs
+}
+
+// tokenEdit is a run of n tokens that are copied, deleted or inserted.
+type tokenEdit struct {
+	op byte
+	n  int
+}
+
+// tokenDiff diffs two token sequences with Myers's algorithm, in the same way that
+// diffMainBytes and diffBisect diff bytes.
+func tokenDiff(seq1, seq2 []int, deadline time.Time) []tokenEdit {
+	var prefix, suffix int
+	for prefix < len(seq1) && prefix < len(seq2) && seq1[prefix] == seq2[prefix] {
+		prefix++
+	}
+	seq1, seq2 = seq1[prefix:], seq2[prefix:]
+	for suffix < len(seq1) && suffix < len(seq2) && seq1[len(seq1)-suffix-1] == seq2[len(seq2)-suffix-1] {
+		suffix++
+	}
+	seq1, seq2 = seq1[:len(seq1)-suffix], seq2[:len(seq2)-suffix]
+
+	var edits []tokenEdit
+	add := func(op byte, n int) {
+		if n > 0 {
+			edits = append(edits, tokenEdit{op, n})
+		}
+	}
+
+	add(OpCopy, prefix)
+	if len(seq1) == 0 || len(seq2) == 0 {
+		add(OpDelete, len(seq1))
+		add(OpInsert, len(seq2))
+	} else if x, y, ok := tokenBisect(seq1, seq2, deadline); ok {
+		edits = append(edits, tokenDiff(seq1[:x], seq2[:y], deadline)...)
+		edits = append(edits, tokenDiff(seq1[x:], seq2[y:], deadline)...)
+	} else {
+		add(OpDelete, len(seq1))
+		add(OpInsert, len(seq2))
+	}
+	add(OpCopy, suffix)
+
+	return edits
+}
+
+// tokenBisect finds the middle snake of the diff of seq1 and seq2, returning the
+// point at which to split them. ok is false if there is nothing in common or the
+// deadline is reached.
+func tokenBisect(seq1, seq2 []int, deadline time.Time) (x, y int, ok bool) {
+	len1, len2 := len(seq1), len(seq2)
+	maxD := (len1 + len2 + 1) / 2
+	vOffset := maxD
+	vLength := 2*maxD + 2
+
+	v := getInts(2 * vLength)
+	defer putInts(v)
+	v1, v2 := v[:vLength], v[vLength:]
+	for i := range v1 {
+		v1[i] = -1
+		v2[i] = -1
+	}
+	v1[vOffset+1] = 0
+	v2[vOffset+1] = 0
+
+	delta := len1 - len2
+	front := delta%2 != 0
+	var k1start, k1end, k2start, k2end int
+	for d := 0; d < maxD; d++ {
+		if !deadline.IsZero() && d%16 == 0 && time.Now().After(deadline) {
+			break
+		}
+
+		for k1 := -d + k1start; k1 <= d-k1end; k1 += 2 {
+			k1Offset := vOffset + k1
+			var x1 int
+			if k1 == -d || (k1 != d && v1[k1Offset-1] < v1[k1Offset+1]) {
+				x1 = v1[k1Offset+1]
+			} else {
+				x1 = v1[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < len1 && y1 < len2 && seq1[x1] == seq2[y1] {
+				x1++
+				y1++
+			}
+			v1[k1Offset] = x1
+			if x1 > len1 {
+				k1end += 2
+			} else if y1 > len2 {
+				k1start += 2
+			} else if front {
+				k2Offset := vOffset + delta - k1
+				if k2Offset >= 0 && k2Offset < vLength && v2[k2Offset] != -1 && x1 >= len1-v2[k2Offset] {
+					return x1, y1, true
+				}
+			}
+		}
+
+		for k2 := -d + k2start; k2 <= d-k2end; k2 += 2 {
+			k2Offset := vOffset + k2
+			var x2 int
+			if k2 == -d || (k2 != d && v2[k2Offset-1] < v2[k2Offset+1]) {
+				x2 = v2[k2Offset+1]
+			} else {
+				x2 = v2[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < len1 && y2 < len2 && seq1[len1-x2-1] == seq2[len2-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2Offset] = x2
+			if x2 > len1 {
+				k2end += 2
+			} else if y2 > len2 {
+				k2start += 2
+			} else if !front {
+				k1Offset := vOffset + delta - k2
+				if k1Offset >= 0 && k1Offset < vLength && v1[k1Offset] != -1 {
+					x1 := v1[k1Offset]
+					y1 := vOffset + x1 - k1Offset
+					if x1 >= len1-x2 {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// splitSentences splits text into sentences, loosely following the sentence
+// boundary rules of Unicode Standard Annex #29. A sentence ends after a run of
+// terminators (such as '.', '?', '!' and their CJK and other counterparts), any
+// closing quotes or brackets, and the whitespace that follows; or after a line
+// break. A '.' must be followed by whitespace, and then not by a lowercase letter
+// as in "e.g. this", to end a sentence.
+func splitSentences(text []byte) []int {
+	if len(text) == 0 {
+		return nil
+	}
+
+	var ends []int
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRune(text[i:])
+		i += size
+
+		if isLineBreak(r) {
+			if r == '\r' && i < len(text) && text[i] == '\n' {
+				i++
+			}
+			ends = append(ends, i)
+			continue
+		}
+
+		if !isSentenceTerminal(r) {
+			continue
+		}
+
+		// Extend over further terminators and closing punctuation.
+		period := r == '.'
+		for i < len(text) {
+			r, size := utf8.DecodeRune(text[i:])
+			if !isSentenceTerminal(r) && !unicode.In(r, unicode.Pe, unicode.Pf) && r != '"' && r != '\'' {
+				break
+			}
+			period = period && (r == '.' || !isSentenceTerminal(r))
+			i += size
+		}
+
+		// The sentence takes any following spaces with it, up to a line break. A '.'
+		// only ends a sentence if spaces follow, so that numbers and names such as
+		// "3.14" and "example.com" are kept together.
+		j := i
+		for j < len(text) {
+			r, size := utf8.DecodeRune(text[j:])
+			if !unicode.IsSpace(r) || isLineBreak(r) {
+				break
+			}
+			j += size
+		}
+		if j == i && j < len(text) && period {
+			continue
+		}
+		if period && j < len(text) {
+			if r, _ := utf8.DecodeRune(text[j:]); unicode.IsLower(r) {
+				continue
+			}
+		}
+
+		i = j
+		ends = append(ends, i)
+	}
+
+	if len(ends) == 0 || ends[len(ends)-1] != len(text) {
+		ends = append(ends, len(text))
+	}
+
+	return ends
+}
+
+func isSentenceTerminal(r rune) bool {
+	return unicode.Is(unicode.Sentence_Terminal, r)
+}
+
+func isLineBreak(r rune) bool {
+	return r == '\n' || r == '\r' || r == '\u0085' || r == '\u2028' || r == '\u2029'
+}
@@ -0,0 +1,131 @@
+package lightpatch
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitSentences(t *testing.T) {
+	split := func(text string) []string {
+		var out []string
+		start := 0
+		for _, end := range splitSentences([]byte(text)) {
+			out = append(out, text[start:end])
+			start = end
+		}
+		return out
+	}
+
+	for _, test := range []struct {
+		text     string
+		expected []string
+	}{
+		{"", nil},
+		{"No terminator", []string{"No terminator"}},
+		{"One. Two! Three? ", []string{"One. ", "Two! ", "Three? "}},
+		{"He said \"Stop.\" Then left.", []string{"He said \"Stop.\" ", "Then left."}},
+		{"Really?! Yes (quite.)  Fine", []string{"Really?! ", "Yes (quite.)  ", "Fine"}},
+		{"Pi is 3.14 or so. E.g. this, e.g. that.", []string{"Pi is 3.14 or so. ", "E.g. this, e.g. that."}},
+		{"Line one\nLine two\r\n\nEnd", []string{"Line one\n", "Line two\r\n", "\n", "End"}},
+		{"你好。再见！好", []string{"你好。", "再见！", "好"}},
+		{"Wait... what? Oh.", []string{"Wait... what? ", "Oh."}},
+	} {
+		assert.Equal(t, test.expected, split(test.text), test.text)
+	}
+}
+
+func TestDiffSentences(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog. It was not amused! Later, the dog slept.\nThe end.")
+	b := []byte("The quick brown fox jumped over the lazy dog. It was rather amused! Later, the dog slept.\nThe end.")
+
+	diffs := diffTokens(a, b, splitSentences, 0)
+	assert.Equal(t, asDiffs([]diffTest{
+		{OpCopy, "The quick brown fox jumped over the lazy dog. "},
+		{OpDelete, "It was not amused! "},
+		{OpInsert, "It was rather amused! "},
+		{OpCopy, "Later, the dog slept.\nThe end."},
+	}), diffs)
+
+	var patchr bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithGranularity(GranularitySentence))
+	assert.NoError(t, err)
+
+	var c bytes.Buffer
+	err = ApplyPatch(bytes.NewReader(a), &patchr, &c)
+	assert.NoError(t, err)
+	assert.Equal(t, b, c.Bytes())
+}
+
+func TestTokenDiff(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	seq := func(n int) []int {
+		s := make([]int, n)
+		for i := range s {
+			s[i] = r.Intn(5)
+		}
+		return s
+	}
+
+	for i := 0; i < 200; i++ {
+		seq1, seq2 := seq(r.Intn(30)), seq(r.Intn(30))
+
+		// Replaying the edits must turn seq1 into seq2.
+		var out []int
+		var i1, i2 int
+		for _, e := range tokenDiff(seq1, seq2, time.Time{}) {
+			switch e.op {
+			case OpCopy:
+				assert.Equal(t, seq1[i1:i1+e.n], seq2[i2:i2+e.n])
+				out = append(out, seq1[i1:i1+e.n]...)
+				i1 += e.n
+				i2 += e.n
+			case OpDelete:
+				i1 += e.n
+			case OpInsert:
+				out = append(out, seq2[i2:i2+e.n]...)
+				i2 += e.n
+			}
+		}
+		assert.Equal(t, len(seq1), i1)
+		assert.Equal(t, len(seq2), i2)
+		assert.Equal(t, append([]int{}, seq2...), append([]int{}, out...))
+	}
+}
+
+func TestWithTokenizer(t *testing.T) {
+	a := []byte("name,age\nalice,30\nbob,25\n")
+	b := []byte("name,age\nalice,31\nbob,25\ncarol,40\n")
+
+	var patch, edits bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithTokenizer(DelimiterTokenizer(",\n")))
+	assert.NoError(t, err)
+
+	var c bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch.Bytes()), &c))
+	assert.Equal(t, b, c.Bytes())
+
+	// Whole fields are replaced, rather than the digit that changed.
+	e, err := Edits(a, patch.Bytes())
+	assert.NoError(t, err)
+	for _, e := range e {
+		if e.Op != OpCopy {
+			fmt.Fprintf(&edits, "%c%q ", e.Op, e.Text)
+		}
+	}
+	assert.Equal(t, `D"30\n" I"31\n" I"carol,40\n" `, edits.String())
+
+	// Invalid offsets are ignored.
+	bad := TokenizerFunc(func(text []byte) []int { return []int{5, 3, 5, len(text) + 10} })
+	assert.Equal(t, []int{5, len(a)}, tokenizerOf(bad)(a))
+	c.Reset()
+	patch.Reset()
+	assert.NoError(t, MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patch, WithTokenizer(bad)))
+	assert.NoError(t, ApplyPatch(bytes.NewReader(a), &patch, &c))
+	assert.Equal(t, b, c.Bytes())
+	assert.Empty(t, tokenizerOf(bad)(nil))
+}
@@ -0,0 +1,499 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// treeMagic starts every tree patch.
+const treeMagic = "LPT1"
+
+// The ops of the changes of a tree patch.
+const (
+	TreeAdded    byte = 'A'
+	TreeDeleted  byte = 'D'
+	TreeModified byte = 'M'
+
+	// TreeSymlink sets the target of a symlink, which is added, or replaced if the
+	// change records the checksum of its old target.
+	TreeSymlink byte = 'L'
+
+	// TreeDir adds an empty directory.
+	TreeDir byte = 'E'
+
+	// treeSum precedes a change that replaces an entry with its checksum.
+	treeSum byte = 'S'
+)
+
+var (
+	// ErrNotTreePatch is returned when reading a tree patch that doesn't start with
+	// the tree patch header.
+	ErrNotTreePatch = errors.New("not a tree patch")
+
+	errTreePath = fmt.Errorf("%w: bad path in tree patch", ErrMalformed)
+	errTreeSum  = fmt.Errorf("%w: bad checksum in tree patch", ErrMalformed)
+
+	errSpecialFile  = errors.New("unsupported file type")
+	errUnderSymlink = errors.New("path is under a symlink")
+)
+
+// A TreeChange is a change to one entry of a directory tree: a file, a symlink or an
+// empty directory.
+type TreeChange struct {
+	Op   byte   // TreeAdded, TreeDeleted, TreeModified, TreeSymlink or TreeDir
+	Path string // Slash-separated path of the entry, relative to the root of the tree
+	Data []byte // The content of an added file, the patch of a modified one, or the target of a symlink
+	Sum  []byte // The checksum of the entry replaced by the change, if recorded
+}
+
+// MakeTreePatch compares the directory trees before and after, and writes a tree
+// patch of their differences to w: the files only in after, whole, the files only in
+// before, and a patch, made with the options given, for each file whose content
+// differs. The patches are made in parallel. Symlinks are compared by their targets,
+// without following them, and empty directories are added and deleted like files;
+// other directories follow from the files in them. It returns an error for a device,
+// named pipe or socket, which a tree patch can't represent.
+//
+// A tree patch is a header followed by the changes in order of their paths. Each is
+// its op, then its path and its data, each a uvarint length followed by that many
+// bytes. Changes that replace an entry are preceded by a record of the same form
+// holding its checksum: the CRC-32 of a file, of the target of a symlink, or of
+// nothing for an empty directory.
+func MakeTreePatch(before, after string, w io.Writer, opts ...Option) error {
+	a, err := treeFiles(before)
+	if err != nil {
+		return err
+	}
+	b, err := treeFiles(after)
+	if err != nil {
+		return err
+	}
+	return makeTreePatch(a, b, w, opts)
+}
+
+// A fileSet is a set of the entries of a tree by their slash-separated paths.
+type fileSet map[string]treeEntry
+
+// A treeEntry is a file, a symlink or an empty directory of a tree.
+type treeEntry struct {
+	mode os.FileMode            // The type bits of the entry's mode
+	read func() ([]byte, error) // Reads the content of a file or the target of a symlink
+}
+
+// makeTreePatch writes a tree patch of the differences between the files a and b.
+func makeTreePatch(a, b fileSet, w io.Writer, opts []Option) error {
+	var paths []string
+	for p := range a {
+		paths = append(paths, p)
+	}
+	for p := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var changes []TreeChange
+	var jobs []PatchJob
+	var modified []int // Indexes of the changes made by jobs
+	for _, p := range paths {
+		ea, inA := a[p]
+		eb, inB := b[p]
+		var aData, bData []byte
+		var err error
+		if inA {
+			if aData, err = ea.read(); err != nil {
+				return err
+			}
+		}
+		if inB {
+			if bData, err = eb.read(); err != nil {
+				return err
+			}
+		}
+
+		if inA && inB && ea.mode == eb.mode {
+			switch {
+			case ea.mode == os.ModeDir || bytes.Equal(aData, bData):
+			case ea.mode == os.ModeSymlink:
+				changes = append(changes, TreeChange{Op: TreeSymlink, Path: p, Data: bData, Sum: treeCRC(aData)})
+			default:
+				jobs = append(jobs, PatchJob{
+					Before:  bytes.NewReader(aData),
+					After:   bytes.NewReader(bData),
+					Patch:   new(bytes.Buffer),
+					Options: opts,
+				})
+				modified = append(modified, len(changes))
+				changes = append(changes, TreeChange{Op: TreeModified, Path: p, Sum: treeCRC(aData)})
+			}
+			continue
+		}
+
+		// An entry whose type has changed is deleted and added again.
+		if inA {
+			changes = append(changes, TreeChange{Op: TreeDeleted, Path: p, Sum: treeCRC(aData)})
+		}
+		if inB {
+			c := TreeChange{Op: TreeAdded, Path: p, Data: bData}
+			switch eb.mode {
+			case os.ModeDir:
+				c.Op = TreeDir
+			case os.ModeSymlink:
+				c.Op = TreeSymlink
+			}
+			changes = append(changes, c)
+		}
+	}
+
+	for i, res := range MakePatchBatch(context.Background(), jobs, 0) {
+		c := &changes[modified[i]]
+		if res.Err != nil {
+			return fmt.Errorf("%s: %w", c.Path, res.Err)
+		}
+		c.Data = jobs[i].Patch.(*bytes.Buffer).Bytes()
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(treeMagic)
+	for _, c := range changes {
+		if c.Sum != nil {
+			writeTreeRecord(bw, treeSum, c.Path, c.Sum)
+		}
+		writeTreeRecord(bw, c.Op, c.Path, c.Data)
+	}
+	return bw.Flush()
+}
+
+// writeTreeRecord writes a record of a tree patch to bw.
+func writeTreeRecord(bw *bufio.Writer, op byte, path string, data []byte) {
+	bw.WriteByte(op)
+	bw.Write(appendUvarint(nil, uint64(len(path))))
+	bw.WriteString(path)
+	bw.Write(appendUvarint(nil, uint64(len(data))))
+	bw.Write(data)
+}
+
+// ReadTreePatch returns the changes of a tree patch.
+func ReadTreePatch(r io.Reader) ([]TreeChange, error) {
+	br := bufio.NewReader(r)
+	if err := readMagic(br, treeMagic, ErrNotTreePatch); err != nil {
+		return nil, err
+	}
+
+	var changes []TreeChange
+	var sum *TreeChange // The last checksum record, until its change
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			if sum != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return changes, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch op {
+		case TreeAdded, TreeDeleted, TreeModified, TreeSymlink, TreeDir, treeSum:
+		default:
+			return nil, unexpectedOp(op)
+		}
+
+		p, data, err := readTreeRecord(br)
+		if err != nil {
+			return nil, err
+		}
+		if !validTreePath(p) {
+			return nil, errTreePath
+		}
+		c := TreeChange{Op: op, Path: p, Data: data}
+
+		switch {
+		case op == treeSum:
+			if sum != nil || len(c.Data) != crc32.Size {
+				return nil, errTreeSum
+			}
+			sum = &c
+			continue
+		case sum != nil:
+			if op == TreeAdded || op == TreeDir || sum.Path != p {
+				return nil, errTreeSum
+			}
+			c.Sum = sum.Data
+			sum = nil
+		}
+		changes = append(changes, c)
+	}
+}
+
+// validTreePath reports whether p is a clean, relative, slash-separated path inside
+// the root of a tree.
+func validTreePath(p string) bool {
+	return p != "" && !path.IsAbs(p) && path.Clean(p) == p && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// readTreeRecord reads the path and data of a record of a tree patch, following its
+// op.
+func readTreeRecord(br *bufio.Reader) (string, []byte, error) {
+	// Grow the fields as they arrive rather than trusting their lengths up front.
+	var fields [2]bytes.Buffer
+	for i := range fields {
+		n, err := readLength(br)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := io.CopyN(&fields[i], br, int64(n)); err == io.EOF {
+			return "", nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return "", nil, err
+		}
+	}
+	return fields[0].String(), fields[1].Bytes(), nil
+}
+
+// ApplyTreePatch applies a tree patch to the directory tree dir, adding, deleting
+// and patching its entries, with the options given to ApplyPatch. Directories are
+// created for added entries, and removed once their last entry is deleted. Every
+// change is checked, and every patch applied in memory, before anything is written,
+// so dir is left as it was if the patch doesn't apply, e.g. because an added file
+// already exists, or a deleted one doesn't or has changed. See ApplyBundle for large
+// trees.
+func ApplyTreePatch(dir string, patch io.Reader, opts ...Option) error {
+	changes, err := ReadTreePatch(patch)
+	if err != nil {
+		return err
+	}
+	if err := checkTree(dir, changes, false); err != nil {
+		return err
+	}
+
+	outputs := make([][]byte, len(changes))
+	for i, c := range changes {
+		if c.Op != TreeModified {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(c.Path)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+		var out bytes.Buffer
+		err = ApplyPatch(f, bytes.NewReader(c.Data), &out, opts...)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+		outputs[i] = out.Bytes()
+	}
+
+	// Entries are deleted first, in case a directory is replaced by a file.
+	for _, c := range changes {
+		if !replaces(c) || c.Op == TreeModified {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(c.Path))); err != nil {
+			return err
+		}
+		if c.Op == TreeDeleted {
+			removeEmptyDirs(dir, c.Path)
+		}
+	}
+
+	for i, c := range changes {
+		name := filepath.Join(dir, filepath.FromSlash(c.Path))
+		switch c.Op {
+		case TreeAdded, TreeSymlink:
+			if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+				return err
+			}
+		}
+
+		switch c.Op {
+		case TreeAdded:
+			err = ioutil.WriteFile(name, c.Data, 0666)
+		case TreeModified:
+			err = ioutil.WriteFile(name, outputs[i], 0666)
+		case TreeSymlink:
+			err = os.Symlink(string(c.Data), name)
+		case TreeDir:
+			err = os.MkdirAll(name, 0777)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaces reports whether the change c replaces an entry of the tree.
+func replaces(c TreeChange) bool {
+	return c.Op == TreeDeleted || c.Op == TreeModified || c.Op == TreeSymlink && c.Sum != nil
+}
+
+// checkTree returns an error if changes don't apply to the tree dir: if an entry they
+// replace doesn't exist, or doesn't match its checksum, or if an entry they add
+// already exists. If verified, every entry replaced must have a checksum. Changes to
+// entries under a symlink are refused, since they would be made wherever it points.
+func checkTree(dir string, changes []TreeChange, verified bool) error {
+	deleted := map[string]bool{}
+	links := map[string]bool{}
+	for _, c := range changes {
+		switch c.Op {
+		case TreeDeleted:
+			deleted[c.Path] = true
+		case TreeSymlink:
+			links[c.Path] = true
+		}
+	}
+
+	for _, c := range changes {
+		name := filepath.Join(dir, filepath.FromSlash(c.Path))
+		for p := path.Dir(c.Path); p != "."; p = path.Dir(p) {
+			info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(p)))
+			if links[p] || err == nil && info.Mode()&os.ModeSymlink != 0 && !deleted[p] {
+				return fmt.Errorf("%s: %w", c.Path, errUnderSymlink)
+			}
+		}
+
+		if !replaces(c) {
+			// A directory may be replaced once the entries under it are deleted.
+			if info, err := os.Lstat(name); err == nil && !info.IsDir() && !deleted[c.Path] {
+				return fmt.Errorf("%s: %w", c.Path, os.ErrExist)
+			}
+			continue
+		}
+		if verified && c.Sum == nil {
+			return fmt.Errorf("%s: %w", c.Path, ErrUnverified)
+		}
+		if err := checkTreeEntry(name, c); err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+	}
+	return nil
+}
+
+// removeEmptyDirs removes the directories of dir containing the file p that are
+// left empty, stopping at the first that isn't.
+func removeEmptyDirs(dir, p string) {
+	for p = path.Dir(p); p != "."; p = path.Dir(p) {
+		if os.Remove(filepath.Join(dir, filepath.FromSlash(p))) != nil {
+			return
+		}
+	}
+}
+
+// treeCRC returns the checksum of an entry recorded in tree patches.
+func treeCRC(data []byte) []byte {
+	sum := make([]byte, crc32.Size)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(data))
+	return sum
+}
+
+// checkTreeEntry returns ErrBeforeMismatch if the entry name, replaced by the change
+// c, isn't of the type c replaces or doesn't have its checksum, or the error from
+// Lstat if it doesn't exist. Only an empty directory can be replaced.
+func checkTreeEntry(name string, c TreeChange) error {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+
+	h := crc32.NewIEEE()
+	switch mode := info.Mode(); {
+	case mode&os.ModeSymlink != 0:
+		if c.Op == TreeModified {
+			return ErrBeforeMismatch
+		}
+		target, err := os.Readlink(name)
+		if err != nil {
+			return err
+		}
+		io.WriteString(h, target)
+	case mode.IsDir():
+		entries, err := ioutil.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if c.Op != TreeDeleted || len(entries) > 0 {
+			return ErrBeforeMismatch
+		}
+	case mode.IsRegular():
+		if c.Op == TreeSymlink {
+			return ErrBeforeMismatch
+		}
+		if c.Sum == nil {
+			return nil
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+	default:
+		return ErrBeforeMismatch
+	}
+
+	if c.Sum != nil && !bytes.Equal(h.Sum(nil), c.Sum) {
+		return ErrBeforeMismatch
+	}
+	return nil
+}
+
+// treeFiles returns the files, symlinks and empty directories under root, by their
+// paths relative to root.
+func treeFiles(root string) (fileSet, error) {
+	files := fileSet{}
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		// Walk visits a directory before its entries, so it is only empty if none
+		// follow.
+		if parent, ok := files[path.Dir(rel)]; ok && parent.mode == os.ModeDir {
+			delete(files, path.Dir(rel))
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsRegular():
+			files[rel] = treeEntry{read: func() ([]byte, error) {
+				return ioutil.ReadFile(name)
+			}}
+		case mode&os.ModeSymlink != 0:
+			files[rel] = treeEntry{mode: os.ModeSymlink, read: func() ([]byte, error) {
+				target, err := os.Readlink(name)
+				return []byte(target), err
+			}}
+		case mode.IsDir():
+			files[rel] = treeEntry{mode: os.ModeDir, read: func() ([]byte, error) {
+				return nil, nil
+			}}
+		default:
+			return fmt.Errorf("%s: %w", rel, errSpecialFile)
+		}
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,272 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTree writes files under root by their paths. A path ending in a slash is an
+// empty directory, and content starting with "-> " is the target of a symlink.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for p, content := range files {
+		name := filepath.Join(root, filepath.FromSlash(p))
+		switch {
+		case strings.HasSuffix(p, "/"):
+			assert.NoError(t, os.MkdirAll(name, 0777))
+		case strings.HasPrefix(content, "-> "):
+			assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0777))
+			assert.NoError(t, os.Symlink(content[3:], name))
+		default:
+			assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0777))
+			assert.NoError(t, ioutil.WriteFile(name, []byte(content), 0666))
+		}
+	}
+}
+
+// readTree returns the entries under root in the form written by writeTree.
+func readTree(t *testing.T, root string) map[string]string {
+	set, err := treeFiles(root)
+	assert.NoError(t, err)
+	files := map[string]string{}
+	for p, e := range set {
+		data, err := e.read()
+		assert.NoError(t, err)
+		switch e.mode {
+		case os.ModeDir:
+			files[p+"/"] = ""
+		case os.ModeSymlink:
+			files[p] = "-> " + string(data)
+		default:
+			files[p] = string(data)
+		}
+	}
+	return files
+}
+
+func TestTreePatch(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "lightpatch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	before := map[string]string{
+		"same.txt":      "unchanged",
+		"fox.txt":       "The quick brown fox jumped over the lazy dog.",
+		"old/gone.txt":  "deleted",
+		"old/deep/x":    "deleted too",
+		"dir/keep.txt":  "kept",
+		"dir/drop.txt":  "dropped",
+		"becomes-a-dir": "a file, for now",
+	}
+	after := map[string]string{
+		"same.txt":            "unchanged",
+		"fox.txt":             "The quick brown cat jumped over the dog!",
+		"dir/keep.txt":        "kept",
+		"new/nested/file.txt": "added",
+		"becomes-a-dir/file":  "a file in a directory",
+	}
+	a, b, dir := filepath.Join(tmp, "a"), filepath.Join(tmp, "b"), filepath.Join(tmp, "dir")
+	writeTree(t, a, before)
+	writeTree(t, b, after)
+	writeTree(t, dir, before)
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakeTreePatch(a, b, &patch))
+
+	changes, err := ReadTreePatch(bytes.NewReader(patch.Bytes()))
+	assert.NoError(t, err)
+	var summary []string
+	for _, c := range changes {
+		summary = append(summary, string(c.Op)+" "+c.Path)
+	}
+	assert.Equal(t, []string{
+		"D becomes-a-dir",
+		"A becomes-a-dir/file",
+		"D dir/drop.txt",
+		"M fox.txt",
+		"A new/nested/file.txt",
+		"D old/deep/x",
+		"D old/gone.txt",
+	}, summary)
+	assert.Equal(t, "added", string(changes[4].Data))
+	assert.Equal(t, treeCRC([]byte(before["fox.txt"])), changes[3].Sum)
+	assert.Nil(t, changes[4].Sum)
+
+	assert.NoError(t, ApplyTreePatch(dir, bytes.NewReader(patch.Bytes())))
+	assert.Equal(t, after, readTree(t, dir))
+
+	// The directories emptied by deletions are removed.
+	_, err = os.Stat(filepath.Join(dir, "old"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "dir"))
+	assert.NoError(t, err)
+
+	// Applying it again fails, without touching the tree.
+	err = ApplyTreePatch(dir, bytes.NewReader(patch.Bytes()))
+	assert.Error(t, err)
+	assert.Equal(t, after, readTree(t, dir))
+
+	// So does applying it to a tree where a patched file differs.
+	writeTree(t, filepath.Join(tmp, "wrong"), before)
+	writeTree(t, filepath.Join(tmp, "wrong"), map[string]string{"fox.txt": "Something else entirely."})
+	err = ApplyTreePatch(filepath.Join(tmp, "wrong"), bytes.NewReader(patch.Bytes()))
+	assert.True(t, errors.Is(err, ErrBeforeMismatch), err)
+	assert.Equal(t, "Something else entirely.", readTree(t, filepath.Join(tmp, "wrong"))["fox.txt"])
+	assert.Equal(t, "dropped", readTree(t, filepath.Join(tmp, "wrong"))["dir/drop.txt"])
+
+	// Identical trees make an empty patch.
+	patch.Reset()
+	assert.NoError(t, MakeTreePatch(a, a, &patch))
+	assert.Equal(t, treeMagic, patch.String())
+}
+
+func TestReadTreePatchMalformed(t *testing.T) {
+	_, err := ReadTreePatch(bytes.NewReader([]byte("C\x04abcd")))
+	assert.Equal(t, ErrNotTreePatch, err)
+	_, err = ReadTreePatch(bytes.NewReader([]byte("LPT2")))
+	assert.True(t, errors.Is(err, ErrUnknownVersion), err)
+
+	for _, p := range []string{"", "/etc/passwd", "..", "../x", "a/../../x", "a//b", "./a"} {
+		patch := append([]byte(treeMagic+"A"), byte(len(p)))
+		patch = append(append(patch, p...), 0)
+		_, err := ReadTreePatch(bytes.NewReader(patch))
+		assert.Equal(t, errTreePath, err, p)
+		assert.True(t, errors.Is(err, ErrMalformed))
+	}
+
+	// A checksum must be 4 bytes, and precede a deletion or modification of its file.
+	for _, patch := range []string{
+		"S\x01a\x02ab" + "M\x01a\x00",
+		"S\x01a\x04abcd" + "A\x01a\x00",
+		"S\x01a\x04abcd" + "D\x01b\x00",
+		"S\x01a\x04abcd" + "S\x01a\x04abcd",
+	} {
+		_, err := ReadTreePatch(bytes.NewReader([]byte(treeMagic + patch)))
+		assert.Equal(t, errTreeSum, err, patch)
+	}
+	_, err = ReadTreePatch(bytes.NewReader([]byte(treeMagic + "S\x01a\x04abcd")))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = ReadTreePatch(bytes.NewReader([]byte(treeMagic + "A\x01a\x05abc")))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestTreePatchEntries(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "lightpatch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	before := map[string]string{
+		"fox.txt":          "The quick brown fox jumped over the lazy dog.",
+		"link":             "-> fox.txt",
+		"same-link":        "-> x",
+		"gone-link":        "-> y",
+		"empty/":           "",
+		"empty-gone/":      "",
+		"file-to-link":     "content",
+		"link-to-file":     "-> z",
+		"dir-to-empty/f":   "emptied",
+		"empty-to-file/":   "",
+		"link-to-dir/":     "",
+		"link-in/dir/link": "-> ../..",
+	}
+	after := map[string]string{
+		"fox.txt":          "The quick brown fox jumped over the lazy dog.",
+		"link":             "-> cat.txt",
+		"same-link":        "-> x",
+		"new-link":         "-> ../outside",
+		"empty/":           "",
+		"new-empty/a/":     "",
+		"file-to-link":     "-> t",
+		"link-to-file":     "content",
+		"dir-to-empty/":    "",
+		"empty-to-file":    "a file",
+		"link-to-dir/":     "",
+		"link-in/dir/link": "-> ../..",
+	}
+	a, b := filepath.Join(tmp, "a"), filepath.Join(tmp, "b")
+	writeTree(t, a, before)
+	writeTree(t, b, after)
+	assert.Equal(t, before, readTree(t, a))
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakeTreePatch(a, b, &patch))
+	changes, err := ReadTreePatch(bytes.NewReader(patch.Bytes()))
+	assert.NoError(t, err)
+	var summary []string
+	for _, c := range changes {
+		summary = append(summary, string(c.Op)+" "+c.Path+" "+string(c.Data))
+	}
+	assert.Equal(t, []string{
+		"E dir-to-empty ",
+		"D dir-to-empty/f ",
+		"D empty-gone ",
+		"D empty-to-file ",
+		"A empty-to-file a file",
+		"D file-to-link ",
+		"L file-to-link t",
+		"D gone-link ",
+		"L link cat.txt",
+		"D link-to-file ",
+		"A link-to-file content",
+		"E new-empty/a ",
+		"L new-link ../outside",
+	}, summary)
+
+	for name, apply := range map[string]func(string, io.Reader, ...Option) error{
+		"ApplyTreePatch": ApplyTreePatch,
+		"ApplyBundle":    ApplyBundle,
+	} {
+		dir := filepath.Join(tmp, name)
+		writeTree(t, dir, before)
+		assert.NoError(t, apply(dir, bytes.NewReader(patch.Bytes())), name)
+		assert.Equal(t, after, readTree(t, dir), name)
+
+		// A symlink is checked by its target.
+		dir = filepath.Join(tmp, name+"-changed")
+		writeTree(t, dir, before)
+		assert.NoError(t, os.Remove(filepath.Join(dir, "link")))
+		assert.NoError(t, os.Symlink("other.txt", filepath.Join(dir, "link")))
+		err := apply(dir, bytes.NewReader(patch.Bytes()))
+		assert.True(t, errors.Is(err, ErrBeforeMismatch), err)
+
+		// Nothing is written under a symlink, which could point outside the tree.
+		dir = filepath.Join(tmp, name+"-escape")
+		writeTree(t, dir, map[string]string{"out": "-> " + tmp})
+		var escape bytes.Buffer
+		bw := bufio.NewWriter(&escape)
+		bw.WriteString(treeMagic)
+		writeTreeRecord(bw, TreeAdded, "out/escaped", []byte("x"))
+		assert.NoError(t, bw.Flush())
+		err = apply(dir, bytes.NewReader(escape.Bytes()))
+		assert.True(t, errors.Is(err, errUnderSymlink), err)
+
+		escape.Reset()
+		bw.WriteString(treeMagic)
+		writeTreeRecord(bw, TreeSymlink, "in", []byte(tmp))
+		writeTreeRecord(bw, TreeAdded, "in/escaped", []byte("x"))
+		assert.NoError(t, bw.Flush())
+		err = apply(dir, bytes.NewReader(escape.Bytes()))
+		assert.True(t, errors.Is(err, errUnderSymlink), err)
+		_, err = os.Lstat(filepath.Join(tmp, "escaped"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// Sockets, devices and named pipes can't be represented.
+	l, err := net.Listen("unix", filepath.Join(b, "socket"))
+	if err != nil {
+		t.Skip("no unix sockets:", err)
+	}
+	defer l.Close()
+	err = MakeTreePatch(a, b, new(bytes.Buffer))
+	assert.True(t, errors.Is(err, errSpecialFile), err)
+}
@@ -0,0 +1,302 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	errUnifiedSyntax = errors.New("invalid unified diff")
+	errUnifiedFiles  = errors.New("unified diff changes more than one file")
+	errUnifiedBinary = errors.New("unified diff has a binary change")
+
+	// ErrUnifiedMismatch is wrapped by the error returned by ParseUnifiedDiff and
+	// ApplyUnifiedDiff when the lines a hunk deletes or keeps aren't in before at the
+	// lines given by its header.
+	ErrUnifiedMismatch = errors.New("unified diff hunk doesn't match")
+)
+
+// unifiedHeader matches a hunk header, which git follows with the enclosing function.
+var unifiedHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// unifiedPrefixes are the prefixes of the lines of a unified diff, by op.
+var unifiedPrefixes = map[byte]byte{
+	OpCopy:   ' ',
+	OpDelete: '-',
+	OpInsert: '+',
+}
+
+// UnifiedDiff writes a unified diff of before and after to w, in the format of
+// diff -u, for review tools and for patch(1). The files are named beforeName and
+// afterName in the "---" and "+++" header lines, and each hunk has up to context
+// lines of unchanged text on either side. Hunks whose context would touch or overlap
+// are merged, and a negative context is treated as 0. Nothing is written if the
+// inputs are equal.
+func UnifiedDiff(before, after []byte, beforeName, afterName string, context int, w io.Writer) error {
+	if context < 0 {
+		context = 0
+	}
+
+	type line struct {
+		op   byte
+		text []byte
+	}
+	var lines []line
+	for _, d := range DiffLines(before, after) {
+		start := 0
+		for _, end := range splitLines(d.Text) {
+			lines = append(lines, line{d.Op, d.Text[start:end]})
+			start = end
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	var a, b int // Lines of before and after preceding lines[i]
+	last := 0    // End of the previous hunk
+	header := false
+	for i := 0; i < len(lines); {
+		if lines[i].op == OpCopy {
+			a++
+			b++
+			i++
+			continue
+		}
+
+		// Extend the hunk over the following changes while their context would meet.
+		k := min(context, i-last) // Lines of leading context
+		end := i
+		for {
+			for end < len(lines) && lines[end].op != OpCopy {
+				end++
+			}
+			next := end
+			for next < len(lines) && lines[next].op == OpCopy {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				end = min(next, end+context)
+				break
+			}
+			end = next
+		}
+
+		h := Hunk{BeforeLine: a - k + 1, AfterLine: b - k + 1}
+		for _, l := range lines[i-k : end] {
+			if l.op != OpInsert {
+				h.BeforeLines++
+			}
+			if l.op != OpDelete {
+				h.AfterLines++
+			}
+		}
+
+		if !header {
+			bw.WriteString("--- " + beforeName + "\n+++ " + afterName + "\n")
+			header = true
+		}
+		bw.WriteString(h.String() + "\n")
+		for _, l := range lines[i-k : end] {
+			bw.WriteByte(unifiedPrefixes[l.op])
+			bw.Write(l.text)
+			if l.text[len(l.text)-1] != '\n' {
+				bw.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		a += h.BeforeLines - k
+		b += h.AfterLines - k
+		i, last = end, end
+	}
+
+	return bw.Flush()
+}
+
+// ParseUnifiedDiff converts a unified diff of a single file, as written by diff -u or
+// git diff, into a patch that turns before into the same output, written to patch
+// with the options given. Lines before the first hunk, such as the file names, are
+// ignored.
+//
+// Unlike patch(1), the hunks aren't searched for: each must match before at the lines
+// given by its header, or an error wrapping ErrUnifiedMismatch is returned. A diff
+// that changes several files, or has a binary change, is an error.
+func ParseUnifiedDiff(before []byte, text io.Reader, patch io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	start := time.Now()
+
+	hunks, err := parseUnified(text)
+	if err != nil {
+		return err
+	}
+
+	var lines [][]byte
+	offset := 0
+	for _, end := range splitLines(before) {
+		lines = append(lines, before[offset:end])
+		offset = end
+	}
+
+	var diffs []diff
+	var after []byte
+	a := 0 // The next line of before
+	for _, h := range hunks {
+		first := h.start
+		if first+h.lines > len(lines) {
+			return fmt.Errorf("%w: %s", ErrUnifiedMismatch, h.header)
+		}
+		for ; a < first; a++ {
+			diffs = append(diffs, diff{OpCopy, lines[a]})
+			after = append(after, lines[a]...)
+		}
+		for _, d := range h.diffs {
+			if d.Type != OpInsert {
+				if !bytes.Equal(d.Text, lines[a]) {
+					return fmt.Errorf("%w: %s", ErrUnifiedMismatch, h.header)
+				}
+				a++
+			}
+			if d.Type != OpDelete {
+				after = append(after, d.Text...)
+			}
+			diffs = append(diffs, diff{d.Type, d.Text})
+		}
+	}
+	for ; a < len(lines); a++ {
+		diffs = append(diffs, diff{OpCopy, lines[a]})
+		after = append(after, lines[a]...)
+	}
+
+	return writePatch(patch, before, after, diffCleanupMerge(diffs), cfg, start, 0)
+}
+
+// ApplyUnifiedDiff applies a unified diff to before, writing the output to after. The
+// diff is converted with ParseUnifiedDiff and applied with ApplyPatch, which verifies
+// the output against its CRC.
+func ApplyUnifiedDiff(before, text io.Reader, after io.Writer) error {
+	beforeBytes, err := ioutil.ReadAll(before)
+	if err != nil {
+		return err
+	}
+
+	var patch bytes.Buffer
+	if err := ParseUnifiedDiff(beforeBytes, text, &patch); err != nil {
+		return err
+	}
+
+	return ApplyPatch(bytes.NewReader(beforeBytes), &patch, after)
+}
+
+// unifiedHunk is a hunk of a unified diff, with one diff per line.
+type unifiedHunk struct {
+	header string
+	start  int // Index of the first line of the hunk in before
+	lines  int // Number of lines of before in the hunk
+	diffs  []diff
+}
+
+// parseUnified parses the hunks of a unified diff.
+func parseUnified(r io.Reader) ([]unifiedHunk, error) {
+	var hunks []unifiedHunk
+
+	br := bufio.NewReader(r)
+	line := 0 // The line of the diff, for errors
+	fail := func(err error) ([]unifiedHunk, error) {
+		return nil, fmt.Errorf("%w: line %d: %v", errUnifiedSyntax, line, err)
+	}
+
+	var oldLeft, newLeft int // Lines left in the current hunk
+	for {
+		text, err := br.ReadBytes('\n')
+		if err == io.EOF && len(text) == 0 {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line++
+
+		// A missing newline is marked after the line that lacks it.
+		if text[0] == '\\' && len(hunks) > 0 {
+			h := &hunks[len(hunks)-1]
+			if n := len(h.diffs); n > 0 && bytes.HasSuffix(h.diffs[n-1].Text, []byte{'\n'}) {
+				last := h.diffs[n-1].Text
+				h.diffs[n-1].Text = last[:len(last)-1]
+				continue
+			}
+			return fail(errors.New("misplaced newline marker"))
+		}
+
+		if oldLeft == 0 && newLeft == 0 {
+			switch {
+			case bytes.HasPrefix(text, []byte("@@")):
+				m := unifiedHeader.FindSubmatch(text)
+				if m == nil {
+					return fail(errors.New("bad hunk header"))
+				}
+				h := unifiedHunk{header: string(bytes.TrimRight(m[0], "\r\n"))}
+				oldStart, oldLines := unifiedRange(m[1], m[2])
+				_, newLines := unifiedRange(m[3], m[4])
+				h.start, h.lines = oldStart-1, oldLines
+				if oldLines == 0 {
+					// The line of an empty range is the one preceding it.
+					h.start++
+				}
+				if h.start < 0 || (len(hunks) > 0 && h.start < hunks[len(hunks)-1].start+hunks[len(hunks)-1].lines) {
+					return fail(errors.New("hunks out of order"))
+				}
+				hunks = append(hunks, h)
+				oldLeft, newLeft = oldLines, newLines
+			case len(hunks) > 0 && (bytes.HasPrefix(text, []byte("--- ")) || bytes.HasPrefix(text, []byte("diff "))):
+				return nil, errUnifiedFiles
+			case bytes.HasPrefix(text, []byte("GIT binary patch")) || bytes.HasPrefix(text, []byte("Binary files ")):
+				return nil, errUnifiedBinary
+			}
+			continue
+		}
+
+		// Some tools drop the space of an empty context line.
+		if len(bytes.TrimRight(text, "\r\n")) == 0 {
+			text = append([]byte{' '}, text...)
+		}
+		if text[len(text)-1] != '\n' {
+			return fail(io.ErrUnexpectedEOF)
+		}
+
+		op, ok := map[byte]byte{' ': OpCopy, '-': OpDelete, '+': OpInsert}[text[0]]
+		if !ok {
+			return fail(fmt.Errorf("unexpected line in hunk: %q", text))
+		}
+		if op != OpInsert {
+			oldLeft--
+		}
+		if op != OpDelete {
+			newLeft--
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return fail(errors.New("hunk longer than its header"))
+		}
+		h := &hunks[len(hunks)-1]
+		h.diffs = append(h.diffs, diff{op, text[1:]})
+	}
+
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, fmt.Errorf("%w: %v", errUnifiedSyntax, io.ErrUnexpectedEOF)
+	}
+	return hunks, nil
+}
+
+// unifiedRange decodes the start and length of a header range, whose length is 1 if
+// it is omitted.
+func unifiedRange(start, length []byte) (int, int) {
+	s, _ := strconv.Atoi(string(start))
+	if len(length) == 0 {
+		return s, 1
+	}
+	l, _ := strconv.Atoi(string(length))
+	return s, l
+}
@@ -0,0 +1,107 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []byte("one\ntwo\nthree\nfour\nfive")
+	b := []byte("one\n2\nthree\nthree and a half\nfour\nfive\n")
+
+	var out bytes.Buffer
+	assert.NoError(t, UnifiedDiff(a, b, "a.txt", "b.txt", 3, &out))
+	assert.Equal(t, `--- a.txt
++++ b.txt
+@@ -1,5 +1,6 @@
+ one
+-two
++2
+ three
++three and a half
+ four
+-five
+\ No newline at end of file
++five
+`, out.String())
+
+	a = []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n")
+	b = []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nL\nm\n")
+	out.Reset()
+	assert.NoError(t, UnifiedDiff(a, b, "a", "b", 1, &out))
+	assert.Equal(t, "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n@@ -11,3 +11,3 @@\n k\n-l\n+L\n m\n", out.String())
+
+	// Hunks whose context meets are merged.
+	out.Reset()
+	assert.NoError(t, UnifiedDiff(a, b, "a", "b", 5, &out))
+	assert.Equal(t, "--- a\n+++ b\n@@ -1,13 +1,13 @@\n a\n-b\n+B\n c\n d\n e\n f\n g\n h\n i\n j\n k\n-l\n+L\n m\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, UnifiedDiff(nil, []byte("x\ny\n"), "a", "b", 3, &out))
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, UnifiedDiff(a, a, "a", "b", 3, &out))
+	assert.Zero(t, out.Len())
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	pairs := [][2]string{
+		{"one\ntwo\nthree\nfour\nfive", "one\n2\nthree\nthree and a half\nfour\nfive\n"},
+		{"a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n", "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nL\nm\n"},
+		{"", "x\ny\n"},
+		{"x\ny\n", ""},
+		{"x\r\ny\r\n", "x\r\n\r\nz"},
+	}
+	for _, p := range pairs {
+		for _, context := range []int{0, 1, 3} {
+			var text, after bytes.Buffer
+			assert.NoError(t, UnifiedDiff([]byte(p[0]), []byte(p[1]), "a", "b", context, &text))
+			assert.NoError(t, ApplyUnifiedDiff(strings.NewReader(p[0]), &text, &after))
+			assert.Equal(t, p[1], after.String())
+		}
+	}
+
+	// As written by git diff, with a function name after the header, and an empty
+	// context line stripped of its space.
+	before := "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n"
+	git := `diff --git a/main.go b/main.go
+index 1b2c3d4..5e6f7a8 100644
+--- a/main.go
++++ b/main.go
+@@ -2,4 +2,4 @@ package main
+
+ func main() {
+-	println("hello")
++	println("goodbye")
+ }
+`
+	var after bytes.Buffer
+	assert.NoError(t, ApplyUnifiedDiff(strings.NewReader(before), strings.NewReader(git), &after))
+	assert.Equal(t, "package main\n\nfunc main() {\n\tprintln(\"goodbye\")\n}\n", after.String())
+
+	err := ApplyUnifiedDiff(strings.NewReader(strings.Replace(before, "hello", "hi", 1)), strings.NewReader(git), &after)
+	assert.True(t, errors.Is(err, ErrUnifiedMismatch), err)
+	err = ApplyUnifiedDiff(strings.NewReader("package main\n"), strings.NewReader(git), &after)
+	assert.True(t, errors.Is(err, ErrUnifiedMismatch), err)
+
+	err = ApplyUnifiedDiff(strings.NewReader(before), strings.NewReader(git+strings.Replace(git, "main.go", "other.go", -1)), &after)
+	assert.Equal(t, errUnifiedFiles, err)
+	err = ApplyUnifiedDiff(strings.NewReader(before), strings.NewReader("diff --git a/x b/x\nGIT binary patch\n"), &after)
+	assert.Equal(t, errUnifiedBinary, err)
+
+	for _, bad := range []string{
+		"@@ -1,2 +1,2 @@\n a\n",
+		"@@ -1,2 +1 @@\n+b\n+c\n",
+		"@@ -1 +1 @@\n*a\n",
+		"@@ -x +1 @@\n",
+		"@@ -1 +1 @@\n-a\n\\ No newline at end of file\n\\ No newline at end of file\n+b\n",
+	} {
+		err = ApplyUnifiedDiff(strings.NewReader("a\nc\n"), strings.NewReader(bad), &after)
+		assert.True(t, errors.Is(err, errUnifiedSyntax), "%q: %v", bad, err)
+	}
+}
@@ -0,0 +1,187 @@
+package lightpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// ErrInsertChecksum is wrapped by an *InsertError when the data of a checked insert
+// doesn't match its CRC.
+var ErrInsertChecksum = errors.New("insert checksum mismatch")
+
+// ErrVerify is returned by MakeVerifiedPatch when the patch it made doesn't
+// reproduce after.
+var ErrVerify = errors.New("patch doesn't reproduce after")
+
+// InsertError reports a checked insert whose data doesn't match its CRC. The op
+// occupies Size bytes of the patch starting at Offset, which is the range that
+// needs to be fetched again.
+type InsertError struct {
+	Offset int64
+	Size   int64
+}
+
+func (e *InsertError) Error() string {
+	return fmt.Sprintf("%v at patch offset %d (%d bytes)", ErrInsertChecksum, e.Offset, e.Size)
+}
+
+func (e *InsertError) Unwrap() error {
+	return ErrInsertChecksum
+}
+
+// VerifyPatch checks the structure of patch and the CRCs of any checked inserts,
+// without needing the before input. It returns an *InsertError for the first
+// damaged insert. The output checksum can only be verified by ApplyPatch.
+func VerifyPatch(patch io.Reader) error {
+	patch, err := openPatch(patch)
+	if err != nil {
+		return err
+	}
+
+	pr := &countingReader{r: patch}
+	patchBR := bufio.NewReader(pr)
+
+	if op, err := patchBR.Peek(1); err == nil && digestSize(op[0]) > 0 {
+		if _, err := patchBR.Discard(1 + digestSize(op[0])); err != nil {
+			return err
+		}
+	}
+
+	for {
+		offset := pr.n - int64(patchBR.Buffered())
+		op, err := patchBR.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if op == OpCRC {
+			if _, err := patchBR.Discard(4); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+			if _, err := patchBR.ReadByte(); err != io.EOF {
+				return ErrExtraData
+			}
+			return nil
+		}
+
+		if op == OpCopyToEnd {
+			continue
+		}
+		if op == OpBeforeCRC {
+			if _, err := patchBR.Discard(4); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+			continue
+		}
+
+		tl, err := readLength(patchBR)
+		if err != nil {
+			return decodeError(err, offset, 0, op)
+		}
+
+		switch op {
+		case OpCopy, OpDelete:
+		case OpChunk:
+			if _, err := patchBR.Discard(blake3Size); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+		case OpReplace:
+			il, err := readLength(patchBR)
+			if err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+			if _, err := io.CopyN(ioutil.Discard, patchBR, int64(il)); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+		case OpInsert, OpAnnotation, OpPadding, OpCheckpoint:
+			if _, err := io.CopyN(ioutil.Discard, patchBR, int64(tl)); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+		case OpCheckedInsert:
+			if err := copyCheckedInsert(ioutil.Discard, patchBR, tl, offset); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+		case OpMetadata:
+			if err := readMetadata(Metadata{}, patchBR, tl); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+		case OpContext:
+			if _, err := readContext(patchBR, tl); err != nil {
+				return decodeError(err, offset, 0, op)
+			}
+		default:
+			return decodeError(unexpectedOp(op), offset, 0, op)
+		}
+	}
+}
+
+// MakeVerifiedPatch makes a patch from before to after, like MakePatch, then applies
+// it and checks that the output is after before returning it. It returns
+// ErrVerify if it isn't, which indicates a bug or failing hardware rather than bad
+// input.
+func MakeVerifiedPatch(before, after []byte, opts ...Option) ([]byte, error) {
+	var patch bytes.Buffer
+	if err := MakePatch(bytes.NewReader(before), bytes.NewReader(after), &patch, opts...); err != nil {
+		return nil, err
+	}
+
+	// XML patches apply to the canonical forms.
+	if newConfig(opts).granularity == GranularityXML {
+		var err error
+		if before, err = canonicalXML(before); err != nil {
+			return nil, err
+		}
+		if after, err = canonicalXML(after); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	if err := ApplyPatch(bytes.NewReader(before), bytes.NewReader(patch.Bytes()), &out, opts...); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrVerify, err)
+	}
+	if !bytes.Equal(out.Bytes(), after) {
+		return nil, ErrVerify
+	}
+
+	return patch.Bytes(), nil
+}
+
+// copyCheckedInsert copies the n data bytes of a checked insert at offset to w, and
+// verifies them against the CRC that follows.
+func copyCheckedInsert(w io.Writer, r *bufio.Reader, n uint64, offset int64) error {
+	h := crc32.NewIEEE()
+	if _, err := io.CopyN(io.MultiWriter(w, h), r, int64(n)); err != nil {
+		return err
+	}
+
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(sum, h.Sum(nil)) {
+		return &InsertError{
+			Offset: offset,
+			Size:   1 + int64(uvarintLen(n)) + int64(n) + 4,
+		}
+	}
+
+	return nil
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
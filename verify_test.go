@@ -0,0 +1,81 @@
+package lightpatch
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyPatch(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	var patchr bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithInsertChecksums())
+	assert.NoError(t, err)
+	patch := patchr.Bytes()
+	assert.Contains(t, string(patch), string([]byte{OpCheckedInsert}))
+	assert.NotContains(t, string(patch), string([]byte{OpInsert}))
+
+	assert.NoError(t, VerifyPatch(bytes.NewReader(patch)))
+
+	var c bytes.Buffer
+	err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), &c)
+	assert.NoError(t, err)
+	assert.Equal(t, b, c.Bytes())
+
+	// Damage the data of the first checked insert
+	offset := bytes.IndexByte(patch, OpCheckedInsert)
+	patch[offset+2] ^= 0xff
+
+	err = VerifyPatch(bytes.NewReader(patch))
+	assert.True(t, errors.Is(err, ErrInsertChecksum))
+	var ie *InsertError
+	if assert.True(t, errors.As(err, &ie)) {
+		assert.Equal(t, int64(offset), ie.Offset)
+		assert.Equal(t, OpCopy, patch[ie.Offset+ie.Size])
+	}
+
+	err = ApplyPatch(bytes.NewReader(a), bytes.NewReader(patch), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrInsertChecksum))
+
+	// Patches without checked inserts only have their structure verified
+	patchr.Reset()
+	err = MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyPatch(&patchr))
+}
+
+// lossyChunks is a ChunkStore that damages what it stores.
+type lossyChunks struct{ chunkMap }
+
+func (l lossyChunks) Put(key string, value []byte) error {
+	value = append([]byte(nil), value...)
+	value[0] ^= 1
+	return l.chunkMap.Put(key, value)
+}
+
+func TestMakeVerifiedPatch(t *testing.T) {
+	a := []byte("The quick brown fox jumped over the lazy dog.")
+	b := []byte("The quick brown cat jumped over the dog!")
+
+	for _, opts := range [][]Option{
+		nil,
+		{WithArmor(ArmorBase64), WithFEC(4, 2)},
+		{WithDedup(chunkMap{}, 0)},
+	} {
+		patch, err := MakeVerifiedPatch(a, b, opts...)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, patch)
+	}
+
+	patch, err := MakeVerifiedPatch([]byte(`<a x="1" y="2"/>`), []byte(`<a y="2" x="1"><b/></a>`), WithGranularity(GranularityXML))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, patch)
+
+	_, err = MakeVerifiedPatch(a, []byte("Something else entirely, and long enough to store"), WithDedup(lossyChunks{chunkMap{}}, 0))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrVerify.Error())
+}
@@ -0,0 +1,191 @@
+package lightpatch
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// CanonicalXML writes the canonical serialization of the XML document in src to dst.
+// Patches made with GranularityXML apply to this form of before, and produce this
+// form of after.
+//
+// The canonical form is close to, but simpler than, Canonical XML: empty elements
+// are written as start and end tags, attributes are sorted by name and quoted with
+// double quotes, character references are replaced by the characters they stand for
+// except where escaping is needed, and text consisting only of whitespace, such as
+// indentation, is dropped. Namespace prefixes are kept as written.
+func CanonicalXML(src io.Reader, dst io.Writer) error {
+	text, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	canon, err := canonicalXML(text)
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(canon)
+	return err
+}
+
+func canonicalXML(text []byte) ([]byte, error) {
+	// RawToken keeps namespace prefixes as written, but doesn't check that elements
+	// are closed and nested properly, so the document is checked with Token first.
+	d := xml.NewDecoder(bytes.NewReader(text))
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	d = xml.NewDecoder(bytes.NewReader(text))
+
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			attrs := t.Attr
+			sort.SliceStable(attrs, func(i, j int) bool {
+				return xmlName(attrs[i].Name) < xmlName(attrs[j].Name)
+			})
+
+			buf.WriteString("<" + xmlName(t.Name))
+			for _, a := range attrs {
+				buf.WriteString(" " + xmlName(a.Name) + `="`)
+				escapeXML(&buf, []byte(a.Value), true)
+				buf.WriteByte('"')
+			}
+			buf.WriteByte('>')
+		case xml.EndElement:
+			buf.WriteString("</" + xmlName(t.Name) + ">")
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				escapeXML(&buf, t, false)
+			}
+		case xml.Comment:
+			buf.WriteString("<!--")
+			buf.Write(t)
+			buf.WriteString("-->")
+		case xml.ProcInst:
+			buf.WriteString("<?" + t.Target)
+			if len(t.Inst) > 0 {
+				buf.WriteByte(' ')
+				buf.Write(t.Inst)
+			}
+			buf.WriteString("?>")
+		case xml.Directive:
+			buf.WriteString("<!")
+			buf.Write(t)
+			buf.WriteByte('>')
+		}
+	}
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// escapeXML writes text to buf, escaping the characters that Canonical XML does in
+// text or, if attr is set, in attribute values.
+func escapeXML(buf *bytes.Buffer, text []byte, attr bool) {
+	for _, c := range text {
+		switch {
+		case c == '&':
+			buf.WriteString("&amp;")
+		case c == '<':
+			buf.WriteString("&lt;")
+		case c == '>' && !attr:
+			buf.WriteString("&gt;")
+		case c == '"' && attr:
+			buf.WriteString("&quot;")
+		case c == '\t' && attr:
+			buf.WriteString("&#x9;")
+		case c == '\n' && attr:
+			buf.WriteString("&#xA;")
+		case c == '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// splitXML splits canonical XML into tokens: the name of a start tag, each of its
+// attributes, the closing '>', end tags, comments, processing instructions and
+// directives whole, and the words of text along with the whitespace following them.
+func splitXML(text []byte) []int {
+	var ends []int
+
+	// until returns the offset following the first sep after start, or the end of
+	// text.
+	until := func(start int, sep string) int {
+		if i := bytes.Index(text[start:], []byte(sep)); i >= 0 {
+			return start + i + len(sep)
+		}
+		return len(text)
+	}
+
+	for start := 0; start < len(text); {
+		rest := text[start:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			start = until(start, "-->")
+			ends = append(ends, start)
+		case bytes.HasPrefix(rest, []byte("<?")):
+			start = until(start, "?>")
+			ends = append(ends, start)
+		case bytes.HasPrefix(rest, []byte("</")), bytes.HasPrefix(rest, []byte("<!")):
+			start = until(start, ">")
+			ends = append(ends, start)
+		case rest[0] == '<':
+			end := start + 1
+			for end < len(text) && text[end] != ' ' && text[end] != '>' {
+				end++
+			}
+			ends = append(ends, end)
+
+			// Attribute values are escaped, so the only quotes are the delimiters.
+			for end < len(text) && text[end] == ' ' {
+				end = until(until(end, `"`), `"`)
+				ends = append(ends, end)
+			}
+			if end < len(text) {
+				end++
+				ends = append(ends, end)
+			}
+			start = end
+		default:
+			end := start
+			for end < len(text) && text[end] != '<' && !isXMLSpace(text[end]) {
+				end++
+			}
+			for end < len(text) && isXMLSpace(text[end]) {
+				end++
+			}
+			start = end
+			ends = append(ends, end)
+		}
+	}
+
+	return ends
+}
+
+func isXMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,68 @@
+package lightpatch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalXML(t *testing.T) {
+	var canon bytes.Buffer
+	err := CanonicalXML(strings.NewReader(`<?xml version="1.0"?>
+<!-- config -->
+<config xmlns:x="urn:x">
+  <server port='80' host="a &amp; b"/>
+  <x:name>&#65;lpha &lt; beta</x:name>
+</config>
+`), &canon)
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0"?><!-- config --><config xmlns:x="urn:x"><server host="a &amp; b" port="80"></server><x:name>Alpha &lt; beta</x:name></config>`, canon.String())
+
+	assert.Error(t, CanonicalXML(strings.NewReader("<a><b></a>"), &canon))
+}
+
+func TestSplitXML(t *testing.T) {
+	text := `<a href="x y" id="1">Hello,  world</a><!-- c --><b></b>`
+	var out []string
+	start := 0
+	for _, end := range splitXML([]byte(text)) {
+		out = append(out, text[start:end])
+		start = end
+	}
+	assert.Equal(t, []string{`<a`, ` href="x y"`, ` id="1"`, `>`, `Hello,  `, `world`, `</a>`, `<!-- c -->`, `<b`, `>`, `</b>`}, out)
+}
+
+func TestDiffXML(t *testing.T) {
+	a := []byte("<doc>\n  <item id=\"1\" name=\"first\">One</item>\n  <item id=\"2\" name=\"second\">Two</item>\n</doc>\n")
+	b := []byte("<doc>\n\t<item name=\"first\" id=\"1\">One</item>\n\t<item name=\"second\" id=\"2\" done=\"yes\">Two</item>\n</doc>")
+
+	var patchr bytes.Buffer
+	err := MakePatch(bytes.NewReader(a), bytes.NewReader(b), &patchr, WithGranularity(GranularityXML))
+	assert.NoError(t, err)
+
+	// Only the new attribute is inserted.
+	edits, err := Edits(mustCanonicalXML(t, a), patchr.Bytes())
+	assert.NoError(t, err)
+	var inserts []string
+	for _, e := range edits {
+		if e.Op != OpCopy {
+			inserts = append(inserts, string([]byte{e.Op})+string(e.Text))
+		}
+	}
+	assert.Equal(t, []string{`I done="yes"`}, inserts)
+
+	var c bytes.Buffer
+	assert.NoError(t, ApplyPatch(bytes.NewReader(mustCanonicalXML(t, a)), &patchr, &c))
+	assert.Equal(t, string(mustCanonicalXML(t, b)), c.String())
+
+	err = MakePatch(bytes.NewReader(a), strings.NewReader("<doc>"), &patchr, WithGranularity(GranularityXML))
+	assert.Error(t, err)
+}
+
+func mustCanonicalXML(t *testing.T, text []byte) []byte {
+	var canon bytes.Buffer
+	assert.NoError(t, CanonicalXML(bytes.NewReader(text), &canon))
+	return canon.Bytes()
+}
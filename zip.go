@@ -0,0 +1,194 @@
+package lightpatch
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	// ErrNotZip is wrapped by the error returned by MakeZipPatch and ApplyZipPatch
+	// when an input isn't a zip archive.
+	ErrNotZip = errors.New("not a zip archive")
+
+	errZipName   = errors.New("zip entry name is not a relative path, or is repeated")
+	errZipChange = errors.New("symlinks and directories can't be patched in a zip archive")
+)
+
+// MakeZipPatch writes a tree patch, or bundle, that turns the zip archive in before
+// into the one in after, with the same form as those made by MakeTreePatch. The
+// compressed streams of zip entries change throughout after any edit, so a byte patch
+// of two archives is about as large as after. Instead, the decompressed entries are
+// diffed as the files of two trees: entries only in after are stored whole, and each
+// entry whose content differs is patched with the options given. Directory entries
+// are ignored.
+//
+// The patch applies to the archive with ApplyZipPatch, or to a directory it was
+// extracted to with ApplyBundle or ApplyTreePatch.
+func MakeZipPatch(before, after io.Reader, patch io.Writer, opts ...Option) error {
+	a, err := zipFiles(before)
+	if err != nil {
+		return err
+	}
+	b, err := zipFiles(after)
+	if err != nil {
+		return err
+	}
+
+	aSet, err := zipFileSet(a)
+	if err != nil {
+		return err
+	}
+	bSet, err := zipFileSet(b)
+	if err != nil {
+		return err
+	}
+	return makeTreePatch(aSet, bSet, patch, opts)
+}
+
+// ApplyZipPatch applies a tree patch made by MakeZipPatch to the zip archive in
+// before, and writes the resulting archive to after, with the options given to
+// ApplyPatch. The entries keep their order and headers from before, and are
+// compressed again with their method; added entries follow, in order of their names,
+// deflated. Every change is checked against before first, as by ApplyTreePatch, and
+// nothing is written if one doesn't apply.
+func ApplyZipPatch(before, patch io.Reader, after io.Writer, opts ...Option) error {
+	files, err := zipFiles(before)
+	if err != nil {
+		return err
+	}
+	if _, err := zipFileSet(files); err != nil {
+		return err
+	}
+	changes, err := ReadTreePatch(patch)
+	if err != nil {
+		return err
+	}
+
+	entries := map[string]*zip.File{}
+	for _, f := range files {
+		entries[f.Name] = f
+	}
+	byPath := map[string]TreeChange{}
+	for _, c := range changes {
+		f, ok := entries[c.Path]
+		switch {
+		case c.Op == TreeSymlink || c.Op == TreeDir:
+			return fmt.Errorf("%s: %w", c.Path, errZipChange)
+		case c.Op == TreeAdded && ok:
+			return fmt.Errorf("%s: entry already exists", c.Path)
+		case c.Op != TreeAdded && !ok:
+			return fmt.Errorf("%s: no such entry", c.Path)
+		case c.Sum != nil && binary.BigEndian.Uint32(c.Sum) != f.CRC32:
+			return fmt.Errorf("%s: %w", c.Path, ErrBeforeMismatch)
+		}
+		byPath[c.Path] = c
+	}
+
+	// Apply the patches before writing anything.
+	outputs := map[string][]byte{}
+	for _, c := range changes {
+		if c.Op != TreeModified {
+			continue
+		}
+		rc, err := entries[c.Path].Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+		var out bytes.Buffer
+		err = ApplyPatch(rc, bytes.NewReader(c.Data), &out, opts...)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+		outputs[c.Path] = out.Bytes()
+	}
+
+	zw := zip.NewWriter(after)
+	for _, f := range files {
+		c, changed := byPath[f.Name]
+		if changed && c.Op == TreeDeleted {
+			continue
+		}
+
+		hdr := f.FileHeader
+		w, err := zw.CreateHeader(&hdr)
+		if err != nil {
+			return err
+		}
+		if changed {
+			_, err = w.Write(outputs[f.Name])
+		} else {
+			err = copyZipFile(w, f)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	for _, c := range changes {
+		if c.Op != TreeAdded {
+			continue
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: c.Path, Method: zip.Deflate})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(c.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// zipFiles reads a zip archive and returns its entries.
+func zipFiles(r io.Reader) ([]*zip.File, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotZip, err)
+	}
+	return zr.File, nil
+}
+
+// zipFileSet returns the entries of a zip archive that aren't directories, by their
+// names, which must be valid paths of a tree patch.
+func zipFileSet(files []*zip.File) (fileSet, error) {
+	set := fileSet{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if _, dup := set[f.Name]; dup || !validTreePath(f.Name) {
+			return nil, fmt.Errorf("%w: %q", errZipName, f.Name)
+		}
+		f := f
+		set[f.Name] = treeEntry{read: func() ([]byte, error) {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return ioutil.ReadAll(rc)
+		}}
+	}
+	return set, nil
+}
+
+// copyZipFile writes the decompressed content of f to w.
+func copyZipFile(w io.Writer, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
@@ -0,0 +1,91 @@
+package lightpatch
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeZip(t *testing.T, names ...string) func(contents ...string) []byte {
+	return func(contents ...string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for i, name := range names {
+			w, err := zw.Create(name)
+			assert.NoError(t, err)
+			_, err = w.Write([]byte(contents[i]))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(rc)
+		assert.NoError(t, err)
+		rc.Close()
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func TestZipPatch(t *testing.T) {
+	fox := strings.Repeat("The quick brown fox jumped over the lazy dog. ", 100)
+	lorem := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 100)
+
+	before := makeZip(t, "docs/", "docs/fox.txt", "lorem.txt", "gone.txt")("", fox, lorem, "deleted")
+	after := makeZip(t, "docs/", "docs/fox.txt", "lorem.txt", "new.txt")("", strings.Replace(fox, "fox", "cat", 1), lorem, "added")
+
+	var patch bytes.Buffer
+	assert.NoError(t, MakeZipPatch(bytes.NewReader(before), bytes.NewReader(after), &patch))
+
+	changes, err := ReadTreePatch(bytes.NewReader(patch.Bytes()))
+	assert.NoError(t, err)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, "docs/fox.txt", changes[0].Path)
+	assert.Equal(t, TreeModified, changes[0].Op)
+
+	var out bytes.Buffer
+	assert.NoError(t, ApplyZipPatch(bytes.NewReader(before), bytes.NewReader(patch.Bytes()), &out))
+	assert.Equal(t, readZip(t, after), readZip(t, out.Bytes()))
+
+	// A byte patch of the compressed archives is much larger.
+	var bytePatch bytes.Buffer
+	assert.NoError(t, MakePatch(bytes.NewReader(before), bytes.NewReader(after), &bytePatch))
+	assert.Less(t, patch.Len(), bytePatch.Len()/2)
+
+	changed := makeZip(t, "docs/fox.txt", "lorem.txt", "gone.txt")("edited", lorem, "deleted")
+	err = ApplyZipPatch(bytes.NewReader(changed), bytes.NewReader(patch.Bytes()), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrBeforeMismatch), err)
+
+	err = ApplyZipPatch(bytes.NewReader(after), bytes.NewReader(patch.Bytes()), new(bytes.Buffer))
+	assert.Error(t, err)
+}
+
+func TestZipPatchErrors(t *testing.T) {
+	archive := makeZip(t, "a.txt")("hello")
+
+	err := MakeZipPatch(strings.NewReader("not a zip archive"), bytes.NewReader(archive), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, ErrNotZip), err)
+
+	for _, name := range []string{"../evil", "/etc/passwd", "a/./b"} {
+		bad := makeZip(t, name)("x")
+		err = MakeZipPatch(bytes.NewReader(archive), bytes.NewReader(bad), new(bytes.Buffer))
+		assert.True(t, errors.Is(err, errZipName), err)
+	}
+	dup := makeZip(t, "a.txt", "a.txt")("x", "y")
+	err = MakeZipPatch(bytes.NewReader(archive), bytes.NewReader(dup), new(bytes.Buffer))
+	assert.True(t, errors.Is(err, errZipName), err)
+}